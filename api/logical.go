@@ -5,8 +5,11 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/jiangjiali/vault/sdk/helper/errwrap"
 	"github.com/jiangjiali/vault/sdk/helper/jsonutil"
@@ -51,6 +54,10 @@ func (c *Logical) Read(path string) (*Secret, error) {
 }
 
 func (c *Logical) ReadWithData(path string, data map[string][]string) (*Secret, error) {
+	return c.ReadWithDataWithContext(context.Background(), path, data)
+}
+
+func (c *Logical) ReadWithDataWithContext(ctx context.Context, path string, data map[string][]string) (*Secret, error) {
 	r := c.c.NewRequest("GET", "/v1/"+path)
 
 	var values url.Values
@@ -67,7 +74,7 @@ func (c *Logical) ReadWithData(path string, data map[string][]string) (*Secret,
 		r.Params = values
 	}
 
-	ctx, cancelFunc := context.WithCancel(context.Background())
+	ctx, cancelFunc := context.WithCancel(ctx)
 	defer cancelFunc()
 	resp, err := c.c.RawRequestWithContext(ctx, r)
 	if resp != nil {
@@ -94,6 +101,38 @@ func (c *Logical) ReadWithData(path string, data map[string][]string) (*Secret,
 	return ParseSecret(resp.Body)
 }
 
+// Watch long-polls a KV v2 data path, e.g. "secret/data/foo". It returns the
+// secret once its current version is no longer index or it is otherwise
+// changed, or after wait elapses, whichever comes first. The version in the
+// returned metadata is the index for the next call. The wait is capped by the
+// server at 15s and should be lower than the client timeout.
+func (c *Logical) Watch(path string, index uint64, wait time.Duration) (*Secret, error) {
+	return c.WatchWithContext(context.Background(), path, index, wait)
+}
+
+func (c *Logical) WatchWithContext(ctx context.Context, path string, index uint64, wait time.Duration) (*Secret, error) {
+	return c.ReadWithDataWithContext(ctx, path, map[string][]string{
+		"watch": {"true"},
+		"index": {strconv.FormatUint(index, 10)},
+		"wait":  {strconv.Itoa(int(wait / time.Second))},
+	})
+}
+
+// Events long-polls a KV v2 events path, e.g. "secret/events/app/", for the
+// changes made to the keys under the prefix after index. The returned data
+// holds the "events", the "index" for the next call and "reset", which is true
+// if the events since index are no longer available.
+func (c *Logical) Events(path string, index uint64, wait time.Duration) (*Secret, error) {
+	return c.EventsWithContext(context.Background(), path, index, wait)
+}
+
+func (c *Logical) EventsWithContext(ctx context.Context, path string, index uint64, wait time.Duration) (*Secret, error) {
+	return c.ReadWithDataWithContext(ctx, path, map[string][]string{
+		"index": {strconv.FormatUint(index, 10)},
+		"wait":  {strconv.Itoa(int(wait / time.Second))},
+	})
+}
+
 func (c *Logical) List(path string) (*Secret, error) {
 	r := c.c.NewRequest("LIST", "/v1/"+path)
 	// Set this for broader compatibility, but we use LIST above to be able to
@@ -160,6 +199,49 @@ func (c *Logical) Write(path string, data map[string]interface{}) (*Secret, erro
 	return ParseSecret(resp.Body)
 }
 
+// JSONMergePatch sends a PATCH request with the data as a JSON merge patch
+// document (RFC 7396). The backend applies it to the existing resource.
+func (c *Logical) JSONMergePatch(path string, data map[string]interface{}) (*Secret, error) {
+	r := c.c.NewRequest("PATCH", "/v1/"+path)
+	if err := r.SetJSONBody(data); err != nil {
+		return nil, err
+	}
+
+	// The request headers are shared with the client, copy them before
+	// setting the content type.
+	headers := make(http.Header, len(r.Headers)+1)
+	for k, v := range r.Headers {
+		headers[k] = v
+	}
+	headers.Set("Content-Type", "application/merge-patch+json")
+	r.Headers = headers
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	resp, err := c.c.RawRequestWithContext(ctx, r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == 404 {
+		secret, parseErr := ParseSecret(resp.Body)
+		switch parseErr {
+		case nil:
+		case io.EOF:
+			return nil, nil
+		default:
+			return nil, err
+		}
+		if secret != nil && (len(secret.Warnings) > 0 || len(secret.Data) > 0) {
+			return secret, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSecret(resp.Body)
+}
+
 func (c *Logical) Delete(path string) (*Secret, error) {
 	r := c.c.NewRequest("DELETE", "/v1/"+path)
 
@@ -38,4 +38,5 @@ type HealthResponse struct {
 	ClusterName                string `json:"cluster_name,omitempty"`
 	ClusterID                  string `json:"cluster_id,omitempty"`
 	LastWAL                    uint64 `json:"last_wal,omitempty"`
+	AutoSnapshotFailing        bool   `json:"auto_snapshot_failing,omitempty"`
 }
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"context"
+	"io"
+)
+
+// StorageSnapshot writes a snapshot of the storage of the cluster to w
+func (c *Sys) StorageSnapshot(w io.Writer) error {
+	r := c.c.NewRequest("GET", "/v1/sys/storage/snapshot")
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	resp, err := c.c.RawRequestWithContext(ctx, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// StorageSnapshotRestore replaces the storage of the cluster with the one of
+// the snapshot. Force restores a snapshot taken with other unseal keys. The
+// snapshot is streamed when it is an io.ReadSeeker, such as an *os.File,
+// otherwise it is read in memory first.
+func (c *Sys) StorageSnapshotRestore(snapshot io.Reader, force bool) error {
+	r := c.c.NewRequest("PUT", "/v1/sys/storage/snapshot-restore")
+	r.Body = snapshot
+	if force {
+		r.Params.Set("force", "true")
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+	resp, err := c.c.RawRequestWithContext(ctx, r)
+	if err == nil {
+		defer resp.Body.Close()
+	}
+	return err
+}
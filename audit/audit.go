@@ -36,6 +36,13 @@ type Backend interface {
 	Invalidate(context.Context)
 }
 
+// Closer is implemented by backends holding resources, such as goroutines
+// delivering buffered entries, which must be released when the backend is
+// disabled or the vault is sealed.
+type Closer interface {
+	Close() error
+}
+
 // LogInput contains the input parameters passed into LogRequest and LogResponse
 type LogInput struct {
 	Auth                *logical.Auth
@@ -0,0 +1,504 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/armon/metrics"
+	"github.com/jiangjiali/vault/sdk/helper/parseutil"
+)
+
+const (
+	// FailPolicyOpen drops the entries which cannot be buffered, so that a
+	// sink which is down for long never fails requests
+	FailPolicyOpen = "open"
+
+	// FailPolicyClosed fails the logging of the entries which cannot be
+	// buffered, so that no request goes unaudited
+	FailPolicyClosed = "closed"
+
+	bufferSegmentExt = ".batch"
+
+	// bufferRecordHeaderSize is the size of the length preceding every entry
+	// in the segments
+	bufferRecordHeaderSize = 4
+)
+
+// ErrBufferFull is returned when an entry does not fit in the buffer of a
+// device which fails closed.
+var ErrBufferFull = errors.New("audit buffer is full")
+
+var bufferSegmentRegex = regexp.MustCompile(`^(\d{20})` + regexp.QuoteMeta(bufferSegmentExt) + `$`)
+
+// BufferConfig is the configuration of a Buffer, shared by the audit devices
+// delivering entries to a remote sink.
+type BufferConfig struct {
+	// Path is the directory of the buffer, which must not be shared with
+	// another device
+	Path string
+
+	// MaxBytes bounds the size of the buffer on disk
+	MaxBytes int64
+
+	// BatchEntries, BatchBytes and BatchInterval bound the batches: a batch
+	// is sent when it holds BatchEntries entries or BatchBytes bytes, or when
+	// its first entry is older than BatchInterval
+	BatchEntries  int
+	BatchBytes    int64
+	BatchInterval time.Duration
+
+	// RetryMin and RetryMax bound the delay between the attempts to send a
+	// batch, which doubles after every failure
+	RetryMin time.Duration
+	RetryMax time.Duration
+
+	// FailPolicy is FailPolicyOpen or FailPolicyClosed
+	FailPolicy string
+}
+
+// ParseBufferConfig parses the buffer options of an audit device
+func ParseBufferConfig(conf map[string]string) (*BufferConfig, error) {
+	c := &BufferConfig{
+		Path:          conf["buffer_path"],
+		MaxBytes:      64 << 20,
+		BatchEntries:  100,
+		BatchBytes:    1 << 20,
+		BatchInterval: time.Second,
+		RetryMin:      time.Second,
+		RetryMax:      time.Minute,
+		FailPolicy:    FailPolicyClosed,
+	}
+
+	if c.Path == "" {
+		return nil, fmt.Errorf("buffer_path is required")
+	}
+
+	if raw, ok := conf["buffer_max_bytes"]; ok {
+		size, err := ParseSize(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid buffer_max_bytes: %v", err)
+		}
+		c.MaxBytes = size
+	}
+
+	if raw, ok := conf["batch_max_entries"]; ok {
+		entries, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid batch_max_entries: %v", err)
+		}
+		c.BatchEntries = entries
+	}
+
+	if raw, ok := conf["batch_max_bytes"]; ok {
+		size, err := ParseSize(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid batch_max_bytes: %v", err)
+		}
+		c.BatchBytes = size
+	}
+
+	for _, d := range []struct {
+		name   string
+		target *time.Duration
+	}{
+		{"batch_interval", &c.BatchInterval},
+		{"retry_min", &c.RetryMin},
+		{"retry_max", &c.RetryMax},
+	} {
+		raw, ok := conf[d.name]
+		if !ok {
+			continue
+		}
+		duration, err := parseutil.ParseDurationSecond(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", d.name, err)
+		}
+		*d.target = duration
+	}
+
+	if raw, ok := conf["fail_policy"]; ok {
+		switch raw {
+		case FailPolicyOpen, FailPolicyClosed:
+			c.FailPolicy = raw
+		default:
+			return nil, fmt.Errorf("unknown fail_policy %q", raw)
+		}
+	}
+
+	switch {
+	case c.MaxBytes <= 0:
+		return nil, fmt.Errorf("buffer_max_bytes must be positive")
+	case c.BatchEntries <= 0:
+		return nil, fmt.Errorf("batch_max_entries must be positive")
+	case c.BatchBytes <= 0:
+		return nil, fmt.Errorf("batch_max_bytes must be positive")
+	case c.BatchBytes > c.MaxBytes:
+		return nil, fmt.Errorf("batch_max_bytes cannot exceed buffer_max_bytes")
+	case c.BatchInterval <= 0:
+		return nil, fmt.Errorf("batch_interval must be positive")
+	case c.RetryMin <= 0 || c.RetryMax < c.RetryMin:
+		return nil, fmt.Errorf("retry_min must be positive and not exceed retry_max")
+	}
+
+	return c, nil
+}
+
+// ParseSize parses a size in bytes, optionally suffixed with KB, MB or GB
+func ParseSize(raw string) (int64, error) {
+	raw = strings.ToUpper(strings.TrimSpace(raw))
+
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"KB", 1 << 10},
+		{"MB", 1 << 20},
+		{"GB", 1 << 30},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(raw, unit.suffix) {
+			raw = strings.TrimSpace(strings.TrimSuffix(raw, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	size, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if size < 0 {
+		return 0, fmt.Errorf("size cannot be negative")
+	}
+	return size * multiplier, nil
+}
+
+// ParseHashChain returns a new chain if the hash_chain option of the device is
+// set, nil otherwise. Chaining is only supported with the json format.
+func ParseHashChain(config map[string]string, format string) (*HashChain, error) {
+	chainRaw, ok := config["hash_chain"]
+	if !ok {
+		return nil, nil
+	}
+
+	chain, err := strconv.ParseBool(chainRaw)
+	if err != nil {
+		return nil, err
+	}
+	if !chain {
+		return nil, nil
+	}
+	if format != "json" {
+		return nil, fmt.Errorf("hash_chain is only supported with the json format")
+	}
+	return NewHashChain(), nil
+}
+
+// SendFunc delivers a batch of formatted entries to a sink. It is retried
+// until it succeeds, so the sink may receive a batch more than once.
+type SendFunc func(ctx context.Context, entries [][]byte) error
+
+// Buffer decouples the logging of entries from their delivery to a sink: the
+// entries are appended to segment files, one per batch, which are sent in the
+// background and removed once delivered. Segments left by a previous run are
+// sent first, so entries survive a restart or a seal.
+type Buffer struct {
+	config *BufferConfig
+	send   SendFunc
+	name   string
+
+	lock sync.Mutex
+
+	// size is the size of all the segments
+	size int64
+
+	// segments are the sealed segments, oldest first
+	segments []*bufferSegment
+
+	// current is the segment entries are appended to, if any
+	current *bufferSegment
+	nextSeq uint64
+
+	wake   chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+	cancel context.CancelFunc
+}
+
+type bufferSegment struct {
+	path    string
+	f       *os.File
+	entries int
+	size    int64
+	created time.Time
+}
+
+// NewBuffer opens the buffer in the configured directory and starts sending
+// its batches. The name identifies the device in the metrics.
+func NewBuffer(config *BufferConfig, name string, send SendFunc) (*Buffer, error) {
+	if err := os.MkdirAll(config.Path, 0700); err != nil {
+		return nil, err
+	}
+
+	infos, err := ioutil.ReadDir(config.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Buffer{
+		config: config,
+		send:   send,
+		name:   name,
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	// Segments left by a previous run are sealed, including the one which
+	// was being appended to
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	for _, info := range infos {
+		match := bufferSegmentRegex.FindStringSubmatch(info.Name())
+		if match == nil || !info.Mode().IsRegular() {
+			continue
+		}
+		seq, _ := strconv.ParseUint(match[1], 10, 64)
+		b.segments = append(b.segments, &bufferSegment{
+			path: filepath.Join(config.Path, info.Name()),
+			size: info.Size(),
+		})
+		b.size += info.Size()
+		b.nextSeq = seq + 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go b.run(ctx)
+
+	return b, nil
+}
+
+// Append adds a formatted entry to the buffer. When the entry cannot be
+// buffered it is dropped, or an error is returned if the buffer fails closed.
+func (b *Buffer) Append(entry []byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	err := b.append(entry)
+	if err == nil {
+		return nil
+	}
+
+	metrics.IncrCounter([]string{"audit", b.name, "buffer_dropped"}, 1)
+	if b.config.FailPolicy == FailPolicyOpen {
+		return nil
+	}
+	return err
+}
+
+func (b *Buffer) append(entry []byte) error {
+	recordSize := int64(bufferRecordHeaderSize + len(entry))
+	if b.size+recordSize > b.config.MaxBytes {
+		return ErrBufferFull
+	}
+
+	if b.current == nil {
+		path := filepath.Join(b.config.Path, fmt.Sprintf("%020d%s", b.nextSeq, bufferSegmentExt))
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		b.nextSeq++
+		b.current = &bufferSegment{
+			path:    path,
+			f:       f,
+			created: time.Now(),
+		}
+	}
+
+	record := make([]byte, recordSize)
+	binary.BigEndian.PutUint32(record, uint32(len(entry)))
+	copy(record[bufferRecordHeaderSize:], entry)
+	n, err := b.current.f.Write(record)
+	b.current.size += int64(n)
+	b.size += int64(n)
+	if err != nil {
+		// The partial record is skipped when the segment is read
+		b.sealCurrent()
+		return err
+	}
+
+	b.current.entries++
+	if b.current.entries >= b.config.BatchEntries || b.current.size >= b.config.BatchBytes {
+		b.sealCurrent()
+	}
+	return nil
+}
+
+// sealCurrent queues the current segment for sending. The lock must be held
+// before calling this.
+func (b *Buffer) sealCurrent() {
+	if b.current == nil {
+		return
+	}
+	b.current.f.Close()
+	b.current.f = nil
+	b.segments = append(b.segments, b.current)
+	b.current = nil
+
+	select {
+	case b.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops sending the batches. The buffered entries are kept on disk and
+// sent by the next buffer opened in the directory.
+func (b *Buffer) Close() error {
+	select {
+	case <-b.stop:
+		return nil
+	default:
+	}
+	close(b.stop)
+	b.cancel()
+	<-b.done
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.current != nil {
+		b.current.f.Close()
+		b.current = nil
+	}
+	return nil
+}
+
+func (b *Buffer) run(ctx context.Context) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.config.BatchInterval / 2)
+	defer ticker.Stop()
+
+	backoff := time.Duration(0)
+	for {
+		b.lock.Lock()
+		if b.current != nil && time.Since(b.current.created) >= b.config.BatchInterval {
+			b.sealCurrent()
+		}
+		var segment *bufferSegment
+		if len(b.segments) > 0 {
+			segment = b.segments[0]
+		}
+		b.lock.Unlock()
+
+		if segment == nil {
+			select {
+			case <-b.stop:
+				return
+			case <-b.wake:
+			case <-ticker.C:
+			}
+			continue
+		}
+
+		err := b.sendSegment(ctx, segment)
+		if err == nil {
+			backoff = 0
+			continue
+		}
+
+		metrics.IncrCounter([]string{"audit", b.name, "send_failure"}, 1)
+		switch {
+		case backoff == 0:
+			backoff = b.config.RetryMin
+		case backoff < b.config.RetryMax:
+			backoff *= 2
+			if backoff > b.config.RetryMax {
+				backoff = b.config.RetryMax
+			}
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-b.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// sendSegment sends the entries of the segment and removes it once they are
+// delivered. A segment which cannot be read is removed as it would otherwise
+// block the ones after it.
+func (b *Buffer) sendSegment(ctx context.Context, segment *bufferSegment) error {
+	entries, readErr := readBufferSegment(segment.path, b.config.MaxBytes)
+	if readErr == nil && len(entries) > 0 {
+		start := time.Now()
+		if err := b.send(ctx, entries); err != nil {
+			return err
+		}
+		metrics.MeasureSince([]string{"audit", b.name, "send"}, start)
+	}
+	if readErr != nil {
+		metrics.IncrCounter([]string{"audit", b.name, "buffer_unreadable"}, 1)
+	}
+
+	if err := os.Remove(segment.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	b.lock.Lock()
+	b.segments = b.segments[1:]
+	b.size -= segment.size
+	b.lock.Unlock()
+	return nil
+}
+
+// readBufferSegment returns the entries of a segment. A record cut short by a
+// failed write or a crash ends the segment.
+func readBufferSegment(path string, maxSize int64) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries [][]byte
+	reader := bufio.NewReader(f)
+	header := make([]byte, bufferRecordHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return entries, nil
+			}
+			return nil, err
+		}
+		size := binary.BigEndian.Uint32(header)
+		if int64(size) > maxSize {
+			return nil, fmt.Errorf("invalid entry size %d in %q", size, path)
+		}
+		entry := make([]byte, size)
+		if _, err := io.ReadFull(reader, entry); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return entries, nil
+			}
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
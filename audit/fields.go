@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jiangjiali/vault/sdk/helper/strutil"
+)
+
+// FieldRules are the fields an audit device removes from its entries or
+// always hashes, even in raw mode or when the mount lists them as non-HMAC
+// keys. Fields are given as request.data.<key> or response.data.<key>, or
+// request.data and response.data for the whole data.
+type FieldRules struct {
+	exclude []fieldRef
+	hmac    []fieldRef
+}
+
+type fieldRef struct {
+	response bool
+
+	// key is the data key, or empty for the whole data
+	key string
+}
+
+func (f fieldRef) matches(key string) bool {
+	return f.key == "" || f.key == key
+}
+
+// ParseFieldRules parses the comma separated lists of fields to exclude and
+// to hash. It returns nil if both are empty.
+func ParseFieldRules(exclude, hmac string) (*FieldRules, error) {
+	var rules FieldRules
+	var err error
+	if rules.exclude, err = parseFieldRefs(exclude); err != nil {
+		return nil, err
+	}
+	if rules.hmac, err = parseFieldRefs(hmac); err != nil {
+		return nil, err
+	}
+	if len(rules.exclude) == 0 && len(rules.hmac) == 0 {
+		return nil, nil
+	}
+	return &rules, nil
+}
+
+func parseFieldRefs(list string) ([]fieldRef, error) {
+	var refs []fieldRef
+	for _, field := range strutil.ParseDedupAndSortStrings(list, ",") {
+		var ref fieldRef
+		switch {
+		case field == "request.data":
+		case field == "response.data":
+			ref.response = true
+		case strings.HasPrefix(field, "request.data."):
+			ref.key = strings.TrimPrefix(field, "request.data.")
+		case strings.HasPrefix(field, "response.data."):
+			ref.response = true
+			ref.key = strings.TrimPrefix(field, "response.data.")
+		default:
+			return nil, fmt.Errorf("invalid field %q, expected request.data[.<key>] or response.data[.<key>]", field)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// Apply returns a copy of the input with the rules applied; the input is not
+// modified. The fields to hash are removed from the non-HMAC keys so that the
+// formatter hashes them, or are hashed with the given function when the
+// device logs raw entries since the formatter then hashes nothing.
+func (r *FieldRules) Apply(in *LogInput, raw bool, hash func(string) (string, error)) (*LogInput, error) {
+	out := *in
+
+	var reqData, respData map[string]interface{}
+	if in.Request != nil {
+		req := *in.Request
+		req.Data = copyData(req.Data)
+		reqData = req.Data
+		out.Request = &req
+	}
+	if in.Response != nil {
+		resp := *in.Response
+		resp.Data = copyData(resp.Data)
+		respData = resp.Data
+		out.Response = &resp
+	}
+
+	for _, ref := range r.exclude {
+		data := reqData
+		if ref.response {
+			data = respData
+		}
+		for key := range data {
+			if ref.matches(key) {
+				delete(data, key)
+			}
+		}
+	}
+
+	for _, ref := range r.hmac {
+		data, nonHMACKeys := reqData, &out.NonHMACReqDataKeys
+		if ref.response {
+			data, nonHMACKeys = respData, &out.NonHMACRespDataKeys
+		}
+
+		if !raw {
+			var keys []string
+			for _, key := range *nonHMACKeys {
+				if !ref.matches(key) {
+					keys = append(keys, key)
+				}
+			}
+			*nonHMACKeys = keys
+			continue
+		}
+
+		for key, value := range data {
+			if !ref.matches(key) {
+				continue
+			}
+			hashed, err := hashValue(value, hash)
+			if err != nil {
+				return nil, err
+			}
+			data[key] = hashed
+		}
+	}
+
+	return &out, nil
+}
+
+func copyData(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		return nil
+	}
+	cp := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		cp[k] = v
+	}
+	return cp
+}
+
+// hashValue hashes a string, or the JSON encoding of other values
+func hashValue(value interface{}, hash func(string) (string, error)) (string, error) {
+	if s, ok := value.(string); ok {
+		return hash(s)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return hash(string(encoded))
+}
@@ -0,0 +1,319 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/jiangjiali/vault/sdk/helper/glob"
+	"github.com/jiangjiali/vault/sdk/helper/namespace"
+)
+
+// FilterFields are the fields a filter expression can test:
+//
+//	type         "request" or "response"
+//	path         the path of the request, relative to its namespace
+//	operation    the operation of the request
+//	mount_path   the path of the mount handling the request
+//	mount_type   the type of the mount handling the request
+//	namespace    the path of the namespace of the request, empty for the root
+//	auth_method  the path the client token was created at, such as
+//	             auth/userpass/login/alice, or the path of a login request
+//	error        whether the entry carries an error
+var FilterFields = []string{
+	"type",
+	"path",
+	"operation",
+	"mount_path",
+	"mount_type",
+	"namespace",
+	"auth_method",
+	"error",
+}
+
+// Filter is a boolean expression selecting the entries an audit device logs.
+// Comparisons are written as `field == "value"`, `field != "value"` or
+// `field matches "glob*"` and are combined with `and`, `or`, `not` and
+// parentheses. The bare field `error` is true for entries carrying an error.
+//
+//	mount_type != "transit" or error
+type Filter struct {
+	expr string
+	root filterNode
+}
+
+// ParseFilter parses a filter expression
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := tokenizeFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok != nil {
+		return nil, fmt.Errorf("unexpected %q in filter at offset %d", tok.value, tok.pos)
+	}
+	return &Filter{
+		expr: expr,
+		root: root,
+	}, nil
+}
+
+// String returns the expression of the filter
+func (f *Filter) String() string {
+	return f.expr
+}
+
+// Evaluate returns whether the entry with the given field values matches the
+// filter. Missing fields are empty.
+func (f *Filter) Evaluate(values map[string]string) bool {
+	return f.root.eval(values)
+}
+
+// FilterValues returns the values of the filter fields for the entry of the
+// given type built from the input.
+func FilterValues(ctx context.Context, entryType string, in *LogInput) map[string]string {
+	values := map[string]string{
+		"type":  entryType,
+		"error": "false",
+	}
+
+	if ns, err := namespace.FromContext(ctx); err == nil {
+		values["namespace"] = ns.Path
+	}
+
+	if in.OuterErr != nil || (in.Response != nil && in.Response.IsError()) {
+		values["error"] = "true"
+	}
+
+	if req := in.Request; req != nil {
+		values["path"] = req.Path
+		values["operation"] = string(req.Operation)
+		values["mount_path"] = req.MountPoint
+		values["mount_type"] = req.MountType
+
+		switch {
+		case req.TokenEntry() != nil:
+			values["auth_method"] = req.TokenEntry().Path
+		case strings.HasPrefix(req.Path, "auth/"):
+			values["auth_method"] = req.Path
+		}
+	}
+
+	return values
+}
+
+type filterNode interface {
+	eval(values map[string]string) bool
+}
+
+type filterAnd struct{ left, right filterNode }
+
+func (n *filterAnd) eval(values map[string]string) bool {
+	return n.left.eval(values) && n.right.eval(values)
+}
+
+type filterOr struct{ left, right filterNode }
+
+func (n *filterOr) eval(values map[string]string) bool {
+	return n.left.eval(values) || n.right.eval(values)
+}
+
+type filterNot struct{ node filterNode }
+
+func (n *filterNot) eval(values map[string]string) bool {
+	return !n.node.eval(values)
+}
+
+type filterCompare struct {
+	field string
+	op    string
+	value string
+}
+
+func (n *filterCompare) eval(values map[string]string) bool {
+	actual := values[n.field]
+	switch n.op {
+	case "==":
+		return actual == n.value
+	case "!=":
+		return actual != n.value
+	case "matches":
+		return glob.Glob(n.value, actual)
+	}
+	return false
+}
+
+type filterToken struct {
+	value  string
+	quoted bool
+	pos    int
+}
+
+func tokenizeFilter(expr string) ([]*filterToken, error) {
+	var tokens []*filterToken
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '(' || c == ')':
+			tokens = append(tokens, &filterToken{value: string(c), pos: i})
+			i++
+
+		case c == '=' || c == '!':
+			if i+1 >= len(expr) || expr[i+1] != '=' {
+				return nil, fmt.Errorf("invalid operator in filter at offset %d", i)
+			}
+			tokens = append(tokens, &filterToken{value: expr[i : i+2], pos: i})
+			i += 2
+
+		case c == '"':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(expr) && expr[j] != '"'; j++ {
+				if expr[j] == '\\' && j+1 < len(expr) {
+					j++
+				}
+				sb.WriteByte(expr[j])
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("unterminated string in filter at offset %d", i)
+			}
+			tokens = append(tokens, &filterToken{value: sb.String(), quoted: true, pos: i})
+			i = j + 1
+
+		default:
+			j := i
+			for j < len(expr) && !unicode.IsSpace(rune(expr[j])) && !strings.ContainsRune(`()=!"`, rune(expr[j])) {
+				j++
+			}
+			tokens = append(tokens, &filterToken{value: expr[i:j], pos: i})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []*filterToken
+	pos    int
+}
+
+func (p *filterParser) peek() *filterToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() *filterToken {
+	tok := p.peek()
+	if tok != nil {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) keyword(word string) bool {
+	tok := p.peek()
+	if tok != nil && !tok.quoted && strings.EqualFold(tok.value, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterOr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterAnd{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.keyword("not") {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{node: node}, nil
+	}
+
+	tok := p.next()
+	switch {
+	case tok == nil:
+		return nil, fmt.Errorf("unexpected end of filter")
+
+	case !tok.quoted && tok.value == "(":
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing == nil || closing.quoted || closing.value != ")" {
+			return nil, fmt.Errorf("missing closing parenthesis in filter for offset %d", tok.pos)
+		}
+		return node, nil
+
+	case tok.quoted || !isFilterField(tok.value):
+		return nil, fmt.Errorf("unknown field %q in filter at offset %d", tok.value, tok.pos)
+	}
+
+	field := tok.value
+	op := p.peek()
+	if op == nil || op.quoted || (op.value != "==" && op.value != "!=" && !strings.EqualFold(op.value, "matches")) {
+		if field == "error" {
+			return &filterCompare{field: field, op: "==", value: "true"}, nil
+		}
+		return nil, fmt.Errorf("missing operator after %q in filter at offset %d", field, tok.pos)
+	}
+	p.pos++
+
+	value := p.next()
+	if value == nil || (!value.quoted && strings.ContainsAny(value.value, "()")) {
+		return nil, fmt.Errorf("missing value after %q in filter at offset %d", op.value, op.pos)
+	}
+
+	return &filterCompare{
+		field: field,
+		op:    strings.ToLower(op.value),
+		value: value.value,
+	}, nil
+}
+
+func isFilterField(name string) bool {
+	for _, field := range FilterFields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
@@ -397,6 +397,10 @@ type AuditRequestEntry struct {
 	Auth    AuditAuth    `json:"auth"`
 	Request AuditRequest `json:"request"`
 	Error   string       `json:"error"`
+
+	// Sequence and PrevHash link the entry when the device chains its entries
+	Sequence uint64 `json:"sequence,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
 }
 
 // AuditResponseEntry is the structure of a response audit log entry in Audit.
@@ -407,6 +411,10 @@ type AuditResponseEntry struct {
 	Request  AuditRequest  `json:"request"`
 	Response AuditResponse `json:"response"`
 	Error    string        `json:"error"`
+
+	// Sequence and PrevHash link the entry when the device chains its entries
+	Sequence uint64 `json:"sequence,omitempty"`
+	PrevHash string `json:"prev_hash,omitempty"`
 }
 
 type AuditRequest struct {
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/salt"
+	"github.com/jiangjiali/vault/sdk/version"
+)
+
+const (
+	cefVendor  = "Vault"
+	cefProduct = "Vault"
+
+	// cefSeverity and cefErrorSeverity are the severities of the entries,
+	// on the 0 to 10 scale of CEF
+	cefSeverity      = 3
+	cefErrorSeverity = 7
+)
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\n", " ", "\r", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+)
+
+// CEFFormatWriter is an AuditFormatWriter implementation that structures data
+// into the ArcSight Common Event Format. The request and its outcome are
+// mapped to the standard extension keys and the rest of the entry to custom
+// string keys; the data of requests and responses is not written.
+type CEFFormatWriter struct {
+	Prefix   string
+	SaltFunc func(context.Context) (*salt.Salt, error)
+}
+
+func (f *CEFFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) error {
+	if req == nil {
+		return fmt.Errorf("request entry was nil, cannot encode")
+	}
+
+	return f.write(w, req.Type, req.Time, &req.Auth, &req.Request, req.Error)
+}
+
+func (f *CEFFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry) error {
+	if resp == nil {
+		return fmt.Errorf("response entry was nil, cannot encode")
+	}
+
+	return f.write(w, resp.Type, resp.Time, &resp.Auth, &resp.Request, resp.Error)
+}
+
+func (f *CEFFormatWriter) Salt(ctx context.Context) (*salt.Salt, error) {
+	return f.SaltFunc(ctx)
+}
+
+func (f *CEFFormatWriter) write(w io.Writer, entryType, entryTime string, auth *AuditAuth, req *AuditRequest, errString string) error {
+	severity, outcome := cefSeverity, "success"
+	if errString != "" {
+		severity, outcome = cefErrorSeverity, "failure"
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(f.Prefix)
+	fmt.Fprintf(&buf, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeaderEscaper.Replace(cefVendor),
+		cefHeaderEscaper.Replace(cefProduct),
+		cefHeaderEscaper.Replace(version.GetVersion().VersionNumber()),
+		cefHeaderEscaper.Replace(entryType),
+		cefHeaderEscaper.Replace(fmt.Sprintf("%s %s", req.Operation, req.Namespace.Path+req.Path)),
+		severity)
+
+	var ext []string
+	add := func(key, value string) {
+		if value != "" {
+			ext = append(ext, key+"="+cefExtensionEscaper.Replace(value))
+		}
+	}
+	if entryTime != "" {
+		if t, err := time.Parse(time.RFC3339Nano, entryTime); err == nil {
+			add("rt", fmt.Sprintf("%d", t.UnixNano()/int64(time.Millisecond)))
+		}
+	}
+	add("act", string(req.Operation))
+	add("request", req.Path)
+	add("src", req.RemoteAddr)
+	add("suser", auth.DisplayName)
+	add("outcome", outcome)
+	add("reason", errString)
+	add("externalId", req.ID)
+	for i, custom := range []struct{ label, value string }{
+		{"namespace", req.Namespace.Path},
+		{"accessor", req.ClientTokenAccessor},
+		{"entity_id", auth.EntityID},
+		{"policies", strings.Join(auth.Policies, ",")},
+		{"client_token", req.ClientToken},
+	} {
+		if custom.value != "" {
+			add(fmt.Sprintf("cs%dLabel", i+1), custom.label)
+			add(fmt.Sprintf("cs%d", i+1), custom.value)
+		}
+	}
+	buf.WriteString(strings.Join(ext, " "))
+	buf.WriteByte('\n')
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
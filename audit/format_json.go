@@ -14,6 +14,9 @@ import (
 type JSONFormatWriter struct {
 	Prefix   string
 	SaltFunc func(context.Context) (*salt.Salt, error)
+
+	// HashChain links the entries written if set
+	HashChain *HashChain
 }
 
 func (f *JSONFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) error {
@@ -21,6 +24,10 @@ func (f *JSONFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) err
 		return fmt.Errorf("request entry was nil, cannot encode")
 	}
 
+	if f.HashChain != nil {
+		return f.writeChained(w, req)
+	}
+
 	if len(f.Prefix) > 0 {
 		_, err := w.Write([]byte(f.Prefix))
 		if err != nil {
@@ -37,6 +44,10 @@ func (f *JSONFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry)
 		return fmt.Errorf("response entry was nil, cannot encode")
 	}
 
+	if f.HashChain != nil {
+		return f.writeChained(w, resp)
+	}
+
 	if len(f.Prefix) > 0 {
 		_, err := w.Write([]byte(f.Prefix))
 		if err != nil {
@@ -48,6 +59,15 @@ func (f *JSONFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry)
 	return enc.Encode(resp)
 }
 
+func (f *JSONFormatWriter) writeChained(w io.Writer, entry chainedEntry) error {
+	// The salt has already been loaded by the formatter at this point
+	salt, err := f.SaltFunc(context.Background())
+	if err != nil {
+		return err
+	}
+	return f.HashChain.write(w, f.Prefix, HashChainKey(salt), entry)
+}
+
 func (f *JSONFormatWriter) Salt(ctx context.Context) (*salt.Salt, error) {
 	return f.SaltFunc(ctx)
 }
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jiangjiali/vault/sdk/helper/salt"
+)
+
+const jsonxHeader = `<?xml version="1.0" encoding="UTF-8"?>`
+
+const jsonxNamespaces = ` xsi:schemaLocation="http://www.datapower.com/schemas/json jsonx.xsd"` +
+	` xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"` +
+	` xmlns:json="http://www.ibm.com/xmlns/prod/2009/jsonx"`
+
+// JSONxFormatWriter is an AuditFormatWriter implementation that structures data into
+// a XML format, following the JSONx representation of the JSON format. Every
+// entry is written as a document on a single line.
+type JSONxFormatWriter struct {
+	Prefix   string
+	SaltFunc func(context.Context) (*salt.Salt, error)
+}
+
+func (f *JSONxFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) error {
+	if req == nil {
+		return fmt.Errorf("request entry was nil, cannot encode")
+	}
+
+	return f.write(w, req)
+}
+
+func (f *JSONxFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry) error {
+	if resp == nil {
+		return fmt.Errorf("response entry was nil, cannot encode")
+	}
+
+	return f.write(w, resp)
+}
+
+func (f *JSONxFormatWriter) Salt(ctx context.Context) (*salt.Salt, error) {
+	return f.SaltFunc(ctx)
+}
+
+func (f *JSONxFormatWriter) write(w io.Writer, entry interface{}) error {
+	value, err := genericEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(f.Prefix)
+	buf.WriteString(jsonxHeader)
+	if err := writeJSONx(&buf, "", value, true); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// genericEntry converts an entry to the values decoded from its JSON encoding
+func genericEntry(entry interface{}) (interface{}, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// writeJSONx writes the JSONx element of a decoded JSON value. Object members
+// are written in the order of their names.
+func writeJSONx(buf *bytes.Buffer, name string, value interface{}, root bool) error {
+	var kind string
+	switch value.(type) {
+	case map[string]interface{}:
+		kind = "object"
+	case []interface{}:
+		kind = "array"
+	case string:
+		kind = "string"
+	case json.Number:
+		kind = "number"
+	case bool:
+		kind = "boolean"
+	case nil:
+		kind = "null"
+	default:
+		return fmt.Errorf("unsupported JSON value of type %T", value)
+	}
+
+	buf.WriteString("<json:" + kind)
+	if root {
+		buf.WriteString(jsonxNamespaces)
+	}
+	if name != "" {
+		buf.WriteString(` name="`)
+		if err := xml.EscapeText(buf, []byte(name)); err != nil {
+			return err
+		}
+		buf.WriteString(`"`)
+	}
+	if value == nil {
+		buf.WriteString("/>")
+		return nil
+	}
+	buf.WriteString(">")
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := writeJSONx(buf, k, v[k], false); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if err := writeJSONx(buf, "", elem, false); err != nil {
+				return err
+			}
+		}
+	case string:
+		if err := xml.EscapeText(buf, []byte(v)); err != nil {
+			return err
+		}
+	case json.Number:
+		buf.WriteString(v.String())
+	case bool:
+		fmt.Fprintf(buf, "%t", v)
+	}
+
+	buf.WriteString("</json:" + kind + ">")
+	return nil
+}
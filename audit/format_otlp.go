@@ -0,0 +1,175 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/salt"
+	"github.com/jiangjiali/vault/sdk/version"
+)
+
+const (
+	otlpScopeName = "vault.audit"
+
+	// otlpSeverityInfo and otlpSeverityError are the severity numbers of the
+	// INFO and ERROR levels of the OpenTelemetry log data model
+	otlpSeverityInfo  = 9
+	otlpSeverityError = 17
+)
+
+// OTLPFormatWriter is an AuditFormatWriter implementation that structures data
+// as OpenTelemetry log records. Every entry is written on a single line as the
+// JSON encoding of an OTLP logs export request holding one record, whose body
+// is the entry and whose attributes identify the request.
+type OTLPFormatWriter struct {
+	Prefix   string
+	SaltFunc func(context.Context) (*salt.Salt, error)
+}
+
+func (f *OTLPFormatWriter) WriteRequest(w io.Writer, req *AuditRequestEntry) error {
+	if req == nil {
+		return fmt.Errorf("request entry was nil, cannot encode")
+	}
+
+	return f.write(w, req, req.Type, req.Time, &req.Auth, &req.Request, req.Error)
+}
+
+func (f *OTLPFormatWriter) WriteResponse(w io.Writer, resp *AuditResponseEntry) error {
+	if resp == nil {
+		return fmt.Errorf("response entry was nil, cannot encode")
+	}
+
+	return f.write(w, resp, resp.Type, resp.Time, &resp.Auth, &resp.Request, resp.Error)
+}
+
+func (f *OTLPFormatWriter) Salt(ctx context.Context) (*salt.Salt, error) {
+	return f.SaltFunc(ctx)
+}
+
+func (f *OTLPFormatWriter) write(w io.Writer, entry interface{}, entryType, entryTime string, auth *AuditAuth, req *AuditRequest, errString string) error {
+	value, err := genericEntry(entry)
+	if err != nil {
+		return err
+	}
+
+	observed := time.Now()
+	record := map[string]interface{}{
+		"observedTimeUnixNano": strconv.FormatInt(observed.UnixNano(), 10),
+		"severityNumber":       otlpSeverityInfo,
+		"severityText":         "INFO",
+		"body":                 otlpValue(value),
+	}
+	if entryTime != "" {
+		if t, err := time.Parse(time.RFC3339Nano, entryTime); err == nil {
+			record["timeUnixNano"] = strconv.FormatInt(t.UnixNano(), 10)
+		}
+	}
+	if errString != "" {
+		record["severityNumber"] = otlpSeverityError
+		record["severityText"] = "ERROR"
+	}
+
+	var attrs []interface{}
+	add := func(key, value string) {
+		if value != "" {
+			attrs = append(attrs, otlpAttribute(key, value))
+		}
+	}
+	add("vault.audit.type", entryType)
+	add("vault.request.id", req.ID)
+	add("vault.request.operation", string(req.Operation))
+	add("vault.request.path", req.Path)
+	add("vault.request.remote_address", req.RemoteAddr)
+	add("vault.namespace.path", req.Namespace.Path)
+	add("vault.auth.accessor", req.ClientTokenAccessor)
+	add("vault.auth.display_name", auth.DisplayName)
+	add("vault.auth.entity_id", auth.EntityID)
+	add("vault.error", errString)
+	record["attributes"] = attrs
+
+	export := map[string]interface{}{
+		"resourceLogs": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{
+						otlpAttribute("service.name", "vault"),
+						otlpAttribute("service.version", version.GetVersion().VersionNumber()),
+					},
+				},
+				"scopeLogs": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{
+							"name": otlpScopeName,
+						},
+						"logRecords": []interface{}{record},
+					},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(f.Prefix)
+	if err := json.NewEncoder(&buf).Encode(export); err != nil {
+		return err
+	}
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func otlpAttribute(key, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"key":   key,
+		"value": map[string]interface{}{"stringValue": value},
+	}
+}
+
+// otlpValue converts a decoded JSON value to an OTLP AnyValue. Integers are
+// encoded as strings as the OTLP JSON encoding requires for 64 bit values.
+func otlpValue(value interface{}) map[string]interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		values := make([]interface{}, 0, len(keys))
+		for _, k := range keys {
+			values = append(values, map[string]interface{}{
+				"key":   k,
+				"value": otlpValue(v[k]),
+			})
+		}
+		return map[string]interface{}{"kvlistValue": map[string]interface{}{"values": values}}
+	case []interface{}:
+		values := make([]interface{}, 0, len(v))
+		for _, elem := range v {
+			values = append(values, otlpValue(elem))
+		}
+		return map[string]interface{}{"arrayValue": map[string]interface{}{"values": values}}
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case json.Number:
+		if !strings.ContainsAny(v.String(), ".eE") {
+			if _, err := v.Int64(); err == nil {
+				return map[string]interface{}{"intValue": v.String()}
+			}
+		}
+		if d, err := v.Float64(); err == nil {
+			return map[string]interface{}{"doubleValue": d}
+		}
+		return map[string]interface{}{"stringValue": v.String()}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	}
+	return map[string]interface{}{}
+}
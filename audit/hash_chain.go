@@ -0,0 +1,256 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/jiangjiali/vault/sdk/helper/salt"
+)
+
+// HashChainKeyInput is hashed with the salt of an audit device to derive the
+// key of its hash chain. The key of a device can thus be retrieved with its
+// sys/audit-hash endpoint in order to verify its log.
+const HashChainKeyInput = "audit-hash-chain"
+
+// hashChainField is the last field of a chained entry. It is appended to the
+// encoded entry so that the hash covers every byte before it.
+const hashChainField = `,"hash":"`
+
+// HashChain links the entries written by an audit device: every entry carries
+// a sequence number and the hash of the previous entry, and is itself hashed
+// with a key derived from the salt of the device. Removing, reordering or
+// modifying entries breaks the chain, which is detected by HashChainVerifier.
+type HashChain struct {
+	lock     sync.Mutex
+	sequence uint64
+	prevHash string
+}
+
+// NewHashChain returns a chain starting at the first sequence number.
+func NewHashChain() *HashChain {
+	return &HashChain{}
+}
+
+// Resume continues the chain after the given entry, which should be the last
+// one written by the device. Entries which are not chained are ignored.
+func (c *HashChain) Resume(line []byte, prefix string) error {
+	entry, ok, err := parseChainedEntry(line, prefix)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sequence = entry.Sequence
+	c.prevHash = entry.Hash
+	return nil
+}
+
+// HashChainKey derives the key of the hash chain from the salt of the device.
+func HashChainKey(salt *salt.Salt) string {
+	return HashString(salt, HashChainKeyInput)
+}
+
+// write links the entry to the chain and writes it, after the prefix, as a
+// single line. The chain only advances if the write succeeded.
+func (c *HashChain) write(w io.Writer, prefix, key string, entry chainedEntry) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	sequence := c.sequence + 1
+	entry.setLink(sequence, c.prevHash)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	hash := hashChainEntry(key, data)
+
+	var buf bytes.Buffer
+	buf.WriteString(prefix)
+	buf.Write(data[:len(data)-1])
+	buf.WriteString(hashChainField)
+	buf.WriteString(hash)
+	buf.WriteString("\"}\n")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	c.sequence = sequence
+	c.prevHash = hash
+	return nil
+}
+
+// chainedEntry is implemented by the entries which can be linked in a chain
+type chainedEntry interface {
+	setLink(sequence uint64, prevHash string)
+}
+
+func (e *AuditRequestEntry) setLink(sequence uint64, prevHash string) {
+	e.Sequence = sequence
+	e.PrevHash = prevHash
+}
+
+func (e *AuditResponseEntry) setLink(sequence uint64, prevHash string) {
+	e.Sequence = sequence
+	e.PrevHash = prevHash
+}
+
+func hashChainEntry(key string, data []byte) string {
+	hm := hmac.New(sha256.New, []byte(key))
+	hm.Write(data)
+	return "hmac-sha256:" + hex.EncodeToString(hm.Sum(nil))
+}
+
+// hashChainLink is the part of a chained entry needed to verify it
+type hashChainLink struct {
+	Sequence uint64 `json:"sequence"`
+	PrevHash string `json:"prev_hash"`
+
+	// Hash and body are split from the line rather than decoded
+	Hash string `json:"-"`
+	body []byte
+}
+
+// parseChainedEntry splits a line written by HashChain into the hashed body
+// and the hash. It returns false if the entry is not chained.
+func parseChainedEntry(line []byte, prefix string) (*hashChainLink, bool, error) {
+	line = bytes.TrimSpace(bytes.TrimPrefix(line, []byte(prefix)))
+	idx := bytes.LastIndex(line, []byte(hashChainField))
+	if idx < 0 || !bytes.HasSuffix(line, []byte("\"}")) {
+		return nil, false, nil
+	}
+
+	body := make([]byte, 0, idx+1)
+	body = append(body, line[:idx]...)
+	body = append(body, '}')
+
+	var entry hashChainLink
+	if err := json.Unmarshal(body, &entry); err != nil {
+		return nil, false, fmt.Errorf("malformed entry: %v", err)
+	}
+	if entry.Sequence == 0 {
+		return nil, false, nil
+	}
+	entry.Hash = string(line[idx+len(hashChainField) : len(line)-2])
+	entry.body = body
+	return &entry, true, nil
+}
+
+// HashChainProblem is a break in the chain found by the verifier
+type HashChainProblem struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (p *HashChainProblem) String() string {
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// HashChainVerifier checks the chain of the entries of an audit log, which can
+// span several files given in order.
+type HashChainVerifier struct {
+	// Key is the key of the chain, see HashChainKey
+	Key string
+
+	// Prefix is the prefix configured on the device, if any
+	Prefix string
+
+	// Entries is the number of chained entries read
+	Entries int
+
+	// Unchained is the number of entries preceding the chain
+	Unchained int
+
+	// FirstSequence and LastSequence are the bounds of the chain read
+	FirstSequence uint64
+	LastSequence  uint64
+
+	// Restarts are the places where a new chain was started, which happens
+	// when the device cannot resume the previous one
+	Restarts []*HashChainProblem
+
+	// Problems are the gaps, reordered entries and modified entries found
+	Problems []*HashChainProblem
+
+	started  bool
+	prevHash string
+}
+
+// Verify reads the entries of the given file and checks them against the
+// chain read so far.
+func (v *HashChainVerifier) Verify(name string, r io.Reader) error {
+	reader := bufio.NewReader(r)
+	for lineNum := 1; ; lineNum++ {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			v.verifyLine(name, lineNum, line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (v *HashChainVerifier) verifyLine(name string, lineNum int, line []byte) {
+	problem := func(format string, args ...interface{}) {
+		v.Problems = append(v.Problems, &HashChainProblem{
+			File:    name,
+			Line:    lineNum,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	entry, ok, err := parseChainedEntry(line, v.Prefix)
+	switch {
+	case err != nil:
+		problem("%v", err)
+		return
+	case !ok && v.started:
+		problem("entry is not part of the chain")
+		return
+	case !ok:
+		v.Unchained++
+		return
+	}
+
+	v.Entries++
+	if !hmac.Equal([]byte(hashChainEntry(v.Key, entry.body)), []byte(entry.Hash)) {
+		problem("entry %d was modified", entry.Sequence)
+	}
+
+	switch {
+	case !v.started:
+		v.started = true
+		v.FirstSequence = entry.Sequence
+	case entry.Sequence == 1 && entry.PrevHash == "":
+		v.Restarts = append(v.Restarts, &HashChainProblem{
+			File:    name,
+			Line:    lineNum,
+			Message: fmt.Sprintf("chain restarted after entry %d", v.LastSequence),
+		})
+	case entry.Sequence <= v.LastSequence:
+		problem("entry %d is out of order after entry %d", entry.Sequence, v.LastSequence)
+	case entry.Sequence > v.LastSequence+1:
+		problem("%d entries missing between entries %d and %d", entry.Sequence-v.LastSequence-1, v.LastSequence, entry.Sequence)
+	case entry.PrevHash != v.prevHash:
+		problem("entry %d does not follow entry %d", entry.Sequence, v.LastSequence)
+	}
+
+	v.LastSequence = entry.Sequence
+	v.prevHash = entry.Hash
+}
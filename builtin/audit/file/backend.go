@@ -3,12 +3,14 @@ package file
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/jiangjiali/vault/audit"
 	"github.com/jiangjiali/vault/sdk/helper/errwrap"
@@ -45,7 +47,7 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		format = "json"
 	}
 	switch format {
-	case "json":
+	case "json", "jsonx", "cef", "otlp":
 	default:
 		return nil, fmt.Errorf("unknown format type %q", format)
 	}
@@ -70,6 +72,12 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		logRaw = b
 	}
 
+	// Check if the entries are chained
+	hashChain, err := audit.ParseHashChain(conf.Config, format)
+	if err != nil {
+		return nil, err
+	}
+
 	// Check if mode is provided
 	mode := os.FileMode(0600)
 	if modeRaw, ok := conf.Config["mode"]; ok {
@@ -82,11 +90,35 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		}
 	}
 
+	rotation, err := parseRotationConfig(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+	switch path {
+	case "stdout", "discard":
+		if rotation.enabled() {
+			return nil, fmt.Errorf("rotation is only supported when writing to a file")
+		}
+	}
+
+	// Check the policy applied when writing to the file fails
+	reopenPolicy := reopenPolicyOnce
+	if policyRaw, ok := conf.Config["reopen_policy"]; ok {
+		switch policyRaw {
+		case reopenPolicyOnce, reopenPolicyNever, reopenPolicyBackoff:
+			reopenPolicy = policyRaw
+		default:
+			return nil, fmt.Errorf("unknown reopen_policy %q", policyRaw)
+		}
+	}
+
 	b := &Backend{
-		path:       path,
-		mode:       mode,
-		saltConfig: conf.SaltConfig,
-		saltView:   conf.SaltView,
+		path:         path,
+		mode:         mode,
+		rotation:     rotation,
+		reopenPolicy: reopenPolicy,
+		saltConfig:   conf.SaltConfig,
+		saltView:     conf.SaltView,
 		formatConfig: audit.FormatterConfig{
 			Raw:          logRaw,
 			HMACAccessor: hmacAccessor,
@@ -96,6 +128,22 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 	switch format {
 	case "json":
 		b.formatter.AuditFormatWriter = &audit.JSONFormatWriter{
+			Prefix:    conf.Config["prefix"],
+			SaltFunc:  b.Salt,
+			HashChain: hashChain,
+		}
+	case "jsonx":
+		b.formatter.AuditFormatWriter = &audit.JSONxFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "cef":
+		b.formatter.AuditFormatWriter = &audit.CEFFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "otlp":
+		b.formatter.AuditFormatWriter = &audit.OTLPFormatWriter{
 			Prefix:   conf.Config["prefix"],
 			SaltFunc: b.Salt,
 		}
@@ -111,6 +159,19 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		if err := b.open(); err != nil {
 			return nil, errwrap.Wrapf(fmt.Sprintf("sanity check failed; unable to open %q for writing: {{err}}", path), err)
 		}
+
+		// Continue the chain of the existing log. If its last entry cannot be
+		// read a new chain is started, which the verification reports.
+		if hashChain != nil {
+			if line, err := b.lastEntry(); err == nil {
+				hashChain.Resume(line, conf.Config["prefix"])
+			}
+		}
+
+		// Take care of the files rotated before a restart
+		if rotation.enabled() {
+			go b.maintain()
+		}
 	}
 
 	return b, nil
@@ -118,9 +179,8 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 
 // Backend is the audit backend for the file-based audit store.
 //
-// NOTE: This audit backend is currently very simple: it appends to a file.
-// It doesn't do anything more at the moment to assist with rotation
-// or reset the write cursor, this should be done in the future.
+// The backend appends to a file, which it can rotate by size or time, see
+// rotate.go. External rotation is supported by reopening the file on SIGHUP.
 type Backend struct {
 	path string
 
@@ -131,6 +191,19 @@ type Backend struct {
 	f        *os.File
 	mode     os.FileMode
 
+	// size and lastWrite describe the open file, for rotation
+	size      int64
+	lastWrite time.Time
+	rotation  *rotationConfig
+
+	// maintenanceLock serializes the compression and removal of rotated
+	// files, which happen in the background
+	maintenanceLock sync.Mutex
+
+	reopenPolicy  string
+	reopenAfter   time.Time
+	reopenBackoff time.Duration
+
 	saltMutex  sync.RWMutex
 	salt       *salt.Salt
 	saltConfig *salt.Config
@@ -168,22 +241,49 @@ func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
 }
 
 func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
+	return b.log(ctx, in, b.formatter.FormatRequest)
+}
+
+func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
+	return b.log(ctx, in, b.formatter.FormatResponse)
+}
+
+type formatFunc func(context.Context, io.Writer, audit.FormatterConfig, *audit.LogInput) error
+
+func (b *Backend) log(ctx context.Context, in *audit.LogInput, format formatFunc) error {
 	b.fileLock.Lock()
 	defer b.fileLock.Unlock()
 
 	switch b.path {
 	case "stdout":
-		return b.formatter.FormatRequest(ctx, os.Stdout, b.formatConfig, in)
+		return format(ctx, os.Stdout, b.formatConfig, in)
 	case "discard":
-		return b.formatter.FormatRequest(ctx, ioutil.Discard, b.formatConfig, in)
+		return format(ctx, ioutil.Discard, b.formatConfig, in)
+	}
+
+	now := time.Now()
+	if b.reopenPolicy == reopenPolicyBackoff && now.Before(b.reopenAfter) {
+		return fmt.Errorf("audit file %q is unavailable, retrying after %s", b.path, b.reopenAfter.Format(time.RFC3339))
 	}
 
 	if err := b.open(); err != nil {
+		b.backoff(now)
 		return err
 	}
 
-	if err := b.formatter.FormatRequest(ctx, b.f, b.formatConfig, in); err == nil {
-		return nil
+	if b.rotation.due(b.size, b.lastWrite, now) {
+		if err := b.rotate(now); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to rotate audit file %q: {{err}}", b.path), err)
+		}
+	}
+
+	err := format(ctx, &countingWriter{w: b.f, n: &b.size}, b.formatConfig, in)
+	if err == nil || b.reopenPolicy == reopenPolicyNever {
+		if err == nil {
+			b.lastWrite = now
+			b.reopenBackoff = 0
+		}
+		return err
 	}
 
 	// Opportunistically try to re-open the FD, once per call
@@ -191,41 +291,36 @@ func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
 	b.f = nil
 
 	if err := b.open(); err != nil {
+		b.backoff(now)
 		return err
 	}
 
-	return b.formatter.FormatRequest(ctx, b.f, b.formatConfig, in)
-}
-
-func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
-
-	b.fileLock.Lock()
-	defer b.fileLock.Unlock()
-
-	switch b.path {
-	case "stdout":
-		return b.formatter.FormatResponse(ctx, os.Stdout, b.formatConfig, in)
-	case "discard":
-		return b.formatter.FormatResponse(ctx, ioutil.Discard, b.formatConfig, in)
-	}
-
-	if err := b.open(); err != nil {
+	if err := format(ctx, &countingWriter{w: b.f, n: &b.size}, b.formatConfig, in); err != nil {
+		b.backoff(now)
 		return err
 	}
+	b.lastWrite = now
+	b.reopenBackoff = 0
+	return nil
+}
 
-	if err := b.formatter.FormatResponse(ctx, b.f, b.formatConfig, in); err == nil {
-		return nil
+// backoff delays the next attempt to open the file when the backoff policy is
+// configured. The file lock must be held before calling this.
+func (b *Backend) backoff(now time.Time) {
+	if b.reopenPolicy != reopenPolicyBackoff {
+		return
 	}
 
-	// Opportunistically try to re-open the FD, once per call
-	b.f.Close()
-	b.f = nil
-
-	if err := b.open(); err != nil {
-		return err
+	switch {
+	case b.reopenBackoff == 0:
+		b.reopenBackoff = time.Second
+	case b.reopenBackoff < maxReopenBackoff:
+		b.reopenBackoff *= 2
+		if b.reopenBackoff > maxReopenBackoff {
+			b.reopenBackoff = maxReopenBackoff
+		}
 	}
-
-	return b.formatter.FormatResponse(ctx, b.f, b.formatConfig, in)
+	b.reopenAfter = now.Add(b.reopenBackoff)
 }
 
 // The file lock must be held before calling this
@@ -256,6 +351,13 @@ func (b *Backend) open() error {
 		}
 	}
 
+	info, err := b.f.Stat()
+	if err != nil {
+		return err
+	}
+	b.size = info.Size()
+	b.lastWrite = info.ModTime()
+
 	return nil
 }
 
@@ -268,6 +370,9 @@ func (b *Backend) Reload(_ context.Context) error {
 	b.fileLock.Lock()
 	defer b.fileLock.Unlock()
 
+	b.reopenAfter = time.Time{}
+	b.reopenBackoff = 0
+
 	if b.f == nil {
 		return b.open()
 	}
@@ -0,0 +1,362 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jiangjiali/vault/audit"
+	"github.com/jiangjiali/vault/sdk/helper/parseutil"
+)
+
+const (
+	// reopenPolicyOnce reopens the file once when a write fails and retries
+	// the write
+	reopenPolicyOnce = "once"
+
+	// reopenPolicyNever returns the error of a failed write. The file is
+	// reopened when the device is reloaded.
+	reopenPolicyNever = "never"
+
+	// reopenPolicyBackoff is like reopenPolicyOnce, but when reopening fails
+	// the writes fail right away until an increasing delay has passed
+	reopenPolicyBackoff = "backoff"
+
+	// maxReopenBackoff is the longest delay of reopenPolicyBackoff
+	maxReopenBackoff = time.Minute
+
+	// rotationTimeFormat is the format of the time in the names of the
+	// rotated files, which sort in the order they were rotated
+	rotationTimeFormat = "20060102T150405.000000000Z"
+
+	gzipExt = ".gz"
+)
+
+// rotationConfig is the rotation and retention of the audit file
+type rotationConfig struct {
+	// bytes is the size after which the file is rotated
+	bytes int64
+
+	// period is the interval at which the file is rotated. The intervals are
+	// aligned on UTC, so a period of 24h rotates the file at midnight.
+	period time.Duration
+
+	// compress enables the gzip compression of rotated files
+	compress bool
+
+	// maxFiles and maxAge bound the rotated files which are kept
+	maxFiles int
+	maxAge   time.Duration
+}
+
+func parseRotationConfig(conf map[string]string) (*rotationConfig, error) {
+	r := &rotationConfig{}
+
+	if raw, ok := conf["rotate_bytes"]; ok {
+		size, err := audit.ParseSize(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotate_bytes: %v", err)
+		}
+		r.bytes = size
+	}
+
+	if raw, ok := conf["rotate_period"]; ok {
+		period, err := parseutil.ParseDurationSecond(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotate_period: %v", err)
+		}
+		if period < 0 {
+			return nil, fmt.Errorf("rotate_period cannot be negative")
+		}
+		r.period = period
+	}
+
+	if raw, ok := conf["rotate_compress"]; ok {
+		compress, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotate_compress: %v", err)
+		}
+		r.compress = compress
+	}
+
+	if raw, ok := conf["rotate_max_files"]; ok {
+		maxFiles, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotate_max_files: %v", err)
+		}
+		if maxFiles < 0 {
+			return nil, fmt.Errorf("rotate_max_files cannot be negative")
+		}
+		r.maxFiles = maxFiles
+	}
+
+	if raw, ok := conf["rotate_max_age"]; ok {
+		maxAge, err := parseutil.ParseDurationSecond(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rotate_max_age: %v", err)
+		}
+		if maxAge < 0 {
+			return nil, fmt.Errorf("rotate_max_age cannot be negative")
+		}
+		r.maxAge = maxAge
+	}
+
+	if !r.enabled() && (r.compress || r.maxFiles > 0 || r.maxAge > 0) {
+		return nil, fmt.Errorf("rotate_compress, rotate_max_files and rotate_max_age require rotate_bytes or rotate_period")
+	}
+
+	return r, nil
+}
+
+func (r *rotationConfig) enabled() bool {
+	return r.bytes > 0 || r.period > 0
+}
+
+// due returns whether a file of the given size, last written at lastWrite,
+// has to be rotated before writing to it. Empty files are never rotated.
+func (r *rotationConfig) due(size int64, lastWrite, now time.Time) bool {
+	switch {
+	case size == 0:
+		return false
+	case r.bytes > 0 && size >= r.bytes:
+		return true
+	case r.period > 0 && !now.Truncate(r.period).Equal(lastWrite.Truncate(r.period)):
+		return true
+	}
+	return false
+}
+
+// countingWriter adds the number of bytes written to n
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside and opens a new one. The rotated files are
+// compressed and pruned in the background. The file lock must be held before
+// calling this.
+func (b *Backend) rotate(now time.Time) error {
+	b.f.Close()
+	b.f = nil
+
+	if err := os.Rename(b.path, b.rotatedPath(now)); err != nil {
+		// Keep writing to the current file, rotation is attempted again on
+		// the next write
+		if openErr := b.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+
+	if err := b.open(); err != nil {
+		return err
+	}
+
+	go b.maintain()
+	return nil
+}
+
+// rotatedPath returns the name of the file rotated at the given time: the time
+// is inserted before the extension of the file.
+func (b *Backend) rotatedPath(t time.Time) string {
+	ext := filepath.Ext(b.path)
+	return strings.TrimSuffix(b.path, ext) + "-" + t.UTC().Format(rotationTimeFormat) + ext
+}
+
+// rotatedFilesRegex matches the names of the rotated files of the backend and
+// captures their rotation time
+func (b *Backend) rotatedFilesRegex() *regexp.Regexp {
+	name := filepath.Base(b.path)
+	ext := filepath.Ext(name)
+	return regexp.MustCompile("^" + regexp.QuoteMeta(strings.TrimSuffix(name, ext)) +
+		`-(\d{8}T\d{6}\.\d{9}Z)` + regexp.QuoteMeta(ext) + "(" + regexp.QuoteMeta(gzipExt) + ")?$")
+}
+
+// rotatedFiles returns the paths of the rotated files, oldest first
+func (b *Backend) rotatedFiles() ([]string, error) {
+	dir := filepath.Dir(b.path)
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	re := b.rotatedFilesRegex()
+	var files []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() && re.MatchString(info.Name()) {
+			files = append(files, filepath.Join(dir, info.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// maintain compresses the rotated files if configured and removes the ones
+// past retention. Failures are left to the next rotation to handle.
+func (b *Backend) maintain() {
+	b.maintenanceLock.Lock()
+	defer b.maintenanceLock.Unlock()
+
+	files, err := b.rotatedFiles()
+	if err != nil {
+		return
+	}
+
+	if b.rotation.compress {
+		for i, file := range files {
+			if strings.HasSuffix(file, gzipExt) {
+				continue
+			}
+			if err := b.compress(file); err == nil {
+				files[i] = file + gzipExt
+			}
+		}
+	}
+
+	re := b.rotatedFilesRegex()
+	now := time.Now()
+	for i, file := range files {
+		expired := b.rotation.maxFiles > 0 && i < len(files)-b.rotation.maxFiles
+		if !expired && b.rotation.maxAge > 0 {
+			match := re.FindStringSubmatch(filepath.Base(file))
+			if rotated, err := time.Parse(rotationTimeFormat, match[1]); err == nil {
+				expired = now.Sub(rotated) > b.rotation.maxAge
+			}
+		}
+		if expired {
+			os.Remove(file)
+		}
+	}
+}
+
+// compress replaces the file with its gzip compressed version
+func (b *Backend) compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Write to a temporary name so that a partial file is never taken for a
+	// rotated one
+	tmpPath := path + gzipExt + ".tmp"
+	dst, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, b.mode)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if closeErr := gz.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path+gzipExt)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// lastEntry returns the last line written by the backend, which is in the
+// latest rotated file if the file is empty.
+func (b *Backend) lastEntry() ([]byte, error) {
+	line, err := lastLine(b.path)
+	if err != nil || len(line) > 0 {
+		return line, err
+	}
+
+	files, err := b.rotatedFiles()
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+	latest := files[len(files)-1]
+	if !strings.HasSuffix(latest, gzipExt) {
+		return lastLine(latest)
+	}
+
+	f, err := os.Open(latest)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	reader := bufio.NewReader(gz)
+	for {
+		next, err := reader.ReadBytes('\n')
+		if trimmed := bytes.TrimRight(next, "\n"); len(trimmed) > 0 {
+			line = trimmed
+		}
+		if err == io.EOF {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lastLine returns the last non-empty line of the file
+func lastLine(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	// Read backwards until the line preceding the last one ends
+	const chunkSize = 4096
+	var buf []byte
+	for offset := info.Size(); offset > 0; {
+		size := int64(chunkSize)
+		if offset < size {
+			size = offset
+		}
+		offset -= size
+
+		chunk := make([]byte, size)
+		if _, err := f.ReadAt(chunk, offset); err != nil {
+			return nil, err
+		}
+		buf = append(chunk, buf...)
+
+		trimmed := bytes.TrimRight(buf, "\n")
+		if idx := bytes.LastIndexByte(trimmed, '\n'); idx >= 0 {
+			return trimmed[idx+1:], nil
+		}
+	}
+
+	return bytes.TrimRight(buf, "\n"), nil
+}
@@ -0,0 +1,302 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"sync"
+
+	"github.com/jiangjiali/vault/audit"
+	"github.com/jiangjiali/vault/sdk/helper/parseutil"
+	"github.com/jiangjiali/vault/sdk/helper/salt"
+	"github.com/jiangjiali/vault/sdk/helper/strutil"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+func Factory(_ context.Context, conf *audit.BackendConfig) (audit.Backend, error) {
+	if conf.SaltConfig == nil {
+		return nil, fmt.Errorf("nil salt config")
+	}
+	if conf.SaltView == nil {
+		return nil, fmt.Errorf("nil salt view")
+	}
+
+	address, ok := conf.Config["address"]
+	if !ok {
+		return nil, fmt.Errorf("address is required")
+	}
+	brokers := strutil.ParseDedupAndSortStrings(address, ",")
+
+	topic, ok := conf.Config["topic"]
+	if !ok || topic == "" {
+		return nil, fmt.Errorf("topic is required")
+	}
+
+	partition := int64(0)
+	if partitionRaw, ok := conf.Config["partition"]; ok {
+		value, err := strconv.ParseInt(partitionRaw, 10, 32)
+		if err != nil || value < 0 {
+			return nil, fmt.Errorf("invalid partition %q", partitionRaw)
+		}
+		partition = value
+	}
+
+	// Check the acknowledgement required from the brokers, either from the
+	// leader only or from all the in-sync replicas
+	acks := int16(1)
+	if acksRaw, ok := conf.Config["required_acks"]; ok {
+		switch acksRaw {
+		case "1", "leader":
+		case "-1", "all":
+			acks = -1
+		default:
+			return nil, fmt.Errorf("invalid required_acks %q, expected 1 or all", acksRaw)
+		}
+	}
+
+	timeoutRaw, ok := conf.Config["timeout"]
+	if !ok {
+		timeoutRaw = "10s"
+	}
+	timeout, err := parseutil.ParseDurationSecond(timeoutRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	clientID, ok := conf.Config["client_id"]
+	if !ok {
+		clientID = "vault"
+	}
+
+	format, ok := conf.Config["format"]
+	if !ok {
+		format = "json"
+	}
+	switch format {
+	case "json", "jsonx", "cef", "otlp":
+	default:
+		return nil, fmt.Errorf("unknown format type %q", format)
+	}
+
+	// Check if hashing of accessor is disabled
+	hmacAccessor := true
+	if hmacAccessorRaw, ok := conf.Config["hmac_accessor"]; ok {
+		value, err := strconv.ParseBool(hmacAccessorRaw)
+		if err != nil {
+			return nil, err
+		}
+		hmacAccessor = value
+	}
+
+	// Check if raw logging is enabled
+	logRaw := false
+	if raw, ok := conf.Config["log_raw"]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		logRaw = b
+	}
+
+	// Check if the entries are chained
+	hashChain, err := audit.ParseHashChain(conf.Config, format)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := parseTLSConfig(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferConfig, err := audit.ParseBufferConfig(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Backend{
+		producer: &producer{
+			brokers:   brokers,
+			topic:     topic,
+			partition: int32(partition),
+			acks:      acks,
+			timeout:   timeout,
+			tlsConfig: tlsConfig,
+			clientID:  clientID,
+		},
+		saltConfig: conf.SaltConfig,
+		saltView:   conf.SaltView,
+		formatConfig: audit.FormatterConfig{
+			Raw:          logRaw,
+			HMACAccessor: hmacAccessor,
+		},
+	}
+
+	switch format {
+	case "json":
+		b.formatter.AuditFormatWriter = &audit.JSONFormatWriter{
+			Prefix:    conf.Config["prefix"],
+			SaltFunc:  b.Salt,
+			HashChain: hashChain,
+		}
+	case "jsonx":
+		b.formatter.AuditFormatWriter = &audit.JSONxFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "cef":
+		b.formatter.AuditFormatWriter = &audit.CEFFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "otlp":
+		b.formatter.AuditFormatWriter = &audit.OTLPFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	}
+
+	b.buffer, err = audit.NewBuffer(bufferConfig, "kafka", b.send)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// parseTLSConfig returns the TLS configuration of the connections to the
+// brokers, or nil if TLS is not enabled
+func parseTLSConfig(conf map[string]string) (*tls.Config, error) {
+	if raw, ok := conf["tls"]; ok {
+		enabled, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls: %v", err)
+		}
+		if !enabled {
+			return nil, nil
+		}
+	} else {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if raw, ok := conf["tls_skip_verify"]; ok {
+		skip, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls_skip_verify: %v", err)
+		}
+		tlsConfig.InsecureSkipVerify = skip
+	}
+
+	if caCert, ok := conf["tls_ca_cert"]; ok {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls_ca_cert: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in tls_ca_cert")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// Backend is the audit backend producing the entries to a Kafka topic. The
+// entries are buffered on disk and produced in batches, one record per entry.
+type Backend struct {
+	producer *producer
+
+	buffer *audit.Buffer
+
+	formatter    audit.AuditFormatter
+	formatConfig audit.FormatterConfig
+
+	saltMutex  sync.RWMutex
+	salt       *salt.Salt
+	saltConfig *salt.Config
+	saltView   logical.Storage
+}
+
+var _ audit.Backend = (*Backend)(nil)
+
+func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
+	salt, err := b.Salt(ctx)
+	if err != nil {
+		return "", err
+	}
+	return audit.HashString(salt, data), nil
+}
+
+func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
+	var buf bytes.Buffer
+	if err := b.formatter.FormatRequest(ctx, &buf, b.formatConfig, in); err != nil {
+		return err
+	}
+
+	return b.buffer.Append(buf.Bytes())
+}
+
+func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
+	var buf bytes.Buffer
+	if err := b.formatter.FormatResponse(ctx, &buf, b.formatConfig, in); err != nil {
+		return err
+	}
+
+	return b.buffer.Append(buf.Bytes())
+}
+
+// send produces a batch of entries. It is only called by the buffer, one batch
+// at a time.
+func (b *Backend) send(ctx context.Context, entries [][]byte) error {
+	values := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		values = append(values, bytes.TrimSuffix(entry, []byte("\n")))
+	}
+	return b.producer.produce(ctx, values)
+}
+
+func (b *Backend) Reload(_ context.Context) error {
+	return nil
+}
+
+// Close stops producing the entries, which remain in the buffer
+func (b *Backend) Close() error {
+	err := b.buffer.Close()
+	b.producer.close()
+	return err
+}
+
+func (b *Backend) Salt(ctx context.Context) (*salt.Salt, error) {
+	b.saltMutex.RLock()
+	if b.salt != nil {
+		defer b.saltMutex.RUnlock()
+		return b.salt, nil
+	}
+	b.saltMutex.RUnlock()
+	b.saltMutex.Lock()
+	defer b.saltMutex.Unlock()
+	if b.salt != nil {
+		return b.salt, nil
+	}
+	salt, err := salt.NewSalt(ctx, b.saltView, b.saltConfig)
+	if err != nil {
+		return nil, err
+	}
+	b.salt = salt
+	return salt, nil
+}
+
+func (b *Backend) Invalidate(_ context.Context) {
+	b.saltMutex.Lock()
+	defer b.saltMutex.Unlock()
+	b.salt = nil
+}
@@ -0,0 +1,416 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// The Kafka protocol requests used by the producer. Produce v3 is the first
+// version carrying record batches, the only format of recent brokers.
+const (
+	apiKeyProduce   = 0
+	apiKeyMetadata  = 3
+	produceVersion  = 3
+	metadataVersion = 1
+
+	recordBatchMagic = 2
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// producer writes records to a partition of a topic. It looks up the leader of
+// the partition through the bootstrap brokers and keeps a connection to it,
+// which is dropped on any error so that the leader is looked up again. It is
+// not safe for concurrent use.
+type producer struct {
+	brokers   []string
+	topic     string
+	partition int32
+	acks      int16
+	timeout   time.Duration
+	tlsConfig *tls.Config
+	clientID  string
+
+	conn          net.Conn
+	correlationID int32
+}
+
+// produce writes the values as one record batch
+func (p *producer) produce(ctx context.Context, values [][]byte) error {
+	if p.conn == nil {
+		conn, err := p.connectLeader(ctx)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+
+	err := p.sendProduce(ctx, values)
+	if err != nil {
+		p.close()
+	}
+	return err
+}
+
+func (p *producer) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+func (p *producer) dial(ctx context.Context, address string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: p.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if p.tlsConfig == nil {
+		return conn, nil
+	}
+
+	tlsConfig := p.tlsConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		host, _, _ := net.SplitHostPort(address)
+		tlsConfig.ServerName = host
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	tlsConn.SetDeadline(time.Now().Add(p.timeout))
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// connectLeader returns a connection to the leader of the partition, asking
+// the bootstrap brokers in turn
+func (p *producer) connectLeader(ctx context.Context) (net.Conn, error) {
+	var errs []string
+	for _, broker := range p.brokers {
+		conn, err := p.dial(ctx, broker)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		leader, err := p.lookupLeader(ctx, conn)
+		if err != nil {
+			conn.Close()
+			errs = append(errs, err.Error())
+			continue
+		}
+
+		// The bootstrap broker is usually the leader of the partition as
+		// well when there is a single one
+		if leader == broker || leader == conn.RemoteAddr().String() {
+			return conn, nil
+		}
+		conn.Close()
+
+		conn, err = p.dial(ctx, leader)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("failed to connect to the leader of partition %d of topic %q: %v", p.partition, p.topic, errs)
+}
+
+// lookupLeader returns the address of the leader of the partition
+func (p *producer) lookupLeader(ctx context.Context, conn net.Conn) (string, error) {
+	var req encoder
+	req.int32(1)
+	req.string(p.topic)
+
+	resp, err := p.roundTrip(ctx, conn, apiKeyMetadata, metadataVersion, req.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	d := &decoder{buf: resp}
+	brokers := make(map[int32]string)
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		nodeID := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller id
+
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		topicErr := d.int16()
+		name := d.string()
+		d.int8() // is internal
+		for m := d.int32(); m > 0 && d.err == nil; m-- {
+			partitionErr := d.int16()
+			index := d.int32()
+			leader := d.int32()
+			d.int32Array() // replicas
+			d.int32Array() // in-sync replicas
+
+			if name != p.topic || index != p.partition {
+				continue
+			}
+			if partitionErr != 0 {
+				return "", fmt.Errorf("metadata of partition %d of topic %q: error code %d", index, name, partitionErr)
+			}
+			address, ok := brokers[leader]
+			if !ok {
+				return "", fmt.Errorf("partition %d of topic %q has no leader", index, name)
+			}
+			return address, d.err
+		}
+		if name == p.topic && topicErr != 0 {
+			return "", fmt.Errorf("metadata of topic %q: error code %d", name, topicErr)
+		}
+	}
+	if d.err != nil {
+		return "", d.err
+	}
+	return "", fmt.Errorf("partition %d of topic %q not found", p.partition, p.topic)
+}
+
+func (p *producer) sendProduce(ctx context.Context, values [][]byte) error {
+	batch := encodeRecordBatch(values, time.Now())
+
+	var req encoder
+	req.int16(-1) // transactional id
+	req.int16(p.acks)
+	req.int32(int32(p.timeout / time.Millisecond))
+	req.int32(1)
+	req.string(p.topic)
+	req.int32(1)
+	req.int32(p.partition)
+	req.int32(int32(len(batch)))
+	req.Write(batch)
+
+	resp, err := p.roundTrip(ctx, p.conn, apiKeyProduce, produceVersion, req.Bytes())
+	if err != nil {
+		return err
+	}
+
+	d := &decoder{buf: resp}
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		name := d.string()
+		for m := d.int32(); m > 0 && d.err == nil; m-- {
+			index := d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if d.err == nil && code != 0 {
+				return fmt.Errorf("produce to partition %d of topic %q: error code %d", index, name, code)
+			}
+		}
+	}
+	return d.err
+}
+
+// roundTrip sends a request and returns the body of its response
+func (p *producer) roundTrip(ctx context.Context, conn net.Conn, apiKey, apiVersion int16, body []byte) ([]byte, error) {
+	p.correlationID++
+	correlationID := p.correlationID
+
+	var req encoder
+	req.int32(0) // size, set below
+	req.int16(apiKey)
+	req.int16(apiVersion)
+	req.int32(correlationID)
+	req.string(p.clientID)
+	req.Write(body)
+	msg := req.Bytes()
+	binary.BigEndian.PutUint32(msg, uint32(len(msg)-4))
+
+	// Abort the request when the context is canceled
+	deadline := time.Now().Add(p.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header))
+	if size < 4 || size > 64<<20 {
+		return nil, fmt.Errorf("invalid response size %d", size)
+	}
+	if got := int32(binary.BigEndian.Uint32(header[4:])); got != correlationID {
+		return nil, fmt.Errorf("unexpected correlation id %d, expected %d", got, correlationID)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// encodeRecordBatch encodes the values as the records of a batch, without
+// keys or headers
+func encodeRecordBatch(values [][]byte, now time.Time) []byte {
+	timestamp := now.UnixNano() / int64(time.Millisecond)
+
+	var records encoder
+	for i, value := range values {
+		var record encoder
+		record.int8(0)          // attributes
+		record.varint(0)        // timestamp delta
+		record.varint(int64(i)) // offset delta
+		record.varint(-1)       // key
+		record.varint(int64(len(value)))
+		record.Write(value)
+		record.varint(0) // headers
+
+		records.varint(int64(record.Len()))
+		records.Write(record.Bytes())
+	}
+
+	// The checksum covers the batch from its attributes
+	var body encoder
+	body.int16(0) // attributes
+	body.int32(int32(len(values) - 1))
+	body.int64(timestamp) // first timestamp
+	body.int64(timestamp) // max timestamp
+	body.int64(-1)        // producer id
+	body.int16(-1)        // producer epoch
+	body.int32(-1)        // base sequence
+	body.int32(int32(len(values)))
+	body.Write(records.Bytes())
+
+	var batch encoder
+	batch.int64(0) // base offset
+	batch.int32(int32(4 + 1 + 4 + body.Len()))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(recordBatchMagic)
+	batch.int32(int32(crc32.Checksum(body.Bytes(), castagnoliTable)))
+	batch.Write(body.Bytes())
+	return batch.Bytes()
+}
+
+// encoder writes the primitive types of the Kafka protocol
+type encoder struct {
+	bytes.Buffer
+}
+
+func (e *encoder) int8(v int8) {
+	e.WriteByte(byte(v))
+}
+
+func (e *encoder) int16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *encoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (e *encoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.WriteString(s)
+}
+
+var errShortResponse = errors.New("short response")
+
+// decoder reads the primitive types of the Kafka protocol. The first error is
+// kept and zero values are returned after it.
+type decoder struct {
+	buf []byte
+	off int
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || d.off+n > len(d.buf) {
+		d.err = errShortResponse
+		return nil
+	}
+	b := d.buf[d.off : d.off+n]
+	d.off += n
+	return b
+}
+
+func (d *decoder) int8() int8 {
+	if b := d.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *decoder) int16() int16 {
+	if b := d.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *decoder) int32() int32 {
+	if b := d.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *decoder) int64() int64 {
+	if b := d.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a string, which is empty if null
+func (d *decoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) int32Array() {
+	for n := d.int32(); n > 0 && d.err == nil; n-- {
+		d.int32()
+	}
+}
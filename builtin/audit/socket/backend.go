@@ -48,7 +48,7 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		format = "json"
 	}
 	switch format {
-	case "json":
+	case "json", "jsonx", "cef", "otlp":
 	default:
 		return nil, fmt.Errorf("unknown format type %q", format)
 	}
@@ -73,6 +73,12 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		logRaw = b
 	}
 
+	// Check if the entries are chained
+	hashChain, err := audit.ParseHashChain(conf.Config, format)
+	if err != nil {
+		return nil, err
+	}
+
 	b := &Backend{
 		saltConfig: conf.SaltConfig,
 		saltView:   conf.SaltView,
@@ -84,11 +90,28 @@ func Factory(ctx context.Context, conf *audit.BackendConfig) (audit.Backend, err
 		writeDuration: writeDuration,
 		address:       address,
 		socketType:    socketType,
+		hashChain:     hashChain != nil,
 	}
 
 	switch format {
 	case "json":
 		b.formatter.AuditFormatWriter = &audit.JSONFormatWriter{
+			Prefix:    conf.Config["prefix"],
+			SaltFunc:  b.Salt,
+			HashChain: hashChain,
+		}
+	case "jsonx":
+		b.formatter.AuditFormatWriter = &audit.JSONxFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "cef":
+		b.formatter.AuditFormatWriter = &audit.CEFFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "otlp":
+		b.formatter.AuditFormatWriter = &audit.OTLPFormatWriter{
 			Prefix:   conf.Config["prefix"],
 			SaltFunc: b.Salt,
 		}
@@ -108,6 +131,11 @@ type Backend struct {
 	address       string
 	socketType    string
 
+	// hashChain is set when the entries are chained. They are then formatted
+	// straight to the socket, so that the chain is linked in the order the
+	// entries are written and only advances once they are.
+	hashChain bool
+
 	sync.Mutex
 
 	saltMutex  sync.RWMutex
@@ -127,51 +155,64 @@ func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
 }
 
 func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
+	if b.hashChain {
+		return b.formatter.FormatRequest(ctx, &entryWriter{ctx: ctx, backend: b}, b.formatConfig, in)
+	}
+
 	var buf bytes.Buffer
 	if err := b.formatter.FormatRequest(ctx, &buf, b.formatConfig, in); err != nil {
 		return err
 	}
 
-	b.Lock()
-	defer b.Unlock()
-
-	err := b.write(ctx, buf.Bytes())
-	if err != nil {
-		rErr := b.reconnect(ctx)
-		if rErr != nil {
-			err = multierror.Append(err, rErr)
-		} else {
-			// Try once more after reconnecting
-			err = b.write(ctx, buf.Bytes())
-		}
-	}
-
-	return err
+	return b.writeEntry(ctx, buf.Bytes())
 }
 
 func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
+	if b.hashChain {
+		return b.formatter.FormatResponse(ctx, &entryWriter{ctx: ctx, backend: b}, b.formatConfig, in)
+	}
+
 	var buf bytes.Buffer
 	if err := b.formatter.FormatResponse(ctx, &buf, b.formatConfig, in); err != nil {
 		return err
 	}
 
+	return b.writeEntry(ctx, buf.Bytes())
+}
+
+// writeEntry writes an entry to the socket, reconnecting once if the write
+// fails.
+func (b *Backend) writeEntry(ctx context.Context, buf []byte) error {
 	b.Lock()
 	defer b.Unlock()
 
-	err := b.write(ctx, buf.Bytes())
+	err := b.write(ctx, buf)
 	if err != nil {
 		rErr := b.reconnect(ctx)
 		if rErr != nil {
 			err = multierror.Append(err, rErr)
 		} else {
 			// Try once more after reconnecting
-			err = b.write(ctx, buf.Bytes())
+			err = b.write(ctx, buf)
 		}
 	}
 
 	return err
 }
 
+// entryWriter writes the entries formatted to it to the socket of the backend.
+type entryWriter struct {
+	ctx     context.Context
+	backend *Backend
+}
+
+func (w *entryWriter) Write(p []byte) (int, error) {
+	if err := w.backend.writeEntry(w.ctx, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 func (b *Backend) write(ctx context.Context, buf []byte) error {
 	if b.connection == nil {
 		if err := b.reconnect(ctx); err != nil {
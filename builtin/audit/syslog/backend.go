@@ -38,7 +38,7 @@ func Factory(_ context.Context, conf *audit.BackendConfig) (audit.Backend, error
 		format = "json"
 	}
 	switch format {
-	case "json":
+	case "json", "jsonx", "cef", "otlp":
 	default:
 		return nil, fmt.Errorf("unknown format type %q", format)
 	}
@@ -63,6 +63,12 @@ func Factory(_ context.Context, conf *audit.BackendConfig) (audit.Backend, error
 		logRaw = b
 	}
 
+	// Check if the entries are chained
+	hashChain, err := audit.ParseHashChain(conf.Config, format)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get the logger
 	logger, err := gsyslog.NewLogger(gsyslog.LOG_INFO, facility, tag)
 	if err != nil {
@@ -71,6 +77,7 @@ func Factory(_ context.Context, conf *audit.BackendConfig) (audit.Backend, error
 
 	b := &Backend{
 		logger:     logger,
+		hashChain:  hashChain != nil,
 		saltConfig: conf.SaltConfig,
 		saltView:   conf.SaltView,
 		formatConfig: audit.FormatterConfig{
@@ -82,6 +89,22 @@ func Factory(_ context.Context, conf *audit.BackendConfig) (audit.Backend, error
 	switch format {
 	case "json":
 		b.formatter.AuditFormatWriter = &audit.JSONFormatWriter{
+			Prefix:    conf.Config["prefix"],
+			SaltFunc:  b.Salt,
+			HashChain: hashChain,
+		}
+	case "jsonx":
+		b.formatter.AuditFormatWriter = &audit.JSONxFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "cef":
+		b.formatter.AuditFormatWriter = &audit.CEFFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "otlp":
+		b.formatter.AuditFormatWriter = &audit.OTLPFormatWriter{
 			Prefix:   conf.Config["prefix"],
 			SaltFunc: b.Salt,
 		}
@@ -94,6 +117,11 @@ func Factory(_ context.Context, conf *audit.BackendConfig) (audit.Backend, error
 type Backend struct {
 	logger gsyslog.Syslogger
 
+	// hashChain is set when the entries are chained. They are then formatted
+	// straight to syslog, so that the chain is linked in the order the entries
+	// are written and only advances once they are.
+	hashChain bool
+
 	formatter    audit.AuditFormatter
 	formatConfig audit.FormatterConfig
 
@@ -114,6 +142,10 @@ func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
 }
 
 func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
+	if b.hashChain {
+		return b.formatter.FormatRequest(ctx, b.logger, b.formatConfig, in)
+	}
+
 	var buf bytes.Buffer
 	if err := b.formatter.FormatRequest(ctx, &buf, b.formatConfig, in); err != nil {
 		return err
@@ -125,6 +157,10 @@ func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
 }
 
 func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
+	if b.hashChain {
+		return b.formatter.FormatResponse(ctx, b.logger, b.formatConfig, in)
+	}
+
 	var buf bytes.Buffer
 	if err := b.formatter.FormatResponse(ctx, &buf, b.formatConfig, in); err != nil {
 		return err
@@ -0,0 +1,306 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jiangjiali/vault/audit"
+	"github.com/jiangjiali/vault/sdk/helper/cleanhttp"
+	"github.com/jiangjiali/vault/sdk/helper/parseutil"
+	"github.com/jiangjiali/vault/sdk/helper/salt"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+// headerPrefix is the prefix of the options setting the headers of the
+// requests, such as header_Authorization
+const headerPrefix = "header_"
+
+func Factory(_ context.Context, conf *audit.BackendConfig) (audit.Backend, error) {
+	if conf.SaltConfig == nil {
+		return nil, fmt.Errorf("nil salt config")
+	}
+	if conf.SaltView == nil {
+		return nil, fmt.Errorf("nil salt view")
+	}
+
+	rawURL, ok := conf.Config["url"]
+	if !ok {
+		return nil, fmt.Errorf("url is required")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("url must be an http or https URL")
+	}
+
+	timeoutRaw, ok := conf.Config["timeout"]
+	if !ok {
+		timeoutRaw = "10s"
+	}
+	timeout, err := parseutil.ParseDurationSecond(timeoutRaw)
+	if err != nil {
+		return nil, err
+	}
+
+	format, ok := conf.Config["format"]
+	if !ok {
+		format = "json"
+	}
+	switch format {
+	case "json", "jsonx", "cef", "otlp":
+	default:
+		return nil, fmt.Errorf("unknown format type %q", format)
+	}
+
+	// Check if hashing of accessor is disabled
+	hmacAccessor := true
+	if hmacAccessorRaw, ok := conf.Config["hmac_accessor"]; ok {
+		value, err := strconv.ParseBool(hmacAccessorRaw)
+		if err != nil {
+			return nil, err
+		}
+		hmacAccessor = value
+	}
+
+	// Check if raw logging is enabled
+	logRaw := false
+	if raw, ok := conf.Config["log_raw"]; ok {
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, err
+		}
+		logRaw = b
+	}
+
+	// Check if the entries are chained
+	hashChain, err := audit.ParseHashChain(conf.Config, format)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := parseTLSConfig(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferConfig, err := audit.ParseBufferConfig(conf.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make(http.Header)
+	for key, value := range conf.Config {
+		if strings.HasPrefix(key, headerPrefix) && len(key) > len(headerPrefix) {
+			headers.Set(strings.TrimPrefix(key, headerPrefix), value)
+		}
+	}
+
+	transport := cleanhttp.DefaultPooledTransport()
+	transport.TLSClientConfig = tlsConfig
+
+	b := &Backend{
+		url:         u.String(),
+		headers:     headers,
+		contentType: contentTypes[format],
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+		},
+		saltConfig: conf.SaltConfig,
+		saltView:   conf.SaltView,
+		formatConfig: audit.FormatterConfig{
+			Raw:          logRaw,
+			HMACAccessor: hmacAccessor,
+		},
+	}
+
+	switch format {
+	case "json":
+		b.formatter.AuditFormatWriter = &audit.JSONFormatWriter{
+			Prefix:    conf.Config["prefix"],
+			SaltFunc:  b.Salt,
+			HashChain: hashChain,
+		}
+	case "jsonx":
+		b.formatter.AuditFormatWriter = &audit.JSONxFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "cef":
+		b.formatter.AuditFormatWriter = &audit.CEFFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	case "otlp":
+		b.formatter.AuditFormatWriter = &audit.OTLPFormatWriter{
+			Prefix:   conf.Config["prefix"],
+			SaltFunc: b.Salt,
+		}
+	}
+
+	b.buffer, err = audit.NewBuffer(bufferConfig, "webhook", b.send)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// contentTypes are the content types of the batches of entries of each format,
+// which are written one per line
+var contentTypes = map[string]string{
+	"json":  "application/x-ndjson",
+	"jsonx": "application/xml",
+	"cef":   "text/plain; charset=utf-8",
+	"otlp":  "application/x-ndjson",
+}
+
+// parseTLSConfig returns the TLS configuration of the requests
+func parseTLSConfig(conf map[string]string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if raw, ok := conf["tls_skip_verify"]; ok {
+		skip, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls_skip_verify: %v", err)
+		}
+		tlsConfig.InsecureSkipVerify = skip
+	}
+
+	if caCert, ok := conf["tls_ca_cert"]; ok {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls_ca_cert: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in tls_ca_cert")
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	return tlsConfig, nil
+}
+
+// Backend is the audit backend posting the entries to an HTTP endpoint. The
+// entries are buffered on disk and posted in batches, one entry per line.
+type Backend struct {
+	url         string
+	headers     http.Header
+	contentType string
+	client      *http.Client
+
+	buffer *audit.Buffer
+
+	formatter    audit.AuditFormatter
+	formatConfig audit.FormatterConfig
+
+	saltMutex  sync.RWMutex
+	salt       *salt.Salt
+	saltConfig *salt.Config
+	saltView   logical.Storage
+}
+
+var _ audit.Backend = (*Backend)(nil)
+
+func (b *Backend) GetHash(ctx context.Context, data string) (string, error) {
+	salt, err := b.Salt(ctx)
+	if err != nil {
+		return "", err
+	}
+	return audit.HashString(salt, data), nil
+}
+
+func (b *Backend) LogRequest(ctx context.Context, in *audit.LogInput) error {
+	var buf bytes.Buffer
+	if err := b.formatter.FormatRequest(ctx, &buf, b.formatConfig, in); err != nil {
+		return err
+	}
+
+	return b.buffer.Append(buf.Bytes())
+}
+
+func (b *Backend) LogResponse(ctx context.Context, in *audit.LogInput) error {
+	var buf bytes.Buffer
+	if err := b.formatter.FormatResponse(ctx, &buf, b.formatConfig, in); err != nil {
+		return err
+	}
+
+	return b.buffer.Append(buf.Bytes())
+}
+
+// send posts a batch of entries
+func (b *Backend) send(ctx context.Context, entries [][]byte) error {
+	req, err := http.NewRequest(http.MethodPost, b.url, bytes.NewReader(bytes.Join(entries, nil)))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	for key, values := range b.headers {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", b.contentType)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (b *Backend) Reload(_ context.Context) error {
+	return nil
+}
+
+// Close stops posting the entries, which remain in the buffer
+func (b *Backend) Close() error {
+	err := b.buffer.Close()
+	b.client.CloseIdleConnections()
+	return err
+}
+
+func (b *Backend) Salt(ctx context.Context) (*salt.Salt, error) {
+	b.saltMutex.RLock()
+	if b.salt != nil {
+		defer b.saltMutex.RUnlock()
+		return b.salt, nil
+	}
+	b.saltMutex.RUnlock()
+	b.saltMutex.Lock()
+	defer b.saltMutex.Unlock()
+	if b.salt != nil {
+		return b.salt, nil
+	}
+	salt, err := salt.NewSalt(ctx, b.saltView, b.saltConfig)
+	if err != nil {
+		return nil, err
+	}
+	b.salt = salt
+	return salt, nil
+}
+
+func (b *Backend) Invalidate(_ context.Context) {
+	b.saltMutex.Lock()
+	defer b.saltMutex.Unlock()
+	b.salt = nil
+}
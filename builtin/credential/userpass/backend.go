@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/locksutil"
 	"github.com/jiangjiali/vault/sdk/helper/mfa"
 	"github.com/jiangjiali/vault/sdk/logical"
 )
@@ -18,6 +19,7 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 
 func Backend() *backend {
 	var b backend
+	b.userLocks = locksutil.CreateLocks()
 	b.Backend = &framework.Backend{
 		Help: backendHelp,
 
@@ -27,13 +29,19 @@ func Backend() *backend {
 			Unauthenticated: []string{
 				"login/*",
 			},
+
+			SealWrapStorage: []string{
+				"user/",
+			},
 		},
 
 		Paths: append([]*framework.Path{
+			pathConfig(&b),
 			pathUsers(&b),
 			pathUsersList(&b),
 			pathUserPolicies(&b),
 			pathUserPassword(&b),
+			pathUserUnlock(&b),
 		},
 			mfa.MFAPaths(b.Backend, pathLogin(&b))...,
 		),
@@ -47,6 +55,10 @@ func Backend() *backend {
 
 type backend struct {
 	*framework.Backend
+
+	// userLocks serializes the updates made to a user, including the login
+	// state recorded on each login.
+	userLocks []*locksutil.LockEntry
 }
 
 const backendHelp = `
@@ -57,4 +69,7 @@ are supported.
 The username/password combination is configured using the "users/"
 endpoints by a user with root access. Authentication is then done
 by supplying the two fields for "login".
+
+Password requirements, password expiration and the lockout of users
+after repeated failed logins are configured using the "config" endpoint.
 `
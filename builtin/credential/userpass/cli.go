@@ -21,6 +21,8 @@ func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, erro
 		Mount    string `mapstructure:"mount"`
 		Method   string `mapstructure:"method"`
 		Passcode string `mapstructure:"passcode"`
+
+		NewPassword string `mapstructure:"new_password"`
 	}
 	if err := mapstructure.WeakDecode(m, &data); err != nil {
 		return nil, err
@@ -51,6 +53,9 @@ func (h *CLIHandler) Auth(c *api.Client, m map[string]string) (*api.Secret, erro
 	if data.Passcode != "" {
 		options["passcode"] = data.Passcode
 	}
+	if data.NewPassword != "" {
+		options["new_password"] = data.NewPassword
+	}
 
 	path := fmt.Sprintf("auth/%s/login/%s", data.Mount, data.Username)
 	secret, err := c.Logical().Write(path, options)
@@ -90,6 +95,10 @@ Configuration:
   method=<string>
       MFA method.
 
+  new_password=<string>
+      Change the password to this value on login. Required once the password
+      has expired.
+
   passcode=<string>
       MFA OTP/passcode.
 
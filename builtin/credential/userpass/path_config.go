@@ -0,0 +1,226 @@
+package userpass
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+// maxPasswordHistory caps the number of previous password hashes kept per
+// user, each of them is checked with bcrypt on every password change.
+const maxPasswordHistory = 24
+
+func pathConfig(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config$",
+		Fields: map[string]*framework.FieldSchema{
+			"password_min_length": {
+				Type:        framework.TypeInt,
+				Description: "Minimum length of passwords. 0 disables the check.",
+			},
+
+			"password_require_uppercase": {
+				Type:        framework.TypeBool,
+				Description: "Require passwords to contain an uppercase letter.",
+			},
+
+			"password_require_lowercase": {
+				Type:        framework.TypeBool,
+				Description: "Require passwords to contain a lowercase letter.",
+			},
+
+			"password_require_digit": {
+				Type:        framework.TypeBool,
+				Description: "Require passwords to contain a digit.",
+			},
+
+			"password_require_symbol": {
+				Type:        framework.TypeBool,
+				Description: "Require passwords to contain a character that is not a letter or a digit.",
+			},
+
+			"password_history": {
+				Type:        framework.TypeInt,
+				Description: fmt.Sprintf("Number of previous passwords that may not be reused, at most %d.", maxPasswordHistory),
+			},
+
+			"password_max_age": {
+				Type: framework.TypeDurationSecond,
+				Description: `Duration after which a password expires and must be changed on the next
+login. 0 disables expiration.`,
+			},
+
+			"bcrypt_cost": {
+				Type:        framework.TypeInt,
+				Description: fmt.Sprintf("The bcrypt cost used to hash new passwords. Defaults to %d.", bcrypt.DefaultCost),
+			},
+
+			"lockout_threshold": {
+				Type:        framework.TypeInt,
+				Description: "Number of consecutive failed logins after which the user is locked. 0 disables lockout.",
+			},
+
+			"lockout_duration": {
+				Type: framework.TypeDurationSecond,
+				Description: `Duration a user stays locked. 0 keeps the user locked until it is unlocked
+through "users/<username>/unlock".`,
+			},
+
+			"lockout_counter_reset": {
+				Type: framework.TypeDurationSecond,
+				Description: `Duration after the last failed login after which the failed login count
+is reset. 0 only resets the count on a successful login.`,
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation:   b.pathConfigRead,
+			logical.UpdateOperation: b.pathConfigWrite,
+		},
+
+		HelpSynopsis:    pathConfigHelpSyn,
+		HelpDescription: pathConfigHelpDesc,
+	}
+}
+
+// config returns the stored configuration, or the default one if none has
+// been written.
+func (b *backend) config(ctx context.Context, s logical.Storage) (*userpassConfig, error) {
+	entry, err := s.Get(ctx, "config")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &userpassConfig{
+		BcryptCost: bcrypt.DefaultCost,
+	}
+	if entry == nil {
+		return result, nil
+	}
+
+	if err := entry.DecodeJSON(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"password_min_length":        config.PasswordMinLength,
+			"password_require_uppercase": config.PasswordRequireUppercase,
+			"password_require_lowercase": config.PasswordRequireLowercase,
+			"password_require_digit":     config.PasswordRequireDigit,
+			"password_require_symbol":    config.PasswordRequireSymbol,
+			"password_history":           config.PasswordHistory,
+			"password_max_age":           int64(config.PasswordMaxAge.Seconds()),
+			"bcrypt_cost":                config.BcryptCost,
+			"lockout_threshold":          config.LockoutThreshold,
+			"lockout_duration":           int64(config.LockoutDuration.Seconds()),
+			"lockout_counter_reset":      int64(config.LockoutCounterReset.Seconds()),
+		},
+	}, nil
+}
+
+func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw, ok := d.GetOk("password_min_length"); ok {
+		config.PasswordMinLength = raw.(int)
+	}
+	if raw, ok := d.GetOk("password_require_uppercase"); ok {
+		config.PasswordRequireUppercase = raw.(bool)
+	}
+	if raw, ok := d.GetOk("password_require_lowercase"); ok {
+		config.PasswordRequireLowercase = raw.(bool)
+	}
+	if raw, ok := d.GetOk("password_require_digit"); ok {
+		config.PasswordRequireDigit = raw.(bool)
+	}
+	if raw, ok := d.GetOk("password_require_symbol"); ok {
+		config.PasswordRequireSymbol = raw.(bool)
+	}
+	if raw, ok := d.GetOk("password_history"); ok {
+		config.PasswordHistory = raw.(int)
+	}
+	if raw, ok := d.GetOk("password_max_age"); ok {
+		config.PasswordMaxAge = time.Duration(raw.(int)) * time.Second
+	}
+	if raw, ok := d.GetOk("bcrypt_cost"); ok {
+		config.BcryptCost = raw.(int)
+	}
+	if raw, ok := d.GetOk("lockout_threshold"); ok {
+		config.LockoutThreshold = raw.(int)
+	}
+	if raw, ok := d.GetOk("lockout_duration"); ok {
+		config.LockoutDuration = time.Duration(raw.(int)) * time.Second
+	}
+	if raw, ok := d.GetOk("lockout_counter_reset"); ok {
+		config.LockoutCounterReset = time.Duration(raw.(int)) * time.Second
+	}
+
+	switch {
+	case config.PasswordMinLength < 0:
+		return logical.ErrorResponse("password_min_length cannot be negative"), logical.ErrInvalidRequest
+	case config.PasswordHistory < 0 || config.PasswordHistory > maxPasswordHistory:
+		return logical.ErrorResponse(fmt.Sprintf("password_history must be between 0 and %d", maxPasswordHistory)), logical.ErrInvalidRequest
+	case config.PasswordMaxAge < 0:
+		return logical.ErrorResponse("password_max_age cannot be negative"), logical.ErrInvalidRequest
+	case config.BcryptCost < bcrypt.MinCost || config.BcryptCost > bcrypt.MaxCost:
+		return logical.ErrorResponse(fmt.Sprintf("bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)), logical.ErrInvalidRequest
+	case config.LockoutThreshold < 0:
+		return logical.ErrorResponse("lockout_threshold cannot be negative"), logical.ErrInvalidRequest
+	case config.LockoutDuration < 0:
+		return logical.ErrorResponse("lockout_duration cannot be negative"), logical.ErrInvalidRequest
+	case config.LockoutCounterReset < 0:
+		return logical.ErrorResponse("lockout_counter_reset cannot be negative"), logical.ErrInvalidRequest
+	}
+
+	entry, err := logical.StorageEntryJSON("config", config)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, req.Storage.Put(ctx, entry)
+}
+
+type userpassConfig struct {
+	PasswordMinLength        int           `json:"password_min_length"`
+	PasswordRequireUppercase bool          `json:"password_require_uppercase"`
+	PasswordRequireLowercase bool          `json:"password_require_lowercase"`
+	PasswordRequireDigit     bool          `json:"password_require_digit"`
+	PasswordRequireSymbol    bool          `json:"password_require_symbol"`
+	PasswordHistory          int           `json:"password_history"`
+	PasswordMaxAge           time.Duration `json:"password_max_age"`
+	BcryptCost               int           `json:"bcrypt_cost"`
+	LockoutThreshold         int           `json:"lockout_threshold"`
+	LockoutDuration          time.Duration `json:"lockout_duration"`
+	LockoutCounterReset      time.Duration `json:"lockout_counter_reset"`
+}
+
+const pathConfigHelpSyn = `
+Configure the password policy and account lockout.
+`
+
+const pathConfigHelpDesc = `
+This endpoint configures the rules new passwords must follow, how long
+passwords stay valid and after how many failed logins users are locked.
+
+The password rules are enforced whenever a password is set, either by an
+operator or by the user on login. Users whose password has expired must provide
+a "new_password" when logging in.
+`
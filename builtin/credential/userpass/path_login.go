@@ -5,9 +5,11 @@ import (
 	"crypto/subtle"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jiangjiali/vault/sdk/framework"
 	"github.com/jiangjiali/vault/sdk/helper/cidrutil"
+	"github.com/jiangjiali/vault/sdk/helper/locksutil"
 	"github.com/jiangjiali/vault/sdk/helper/policyutil"
 	"github.com/jiangjiali/vault/sdk/logical"
 	"golang.org/x/crypto/bcrypt"
@@ -26,6 +28,11 @@ func pathLogin(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Password for this user.",
 			},
+
+			"new_password": {
+				Type:        framework.TypeString,
+				Description: "If set, the password of the user is changed to this value on login. Required once the password has expired.",
+			},
 		},
 
 		Callbacks: map[logical.Operation]framework.OperationFunc{
@@ -61,6 +68,11 @@ func (b *backend) pathLogin(ctx context.Context, req *logical.Request, d *framew
 		return nil, fmt.Errorf("missing password")
 	}
 
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
 	// Get the user and validate auth
 	user, userError := b.user(ctx, req.Storage, username)
 
@@ -86,14 +98,11 @@ func (b *backend) pathLogin(ctx context.Context, req *logical.Request, d *framew
 	// Check for a password match. Check for a hash collision for Vault 0.2+,
 	// but handle the older legacy passwords with a constant time comparison.
 	passwordBytes := []byte(password)
+	var passwordOK bool
 	if !legacyPassword {
-		if err := bcrypt.CompareHashAndPassword(userPassword, passwordBytes); err != nil {
-			return logical.ErrorResponse("invalid username or password"), nil
-		}
+		passwordOK = bcrypt.CompareHashAndPassword(userPassword, passwordBytes) == nil
 	} else {
-		if subtle.ConstantTimeCompare(userPassword, passwordBytes) != 1 {
-			return logical.ErrorResponse("invalid username or password"), nil
-		}
+		passwordOK = subtle.ConstantTimeCompare(userPassword, passwordBytes) == 1
 	}
 
 	if userError != nil {
@@ -103,11 +112,38 @@ func (b *backend) pathLogin(ctx context.Context, req *logical.Request, d *framew
 		return logical.ErrorResponse("invalid username or password"), nil
 	}
 
+	// Locked users get the same error as a wrong password, and their failed
+	// attempts are not counted so the lock is not extended.
+	now := time.Now()
+	if user.locked(now) {
+		return logical.ErrorResponse("invalid username or password"), nil
+	}
+
+	if !passwordOK {
+		if err := b.recordFailedLogin(ctx, req.Storage, config, username); err != nil {
+			b.Logger().Error("failed to record failed login", "username", username, "error", err)
+		}
+		return logical.ErrorResponse("invalid username or password"), nil
+	}
+
 	// Check for a CIDR match.
 	if !cidrutil.RemoteAddrIsOk(req.Connection.RemoteAddr, user.BoundCIDRs) {
 		return logical.ErrorResponse("login request originated from invalid CIDR"), nil
 	}
 
+	newPassword := d.Get("new_password").(string)
+	if newPassword == "" && user.passwordExpired(config, now) {
+		return logical.ErrorResponse("password has expired, a new_password must be provided"), nil
+	}
+
+	userErr, err := b.recordLogin(ctx, req.Storage, config, username, newPassword)
+	if err != nil {
+		return nil, err
+	}
+	if userErr != nil {
+		return logical.ErrorResponse(userErr.Error()), nil
+	}
+
 	return &logical.Response{
 		Auth: &logical.Auth{
 			Policies: user.Policies,
@@ -128,6 +164,81 @@ func (b *backend) pathLogin(ctx context.Context, req *logical.Request, d *framew
 	}, nil
 }
 
+// recordFailedLogin increments the failed login count of the user and locks
+// the user once the lockout threshold is reached.
+func (b *backend) recordFailedLogin(ctx context.Context, s logical.Storage, config *userpassConfig, username string) error {
+	if config.LockoutThreshold <= 0 {
+		return nil
+	}
+
+	lock := locksutil.LockForKey(b.userLocks, username)
+	lock.Lock()
+	defer lock.Unlock()
+
+	user, err := b.user(ctx, s, username)
+	if err != nil || user == nil {
+		return err
+	}
+
+	now := time.Now()
+	if user.locked(now) {
+		return nil
+	}
+
+	// Start counting again once an earlier lock expired, or if the last
+	// failure is older than the reset window.
+	if user.Locked || (config.LockoutCounterReset > 0 && now.Sub(user.LastFailedLoginTime) > config.LockoutCounterReset) {
+		user.Locked = false
+		user.LockedUntil = time.Time{}
+		user.FailedLoginCount = 0
+	}
+
+	user.FailedLoginCount++
+	user.LastFailedLoginTime = now
+
+	if user.FailedLoginCount >= config.LockoutThreshold {
+		user.Locked = true
+		if config.LockoutDuration > 0 {
+			user.LockedUntil = now.Add(config.LockoutDuration)
+		}
+		b.Logger().Warn("user locked after too many failed logins", "username", username, "failed_logins", user.FailedLoginCount)
+	}
+
+	return b.setUser(ctx, s, username, user)
+}
+
+// recordLogin resets the failed login count of the user and records the login
+// time, changing the password first if newPassword is set. The first error is
+// meant for the user, the second one is internal.
+func (b *backend) recordLogin(ctx context.Context, s logical.Storage, config *userpassConfig, username, newPassword string) (error, error) {
+	lock := locksutil.LockForKey(b.userLocks, username)
+	lock.Lock()
+	defer lock.Unlock()
+
+	user, err := b.user(ctx, s, username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return fmt.Errorf("invalid username or password"), nil
+	}
+
+	if newPassword != "" {
+		userErr, intErr := b.updateUserPassword(config, newPassword, user)
+		if intErr != nil || userErr != nil {
+			return userErr, intErr
+		}
+	}
+
+	user.Locked = false
+	user.LockedUntil = time.Time{}
+	user.FailedLoginCount = 0
+	user.LastFailedLoginTime = time.Time{}
+	user.LastLoginTime = time.Now()
+
+	return nil, b.setUser(ctx, s, username, user)
+}
+
 func (b *backend) pathLoginRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	// Get the user
 	user, err := b.user(ctx, req.Storage, req.Auth.Metadata["username"])
@@ -154,5 +265,7 @@ Log in with a username and password.
 `
 
 const pathLoginDesc = `
-This endpoint authenticates using a username and password.
+This endpoint authenticates using a username and password. If the password has
+expired, a "new_password" following the password policy must be provided and
+replaces the current password on a successful login.
 `
@@ -3,10 +3,15 @@ package userpass
 import (
 	"context"
 	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/crypto/bcrypt"
 
 	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/locksutil"
 	"github.com/jiangjiali/vault/sdk/logical"
 )
 
@@ -37,6 +42,10 @@ func pathUserPassword(b *backend) *framework.Path {
 func (b *backend) pathUserPasswordUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	username := d.Get("username").(string)
 
+	lock := locksutil.LockForKey(b.userLocks, strings.ToLower(username))
+	lock.Lock()
+	defer lock.Unlock()
+
 	userEntry, err := b.user(ctx, req.Storage, username)
 	if err != nil {
 		return nil, err
@@ -45,10 +54,15 @@ func (b *backend) pathUserPasswordUpdate(ctx context.Context, req *logical.Reque
 		return nil, fmt.Errorf("username does not exist")
 	}
 
-	userErr, intErr := b.updateUserPassword(d, userEntry)
-	if intErr != nil {
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
 		return nil, err
 	}
+
+	userErr, intErr := b.updateUserPassword(config, d.Get("password").(string), userEntry)
+	if intErr != nil {
+		return nil, intErr
+	}
 	if userErr != nil {
 		return logical.ErrorResponse(userErr.Error()), logical.ErrInvalidRequest
 	}
@@ -56,24 +70,90 @@ func (b *backend) pathUserPasswordUpdate(ctx context.Context, req *logical.Reque
 	return nil, b.setUser(ctx, req.Storage, username, userEntry)
 }
 
-func (b *backend) updateUserPassword(d *framework.FieldData, userEntry *UserEntry) (error, error) {
-	password := d.Get("password").(string)
+// updateUserPassword checks the password against the password policy and the
+// user's previous passwords and sets it as the user's password. The first
+// error is meant for the user, the second one is internal.
+func (b *backend) updateUserPassword(config *userpassConfig, password string, userEntry *UserEntry) (error, error) {
 	if password == "" {
 		return fmt.Errorf("missing password"), nil
 	}
+	if err := validatePassword(config, password); err != nil {
+		return err, nil
+	}
+
+	if config.PasswordHistory > 0 {
+		previous := append([][]byte{userEntry.PasswordHash}, userEntry.PasswordHistory...)
+		if len(previous) > config.PasswordHistory {
+			previous = previous[:config.PasswordHistory]
+		}
+		for _, hash := range previous {
+			if hash != nil && bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+				return fmt.Errorf("password was used recently and cannot be reused"), nil
+			}
+		}
+	}
+
 	// Generate a hash of the password
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), config.BcryptCost)
 	if err != nil {
 		return nil, err
 	}
+
+	// Keep the hashes of the previous passwords, most recent first
+	userEntry.PasswordHistory = nil
+	if config.PasswordHistory > 1 && userEntry.PasswordHash != nil {
+		history := append([][]byte{userEntry.PasswordHash}, userEntry.PasswordHistory...)
+		if len(history) > config.PasswordHistory-1 {
+			history = history[:config.PasswordHistory-1]
+		}
+		userEntry.PasswordHistory = history
+	}
+
 	userEntry.PasswordHash = hash
+	userEntry.Password = ""
+	userEntry.PasswordLastSet = time.Now()
 	return nil, nil
 }
 
+// validatePassword checks the password against the configured password policy.
+func validatePassword(config *userpassConfig, password string) error {
+	if config.PasswordMinLength > 0 && utf8.RuneCountInString(password) < config.PasswordMinLength {
+		return fmt.Errorf("password must be at least %d characters long", config.PasswordMinLength)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+
+	switch {
+	case config.PasswordRequireUppercase && !upper:
+		return fmt.Errorf("password must contain an uppercase letter")
+	case config.PasswordRequireLowercase && !lower:
+		return fmt.Errorf("password must contain a lowercase letter")
+	case config.PasswordRequireDigit && !digit:
+		return fmt.Errorf("password must contain a digit")
+	case config.PasswordRequireSymbol && !symbol:
+		return fmt.Errorf("password must contain a symbol")
+	}
+
+	return nil
+}
+
 const pathUserPasswordHelpSyn = `
 Reset user's password.
 `
 
 const pathUserPasswordHelpDesc = `
-This endpoint allows resetting the user's password.
+This endpoint allows resetting the user's password. The new password must
+follow the password policy set through "config".
 `
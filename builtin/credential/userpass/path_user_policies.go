@@ -3,8 +3,10 @@ package userpass
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/locksutil"
 	"github.com/jiangjiali/vault/sdk/helper/policyutil"
 	"github.com/jiangjiali/vault/sdk/logical"
 )
@@ -35,6 +37,10 @@ func pathUserPolicies(b *backend) *framework.Path {
 func (b *backend) pathUserPoliciesUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	username := d.Get("username").(string)
 
+	lock := locksutil.LockForKey(b.userLocks, strings.ToLower(username))
+	lock.Lock()
+	defer lock.Unlock()
+
 	userEntry, err := b.user(ctx, req.Storage, username)
 	if err != nil {
 		return nil, err
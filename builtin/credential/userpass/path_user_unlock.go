@@ -0,0 +1,62 @@
+package userpass
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/locksutil"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+func pathUserUnlock(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "users/" + framework.GenericNameRegex("username") + "/unlock$",
+		Fields: map[string]*framework.FieldSchema{
+			"username": {
+				Type:        framework.TypeString,
+				Description: "Username for this user.",
+			},
+		},
+
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.UpdateOperation: b.pathUserUnlockUpdate,
+		},
+
+		HelpSynopsis:    pathUserUnlockHelpSyn,
+		HelpDescription: pathUserUnlockHelpDesc,
+	}
+}
+
+func (b *backend) pathUserUnlockUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	username := strings.ToLower(d.Get("username").(string))
+
+	lock := locksutil.LockForKey(b.userLocks, username)
+	lock.Lock()
+	defer lock.Unlock()
+
+	userEntry, err := b.user(ctx, req.Storage, username)
+	if err != nil {
+		return nil, err
+	}
+	if userEntry == nil {
+		return nil, fmt.Errorf("username does not exist")
+	}
+
+	userEntry.Locked = false
+	userEntry.LockedUntil = time.Time{}
+	userEntry.FailedLoginCount = 0
+	userEntry.LastFailedLoginTime = time.Time{}
+
+	return nil, b.setUser(ctx, req.Storage, username, userEntry)
+}
+
+const pathUserUnlockHelpSyn = `
+Unlock a user locked after too many failed logins.
+`
+
+const pathUserUnlockHelpDesc = `
+This endpoint unlocks the user and resets its failed login count.
+`
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/locksutil"
 	"github.com/jiangjiali/vault/sdk/helper/parseutil"
 	"github.com/jiangjiali/vault/sdk/helper/policyutil"
 	"github.com/jiangjiali/vault/sdk/helper/sockaddr"
@@ -141,18 +142,40 @@ func (b *backend) pathUserRead(ctx context.Context, req *logical.Request, d *fra
 		return nil, nil
 	}
 
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	data := map[string]interface{}{
+		"policies":           user.Policies,
+		"ttl":                user.TTL.Seconds(),
+		"max_ttl":            user.MaxTTL.Seconds(),
+		"bound_cidrs":        user.BoundCIDRs,
+		"last_login_time":    formatTime(user.LastLoginTime),
+		"password_last_set":  formatTime(user.PasswordLastSet),
+		"password_expired":   user.passwordExpired(config, now),
+		"failed_login_count": user.FailedLoginCount,
+		"locked":             user.locked(now),
+		"locked_until":       "",
+	}
+	if user.locked(now) {
+		data["locked_until"] = formatTime(user.LockedUntil)
+	}
+
 	return &logical.Response{
-		Data: map[string]interface{}{
-			"policies":    user.Policies,
-			"ttl":         user.TTL.Seconds(),
-			"max_ttl":     user.MaxTTL.Seconds(),
-			"bound_cidrs": user.BoundCIDRs,
-		},
+		Data: data,
 	}, nil
 }
 
 func (b *backend) userCreateUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	username := strings.ToLower(d.Get("username").(string))
+
+	lock := locksutil.LockForKey(b.userLocks, username)
+	lock.Lock()
+	defer lock.Unlock()
+
 	userEntry, err := b.user(ctx, req.Storage, username)
 	if err != nil {
 		return nil, err
@@ -163,10 +186,15 @@ func (b *backend) userCreateUpdate(ctx context.Context, req *logical.Request, d
 	}
 
 	if _, ok := d.GetOk("password"); ok {
-		userErr, intErr := b.updateUserPassword(d, userEntry)
-		if intErr != nil {
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
 			return nil, err
 		}
+
+		userErr, intErr := b.updateUserPassword(config, d.Get("password").(string), userEntry)
+		if intErr != nil {
+			return nil, intErr
+		}
 		if userErr != nil {
 			return logical.ErrorResponse(userErr.Error()), logical.ErrInvalidRequest
 		}
@@ -221,6 +249,48 @@ type UserEntry struct {
 	MaxTTL time.Duration
 
 	BoundCIDRs []*sockaddr.SockAddrMarshaler
+
+	// PasswordHistory holds the hashes of the previous passwords, most
+	// recent first.
+	PasswordHistory [][]byte
+
+	// PasswordLastSet is the time the password was last changed. It is zero
+	// for passwords set before it was tracked, these never expire.
+	PasswordLastSet time.Time
+
+	LastLoginTime time.Time
+
+	// FailedLoginCount is the number of consecutive failed logins
+	FailedLoginCount    int
+	LastFailedLoginTime time.Time
+
+	// Locked is set once the lockout threshold is reached. The user stays
+	// locked until LockedUntil, or until unlocked if it is zero.
+	Locked      bool
+	LockedUntil time.Time
+}
+
+// locked returns whether the user is currently locked out.
+func (u *UserEntry) locked(now time.Time) bool {
+	return u.Locked && (u.LockedUntil.IsZero() || now.Before(u.LockedUntil))
+}
+
+// passwordExpired returns whether the password is older than the configured
+// maximum age.
+func (u *UserEntry) passwordExpired(config *userpassConfig, now time.Time) bool {
+	if config.PasswordMaxAge <= 0 || u.PasswordLastSet.IsZero() {
+		return false
+	}
+
+	return now.After(u.PasswordLastSet.Add(config.PasswordMaxAge))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.UTC().Format(time.RFC3339Nano)
 }
 
 const pathUserHelpSyn = `
@@ -363,6 +363,7 @@ func (c *AgentCommand) Run(args []string) int {
 			BaseContext: ctx,
 			Proxier:     apiProxy,
 			Logger:      cacheLogger.Named("leasecache"),
+			KVWatch:     xxconfig.Cache.KVWatch,
 		})
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error creating lease cache: %v", err))
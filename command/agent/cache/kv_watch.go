@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jiangjiali/vault/api"
+	"github.com/jiangjiali/vault/command/agent/cache/cachememdb"
+)
+
+// kvWatchWait is the time each watch request made by the agent blocks for. It
+// is the maximum wait of the KV backend, which caps longer ones.
+const kvWatchWait = 15 * time.Second
+
+// kvDataVersion returns the version of the key if the response is a KV v2 read
+// of the current version that can be cached and kept up to date by watching the
+// key.
+func (c *LeaseCache) kvDataVersion(req *SendRequest, secret *api.Secret) (uint64, bool) {
+	if !c.kvWatch || secret == nil || secret.LeaseID != "" || secret.Auth != nil {
+		return 0, false
+	}
+
+	// Reads of a specific version and watch requests are passed through
+	if req.Request.Method != http.MethodGet || len(req.Request.URL.Query()) != 0 {
+		return 0, false
+	}
+
+	if _, ok := secret.Data["data"].(map[string]interface{}); !ok {
+		return 0, false
+	}
+	metadata, ok := secret.Data["metadata"].(map[string]interface{})
+	if !ok {
+		return 0, false
+	}
+
+	return kvVersion(metadata["version"])
+}
+
+func kvVersion(raw interface{}) (uint64, bool) {
+	switch v := raw.(type) {
+	case json.Number:
+		version, err := strconv.ParseUint(v.String(), 10, 64)
+		return version, err == nil
+	case float64:
+		return uint64(v), true
+	}
+
+	return 0, false
+}
+
+// startWatching watches the key of a cached KV v2 read and evicts the cached
+// response as soon as the key changes, the watch fails or the context is
+// cancelled.
+func (c *LeaseCache) startWatching(ctx context.Context, index *cachememdb.Index, req *SendRequest, secret *api.Secret, version uint64) {
+	defer func() {
+		id := ctx.Value(contextIndexID).(string)
+		c.logger.Debug("evicting index from cache", "id", id, "method", req.Request.Method, "path", req.Request.URL.Path)
+		err := c.db.Evict(cachememdb.IndexNameID, id)
+		if err != nil {
+			c.logger.Error("failed to evict index", "id", id, "error", err)
+			return
+		}
+	}()
+
+	client, err := c.client.Clone()
+	if err != nil {
+		c.logger.Error("failed to create API client in the watcher", "error", err)
+		return
+	}
+	client.SetToken(req.Token)
+	client.SetHeaders(req.Request.Header)
+
+	// Stop the watch request when the index is removed without affecting
+	// any of the derived contexts
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-index.RenewCtxInfo.DoneCh:
+			cancel()
+		case <-watchCtx.Done():
+		}
+	}()
+
+	path := strings.TrimPrefix(req.Request.URL.Path, "/v1/")
+
+	c.logger.Debug("initiating kv watch", "method", req.Request.Method, "path", req.Request.URL.Path)
+	for {
+		current, err := client.Logical().WatchWithContext(watchCtx, path, version, kvWatchWait)
+		if watchCtx.Err() != nil {
+			c.logger.Debug("context cancelled; stopping watcher", "path", req.Request.URL.Path)
+			return
+		}
+		if err != nil {
+			c.logger.Error("failed to watch kv secret", "error", err)
+			return
+		}
+
+		if current == nil || !reflect.DeepEqual(current.Data, secret.Data) {
+			c.logger.Debug("kv secret changed; evicting from cache", "path", req.Request.URL.Path)
+			return
+		}
+	}
+}
@@ -78,6 +78,10 @@ type LeaseCache struct {
 	// idLocks is used during cache lookup to ensure that identical requests made
 	// in parallel won't trigger multiple renewal goroutines.
 	idLocks []*locksutil.LockEntry
+
+	// kvWatch enables caching KV v2 reads, which are kept up to date by
+	// watching the key.
+	kvWatch bool
 }
 
 // LeaseCacheConfig is the configuration for initializing a new
@@ -87,6 +91,7 @@ type LeaseCacheConfig struct {
 	BaseContext context.Context
 	Proxier     Proxier
 	Logger      hclog.Logger
+	KVWatch     bool
 }
 
 // NewLeaseCache creates a new instance of a LeaseCache.
@@ -119,6 +124,7 @@ func NewLeaseCache(conf *LeaseCacheConfig) (*LeaseCache, error) {
 		baseCtxInfo: baseCtxInfo,
 		l:           &sync.RWMutex{},
 		idLocks:     locksutil.CreateLocks(),
+		kvWatch:     conf.KVWatch,
 	}, nil
 }
 
@@ -258,19 +264,39 @@ func (c *LeaseCache) Send(ctx context.Context, req *SendRequest) (*SendResponse,
 		return resp, nil
 	}
 
+	// KV v2 reads are not renewable but can be kept up to date by watching
+	// the key
+	kvVersion, isKV := c.kvDataVersion(req, secret)
+
 	// Short-circuit if the secret is not renewable
 	tokenRenewable, err := secret.TokenIsRenewable()
 	if err != nil {
 		c.logger.Error("failed to parse renewable param", "error", err)
 		return nil, err
 	}
-	if !secret.Renewable && !tokenRenewable {
+	if !isKV && !secret.Renewable && !tokenRenewable {
 		c.logger.Debug("pass-through response; secret not renewable", "method", req.Request.Method, "path", req.Request.URL.Path)
 		return resp, nil
 	}
 
 	var renewCtxInfo *cachememdb.ContextInfo
 	switch {
+	case isKV:
+		c.logger.Debug("processing kv response", "method", req.Request.Method, "path", req.Request.URL.Path)
+		entry, err := c.db.Get(cachememdb.IndexNameToken, req.Token)
+		if err != nil {
+			return nil, err
+		}
+		// If the token is not managed by the agent, return the response
+		// without caching it.
+		if entry == nil {
+			c.logger.Debug("pass-through kv response; token not managed by agent", "method", req.Request.Method, "path", req.Request.URL.Path)
+			return resp, nil
+		}
+
+		// Derive a context for watching using the token's context
+		renewCtxInfo = cachememdb.NewContextInfo(entry.RenewCtxInfo.Ctx)
+
 	case secret.LeaseID != "":
 		c.logger.Debug("processing lease response", "method", req.Request.Method, "path", req.Request.URL.Path)
 		entry, err := c.db.Get(cachememdb.IndexNameToken, req.Token)
@@ -360,7 +386,12 @@ func (c *LeaseCache) Send(ctx context.Context, req *SendRequest) (*SendResponse,
 		return nil, err
 	}
 
-	// Start renewing the secret in the response
+	// Start renewing the secret in the response, or watching the key for
+	// KV reads
+	if isKV {
+		go c.startWatching(renewCtx, index, req, secret, kvVersion)
+		return resp, nil
+	}
 	go c.startRenewing(renewCtx, index, req, secret)
 
 	return resp, nil
@@ -37,6 +37,7 @@ type Vault struct {
 
 type Cache struct {
 	UseAutoAuthToken bool `hcl:"use_auto_auth_token"`
+	KVWatch          bool `hcl:"kv_watch"`
 }
 
 type Listener struct {
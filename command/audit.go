@@ -31,6 +31,14 @@ func (c *AuditCommand) Help() string {
 
        $ vault audit enable file file_path=/var/log/audit.log
 
+  验证以"hash_chain=true"启用的审核设备的日志：
+
+      $ vault audit verify -device=file/ /var/log/audit.log
+
+  在审核日志中搜索读取了"secret/app"的条目：
+
+      $ vault audit search -device=file/ -path=secret/app /var/log/audit.log
+
   有关详细的用法信息，请参阅各个子命令帮助。
 `
 
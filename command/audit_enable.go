@@ -43,6 +43,36 @@ Usage: vault audit enable [options] TYPE [CONFIG K=V...]
 
       $ vault audit enable file file_path=/var/log/audit.log
 
+  To rotate the audit log daily and keep a week of compressed logs:
+
+      $ vault audit enable file file_path=/var/log/audit.log \
+          rotate_period=24h rotate_compress=true rotate_max_files=7
+
+  Every audit device accepts a filter selecting the entries it logs, and lists
+  of fields to exclude from the entries or to always hash:
+
+      $ vault audit enable -path=siem file file_path=/var/log/siem.log \
+          filter='mount_type != "transit" or error' \
+          exclude_fields=response.data hmac_fields=request.data.username
+
+  Entries are written as JSON by default. The format option selects "jsonx"
+  (XML), "cef" (ArcSight Common Event Format) or "otlp" (OpenTelemetry log
+  records) instead:
+
+      $ vault audit enable syslog format=cef tag=vault
+
+  The webhook and kafka devices buffer the entries on disk and deliver them in
+  batches, retrying until the sink accepts them. When the buffer is full, the
+  entries are dropped with fail_policy=open, or fail the requests with the
+  default fail_policy=closed:
+
+      $ vault audit enable webhook url=https://siem.example.com/ingest \
+          buffer_path=/var/lib/vault/audit-webhook buffer_max_bytes=256MB \
+          header_Authorization="Bearer ..." fail_policy=open
+
+      $ vault audit enable kafka address=kafka1:9092,kafka2:9092 \
+          topic=vault-audit buffer_path=/var/lib/vault/audit-kafka
+
 ` + c.Flags().Help()
 
 	return strings.TrimSpace(helpText)
@@ -91,6 +121,8 @@ func (c *AuditEnableCommand) AutocompleteArgs() complete.Predictor {
 		"file",
 		"syslog",
 		"socket",
+		"webhook",
+		"kafka",
 	)
 }
 
@@ -0,0 +1,436 @@
+package command
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/complete"
+	"github.com/jiangjiali/vault/sdk/helper/glob"
+	"github.com/jiangjiali/vault/sdk/helper/mitchellh/cli"
+	"github.com/jiangjiali/vault/sdk/helper/parseutil"
+)
+
+var _ cli.Command = (*AuditSearchCommand)(nil)
+var _ cli.CommandAutocomplete = (*AuditSearchCommand)(nil)
+
+type AuditSearchCommand struct {
+	*BaseCommand
+
+	flagDevice    string
+	flagValues    []string
+	flagPath      string
+	flagAccessor  string
+	flagEntityID  string
+	flagOperation string
+	flagType      string
+	flagSince     string
+	flagUntil     string
+	flagPrefix    string
+}
+
+func (c *AuditSearchCommand) Synopsis() string {
+	return "搜索审核日志"
+}
+
+func (c *AuditSearchCommand) Help() string {
+	helpText := `
+使用: vault audit search [选项] FILE...
+
+  在JSON格式的审核日志中搜索与所有给定条件匹配的条目。已轮换的日志（包括以
+  ".gz"结尾的压缩文件）可以作为多个文件给出。
+
+  审核日志中的敏感值经过HMAC哈希处理。给出 -device 时，-value 和 -accessor
+  的明文值将通过设备的"sys/audit-hash"端点进行哈希，以匹配日志中的哈希值；
+  明文值本身也会匹配，例如在以"log_raw=true"启用的设备的日志中。
+
+  查找上周读取了"secret/app"的条目：
+
+      $ vault audit search -device=file/ -path=secret/app -operation=read \
+          -since=168h /var/log/audit.log
+
+  查找包含某个值（例如令牌）的所有条目：
+
+      $ vault audit search -device=file/ -value=s.abcd1234 /var/log/audit.log
+
+` + c.Flags().Help()
+
+	return strings.TrimSpace(helpText)
+}
+
+func (c *AuditSearchCommand) Flags() *FlagSets {
+	set := c.flagSet(FlagSetHTTP | FlagSetOutputFormat)
+
+	f := set.NewFlagSet("命令选项")
+
+	f.StringVar(&StringVar{
+		Name:       "device",
+		Target:     &c.flagDevice,
+		Default:    "",
+		EnvVar:     "",
+		Completion: c.PredictVaultAudits(),
+		Usage:      "写入日志的审核设备的路径，用于哈希 -value 和 -accessor 的明文值。",
+	})
+
+	f.StringSliceVar(&StringSliceVar{
+		Name:       "value",
+		Target:     &c.flagValues,
+		Completion: complete.PredictAnything,
+		Usage:      "条目的任意字段中必须包含的值。可以多次指定，匹配其中任意一个即可。",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "path",
+		Target:     &c.flagPath,
+		Default:    "",
+		EnvVar:     "",
+		Completion: complete.PredictAnything,
+		Usage:      "请求的路径，可以使用\"*\"通配符。与相对于命名空间的路径或完整路径匹配。",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "accessor",
+		Target:     &c.flagAccessor,
+		Default:    "",
+		EnvVar:     "",
+		Completion: complete.PredictAnything,
+		Usage:      "发出请求的令牌的访问器。",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "entity-id",
+		Target:     &c.flagEntityID,
+		Default:    "",
+		EnvVar:     "",
+		Completion: complete.PredictAnything,
+		Usage:      "发出请求的实体的ID。",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "operation",
+		Target:     &c.flagOperation,
+		Default:    "",
+		EnvVar:     "",
+		Completion: complete.PredictSet("create", "read", "update", "delete", "list"),
+		Usage:      "请求的操作，例如\"read\"或\"update\"。",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "type",
+		Target:     &c.flagType,
+		Default:    "",
+		EnvVar:     "",
+		Completion: complete.PredictSet("request", "response"),
+		Usage:      "条目的类型，\"request\"或\"response\"。",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "since",
+		Target:     &c.flagSince,
+		Default:    "",
+		EnvVar:     "",
+		Completion: complete.PredictAnything,
+		Usage:      "最早的条目时间，RFC3339格式的时间或距现在的持续时间，例如\"24h\"。",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "until",
+		Target:     &c.flagUntil,
+		Default:    "",
+		EnvVar:     "",
+		Completion: complete.PredictAnything,
+		Usage:      "最晚的条目时间，格式与 -since 相同。",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "prefix",
+		Target:     &c.flagPrefix,
+		Default:    "",
+		EnvVar:     "",
+		Completion: complete.PredictAnything,
+		Usage:      "设备上配置的条目前缀（如果有）。",
+	})
+
+	return set
+}
+
+func (c *AuditSearchCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictFiles("*")
+}
+
+func (c *AuditSearchCommand) AutocompleteFlags() complete.Flags {
+	return c.Flags().Completions()
+}
+
+// auditSearch are the criteria of the entries to find. Empty criteria match
+// every entry.
+type auditSearch struct {
+	values    map[string]bool
+	accessors map[string]bool
+	path      string
+	entityID  string
+	operation string
+	entryType string
+	since     time.Time
+	until     time.Time
+}
+
+func (c *AuditSearchCommand) Run(args []string) int {
+	f := c.Flags()
+
+	if err := f.Parse(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	args = f.Args()
+	if len(args) < 1 {
+		c.UI.Error(fmt.Sprintf("Not enough arguments (expected at least 1, got %d)", len(args)))
+		return 1
+	}
+
+	search := &auditSearch{
+		path:      c.flagPath,
+		entityID:  c.flagEntityID,
+		operation: c.flagOperation,
+		entryType: c.flagType,
+	}
+
+	now := time.Now()
+	var err error
+	if search.since, err = parseSearchTime(c.flagSince, now); err != nil {
+		c.UI.Error(fmt.Sprintf("Invalid -since: %s", err))
+		return 1
+	}
+	if search.until, err = parseSearchTime(c.flagUntil, now); err != nil {
+		c.UI.Error(fmt.Sprintf("Invalid -until: %s", err))
+		return 1
+	}
+
+	// Both the plaintext values and their hashes are matched
+	var plaintexts []string
+	if len(c.flagValues) > 0 {
+		search.values = make(map[string]bool)
+		for _, value := range c.flagValues {
+			search.values[value] = true
+			plaintexts = append(plaintexts, value)
+		}
+	}
+	if c.flagAccessor != "" {
+		search.accessors = map[string]bool{c.flagAccessor: true}
+		if !strings.HasPrefix(c.flagAccessor, "hmac-") {
+			plaintexts = append(plaintexts, c.flagAccessor)
+		}
+	}
+
+	if c.flagDevice != "" && len(plaintexts) > 0 {
+		client, err := c.Client()
+		if err != nil {
+			c.UI.Error(err.Error())
+			return 2
+		}
+
+		device := ensureNoTrailingSlash(sanitizePath(c.flagDevice))
+		for _, plaintext := range plaintexts {
+			hash, err := client.Sys().AuditHash(device, plaintext)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error hashing the search values: %s", err))
+				return 2
+			}
+			if search.values[plaintext] {
+				search.values[hash] = true
+			}
+			if plaintext == c.flagAccessor {
+				search.accessors[hash] = true
+			}
+		}
+	}
+
+	var matches []map[string]interface{}
+	var skipped int
+	for _, path := range args {
+		found, invalid, err := c.searchFile(path, search)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading audit log %q: %s", path, err))
+			return 2
+		}
+		matches = append(matches, found...)
+		skipped += invalid
+	}
+
+	if skipped > 0 {
+		c.UI.Warn(fmt.Sprintf("Skipped %d lines which are not JSON audit entries", skipped))
+	}
+
+	switch Format(c.UI) {
+	case "table":
+		if len(matches) == 0 {
+			c.UI.Output("No matching audit entries found.")
+			return 2
+		}
+		c.UI.Output(tableOutput(auditSearchTable(matches), nil))
+		return 0
+	default:
+		if matches == nil {
+			matches = []map[string]interface{}{}
+		}
+		return OutputData(c.UI, matches)
+	}
+}
+
+// parseSearchTime parses a time given as RFC3339 or as a duration before now
+func parseSearchTime(raw string, now time.Time) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	d, err := parseutil.ParseDurationSecond(raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a RFC3339 time or a duration, got %q", raw)
+	}
+	return now.Add(-d), nil
+}
+
+// searchFile returns the entries of the file matching the search, and the
+// number of lines which could not be parsed
+func (c *AuditSearchCommand) searchFile(path string, search *auditSearch) ([]map[string]interface{}, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var matches []map[string]interface{}
+	var invalid int
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			line = bytes.TrimPrefix(line, []byte(c.flagPrefix))
+			var entry map[string]interface{}
+			if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+				invalid++
+			} else if search.match(entry) {
+				matches = append(matches, entry)
+			}
+		}
+		if err == io.EOF {
+			return matches, invalid, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+}
+
+func (s *auditSearch) match(entry map[string]interface{}) bool {
+	auth, _ := entry["auth"].(map[string]interface{})
+	req, _ := entry["request"].(map[string]interface{})
+
+	if s.entryType != "" && entryString(entry, "type") != s.entryType {
+		return false
+	}
+
+	if s.operation != "" && entryString(req, "operation") != s.operation {
+		return false
+	}
+
+	if s.path != "" {
+		path := entryString(req, "path")
+		ns, _ := req["namespace"].(map[string]interface{})
+		if !glob.Glob(s.path, path) && !glob.Glob(s.path, entryString(ns, "path")+path) {
+			return false
+		}
+	}
+
+	if s.entityID != "" && entryString(auth, "entity_id") != s.entityID {
+		return false
+	}
+
+	if s.accessors != nil &&
+		!s.accessors[entryString(auth, "accessor")] &&
+		!s.accessors[entryString(req, "client_token_accessor")] {
+		return false
+	}
+
+	if !s.since.IsZero() || !s.until.IsZero() {
+		t, err := time.Parse(time.RFC3339Nano, entryString(entry, "time"))
+		if err != nil {
+			return false
+		}
+		if (!s.since.IsZero() && t.Before(s.since)) || (!s.until.IsZero() && t.After(s.until)) {
+			return false
+		}
+	}
+
+	if s.values != nil && !containsValue(entry, s.values) {
+		return false
+	}
+
+	return true
+}
+
+// containsValue returns whether any string in the decoded JSON value is one of
+// the values
+func containsValue(value interface{}, values map[string]bool) bool {
+	switch v := value.(type) {
+	case string:
+		return values[v]
+	case map[string]interface{}:
+		for _, elem := range v {
+			if containsValue(elem, values) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, elem := range v {
+			if containsValue(elem, values) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func entryString(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+func auditSearchTable(entries []map[string]interface{}) []string {
+	columns := []string{"Time | Type | Operation | Path | Display Name | Entity ID | Remote Address | Error"}
+	for _, entry := range entries {
+		auth, _ := entry["auth"].(map[string]interface{})
+		req, _ := entry["request"].(map[string]interface{})
+		ns, _ := req["namespace"].(map[string]interface{})
+		columns = append(columns, fmt.Sprintf("%s | %s | %s | %s | %s | %s | %s | %s",
+			entryString(entry, "time"),
+			entryString(entry, "type"),
+			entryString(req, "operation"),
+			entryString(ns, "path")+entryString(req, "path"),
+			entryString(auth, "display_name"),
+			entryString(auth, "entity_id"),
+			entryString(req, "remote_address"),
+			strings.Replace(entryString(entry, "error"), "\n", " ", -1),
+		))
+	}
+	return columns
+}
@@ -0,0 +1,190 @@
+package command
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jiangjiali/vault/audit"
+	"github.com/jiangjiali/vault/sdk/helper/complete"
+	"github.com/jiangjiali/vault/sdk/helper/mitchellh/cli"
+)
+
+var _ cli.Command = (*AuditVerifyCommand)(nil)
+var _ cli.CommandAutocomplete = (*AuditVerifyCommand)(nil)
+
+type AuditVerifyCommand struct {
+	*BaseCommand
+
+	flagDevice string
+	flagKey    string
+	flagPrefix string
+}
+
+func (c *AuditVerifyCommand) Synopsis() string {
+	return "验证审核日志的哈希链"
+}
+
+func (c *AuditVerifyCommand) Help() string {
+	helpText := `
+使用: vault audit verify [选项] FILE...
+
+  验证以"hash_chain=true"启用的审核设备写入的日志。每个条目都带有序列号和
+  前一个条目的哈希，并由从设备盐派生的密钥进行哈希。此命令报告缺失、重新排序
+  和被修改的条目。已轮换的日志（包括以".gz"结尾的压缩文件）可以按顺序作为多个
+  文件给出。
+
+  链的密钥通过设备的"sys/audit-hash"端点获取，或者直接使用 -key 给出。
+
+  验证审核设备"file/"的日志：
+
+      $ vault audit verify -device=file/ /var/log/audit.log
+
+  使用已知密钥离线验证日志：
+
+      $ vault audit verify -key=hmac-sha256:... /var/log/audit.log
+
+` + c.Flags().Help()
+
+	return strings.TrimSpace(helpText)
+}
+
+func (c *AuditVerifyCommand) Flags() *FlagSets {
+	set := c.flagSet(FlagSetHTTP)
+
+	f := set.NewFlagSet("命令选项")
+
+	f.StringVar(&StringVar{
+		Name:       "device",
+		Target:     &c.flagDevice,
+		Default:    "",
+		EnvVar:     "",
+		Completion: c.PredictVaultAudits(),
+		Usage:      "写入日志的审核设备的路径，用于从服务器获取链的密钥。",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "key",
+		Target:     &c.flagKey,
+		Default:    "",
+		EnvVar:     "",
+		Completion: complete.PredictAnything,
+		Usage: "链的密钥，即设备对\"" + audit.HashChainKeyInput + "\"的审核哈希。" +
+			"给出时不会联系服务器。",
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "prefix",
+		Target:     &c.flagPrefix,
+		Default:    "",
+		EnvVar:     "",
+		Completion: complete.PredictAnything,
+		Usage:      "设备上配置的条目前缀（如果有）。",
+	})
+
+	return set
+}
+
+func (c *AuditVerifyCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictFiles("*")
+}
+
+func (c *AuditVerifyCommand) AutocompleteFlags() complete.Flags {
+	return c.Flags().Completions()
+}
+
+func (c *AuditVerifyCommand) Run(args []string) int {
+	f := c.Flags()
+
+	if err := f.Parse(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	args = f.Args()
+	if len(args) < 1 {
+		c.UI.Error(fmt.Sprintf("Not enough arguments (expected at least 1, got %d)", len(args)))
+		return 1
+	}
+
+	key := c.flagKey
+	switch {
+	case key != "" && c.flagDevice != "":
+		c.UI.Error("Only one of -device and -key can be given")
+		return 1
+	case key == "" && c.flagDevice == "":
+		c.UI.Error("One of -device or -key is required")
+		return 1
+	case key == "":
+		client, err := c.Client()
+		if err != nil {
+			c.UI.Error(err.Error())
+			return 2
+		}
+
+		key, err = client.Sys().AuditHash(ensureNoTrailingSlash(sanitizePath(c.flagDevice)), audit.HashChainKeyInput)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error fetching the key of the hash chain: %s", err))
+			return 2
+		}
+	}
+
+	verifier := &audit.HashChainVerifier{
+		Key:    key,
+		Prefix: c.flagPrefix,
+	}
+	for _, path := range args {
+		file, err := os.Open(path)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error opening audit log: %s", err))
+			return 2
+		}
+		var r io.Reader = file
+		if strings.HasSuffix(path, ".gz") {
+			gz, err := gzip.NewReader(file)
+			if err != nil {
+				file.Close()
+				c.UI.Error(fmt.Sprintf("Error reading audit log %q: %s", path, err))
+				return 2
+			}
+			r = gz
+		}
+		err = verifier.Verify(path, r)
+		file.Close()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error reading audit log %q: %s", path, err))
+			return 2
+		}
+	}
+
+	if verifier.Entries == 0 {
+		c.UI.Error("No chained entries found")
+		return 2
+	}
+
+	if verifier.Unchained > 0 {
+		c.UI.Warn(fmt.Sprintf("Skipped %d entries written before the chain started", verifier.Unchained))
+	}
+	for _, restart := range verifier.Restarts {
+		c.UI.Warn(restart.String())
+	}
+	for _, problem := range verifier.Problems {
+		c.UI.Error(problem.String())
+	}
+
+	if len(verifier.Problems) > 0 {
+		c.UI.Error(fmt.Sprintf("Verification failed: %d problems found in %d entries", len(verifier.Problems), verifier.Entries))
+		return 2
+	}
+
+	if len(verifier.Restarts) > 0 {
+		c.UI.Output(fmt.Sprintf("Success! Verified %d entries in %d chains", verifier.Entries, len(verifier.Restarts)+1))
+		return 0
+	}
+
+	c.UI.Output(fmt.Sprintf("Success! Verified %d entries (sequence %d to %d)",
+		verifier.Entries, verifier.FirstSequence, verifier.LastSequence))
+	return 0
+}
@@ -20,15 +20,20 @@ import (
 	_ "github.com/jiangjiali/vault/builtin/registry"
 
 	auditFile "github.com/jiangjiali/vault/builtin/audit/file"
+	auditKafka "github.com/jiangjiali/vault/builtin/audit/kafka"
 	auditSocket "github.com/jiangjiali/vault/builtin/audit/socket"
 	auditSyslog "github.com/jiangjiali/vault/builtin/audit/syslog"
+	auditWebhook "github.com/jiangjiali/vault/builtin/audit/webhook"
 
 	credToken "github.com/jiangjiali/vault/builtin/credential/token"
 	credUserpass "github.com/jiangjiali/vault/builtin/credential/userpass"
 	credOIDC "github.com/jiangjiali/vault/plugins/vault-plugin-auth-jwt"
 
+	physBolt "github.com/jiangjiali/vault/sdk/physical/boltdb"
 	physFile "github.com/jiangjiali/vault/sdk/physical/file"
 	physInmem "github.com/jiangjiali/vault/sdk/physical/inmem"
+	physRaft "github.com/jiangjiali/vault/sdk/physical/raft"
+	physSQL "github.com/jiangjiali/vault/sdk/physical/sqldb"
 )
 
 const (
@@ -73,9 +78,11 @@ const (
 
 var (
 	auditBackends = map[string]audit.Factory{
-		"file":   auditFile.Factory,
-		"socket": auditSocket.Factory,
-		"syslog": auditSyslog.Factory,
+		"file":    auditFile.Factory,
+		"kafka":   auditKafka.Factory,
+		"socket":  auditSocket.Factory,
+		"syslog":  auditSyslog.Factory,
+		"webhook": auditWebhook.Factory,
 	}
 
 	credentialBackends = map[string]logical.Factory{
@@ -87,10 +94,14 @@ var (
 	}
 
 	physicalBackends = map[string]physical.Factory{
+		"boltdb":                 physBolt.NewBoltBackend,
 		"file_transactional":     physFile.NewTransactionalFileBackend,
 		"file":                   physFile.NewFileBackend,
 		"inmem_transactional":    physInmem.NewTransactionalInmem,
 		"inmem":                  physInmem.NewInmem,
+		"postgresql":             physSQL.NewPostgreSQLBackend,
+		"raft":                   physRaft.NewRaftBackend,
+		"sqlite":                 physSQL.NewSQLiteBackend,
 	}
 )
 
@@ -144,6 +155,16 @@ func initCommands(ui, serverCmdUi cli.Ui, runOpts *RunOptions) {
 				BaseCommand: getBaseCommand(),
 			}, nil
 		},
+		"audit search": func() (cli.Command, error) {
+			return &AuditSearchCommand{
+				BaseCommand: getBaseCommand(),
+			}, nil
+		},
+		"audit verify": func() (cli.Command, error) {
+			return &AuditVerifyCommand{
+				BaseCommand: getBaseCommand(),
+			}, nil
+		},
 		"auth tune": func() (cli.Command, error) {
 			return &AuthTuneCommand{
 				BaseCommand: getBaseCommand(),
@@ -278,6 +299,23 @@ func initCommands(ui, serverCmdUi cli.Ui, runOpts *RunOptions) {
 				BaseCommand: getBaseCommand(),
 			}, nil
 		},
+		"operator snapshot": func() (cli.Command, error) {
+			return &OperatorSnapshotCommand{
+				BaseCommand: getBaseCommand(),
+			}, nil
+		},
+		"operator snapshot restore": func() (cli.Command, error) {
+			return &OperatorSnapshotRestoreCommand{
+				BaseCommand:      getBaseCommand(),
+				PhysicalBackends: physicalBackends,
+			}, nil
+		},
+		"operator snapshot save": func() (cli.Command, error) {
+			return &OperatorSnapshotSaveCommand{
+				BaseCommand:      getBaseCommand(),
+				PhysicalBackends: physicalBackends,
+			}, nil
+		},
 		"operator step-down": func() (cli.Command, error) {
 			return &OperatorStepDownCommand{
 				BaseCommand: getBaseCommand(),
@@ -475,6 +513,16 @@ func initCommands(ui, serverCmdUi cli.Ui, runOpts *RunOptions) {
 				BaseCommand: getBaseCommand(),
 			}, nil
 		},
+		"kv export": func() (cli.Command, error) {
+			return &KVExportCommand{
+				BaseCommand: getBaseCommand(),
+			}, nil
+		},
+		"kv import": func() (cli.Command, error) {
+			return &KVImportCommand{
+				BaseCommand: getBaseCommand(),
+			}, nil
+		},
 		"kv patch": func() (cli.Command, error) {
 			return &KVPatchCommand{
 				BaseCommand: getBaseCommand(),
@@ -0,0 +1,384 @@
+package command
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jiangjiali/vault/api"
+
+	"github.com/jiangjiali/vault/sdk/helper/complete"
+	"github.com/jiangjiali/vault/sdk/helper/mitchellh/cli"
+	"github.com/jiangjiali/vault/sdk/helper/password"
+	"github.com/jiangjiali/vault/sdk/helper/zcrypto"
+)
+
+var _ cli.Command = (*KVExportCommand)(nil)
+var _ cli.CommandAutocomplete = (*KVExportCommand)(nil)
+
+// kvArchiveVersion is the format version of the archives written by
+// "vault kv export".
+const kvArchiveVersion = 1
+
+// kvArchive is the decrypted content of a KV export archive.
+type kvArchive struct {
+	Version     int               `json:"version"`
+	Source      string            `json:"source"`
+	CreatedTime string            `json:"created_time"`
+	Secrets     []*kvArchiveEntry `json:"secrets"`
+}
+
+// kvArchiveEntry is a single key of the archive, in the format of the kv
+// plugin's export endpoint. Path is relative to the exported path.
+type kvArchiveEntry struct {
+	Path     string                 `json:"path"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Versions map[string]interface{} `json:"versions"`
+}
+
+type KVExportCommand struct {
+	*BaseCommand
+
+	flagOutput      string
+	flagPassword    string
+	flagAllVersions bool
+	flagMetadata    bool
+}
+
+func (c *KVExportCommand) Synopsis() string {
+	return "将KV存储中的数据导出为加密归档"
+}
+
+func (c *KVExportCommand) Help() string {
+	helpText := `
+使用: vault kv export [选项] PATH
+
+ *注意*：这只支持KV v2机密引擎。
+
+  将给定路径下的键（或单个键）导出为使用密码加密的归档文件，
+  归档可以使用“vault kv import”导入到其他挂载点或其他集群中。
+
+  导出“secret/my-app”下的所有键，包括所有版本和元数据：
+
+      $ vault kv export -output=my-app.kva secret/my-app/
+
+  只导出每个键的当前版本：
+
+      $ vault kv export -all-versions=false -output=my-app.kva secret/my-app/
+
+  如果未指定密码，将提示输入密码。
+
+  下面详细介绍了其他标志和更高级的用例。
+
+` + c.Flags().Help()
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVExportCommand) Flags() *FlagSets {
+	set := c.flagSet(FlagSetHTTP)
+
+	// Common Options
+	f := set.NewFlagSet("命令选项")
+
+	f.StringVar(&StringVar{
+		Name:       "output",
+		Target:     &c.flagOutput,
+		Default:    "-",
+		Completion: complete.PredictFiles("*"),
+		Usage:      `归档的写入路径。“-”表示写入标准输出。`,
+	})
+
+	f.StringVar(&StringVar{
+		Name:    "password",
+		Target:  &c.flagPassword,
+		Default: "",
+		EnvVar:  "VAULT_KV_ARCHIVE_PASSWORD",
+		Usage:   `用于加密归档的密码。如果未设置，将提示输入密码。`,
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "all-versions",
+		Target:  &c.flagAllVersions,
+		Default: true,
+		Usage:   `导出每个键的所有版本，包括已删除的版本。如果为false，只导出当前版本。`,
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "metadata",
+		Target:  &c.flagMetadata,
+		Default: true,
+		Usage: `导出键的设置，如max_versions、cas_required、delete_version_after
+		和custom_metadata。`,
+	})
+
+	return set
+}
+
+func (c *KVExportCommand) AutocompleteArgs() complete.Predictor {
+	return c.PredictVaultFolders()
+}
+
+func (c *KVExportCommand) AutocompleteFlags() complete.Flags {
+	return c.Flags().Completions()
+}
+
+func (c *KVExportCommand) Run(args []string) int {
+	f := c.Flags()
+
+	if err := f.Parse(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	args = f.Args()
+	switch {
+	case len(args) < 1:
+		c.UI.Error(fmt.Sprintf("Not enough arguments (expected 1, got %d)", len(args)))
+		return 1
+	case len(args) > 1:
+		c.UI.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+
+	client, err := c.Client()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 2
+	}
+
+	path := sanitizePath(args[0])
+	mountPath, v2, err := isKVv2(path, client)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 2
+	}
+	if !v2 {
+		c.UI.Error("Export only works with KV v2 secrets engines")
+		return 1
+	}
+
+	pwd, err := readKVArchivePassword(c.flagPassword, true, false)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	archive := &kvArchive{
+		Version:     kvArchiveVersion,
+		Source:      path,
+		CreatedTime: time.Now().UTC().Format(time.RFC3339Nano),
+	}
+
+	// The path is either a single key or a folder, or both
+	relPath := strings.TrimPrefix(ensureTrailingSlash(path), mountPath)
+	keys := []string{}
+	if relPath != "" {
+		keys = append(keys, strings.TrimSuffix(relPath, "/"))
+	}
+	folderKeys, err := c.listKeys(client, mountPath, relPath)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 2
+	}
+	keys = append(keys, folderKeys...)
+
+	for _, key := range keys {
+		secret, err := client.Logical().Read(mountPath + "export/" + key)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error exporting %s: %s", mountPath+key, err))
+			return 2
+		}
+		if secret == nil || secret.Data == nil {
+			continue
+		}
+
+		entry := &kvArchiveEntry{
+			Path: strings.TrimPrefix(key, relPath),
+		}
+		if key+"/" == relPath {
+			entry.Path = ""
+		}
+		entry.Metadata, _ = secret.Data["metadata"].(map[string]interface{})
+		entry.Versions, _ = secret.Data["versions"].(map[string]interface{})
+
+		if !c.flagAllVersions {
+			trimKVArchiveVersions(entry)
+		}
+		if !c.flagMetadata {
+			for _, k := range []string{"max_versions", "cas_required", "delete_version_after", "custom_metadata"} {
+				delete(entry.Metadata, k)
+			}
+		}
+
+		archive.Secrets = append(archive.Secrets, entry)
+	}
+
+	if len(archive.Secrets) == 0 {
+		c.UI.Error(fmt.Sprintf("No value found at %s", path))
+		return 2
+	}
+
+	buf, err := encodeKVArchive(archive, pwd)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error encoding archive: %s", err))
+		return 2
+	}
+
+	if c.flagOutput == "-" {
+		if _, err := os.Stdout.Write(buf); err != nil {
+			c.UI.Error(fmt.Sprintf("Error writing archive: %s", err))
+			return 2
+		}
+		return 0
+	}
+
+	if err := ioutil.WriteFile(c.flagOutput, buf, 0600); err != nil {
+		c.UI.Error(fmt.Sprintf("Error writing archive: %s", err))
+		return 2
+	}
+
+	c.UI.Info(fmt.Sprintf("Success! Exported %d secrets from %s to %s", len(archive.Secrets), path, c.flagOutput))
+	return 0
+}
+
+// listKeys recursively lists the keys below the folder relPath of the mount.
+// The returned keys are relative to the mount.
+func (c *KVExportCommand) listKeys(client *api.Client, mountPath, relPath string) ([]string, error) {
+	secret, err := client.Logical().List(mountPath + "metadata/" + relPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error listing %s: %s", mountPath+relPath, err)
+	}
+
+	list, ok := extractListData(secret)
+	if !ok {
+		return nil, nil
+	}
+
+	var keys []string
+	for _, raw := range list {
+		name, ok := raw.(string)
+		if !ok {
+			continue
+		}
+
+		if strings.HasSuffix(name, "/") {
+			nested, err := c.listKeys(client, mountPath, relPath+name)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, nested...)
+			continue
+		}
+
+		keys = append(keys, relPath+name)
+	}
+
+	return keys, nil
+}
+
+// trimKVArchiveVersions removes every version of the entry but the current
+// one.
+func trimKVArchiveVersions(entry *kvArchiveEntry) {
+	current := fmt.Sprint(entry.Metadata["current_version"])
+	version, ok := entry.Versions[current]
+	if !ok {
+		return
+	}
+
+	entry.Versions = map[string]interface{}{current: version}
+	if n, err := strconv.ParseUint(current, 10, 64); err == nil {
+		entry.Metadata["oldest_version"] = n
+	}
+}
+
+// encodeKVArchive compresses and encrypts the archive with the password.
+func encodeKVArchive(archive *kvArchive, pwd string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(archive); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return zcrypto.GcmEncrypt(buf.Bytes(), pwd)
+}
+
+// decodeKVArchive decrypts and decompresses an archive written by
+// encodeKVArchive.
+func decodeKVArchive(data []byte, pwd string) (*kvArchive, error) {
+	plain, err := zcrypto.GcmDecrypt(data, pwd)
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var archive kvArchive
+	dec := json.NewDecoder(zr)
+	dec.UseNumber()
+	if err := dec.Decode(&archive); err != nil {
+		return nil, err
+	}
+	if archive.Version != kvArchiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", archive.Version)
+	}
+
+	return &archive, nil
+}
+
+// readKVArchivePassword returns the provided password, or asks for one on the
+// terminal if it is empty. The password is read from the controlling terminal
+// rather than stdin when there is one, and stdinInUse refuses to fall back to
+// stdin when it carries the archive.
+func readKVArchivePassword(pwd string, confirm, stdinInUse bool) (string, error) {
+	if pwd != "" {
+		return pwd, nil
+	}
+
+	tty, err := os.Open("/dev/tty")
+	switch {
+	case err == nil:
+		defer tty.Close()
+	case stdinInUse:
+		return "", fmt.Errorf("No terminal to read the archive password from, set -password or VAULT_KV_ARCHIVE_PASSWORD")
+	default:
+		tty = os.Stdin
+	}
+
+	fmt.Fprintf(os.Stderr, "Archive password (will be hidden): ")
+	value, err := password.Read(tty)
+	fmt.Fprintf(os.Stderr, "\n")
+	if err != nil {
+		return "", fmt.Errorf("Error reading password: %s", err)
+	}
+
+	if confirm {
+		fmt.Fprintf(os.Stderr, "Confirm archive password: ")
+		again, err := password.Read(tty)
+		fmt.Fprintf(os.Stderr, "\n")
+		if err != nil {
+			return "", fmt.Errorf("Error reading password: %s", err)
+		}
+		if again != value {
+			return "", fmt.Errorf("Passwords do not match")
+		}
+	}
+
+	if value == "" {
+		return "", fmt.Errorf("Missing archive password")
+	}
+
+	return value, nil
+}
@@ -0,0 +1,185 @@
+package command
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/jiangjiali/vault/sdk/helper/complete"
+	"github.com/jiangjiali/vault/sdk/helper/mitchellh/cli"
+)
+
+var _ cli.Command = (*KVImportCommand)(nil)
+var _ cli.CommandAutocomplete = (*KVImportCommand)(nil)
+
+type KVImportCommand struct {
+	*BaseCommand
+
+	flagInput    string
+	flagPassword string
+	testStdin    io.Reader // for tests
+}
+
+func (c *KVImportCommand) Synopsis() string {
+	return "将加密归档导入KV存储"
+}
+
+func (c *KVImportCommand) Help() string {
+	helpText := `
+使用: vault kv import [选项] PATH
+
+ *注意*：这只支持KV v2机密引擎。
+
+  将“vault kv export”生成的归档导入到给定路径下，保留每个键的版本历史、
+  删除状态以及max_versions和cas_required等设置。目标键必须不存在。
+
+  将归档导入到“secret2/my-app”下：
+
+      $ vault kv import -input=my-app.kva secret2/my-app/
+
+  在两个集群之间复制：
+
+      $ export VAULT_KV_ARCHIVE_PASSWORD=...
+      $ vault kv export -address=https://a:8200 secret/my-app/ | \
+          VAULT_ADDR=https://b:8200 vault kv import secret/my-app/
+
+  如果未指定密码，将在终端上提示输入密码，而不是从标准输入读取。从标准输入读取
+  归档且没有终端时，必须使用 -password 或 VAULT_KV_ARCHIVE_PASSWORD 指定密码。
+
+  下面详细介绍了其他标志和更高级的用例。
+
+` + c.Flags().Help()
+	return strings.TrimSpace(helpText)
+}
+
+func (c *KVImportCommand) Flags() *FlagSets {
+	set := c.flagSet(FlagSetHTTP)
+
+	// Common Options
+	f := set.NewFlagSet("命令选项")
+
+	f.StringVar(&StringVar{
+		Name:       "input",
+		Target:     &c.flagInput,
+		Default:    "-",
+		Completion: complete.PredictFiles("*"),
+		Usage:      `要导入的归档路径。“-”表示从标准输入读取。`,
+	})
+
+	f.StringVar(&StringVar{
+		Name:    "password",
+		Target:  &c.flagPassword,
+		Default: "",
+		EnvVar:  "VAULT_KV_ARCHIVE_PASSWORD",
+		Usage:   `用于解密归档的密码。如果未设置，将提示输入密码。`,
+	})
+
+	return set
+}
+
+func (c *KVImportCommand) AutocompleteArgs() complete.Predictor {
+	return c.PredictVaultFolders()
+}
+
+func (c *KVImportCommand) AutocompleteFlags() complete.Flags {
+	return c.Flags().Completions()
+}
+
+func (c *KVImportCommand) Run(args []string) int {
+	f := c.Flags()
+
+	if err := f.Parse(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	args = f.Args()
+	switch {
+	case len(args) < 1:
+		c.UI.Error(fmt.Sprintf("Not enough arguments (expected 1, got %d)", len(args)))
+		return 1
+	case len(args) > 1:
+		c.UI.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+
+	var data []byte
+	var err error
+	if c.flagInput == "-" {
+		var stdin io.Reader = os.Stdin
+		if c.testStdin != nil {
+			stdin = c.testStdin
+		}
+		data, err = ioutil.ReadAll(stdin)
+	} else {
+		data, err = ioutil.ReadFile(c.flagInput)
+	}
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading archive: %s", err))
+		return 1
+	}
+
+	pwd, err := readKVArchivePassword(c.flagPassword, false, c.flagInput == "-")
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	archive, err := decodeKVArchive(data, pwd)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error decoding archive: %s", err))
+		return 1
+	}
+
+	client, err := c.Client()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 2
+	}
+
+	dest := sanitizePath(args[0])
+	mountPath, v2, err := isKVv2(dest, client)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 2
+	}
+	if !v2 {
+		c.UI.Error("Import only works with KV v2 secrets engines")
+		return 1
+	}
+
+	relPath := strings.TrimPrefix(ensureTrailingSlash(dest), mountPath)
+
+	var failed int
+	for _, entry := range archive.Secrets {
+		key := strings.TrimSuffix(relPath, "/")
+		if entry.Path != "" {
+			key = path.Join(relPath, entry.Path)
+		}
+		if key == "" {
+			c.UI.Error(fmt.Sprintf("Error importing %s: missing destination key", archive.Source))
+			failed++
+			continue
+		}
+
+		_, err := client.Logical().Write(mountPath+"import/"+key, map[string]interface{}{
+			"metadata": entry.Metadata,
+			"versions": entry.Versions,
+		})
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error importing %s: %s", mountPath+key, err))
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		c.UI.Error(fmt.Sprintf("Imported %d of %d secrets to %s", len(archive.Secrets)-failed, len(archive.Secrets), dest))
+		return 2
+	}
+
+	c.UI.Info(fmt.Sprintf("Success! Imported %d secrets to %s", len(archive.Secrets), dest))
+	return 0
+}
@@ -1,9 +1,11 @@
 package command
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/jiangjiali/vault/sdk/helper/complete"
 	"github.com/jiangjiali/vault/sdk/helper/mitchellh/cli"
@@ -15,9 +17,11 @@ var _ cli.CommandAutocomplete = (*KVMetadataPutCommand)(nil)
 type KVMetadataPutCommand struct {
 	*BaseCommand
 
-	flagMaxVersions int
-	flagCASRequired bool
-	testStdin       io.Reader // for tests
+	flagMaxVersions        int
+	flagCASRequired        bool
+	flagDeleteVersionAfter time.Duration
+	flagCustomMetadata     map[string]string
+	testStdin              io.Reader // for tests
 }
 
 func (c *KVMetadataPutCommand) Synopsis() string {
@@ -42,6 +46,14 @@ func (c *KVMetadataPutCommand) Help() string {
 
       $ vault kv metadata put -cas-required secret/foo
 
+  一小时后自动删除此密钥的版本：
+
+      $ vault kv metadata put -delete-version-after=1h secret/foo
+
+  设置此密钥的自定义元数据：
+
+      $ vault kv metadata put -custom-metadata=owner=ops -custom-metadata=team=db secret/foo
+
   下面详细介绍了其他标志和更高级的用例。
 
 ` + c.Flags().Help()
@@ -68,6 +80,21 @@ func (c *KVMetadataPutCommand) Flags() *FlagSets {
 		Usage:   `如果为true，则键将要求在所有写请求上设置cas参数。如果为false，将使用后端的配置。`,
 	})
 
+	f.DurationVar(&DurationVar{
+		Name:       "delete-version-after",
+		Target:     &c.flagDeleteVersionAfter,
+		Default:    0,
+		Completion: complete.PredictAnything,
+		Usage:      `版本在被自动删除之前保留的时长。如果未设置，则使用后端配置的值。设置为0s可清除已设置的值。不能大于后端配置的值。`,
+	})
+
+	f.StringMapVar(&StringMapVar{
+		Name:    "custom-metadata",
+		Target:  &c.flagCustomMetadata,
+		Default: map[string]string{},
+		Usage:   `以key=value形式指定的自定义元数据。可以多次指定此参数以添加多个键值对。指定后将替换已有的全部自定义元数据。`,
+	})
+
 	return set
 }
 
@@ -121,6 +148,16 @@ func (c *KVMetadataPutCommand) Run(args []string) int {
 		"cas_required": c.flagCASRequired,
 	}
 
+	// Send the value whenever it is given, so that "0s" clears it
+	f.Visit(func(fl *flag.Flag) {
+		if fl.Name == "delete-version-after" {
+			data["delete_version_after"] = c.flagDeleteVersionAfter.String()
+		}
+	})
+	if len(c.flagCustomMetadata) > 0 {
+		data["custom_metadata"] = c.flagCustomMetadata
+	}
+
 	secret, err := client.Logical().Write(path, data)
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("Error writing data to %s: %s", path, err))
@@ -6,6 +6,8 @@ import (
 	"os"
 	"strings"
 
+	"github.com/jiangjiali/vault/api"
+
 	"github.com/jiangjiali/vault/sdk/helper/complete"
 	"github.com/jiangjiali/vault/sdk/helper/mitchellh/cli"
 )
@@ -16,7 +18,9 @@ var _ cli.CommandAutocomplete = (*KVPatchCommand)(nil)
 type KVPatchCommand struct {
 	*BaseCommand
 
-	testStdin io.Reader // for tests
+	flagCAS    int
+	flagMethod string
+	testStdin  io.Reader // for tests
 }
 
 func (c *KVPatchCommand) Synopsis() string {
@@ -41,6 +45,11 @@ func (c *KVPatchCommand) Help() string {
 
       $ echo "abcd1234" | vault kv patch secret/foo bar=-
 
+  默认情况下，修补操作在服务器端以JSON合并修补（RFC 7396）的方式原子地执行，
+  这需要对路径具有“patch”权限。旧版本的服务器可以使用先读后写的方式：
+
+      $ vault kv patch -method=rw secret/foo bar=baz
+
   下面详细介绍了其他标志和更高级的用例。
 
 ` + c.Flags().Help()
@@ -50,6 +59,26 @@ func (c *KVPatchCommand) Help() string {
 func (c *KVPatchCommand) Flags() *FlagSets {
 	set := c.flagSet(FlagSetHTTP | FlagSetOutputField | FlagSetOutputFormat)
 
+	// Common Options
+	f := set.NewFlagSet("命令选项")
+
+	f.IntVar(&IntVar{
+		Name:    "cas",
+		Target:  &c.flagCAS,
+		Default: -1,
+		Usage: `指定使用检查和设置操作。如果未设置，将允许写入。如果设置，则仅当密钥的
+		当前版本与cas参数中指定的版本匹配时，才允许写入。仅适用于patch方法。`,
+	})
+
+	f.StringVar(&StringVar{
+		Name:       "method",
+		Target:     &c.flagMethod,
+		Default:    "patch",
+		Completion: complete.PredictSet("patch", "rw"),
+		Usage: `修补的方式。“patch”在服务器端使用HTTP PATCH请求原子地合并数据，
+		“rw”先读取当前数据，在客户端合并后再写入。`,
+	})
+
 	return set
 }
 
@@ -113,49 +142,99 @@ func (c *KVPatchCommand) Run(args []string) int {
 
 	path = addPrefixToVKVPath(path, mountPath, "data")
 
+	var secret *api.Secret
+	switch c.flagMethod {
+	case "patch":
+		secret, err = c.mergePatch(client, path, newData)
+	case "rw":
+		secret, err = c.readThenWrite(client, path, newData)
+	default:
+		c.UI.Error(fmt.Sprintf("Unsupported method provided to -method flag: %s", c.flagMethod))
+		return 1
+	}
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 2
+	}
+	if secret == nil {
+		// Don't output anything unless using the "table" format
+		if Format(c.UI) == "table" {
+			c.UI.Info(fmt.Sprintf("Success! Data written to: %s", path))
+		}
+		return 0
+	}
+
+	if c.flagField != "" {
+		return PrintRawField(c.UI, secret, c.flagField)
+	}
+
+	return OutputSecret(c.UI, secret)
+}
+
+// mergePatch applies the new data on the server with a JSON merge patch
+// request against the latest version.
+func (c *KVPatchCommand) mergePatch(client *api.Client, path string, newData map[string]interface{}) (*api.Secret, error) {
+	data := map[string]interface{}{
+		"data": newData,
+	}
+	if c.flagCAS > -1 {
+		data["options"] = map[string]interface{}{
+			"cas": c.flagCAS,
+		}
+	}
+
+	secret, err := client.Logical().JSONMergePatch(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("Error writing data to %s: %s", path, err)
+	}
+
+	// A successful patch always returns the new version, an empty response
+	// means there was no existing data to patch
+	if secret == nil {
+		return nil, fmt.Errorf("No value found at %s", path)
+	}
+
+	return secret, nil
+}
+
+// readThenWrite merges the new data into the latest version on the client and
+// writes it back using check-and-set with the version that was read.
+func (c *KVPatchCommand) readThenWrite(client *api.Client, path string, newData map[string]interface{}) (*api.Secret, error) {
 	// First, do a read
 	secret, err := kvReadRequest(client, path, nil)
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("Error doing pre-read at %s: %s", path, err))
-		return 2
+		return nil, fmt.Errorf("Error doing pre-read at %s: %s", path, err)
 	}
 
 	// Make sure a value already exists
 	if secret == nil || secret.Data == nil {
-		c.UI.Error(fmt.Sprintf("No value found at %s", path))
-		return 2
+		return nil, fmt.Errorf("No value found at %s", path)
 	}
 
 	// Verify metadata found
 	rawMeta, ok := secret.Data["metadata"]
 	if !ok || rawMeta == nil {
-		c.UI.Error(fmt.Sprintf("No metadata found at %s; patch only works on existing data", path))
-		return 2
+		return nil, fmt.Errorf("No metadata found at %s; patch only works on existing data", path)
 	}
 	meta, ok := rawMeta.(map[string]interface{})
 	if !ok {
-		c.UI.Error(fmt.Sprintf("Metadata found at %s is not the expected type (JSON object)", path))
-		return 2
+		return nil, fmt.Errorf("Metadata found at %s is not the expected type (JSON object)", path)
 	}
 	if meta == nil {
-		c.UI.Error(fmt.Sprintf("No metadata found at %s; patch only works on existing data", path))
-		return 2
+		return nil, fmt.Errorf("No metadata found at %s; patch only works on existing data", path)
 	}
 
 	// Verify old data found
 	rawData, ok := secret.Data["data"]
 	if !ok || rawData == nil {
-		c.UI.Error(fmt.Sprintf("No data found at %s; patch only works on existing data", path))
-		return 2
+		return nil, fmt.Errorf("No data found at %s; patch only works on existing data", path)
 	}
 	data, ok := rawData.(map[string]interface{})
 	if !ok {
-		c.UI.Error(fmt.Sprintf("Data found at %s is not the expected type (JSON object)", path))
-		return 2
+		return nil, fmt.Errorf("Data found at %s is not the expected type (JSON object)", path)
 	}
 	if data == nil {
-		c.UI.Error(fmt.Sprintf("No data found at %s; patch only works on existing data", path))
-		return 2
+		return nil, fmt.Errorf("No data found at %s; patch only works on existing data", path)
 	}
 
 	// Copy new data over
@@ -170,20 +249,8 @@ func (c *KVPatchCommand) Run(args []string) int {
 		},
 	})
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("Error writing data to %s: %s", path, err))
-		return 2
-	}
-	if secret == nil {
-		// Don't output anything unless using the "table" format
-		if Format(c.UI) == "table" {
-			c.UI.Info(fmt.Sprintf("Success! Data written to: %s", path))
-		}
-		return 0
+		return nil, fmt.Errorf("Error writing data to %s: %s", path, err)
 	}
 
-	if c.flagField != "" {
-		return PrintRawField(c.UI, secret, c.flagField)
-	}
-
-	return OutputSecret(c.UI, secret)
+	return secret, nil
 }
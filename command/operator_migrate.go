@@ -1,12 +1,15 @@
 package command
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jiangjiali/vault/command/server"
@@ -16,6 +19,7 @@ import (
 	"github.com/jiangjiali/vault/sdk/helper/hclutil/hcl"
 	"github.com/jiangjiali/vault/sdk/helper/hclutil/hcl/hcl/ast"
 	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/sdk/helper/jsonutil"
 	"github.com/jiangjiali/vault/sdk/helper/logging"
 	"github.com/jiangjiali/vault/sdk/helper/mitchellh/cli"
 	"github.com/jiangjiali/vault/sdk/physical"
@@ -27,6 +31,13 @@ var _ cli.CommandAutocomplete = (*OperatorMigrateCommand)(nil)
 
 var errAbort = errors.New("Migration aborted")
 
+// storageMigrationProgress is the key of the destination where the progress
+// of a migration is recorded so that it can be resumed.
+const storageMigrationProgress = "core/migration-progress"
+
+// migrationProgressInterval is how often the progress is recorded.
+const migrationProgressInterval = 2 * time.Second
+
 type OperatorMigrateCommand struct {
 	*BaseCommand
 
@@ -34,6 +45,10 @@ type OperatorMigrateCommand struct {
 	flagConfig       string
 	flagStart        string
 	flagReset        bool
+	flagVerify       bool
+	flagResume       bool
+	flagDryRun       bool
+	flagParallel     int
 	logger           log.Logger
 	ShutdownCh       chan struct{}
 }
@@ -58,6 +73,18 @@ func (c *OperatorMigrateCommand) Help() string {
 
       $ vault operator migrate -config=migrate.hcl
 
+  使用多个并行工作者复制键，并在中断后从记录的进度继续迁移：
+
+      $ vault operator migrate -config=migrate.hcl -parallel=8 -resume
+
+  迁移完成后，按校验和逐键比较源和目标：
+
+      $ vault operator migrate -config=migrate.hcl -verify
+
+  仅统计每个顶级前缀下的键数，不复制任何数据：
+
+      $ vault operator migrate -config=migrate.hcl -dry-run
+
   有关更多信息，请参阅文档。
 
 ` + c.Flags().Help()
@@ -90,6 +117,33 @@ func (c *OperatorMigrateCommand) Flags() *FlagSets {
 		Usage:  "重置迁移锁定。不会发生迁移。",
 	})
 
+	f.BoolVar(&BoolVar{
+		Name:   "verify",
+		Target: &c.flagVerify,
+		Usage: "按校验和逐键比较源和目标，报告缺失、不同和多余的键。" +
+			"不会发生迁移。",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:   "resume",
+		Target: &c.flagResume,
+		Usage: "从目标中记录的进度继续被中断的迁移，" +
+			"即使迁移锁定仍然存在。",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:   "dry-run",
+		Target: &c.flagDryRun,
+		Usage:  "输出源中每个顶级前缀下的键数。不会发生迁移。",
+	})
+
+	f.IntVar(&IntVar{
+		Name:    "parallel",
+		Target:  &c.flagParallel,
+		Default: 1,
+		Usage:   "并行复制或比较键的工作者数量。",
+	})
+
 	return set
 }
 
@@ -115,6 +169,22 @@ func (c *OperatorMigrateCommand) Run(args []string) int {
 		return 1
 	}
 
+	modes := 0
+	for _, set := range []bool{c.flagReset, c.flagVerify, c.flagDryRun} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		c.UI.Error("Only one of -reset, -verify and -dry-run can be specified")
+		return 1
+	}
+
+	if c.flagParallel < 1 {
+		c.UI.Error("The -parallel flag must be at least 1")
+		return 1
+	}
+
 	config, err := c.loadMigratorConfig(c.flagConfig)
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("Error loading configuration from %s: %s", c.flagConfig, err))
@@ -129,9 +199,13 @@ func (c *OperatorMigrateCommand) Run(args []string) int {
 		return 2
 	}
 
-	if c.flagReset {
+	switch {
+	case c.flagReset:
 		c.UI.Output("Success! Migration lock reset (if it was set).")
-	} else {
+	case c.flagVerify:
+		c.UI.Output("Success! The destination matches the source.")
+	case c.flagDryRun:
+	default:
 		c.UI.Output("Success! All of the keys have been migrated.")
 	}
 
@@ -153,31 +227,67 @@ func (c *OperatorMigrateCommand) migrate(config *migratorConfig) error {
 		return nil
 	}
 
+	if c.flagDryRun {
+		return c.runInterruptible(func(ctx context.Context) error {
+			return c.dryRun(ctx, from)
+		})
+	}
+
 	to, err := c.newBackend(config.StorageDestination.Type, config.StorageDestination.Config)
 	if err != nil {
 		return errwrap.Wrapf("error mounting 'storage_destination': {{err}}", err)
 	}
 
+	if c.flagVerify {
+		return c.runInterruptible(func(ctx context.Context) error {
+			return c.verify(ctx, from, to)
+		})
+	}
+
 	migrationStatus, err := CheckStorageMigration(from)
 	if err != nil {
 		return errwrap.Wrapf("error checking migration status: {{err}}", err)
 	}
 
-	if migrationStatus != nil {
+	if migrationStatus != nil && !c.flagResume {
 		return fmt.Errorf("storage migration in progress (started: %s)", migrationStatus.Start.Format(time.RFC3339))
 	}
 
+	var progress *migrationProgress
+	if c.flagResume {
+		progress, err = loadMigrationProgress(to)
+		if err != nil {
+			return errwrap.Wrapf("error loading migration progress: {{err}}", err)
+		}
+		if progress == nil {
+			c.UI.Warn("No migration progress recorded in the destination, starting from the beginning.")
+		} else {
+			c.UI.Output(fmt.Sprintf("Resuming migration after %q (%d keys already copied)", progress.LastKey, progress.Copied))
+		}
+	}
+	if progress == nil {
+		progress = &migrationProgress{}
+	}
+
 	if err := SetStorageMigration(from, true); err != nil {
 		return errwrap.Wrapf("error setting migration lock: {{err}}", err)
 	}
 
 	defer SetStorageMigration(from, false)
 
+	return c.runInterruptible(func(ctx context.Context) error {
+		return c.migrateAll(ctx, from, to, progress)
+	})
+}
+
+// runInterruptible runs f until it returns or a shutdown is triggered, in
+// which case the context given to f is canceled.
+func (c *OperatorMigrateCommand) runInterruptible(f func(ctx context.Context) error) error {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
 	doneCh := make(chan error)
 	go func() {
-		doneCh <- c.migrateAll(ctx, from, to)
+		doneCh <- f(ctx)
 	}()
 
 	select {
@@ -192,29 +302,340 @@ func (c *OperatorMigrateCommand) migrate(config *migratorConfig) error {
 	}
 }
 
-// migrateAll copies all keys in lexicographic order.
-func (c *OperatorMigrateCommand) migrateAll(ctx context.Context, from physical.Backend, to physical.Backend) error {
-	return dfsScan(ctx, from, func(ctx context.Context, path string) error {
-		if path < c.flagStart || path == storageMigrationLock || path == vault.CoreLockPath {
+// skipKey returns whether the key is internal to the storage or to the
+// migration and must not be copied.
+func skipKey(path string) bool {
+	return path == storageMigrationLock || path == storageMigrationProgress || path == vault.CoreLockPath
+}
+
+// migrationProgress records the last key of a migration up to which all the
+// keys were copied.
+type migrationProgress struct {
+	LastKey string    `json:"last_key"`
+	Copied  int       `json:"copied"`
+	Updated time.Time `json:"updated"`
+}
+
+func loadMigrationProgress(b physical.Backend) (*migrationProgress, error) {
+	entry, err := b.Get(context.Background(), storageMigrationProgress)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	var progress migrationProgress
+	if err := jsonutil.DecodeJSON(entry.Value, &progress); err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+func storeMigrationProgress(b physical.Backend, progress *migrationProgress) error {
+	progress.Updated = time.Now()
+	enc, err := jsonutil.EncodeJSON(progress)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(context.Background(), &physical.Entry{
+		Key:   storageMigrationProgress,
+		Value: enc,
+	})
+}
+
+// progressTracker tracks the keys copied by the workers, which complete out of
+// order, to find the last key up to which all the keys were copied.
+type progressTracker struct {
+	l        sync.Mutex
+	next     int
+	done     map[int]string
+	progress migrationProgress
+	dirty    bool
+}
+
+func (t *progressTracker) markDone(seq int, key string) {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	t.done[seq] = key
+	for {
+		last, ok := t.done[t.next]
+		if !ok {
+			return
+		}
+		delete(t.done, t.next)
+		t.next++
+		t.progress.LastKey = last
+		t.progress.Copied++
+		t.dirty = true
+	}
+}
+
+// snapshot returns the progress if it changed since the last call.
+func (t *progressTracker) snapshot() (migrationProgress, bool) {
+	t.l.Lock()
+	defer t.l.Unlock()
+
+	dirty := t.dirty
+	t.dirty = false
+	return t.progress, dirty
+}
+
+// scannedKey is a key found by the scan, numbered in the scan order.
+type scannedKey struct {
+	seq  int
+	path string
+}
+
+// scanParallel scans the keys of source in lexicographic order and calls cb
+// for each of them from the given number of workers. It stops at the first
+// error returned by cb.
+func scanParallel(ctx context.Context, source physical.Backend, workers int, filter func(path string) bool, cb func(ctx context.Context, key scannedKey) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var errOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	keysCh := make(chan scannedKey, workers*2)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keysCh {
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := cb(ctx, key); err != nil {
+					fail(err)
+				}
+			}
+		}()
+	}
+
+	seq := 0
+	err := dfsScan(ctx, source, func(ctx context.Context, path string) error {
+		if !filter(path) {
 			return nil
 		}
+		select {
+		case keysCh <- scannedKey{seq: seq, path: path}:
+			seq++
+		case <-ctx.Done():
+		}
+		return nil
+	})
+	close(keysCh)
+	wg.Wait()
 
-		entry, err := from.Get(ctx, path)
+	if err != nil {
+		return err
+	}
+	return firstErr
+}
 
+// migrateAll copies all keys in lexicographic order, after the last key of
+// the given progress if it is set. The progress is recorded in the
+// destination while copying and removed once all the keys are copied.
+func (c *OperatorMigrateCommand) migrateAll(ctx context.Context, from physical.Backend, to physical.Backend, resumed *migrationProgress) error {
+	resumeAfter := resumed.LastKey
+	tracker := &progressTracker{
+		done:     make(map[int]string),
+		progress: *resumed,
+	}
+
+	stopCh := make(chan struct{})
+	progressDoneCh := make(chan struct{})
+	go func() {
+		defer close(progressDoneCh)
+
+		ticker := time.NewTicker(migrationProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+			if progress, dirty := tracker.snapshot(); dirty {
+				if err := storeMigrationProgress(to, &progress); err != nil {
+					c.logger.Warn("failed to record migration progress", "error", err)
+				}
+			}
+		}
+	}()
+
+	filter := func(path string) bool {
+		if path < c.flagStart || skipKey(path) {
+			return false
+		}
+		return resumeAfter == "" || path > resumeAfter
+	}
+
+	err := scanParallel(ctx, from, c.flagParallel, filter, func(ctx context.Context, key scannedKey) error {
+		entry, err := from.Get(ctx, key.path)
 		if err != nil {
 			return errwrap.Wrapf("error reading entry: {{err}}", err)
 		}
 
-		if entry == nil {
+		if entry != nil {
+			if err := to.Put(ctx, entry); err != nil {
+				return errwrap.Wrapf("error writing entry: {{err}}", err)
+			}
+			c.logger.Info("copied key", "path", key.path)
+		}
+
+		tracker.markDone(key.seq, key.path)
+		return nil
+	})
+
+	close(stopCh)
+	<-progressDoneCh
+
+	if err == nil && ctx.Err() == nil {
+		if err := to.Delete(context.Background(), storageMigrationProgress); err != nil {
+			c.logger.Warn("failed to remove migration progress", "error", err)
+		}
+		return nil
+	}
+
+	// Record where the migration stopped so that it can be resumed
+	progress, _ := tracker.snapshot()
+	if progress.LastKey != "" {
+		if storeErr := storeMigrationProgress(to, &progress); storeErr != nil {
+			c.logger.Warn("failed to record migration progress", "error", storeErr)
+		} else {
+			c.UI.Warn(fmt.Sprintf("Migration progress recorded after %q, run again with -resume to continue.", progress.LastKey))
+		}
+	}
+	return err
+}
+
+// verify compares the source and the destination key by key and reports the
+// keys that are missing from the destination, that differ, or that are only
+// in the destination.
+func (c *OperatorMigrateCommand) verify(ctx context.Context, from physical.Backend, to physical.Backend) error {
+	var l sync.Mutex
+	var matched, missing, different, extra int
+
+	filter := func(path string) bool {
+		return !skipKey(path)
+	}
+
+	err := scanParallel(ctx, from, c.flagParallel, filter, func(ctx context.Context, key scannedKey) error {
+		src, err := from.Get(ctx, key.path)
+		if err != nil {
+			return errwrap.Wrapf("error reading source entry: {{err}}", err)
+		}
+		dst, err := to.Get(ctx, key.path)
+		if err != nil {
+			return errwrap.Wrapf("error reading destination entry: {{err}}", err)
+		}
+
+		l.Lock()
+		defer l.Unlock()
+		switch {
+		case src == nil:
+		case dst == nil:
+			missing++
+			c.logger.Warn("key missing from destination", "path", key.path)
+		case !bytes.Equal(checksum(src.Value), checksum(dst.Value)):
+			different++
+			c.logger.Warn("key differs in destination", "path", key.path)
+		default:
+			matched++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	// Keys only present in the destination
+	err = scanParallel(ctx, to, c.flagParallel, filter, func(ctx context.Context, key scannedKey) error {
+		src, err := from.Get(ctx, key.path)
+		if err != nil {
+			return errwrap.Wrapf("error reading source entry: {{err}}", err)
+		}
+		if src == nil {
+			l.Lock()
+			extra++
+			l.Unlock()
+			c.logger.Warn("key only in destination", "path", key.path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.UI.Output(tableOutput([]string{
+		"Result | Keys",
+		fmt.Sprintf("matched | %d", matched),
+		fmt.Sprintf("missing | %d", missing),
+		fmt.Sprintf("different | %d", different),
+		fmt.Sprintf("extra | %d", extra),
+	}, nil))
+
+	if missing+different+extra > 0 {
+		return fmt.Errorf("verification failed: %d keys missing, %d different, %d extra", missing, different, extra)
+	}
+	return nil
+}
+
+func checksum(value []byte) []byte {
+	sum := sha256.Sum256(value)
+	return sum[:]
+}
+
+// dryRun counts the keys of the source under each top-level prefix without
+// copying anything.
+func (c *OperatorMigrateCommand) dryRun(ctx context.Context, from physical.Backend) error {
+	counts := make(map[string]int)
+	total := 0
+
+	err := dfsScan(ctx, from, func(ctx context.Context, path string) error {
+		if path < c.flagStart || skipKey(path) {
 			return nil
 		}
 
-		if err := to.Put(ctx, entry); err != nil {
-			return errwrap.Wrapf("error writing entry: {{err}}", err)
+		prefix := path
+		if i := strings.Index(path, "/"); i != -1 {
+			prefix = path[:i+1]
 		}
-		c.logger.Info("copied key", "path", path)
+		counts[prefix]++
+		total++
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+
+	prefixes := make([]string, 0, len(counts))
+	for prefix := range counts {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	out := []string{"Prefix | Keys"}
+	for _, prefix := range prefixes {
+		out = append(out, fmt.Sprintf("%s | %d", prefix, counts[prefix]))
+	}
+	out = append(out, fmt.Sprintf("total | %d", total))
+	c.UI.Output(tableOutput(out, nil))
+	return nil
 }
 
 func (c *OperatorMigrateCommand) newBackend(kind string, conf map[string]string) (physical.Backend, error) {
@@ -0,0 +1,72 @@
+package command
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jiangjiali/vault/command/server"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/sdk/helper/logging"
+	"github.com/jiangjiali/vault/sdk/helper/mitchellh/cli"
+	"github.com/jiangjiali/vault/sdk/physical"
+)
+
+var _ cli.Command = (*OperatorSnapshotCommand)(nil)
+
+type OperatorSnapshotCommand struct {
+	*BaseCommand
+}
+
+func (c *OperatorSnapshotCommand) Synopsis() string {
+	return "保存和还原存储的快照"
+}
+
+func (c *OperatorSnapshotCommand) Help() string {
+	helpText := `
+使用: vault operator snapshot <子命令> [选项] [参数]
+
+  此命令包含用于保存和还原安全库存储的一致快照的子命令，适用于任何存储后端。
+  快照包含经屏障加密的所有存储条目及其SHA-256校验和，只能与相同的启封钥匙
+  一起使用。
+
+  保存快照：
+
+      $ vault operator snapshot save backup.snap
+
+  还原快照：
+
+      $ vault operator snapshot restore backup.snap
+
+  在服务器停止时，直接对服务器配置的存储后端保存快照：
+
+      $ vault operator snapshot save -config=/etc/vault/server.hcl backup.snap
+
+  有关详细的用法信息，请参阅各个子命令帮助。
+`
+
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorSnapshotCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+// snapshotBackend sets up the storage backend of the server configuration at
+// the path, for the snapshots taken while the server is stopped
+func snapshotBackend(factories map[string]physical.Factory, path string) (physical.Backend, error) {
+	logger := logging.NewVaultLogger(log.Info)
+
+	config, err := server.LoadConfig(path, logger)
+	if err != nil {
+		return nil, err
+	}
+	if config.Storage == nil {
+		return nil, fmt.Errorf("no storage configured in %s", path)
+	}
+
+	factory, ok := factories[config.Storage.Type]
+	if !ok {
+		return nil, fmt.Errorf("no Vault storage backend named: %+q", config.Storage.Type)
+	}
+	return factory(config.Storage.Config, logger)
+}
@@ -0,0 +1,150 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jiangjiali/vault/sdk/helper/complete"
+	"github.com/jiangjiali/vault/sdk/helper/mitchellh/cli"
+	"github.com/jiangjiali/vault/sdk/physical"
+	"github.com/jiangjiali/vault/vault"
+)
+
+var _ cli.Command = (*OperatorSnapshotRestoreCommand)(nil)
+var _ cli.CommandAutocomplete = (*OperatorSnapshotRestoreCommand)(nil)
+
+type OperatorSnapshotRestoreCommand struct {
+	*BaseCommand
+
+	PhysicalBackends map[string]physical.Factory
+	flagConfig       string
+	flagForce        bool
+}
+
+func (c *OperatorSnapshotRestoreCommand) Synopsis() string {
+	return "还原存储的快照"
+}
+
+func (c *OperatorSnapshotRestoreCommand) Help() string {
+	helpText := `
+使用: vault operator snapshot restore [选项] PATH
+
+  用给定路径的快照替换安全库存储的所有条目，HA和复制的状态除外。快照的校验和
+  在还原前验证。之后活动节点会退出现役，以便从还原的存储重新设置其状态。此命令
+  需要root令牌。
+
+  还原快照：
+
+      $ vault operator snapshot restore backup.snap
+
+  使用其他启封钥匙获取的快照会被拒绝，除非给出 -force；此时还原后必须用快照
+  的启封钥匙启封群集：
+
+      $ vault operator snapshot restore -force backup.snap
+
+  给出 -config 时，直接还原到服务器配置的存储后端，而不经过服务器。此时服务器
+  必须已停止，并且不会检查启封钥匙：
+
+      $ vault operator snapshot restore -config=/etc/vault/server.hcl backup.snap
+
+` + c.Flags().Help()
+
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorSnapshotRestoreCommand) Flags() *FlagSets {
+	set := c.flagSet(FlagSetHTTP)
+
+	f := set.NewFlagSet("命令选项")
+
+	f.StringVar(&StringVar{
+		Name:   "config",
+		Target: &c.flagConfig,
+		Completion: complete.PredictOr(
+			complete.PredictFiles("*.hcl"),
+			complete.PredictFiles("*.json"),
+		),
+		Usage: "服务器配置文件的路径。给出时直接还原到其存储后端，服务器必须已停止。",
+	})
+
+	f.BoolVar(&BoolVar{
+		Name:    "force",
+		Target:  &c.flagForce,
+		Default: false,
+		Usage:   "即使快照是使用其他启封钥匙获取的，也还原快照。",
+	})
+
+	return set
+}
+
+func (c *OperatorSnapshotRestoreCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictFiles("*")
+}
+
+func (c *OperatorSnapshotRestoreCommand) AutocompleteFlags() complete.Flags {
+	return c.Flags().Completions()
+}
+
+func (c *OperatorSnapshotRestoreCommand) Run(args []string) int {
+	f := c.Flags()
+
+	if err := f.Parse(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	args = f.Args()
+	switch {
+	case len(args) < 1:
+		c.UI.Error(fmt.Sprintf("Not enough arguments (expected 1, got %d)", len(args)))
+		return 1
+	case len(args) > 1:
+		c.UI.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+	path := args[0]
+
+	snapshot, err := os.Open(path)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading snapshot: %s", err))
+		return 1
+	}
+	defer snapshot.Close()
+	meta, err := physical.ReadSnapshot(snapshot, nil)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error verifying snapshot: %s", err))
+		return 1
+	}
+	if _, err := snapshot.Seek(0, io.SeekStart); err != nil {
+		c.UI.Error(fmt.Sprintf("Error reading snapshot: %s", err))
+		return 1
+	}
+
+	if c.flagConfig != "" {
+		backend, err := snapshotBackend(c.PhysicalBackends, c.flagConfig)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error loading storage from %s: %s", c.flagConfig, err))
+			return 1
+		}
+		if _, _, err := physical.RestoreSnapshot(context.Background(), backend, snapshot, vault.SnapshotKey); err != nil {
+			c.UI.Error(fmt.Sprintf("Error restoring snapshot: %s", err))
+			return 2
+		}
+	} else {
+		client, err := c.Client()
+		if err != nil {
+			c.UI.Error(err.Error())
+			return 2
+		}
+		if err := client.Sys().StorageSnapshotRestore(snapshot, c.flagForce); err != nil {
+			c.UI.Error(fmt.Sprintf("Error restoring snapshot: %s", err))
+			return 2
+		}
+	}
+
+	c.UI.Output(fmt.Sprintf("Success! Restored the snapshot of %d entries taken at %s", meta.Entries, meta.CreatedAt))
+	return 0
+}
@@ -0,0 +1,151 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jiangjiali/vault/sdk/helper/complete"
+	"github.com/jiangjiali/vault/sdk/helper/mitchellh/cli"
+	"github.com/jiangjiali/vault/sdk/physical"
+	"github.com/jiangjiali/vault/vault"
+)
+
+var _ cli.Command = (*OperatorSnapshotSaveCommand)(nil)
+var _ cli.CommandAutocomplete = (*OperatorSnapshotSaveCommand)(nil)
+
+type OperatorSnapshotSaveCommand struct {
+	*BaseCommand
+
+	PhysicalBackends map[string]physical.Factory
+	flagConfig       string
+}
+
+func (c *OperatorSnapshotSaveCommand) Synopsis() string {
+	return "保存存储的快照"
+}
+
+func (c *OperatorSnapshotSaveCommand) Help() string {
+	helpText := `
+使用: vault operator snapshot save [选项] PATH
+
+  将安全库存储的快照保存到给定路径。快照在存储写入等待期间获取，因此是一致的。
+  保存后会验证快照的校验和。此命令需要root令牌。
+
+  保存快照：
+
+      $ vault operator snapshot save backup.snap
+
+  给出 -config 时，直接从服务器配置的存储后端保存快照，而不经过服务器。
+  此时服务器必须已停止：
+
+      $ vault operator snapshot save -config=/etc/vault/server.hcl backup.snap
+
+` + c.Flags().Help()
+
+	return strings.TrimSpace(helpText)
+}
+
+func (c *OperatorSnapshotSaveCommand) Flags() *FlagSets {
+	set := c.flagSet(FlagSetHTTP)
+
+	f := set.NewFlagSet("命令选项")
+
+	f.StringVar(&StringVar{
+		Name:   "config",
+		Target: &c.flagConfig,
+		Completion: complete.PredictOr(
+			complete.PredictFiles("*.hcl"),
+			complete.PredictFiles("*.json"),
+		),
+		Usage: "服务器配置文件的路径。给出时直接从其存储后端保存快照，服务器必须已停止。",
+	})
+
+	return set
+}
+
+func (c *OperatorSnapshotSaveCommand) AutocompleteArgs() complete.Predictor {
+	return complete.PredictFiles("*")
+}
+
+func (c *OperatorSnapshotSaveCommand) AutocompleteFlags() complete.Flags {
+	return c.Flags().Completions()
+}
+
+func (c *OperatorSnapshotSaveCommand) Run(args []string) int {
+	f := c.Flags()
+
+	if err := f.Parse(args); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	args = f.Args()
+	switch {
+	case len(args) < 1:
+		c.UI.Error(fmt.Sprintf("Not enough arguments (expected 1, got %d)", len(args)))
+		return 1
+	case len(args) > 1:
+		c.UI.Error(fmt.Sprintf("Too many arguments (expected 1, got %d)", len(args)))
+		return 1
+	}
+	path := args[0]
+
+	// The snapshot is written next to its destination and only moved there
+	// once verified, so that a failure does not leave a partial snapshot
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error creating snapshot file: %s", err))
+		return 1
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	if c.flagConfig != "" {
+		backend, err := snapshotBackend(c.PhysicalBackends, c.flagConfig)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error loading storage from %s: %s", c.flagConfig, err))
+			return 1
+		}
+		if _, err := physical.WriteSnapshot(context.Background(), backend, tmp, vault.SnapshotKey); err != nil {
+			c.UI.Error(fmt.Sprintf("Error saving snapshot: %s", err))
+			return 2
+		}
+	} else {
+		client, err := c.Client()
+		if err != nil {
+			c.UI.Error(err.Error())
+			return 2
+		}
+		if err := client.Sys().StorageSnapshot(tmp); err != nil {
+			c.UI.Error(fmt.Sprintf("Error saving snapshot: %s", err))
+			return 2
+		}
+	}
+
+	if _, err := tmp.Seek(0, 0); err != nil {
+		c.UI.Error(fmt.Sprintf("Error verifying snapshot: %s", err))
+		return 2
+	}
+	meta, err := physical.ReadSnapshot(tmp, nil)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error verifying snapshot: %s", err))
+		return 2
+	}
+	if err := tmp.Close(); err != nil {
+		c.UI.Error(fmt.Sprintf("Error saving snapshot: %s", err))
+		return 2
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		c.UI.Error(fmt.Sprintf("Error saving snapshot: %s", err))
+		return 2
+	}
+
+	c.UI.Output(fmt.Sprintf("Success! Saved a snapshot of %d entries to: %s", meta.Entries, path))
+	return 0
+}
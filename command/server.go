@@ -596,6 +596,13 @@ func (c *ServerCommand) Run(args []string) int {
 		DisableKeyEncodingChecks:  config.DisablePrintableCheck,
 		MetricsHelper:             metricsHelper,
 	}
+	if config.SnapshotAuto != nil {
+		coreConfig.AutoSnapshot = &vault.AutoSnapshotConfig{
+			Interval:     config.SnapshotAuto.Interval,
+			PathTemplate: config.SnapshotAuto.Path,
+			Retain:       config.SnapshotAuto.Retain,
+		}
+	}
 	if c.flagDev {
 		coreConfig.DevToken = c.flagDevRootTokenID
 		if c.flagDevLeasedKV {
@@ -45,6 +45,8 @@ type Config struct {
 
 	Telemetry *Telemetry `hcl:"telemetry"`
 
+	SnapshotAuto *SnapshotAuto `hcl:"-"`
+
 	MaxLeaseTTL        time.Duration `hcl:"-"`
 	MaxLeaseTTLRaw     interface{}   `hcl:"max_lease_ttl"`
 	DefaultLeaseTTL    time.Duration `hcl:"-"`
@@ -253,6 +255,25 @@ func (s *Telemetry) GoString() string {
 	return fmt.Sprintf("*%#v", *s)
 }
 
+// SnapshotAuto is the configuration of the automatic snapshots of the
+// storage taken by the active node
+type SnapshotAuto struct {
+	Interval    time.Duration `hcl:"-"`
+	IntervalRaw interface{}   `hcl:"interval"`
+
+	// Path is the path of the snapshot files, see
+	// vault.AutoSnapshotConfig.PathTemplate
+	Path string `hcl:"path"`
+
+	// Retain is the number of snapshot files kept, all of them are kept
+	// when zero
+	Retain int `hcl:"retain"`
+}
+
+func (s *SnapshotAuto) GoString() string {
+	return fmt.Sprintf("*%#v", *s)
+}
+
 // Merge merges two configurations.
 func (c *Config) Merge(c2 *Config) *Config {
 	if c2 == nil {
@@ -289,6 +310,11 @@ func (c *Config) Merge(c2 *Config) *Config {
 		result.Telemetry = c2.Telemetry
 	}
 
+	result.SnapshotAuto = c.SnapshotAuto
+	if c2.SnapshotAuto != nil {
+		result.SnapshotAuto = c2.SnapshotAuto
+	}
+
 	result.CacheSize = c.CacheSize
 	if c2.CacheSize != 0 {
 		result.CacheSize = c2.CacheSize
@@ -584,6 +610,12 @@ func ParseConfig(d string) (*Config, error) {
 		}
 	}
 
+	if o := list.Filter("snapshot_auto"); len(o.Items) > 0 {
+		if err := parseSnapshotAuto(&result, o); err != nil {
+			return nil, errwrap.Wrapf("error parsing 'snapshot_auto': {{err}}", err)
+		}
+	}
+
 	return &result, nil
 }
 
@@ -898,3 +930,28 @@ func parseTelemetry(result *Config, list *ast.ObjectList) error {
 
 	return nil
 }
+
+func parseSnapshotAuto(result *Config, list *ast.ObjectList) error {
+	if len(list.Items) > 1 {
+		return fmt.Errorf("only one 'snapshot_auto' block is permitted")
+	}
+
+	// Get our one item
+	item := list.Items[0]
+
+	var s SnapshotAuto
+	if err := hcl.DecodeObject(&s, item.Val); err != nil {
+		return multierror.Prefix(err, "snapshot_auto:")
+	}
+
+	// The configuration is validated by the core
+	if s.IntervalRaw != nil {
+		var err error
+		if s.Interval, err = parseutil.ParseDurationSecond(s.IntervalRaw); err != nil {
+			return err
+		}
+	}
+
+	result.SnapshotAuto = &s
+	return nil
+}
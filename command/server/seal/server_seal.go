@@ -19,7 +19,7 @@ func configureSeal(configSeal *server.Seal, infoKeys *[]string, info *map[string
 		return configureTransitSeal(configSeal, infoKeys, info, logger)
 
 	case seal.PKCS11:
-		return nil, fmt.Errorf("seal type 'pkcs11' requires the Vault Enterprise HSM binary")
+		return configurePKCS11Seal(configSeal, infoKeys, info, logger)
 
 	case seal.Shamir:
 		return inseal, nil
@@ -0,0 +1,32 @@
+// +build cgo
+
+package seal
+
+import (
+	"github.com/jiangjiali/vault/command/server"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/vault"
+	"github.com/jiangjiali/vault/vault/seal/pkcs11"
+)
+
+func configurePKCS11Seal(configSeal *server.Seal, infoKeys *[]string, info *map[string]string, logger log.Logger) (vault.Seal, error) {
+	hsmSeal := pkcs11.NewSeal(logger)
+	sealInfo, err := hsmSeal.SetConfig(configSeal.Config)
+	if err != nil {
+		return nil, err
+	}
+	autoseal := vault.NewAutoSeal(hsmSeal)
+	if sealInfo != nil {
+		*infoKeys = append(*infoKeys, "Seal Type", "HSM Library", "HSM Slot", "HSM Key Label", "HSM Mechanism")
+		(*info)["Seal Type"] = configSeal.Type
+		(*info)["HSM Library"] = sealInfo["lib"]
+		(*info)["HSM Slot"] = sealInfo["slot"]
+		(*info)["HSM Key Label"] = sealInfo["key_label"]
+		(*info)["HSM Mechanism"] = sealInfo["mechanism"]
+		if tokenLabel, ok := sealInfo["token_label"]; ok {
+			*infoKeys = append(*infoKeys, "HSM Token Label")
+			(*info)["HSM Token Label"] = tokenLabel
+		}
+	}
+	return autoseal, nil
+}
@@ -0,0 +1,17 @@
+// +build !cgo
+
+package seal
+
+import (
+	"errors"
+
+	"github.com/jiangjiali/vault/command/server"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/vault"
+)
+
+// configurePKCS11Seal fails without cgo, which the PKCS#11 library is loaded
+// through.
+func configurePKCS11Seal(configSeal *server.Seal, infoKeys *[]string, info *map[string]string, logger log.Logger) (vault.Seal, error) {
+	return nil, errors.New("the pkcs11 seal is unsupported without cgo")
+}
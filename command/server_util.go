@@ -47,11 +47,23 @@ func adjustCoreForSealMigration(core *vault.Core, barrierSeal, unwrapSeal vault.
 	var newSeal vault.Seal
 
 	if existBarrierSealConfig.Type == barrierSeal.BarrierType() {
-		// In this case our migration seal is set so we are using it
-		// (potentially) for unwrapping. Set it on core for that purpose then
-		// exit.
-		core.SetSealsForMigration(nil, nil, unwrapSeal)
-		return nil
+		// A disabled seal of the same type is a migration between two keys,
+		// for instance two transit keys, as long as the stored keys are still
+		// encrypted with its key
+		migrating := false
+		if barrierSeal.StoredKeysSupported() && unwrapSeal.StoredKeysSupported() {
+			migrating, err = core.StoredKeysMigrationPending(context.Background(), barrierSeal, unwrapSeal)
+			if err != nil {
+				return fmt.Errorf("error checking for seal migration: %s", err)
+			}
+		}
+		if !migrating {
+			// In this case our migration seal is set so we are using it
+			// (potentially) for unwrapping. Set it on core for that purpose
+			// then exit.
+			core.SetSealsForMigration(nil, nil, unwrapSeal)
+			return nil
+		}
 	}
 
 	if existBarrierSealConfig.Type != vaultseal.Shamir && existRecoverySealConfig == nil {
@@ -77,7 +89,20 @@ func adjustCoreForSealMigration(core *vault.Core, barrierSeal, unwrapSeal vault.
 		// in the config and disabled.
 		existSeal = unwrapSeal
 		newSeal = barrierSeal
-		newSeal.SetCachedBarrierConfig(existRecoverySealConfig)
+		if newSeal.BarrierType() == vaultseal.Shamir {
+			// The recovery keys become the unseal keys
+			newSeal.SetCachedBarrierConfig(existRecoverySealConfig)
+		} else {
+			// The recovery keys are kept, the master key is stored with the
+			// new seal
+			newSeal.SetCachedBarrierConfig(&vault.SealConfig{
+				Type:            newSeal.BarrierType(),
+				SecretShares:    1,
+				SecretThreshold: 1,
+				StoredShares:    1,
+			})
+			newSeal.SetCachedRecoveryConfig(existRecoverySealConfig)
+		}
 	}
 
 	core.SetSealsForMigration(existSeal, newSeal, unwrapSeal)
@@ -97,6 +97,7 @@ func Handler(props *vault.HandlerProperties) http.Handler {
 	// Create the muxer to handle the actual endpoints
 	mux := http.NewServeMux()
 	mux.Handle("/v1/sys/init", handleSysInit(core))
+	mux.Handle("/v1/sys/storage/raft/join", handleSysRaftJoin(core))
 	mux.Handle("/v1/sys/seal-status", handleSysSealStatus(core))
 	mux.Handle("/v1/sys/seal", handleSysSeal(core))
 	mux.Handle("/v1/sys/step-down", handleRequestForwarding(core, handleSysStepDown(core)))
@@ -105,6 +106,8 @@ func Handler(props *vault.HandlerProperties) http.Handler {
 	mux.Handle("/v1/sys/health", handleSysHealth(core))
 	mux.Handle("/v1/sys/generate-root/attempt", handleRequestForwarding(core, handleSysGenerateRootAttempt(core, vault.GenerateStandardRootTokenStrategy)))
 	mux.Handle("/v1/sys/generate-root/update", handleRequestForwarding(core, handleSysGenerateRootUpdate(core, vault.GenerateStandardRootTokenStrategy)))
+	mux.Handle("/v1/sys/replication/dr/secondary/generate-operation-token/attempt", handleRequestForwarding(core, handleSysGenerateRootAttempt(core, vault.GenerateDROperationTokenStrategy)))
+	mux.Handle("/v1/sys/replication/dr/secondary/generate-operation-token/update", handleRequestForwarding(core, handleSysGenerateRootUpdate(core, vault.GenerateDROperationTokenStrategy)))
 	mux.Handle("/v1/sys/rekey/init", handleRequestForwarding(core, handleSysRekeyInit(core, false)))
 	mux.Handle("/v1/sys/rekey/update", handleRequestForwarding(core, handleSysRekeyUpdate(core, false)))
 	mux.Handle("/v1/sys/rekey/verify", handleRequestForwarding(core, handleSysRekeyVerify(core, false)))
@@ -164,9 +167,9 @@ func wrapGenericHandler(core *vault.Core, h http.Handler, maxRequestSize int64,
 
 		switch {
 		case strings.HasPrefix(r.URL.Path, "/v1/"):
-			newR, status := adjustRequest(core, r)
+			newR, status, err := adjustRequest(core, r)
 			if status != 0 {
-				respondError(w, status, nil)
+				respondError(w, status, err)
 				cancelFunc()
 				return
 			}
@@ -369,7 +372,7 @@ func parseRequest(core *vault.Core, r *http.Request, w http.ResponseWriter, out
 		}
 	}
 	var origBody io.ReadWriter
-	if core.PerfStandby() {
+	if core.PerfStandby() || core.IsPerfSecondary() {
 		// Since we're checking PerfStandby here we key on origBody being nil
 		// or not later, so we need to always allocate so it's non-nil
 		origBody = new(bytes.Buffer)
@@ -496,6 +499,16 @@ func request(core *vault.Core, w http.ResponseWriter, rawReq *http.Request, r *l
 		return nil, false, true
 	}
 
+	// A streamed response is already sent. If it failed midway, the response
+	// is aborted so that the client does not take it for a complete one.
+	if r.ResponseWriter != nil && r.ResponseWriter.Written() {
+		if err != nil {
+			core.Logger().Error("failed to stream the response", "path", r.Path, "error", err)
+			panic(http.ErrAbortHandler)
+		}
+		return nil, false, false
+	}
+
 	if resp != nil && len(resp.Headers) > 0 {
 		// Set this here so it will take effect regardless of any other type of
 		// response processing
@@ -18,6 +18,24 @@ import (
 	"github.com/jiangjiali/vault/vault"
 )
 
+// mergePatchContentType is the media type of JSON merge patch documents
+// accepted by PATCH requests.
+const mergePatchContentType = "application/merge-patch+json"
+
+var (
+	// streamedResponsePaths are the paths whose handlers stream the body of
+	// their response, see logical.Request.ResponseWriter
+	streamedResponsePaths = map[string]bool{
+		"sys/storage/snapshot": true,
+	}
+
+	// streamedRequestPaths are the paths whose handlers read the raw body of
+	// their request, see logical.Request.RequestReader
+	streamedRequestPaths = map[string]bool{
+		"sys/storage/snapshot-restore": true,
+	}
+)
+
 func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Request) (*logical.Request, io.ReadCloser, int, error) {
 	ns, err := namespace.FromContext(r.Context())
 	if err != nil {
@@ -27,6 +45,8 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 
 	var data map[string]interface{}
 	var origBody io.ReadCloser
+	var responseWriter *logical.HTTPResponseWriter
+	var requestReader io.ReadCloser
 
 	// Determine the operation
 	var op logical.Operation
@@ -74,12 +94,31 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 			if len(getData) > 0 {
 				data = getData
 			}
+
+			if streamedResponsePaths[path] {
+				responseWriter = logical.NewHTTPResponseWriter(w)
+
+				// A client reading the response slowly must not hold the
+				// handler past the request duration
+				if deadline, ok := r.Context().Deadline(); ok {
+					http.NewResponseController(w).SetWriteDeadline(deadline)
+				}
+			}
 		}
 
 	case "POST", "PUT":
 		op = logical.UpdateOperation
-		// Parse the request if we can
-		if op == logical.UpdateOperation {
+		// The streamed requests are left unparsed and unbounded by the
+		// maximum request size, their parameters are given in the query
+		if streamedRequestPaths[path] {
+			requestReader = r.Body
+			data = make(map[string]interface{})
+			for k, v := range r.URL.Query() {
+				if len(v) > 0 {
+					data[k] = v[0]
+				}
+			}
+		} else {
 			origBody, err = parseRequest(core, r, w, &data)
 			if err == io.EOF {
 				data = nil
@@ -90,6 +129,24 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 			}
 		}
 
+	case "PATCH":
+		op = logical.PatchOperation
+
+		// Only JSON merge patch documents are accepted, see RFC 7396
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" || !strings.HasPrefix(contentType, mergePatchContentType) {
+			return nil, nil, http.StatusUnsupportedMediaType, fmt.Errorf("PATCH requires Content-Type of %s, provided %q", mergePatchContentType, contentType)
+		}
+
+		origBody, err = parseRequest(core, r, w, &data)
+		if err == io.EOF {
+			data = nil
+			err = nil
+		}
+		if err != nil {
+			return nil, nil, http.StatusBadRequest, err
+		}
+
 	case "LIST":
 		op = logical.ListOperation
 		if !strings.HasSuffix(path, "/") {
@@ -106,13 +163,17 @@ func buildLogicalRequest(core *vault.Core, w http.ResponseWriter, r *http.Reques
 		return nil, nil, http.StatusBadRequest, errwrap.Wrapf("failed to generate identifier for the request: {{err}}", err)
 	}
 
+	// The headers are copied as handling the request strips the token from
+	// them, and the raw request may still be forwarded afterwards
 	req, err := requestAuth(core, r, &logical.Request{
-		ID:         requestId,
-		Operation:  op,
-		Path:       path,
-		Data:       data,
-		Connection: getConnection(r),
-		Headers:    r.Header,
+		ID:             requestId,
+		Operation:      op,
+		Path:           path,
+		Data:           data,
+		Connection:     getConnection(r),
+		Headers:        r.Header.Clone(),
+		ResponseWriter: responseWriter,
+		RequestReader:  requestReader,
 	})
 	if err != nil {
 		if errwrap.Contains(err, logical.ErrPermissionDenied.Error()) {
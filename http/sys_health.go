@@ -119,6 +119,14 @@ func getSysHealth(core *vault.Core, r *http.Request) (int, *HealthResponse, erro
 		perfStandbyCode = code
 	}
 
+	// Only used by an active node whose last automatic snapshot failed
+	snapshotFailureCode := activeCode
+	if code, found, ok := fetchStatusCode(r, "snapshotfailurecode"); !ok {
+		return http.StatusBadRequest, nil, nil
+	} else if found {
+		snapshotFailureCode = code
+	}
+
 	ctx := context.Background()
 
 	// Check system status
@@ -182,6 +190,10 @@ func getSysHealth(core *vault.Core, r *http.Request) (int, *HealthResponse, erro
 
 	if init && !sealed && !standby {
 		body.LastWAL = vault.LastWAL(core)
+		body.AutoSnapshotFailing = core.AutoSnapshotFailing()
+		if body.AutoSnapshotFailing && code == activeCode {
+			code = snapshotFailureCode
+		}
 	}
 
 	return code, body, nil
@@ -199,4 +211,5 @@ type HealthResponse struct {
 	ClusterName                string `json:"cluster_name,omitempty"`
 	ClusterID                  string `json:"cluster_id,omitempty"`
 	LastWAL                    uint64 `json:"last_wal,omitempty"`
+	AutoSnapshotFailing        bool   `json:"auto_snapshot_failing,omitempty"`
 }
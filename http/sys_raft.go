@@ -0,0 +1,50 @@
+package http
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jiangjiali/vault/vault"
+)
+
+func handleSysRaftJoin(core *vault.Core) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PUT", "POST":
+			handleSysRaftJoinPut(core, w, r)
+		default:
+			respondError(w, http.StatusMethodNotAllowed, nil)
+		}
+	})
+}
+
+func handleSysRaftJoinPut(core *vault.Core, w http.ResponseWriter, r *http.Request) {
+	// Parse the request
+	var req JoinRequest
+	if _, err := parseRequest(core, r, w, &req); err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	err := core.JoinRaftCluster(context.Background(), &vault.RaftJoinParams{
+		LeaderAPIAddr: req.LeaderAPIAddr,
+		LeaderCACert:  req.LeaderCACert,
+	})
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	respondOk(w, &JoinResponse{
+		Joined: true,
+	})
+}
+
+type JoinRequest struct {
+	LeaderAPIAddr string `json:"leader_api_addr"`
+	LeaderCACert  string `json:"leader_ca_cert"`
+}
+
+type JoinResponse struct {
+	Joined bool `json:"joined"`
+}
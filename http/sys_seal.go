@@ -192,6 +192,11 @@ func handleSysSealStatusRaw(core *vault.Core, w http.ResponseWriter) {
 		return
 	}
 
+	// A node joining a raft cluster is unsealed with the keys of the cluster
+	if sealConfig == nil {
+		sealConfig = core.RaftJoinSealConfig()
+	}
+
 	if sealConfig == nil {
 		respondOk(w, &SealStatusResponse{
 			Type:         core.SealAccess().BarrierType(),
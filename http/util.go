@@ -1,15 +1,56 @@
 package http
 
 import (
+	"fmt"
 	"net/http"
+	"strings"
 
+	"github.com/jiangjiali/vault/sdk/helper/consts"
 	"github.com/jiangjiali/vault/sdk/helper/namespace"
 	"github.com/jiangjiali/vault/vault"
 )
 
 var (
-	adjustRequest = func(c *vault.Core, r *http.Request) (*http.Request, int) {
-		return r.WithContext(namespace.ContextWithNamespace(r.Context(), namespace.RootNamespace)), 0
+	// adjustRequest resolves the namespace of the request from the namespace
+	// header and the path. The path of the namespace given in the header is
+	// moved into the URL so the rest of the handlers only have to look at the
+	// path.
+	adjustRequest = func(c *vault.Core, r *http.Request) (*http.Request, int, error) {
+		// The namespaces are not loaded while sealed, and nothing past the
+		// unauthenticated endpoints can be reached anyway
+		if c.Sealed() {
+			return r.WithContext(namespace.ContextWithNamespace(r.Context(), namespace.RootNamespace)), 0, nil
+		}
+
+		// Standbys do not load the namespaces either, the request is left as
+		// is for the active node it is forwarded or redirected to
+		if standby, _ := c.Standby(); standby {
+			return r.WithContext(namespace.ContextWithNamespace(r.Context(), namespace.RootNamespace)), 0, nil
+		}
+
+		nsHeader := namespace.Canonicalize(r.Header.Get(consts.NamespaceHeaderName))
+		if nsHeader != "" && c.NamespaceByPath(nsHeader) == nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("namespace %q does not exist", strings.TrimSuffix(nsHeader, "/"))
+		}
+
+		reqPath := nsHeader + strings.TrimPrefix(r.URL.Path, "/v1/")
+		ns := c.LongestNamespacePrefix(reqPath)
+
+		newR := r.WithContext(namespace.ContextWithNamespace(r.Context(), ns))
+		if nsHeader != "" {
+			// Forwarded requests are adjusted again by the active node, so the
+			// header must not be applied twice
+			newURL := *r.URL
+			newURL.Path = "/v1/" + reqPath
+			if newURL.RawPath != "" {
+				newURL.RawPath = "/v1/" + nsHeader + strings.TrimPrefix(newURL.RawPath, "/v1/")
+			}
+			newR.URL = &newURL
+			newR.Header = r.Header.Clone()
+			newR.Header.Del(consts.NamespaceHeaderName)
+		}
+
+		return newR, 0, nil
 	}
 
 	genericWrapping = func(core *vault.Core, in http.Handler, props *vault.HandlerProperties) http.Handler {
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/jiangjiali/vault/sdk/framework"
-	"github.com/jiangjiali/vault/sdk/helper/cache"
 	"github.com/jiangjiali/vault/sdk/helper/oidc"
 	"github.com/jiangjiali/vault/sdk/logical"
 )
@@ -14,6 +13,10 @@ import (
 const (
 	configPath string = "config"
 	rolePrefix string = "role/"
+
+	// jwksRefreshInterval is how often the keys served at the JWKS URL are
+	// fetched in the background.
+	jwksRefreshInterval = 5 * time.Minute
 )
 
 // Factory is used by framework
@@ -30,17 +33,24 @@ type jwtAuthBackend struct {
 
 	l            sync.RWMutex
 	provider     *oidc.Provider
+	keySet       oidc.KeySet
 	cachedConfig *jwtConfig
-	oidcStates   *cache.Cache
 
 	providerCtx       context.Context
 	providerCtxCancel context.CancelFunc
+
+	// keySetCancel stops the background refresh of keySet
+	keySetCancel context.CancelFunc
+
+	// pendingStates is the number of OAuth states stored and not yet used or
+	// tidied, bounded by maxOIDCStates
+	stateLock     sync.Mutex
+	pendingStates int
 }
 
 func backend() *jwtAuthBackend {
 	b := new(jwtAuthBackend)
 	b.providerCtx, b.providerCtxCancel = context.WithCancel(context.Background())
-	b.oidcStates = cache.New(oidcStateTimeout, 1*time.Minute)
 
 	b.Backend = &framework.Backend{
 		AuthRenew:   b.pathLoginRenew,
@@ -72,7 +82,8 @@ func backend() *jwtAuthBackend {
 			},
 			pathOIDC(b),
 		),
-		Clean: b.cleanup,
+		Clean:        b.cleanup,
+		PeriodicFunc: b.tidyStates,
 	}
 
 	return b
@@ -97,6 +108,11 @@ func (b *jwtAuthBackend) reset() {
 	b.l.Lock()
 	b.provider = nil
 	b.cachedConfig = nil
+	if b.keySetCancel != nil {
+		b.keySetCancel()
+		b.keySetCancel = nil
+	}
+	b.keySet = nil
 	b.l.Unlock()
 }
 
@@ -126,6 +142,35 @@ func (b *jwtAuthBackend) getProvider(config *jwtConfig) (*oidc.Provider, error)
 	return provider, nil
 }
 
+func (b *jwtAuthBackend) getKeySet(config *jwtConfig) (oidc.KeySet, error) {
+	b.l.RLock()
+	unlockFunc := b.l.RUnlock
+	defer func() { unlockFunc() }()
+
+	if b.keySet != nil {
+		return b.keySet, nil
+	}
+
+	b.l.RUnlock()
+	b.l.Lock()
+	unlockFunc = b.l.Unlock
+
+	if b.keySet != nil {
+		return b.keySet, nil
+	}
+
+	ctx, cancel := context.WithCancel(b.providerCtx)
+	keySet, err := createKeySet(ctx, config)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	b.keySet = keySet
+	b.keySetCancel = cancel
+	return keySet, nil
+}
+
 const (
 	backendHelp = `
 The JWT backend plugin allows authentication using JWTs (including OIDC).
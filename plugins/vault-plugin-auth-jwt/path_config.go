@@ -24,12 +24,20 @@ func pathConfig(b *jwtAuthBackend) *framework.Path {
 		Fields: map[string]*framework.FieldSchema{
 			"oidc_discovery_url": {
 				Type:        framework.TypeString,
-				Description: `OIDC Discovery URL, without any .well-known component (base path). Cannot be used with "jwt_validation_pubkeys".`,
+				Description: `OIDC Discovery URL, without any .well-known component (base path). Cannot be used with "jwks_url" or "jwt_validation_pubkeys".`,
 			},
 			"oidc_discovery_ca_pem": {
 				Type:        framework.TypeString,
 				Description: "The CA certificate or chain of certificates, in PEM format, to use to validate conections to the OIDC Discovery URL. If not set, system certificates are used.",
 			},
+			"jwks_url": {
+				Type:        framework.TypeString,
+				Description: `JWKS URL to use to authenticate signatures. Cannot be used with "oidc_discovery_url" or "jwt_validation_pubkeys".`,
+			},
+			"jwks_ca_pem": {
+				Type:        framework.TypeString,
+				Description: "The CA certificate or chain of certificates, in PEM format, to use to validate connections to the JWKS URL. If not set, system certificates are used.",
+			},
 			"oidc_client_id": {
 				Type:        framework.TypeString,
 				Description: "The OAuth Client ID configured with your OIDC provider.",
@@ -45,7 +53,7 @@ func pathConfig(b *jwtAuthBackend) *framework.Path {
 			},
 			"jwt_validation_pubkeys": {
 				Type:        framework.TypeCommaStringSlice,
-				Description: `A list of PEM-encoded public keys to use to authenticate signatures locally. Cannot be used with "oidc_discovery_url".`,
+				Description: `A list of PEM-encoded public keys to use to authenticate signatures locally. Cannot be used with "jwks_url" or "oidc_discovery_url".`,
 			},
 			"jwt_supported_algs": {
 				Type:        framework.TypeCommaStringSlice,
@@ -92,6 +100,9 @@ func (b *jwtAuthBackend) config(ctx context.Context, s logical.Storage) (*jwtCon
 	}
 
 	result := &jwtConfig{}
+	if err := entry.DecodeJSON(result); err != nil {
+		return nil, err
+	}
 
 	for _, v := range result.JWTValidationPubKeys {
 		key, err := certutil.ParsePublicKeyPEM([]byte(v))
@@ -119,6 +130,8 @@ func (b *jwtAuthBackend) pathConfigRead(ctx context.Context, req *logical.Reques
 		Data: map[string]interface{}{
 			"oidc_discovery_url":     config.OIDCDiscoveryURL,
 			"oidc_discovery_ca_pem":  config.OIDCDiscoveryCAPEM,
+			"jwks_url":               config.JWKSURL,
+			"jwks_ca_pem":            config.JWKSCAPEM,
 			"oidc_client_id":         config.OIDCClientID,
 			"default_role":           config.DefaultRole,
 			"jwt_validation_pubkeys": config.JWTValidationPubKeys,
@@ -134,6 +147,8 @@ func (b *jwtAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 	config := &jwtConfig{
 		OIDCDiscoveryURL:     d.Get("oidc_discovery_url").(string),
 		OIDCDiscoveryCAPEM:   d.Get("oidc_discovery_ca_pem").(string),
+		JWKSURL:              d.Get("jwks_url").(string),
+		JWKSCAPEM:            d.Get("jwks_ca_pem").(string),
 		OIDCClientID:         d.Get("oidc_client_id").(string),
 		OIDCClientSecret:     d.Get("oidc_client_secret").(string),
 		DefaultRole:          d.Get("default_role").(string),
@@ -142,11 +157,21 @@ func (b *jwtAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 		BoundIssuer:          d.Get("bound_issuer").(string),
 	}
 
+	methodCount := 0
+	if config.OIDCDiscoveryURL != "" {
+		methodCount++
+	}
+	if config.JWKSURL != "" {
+		methodCount++
+	}
+	if len(config.JWTValidationPubKeys) != 0 {
+		methodCount++
+	}
+
 	// Run checks on values
 	switch {
-	case config.OIDCDiscoveryURL == "" && len(config.JWTValidationPubKeys) == 0,
-		config.OIDCDiscoveryURL != "" && len(config.JWTValidationPubKeys) != 0:
-		return logical.ErrorResponse("exactly one of 'oidc_discovery_url' and 'jwt_validation_pubkeys' must be set"), nil
+	case methodCount != 1:
+		return logical.ErrorResponse("exactly one of 'oidc_discovery_url', 'jwks_url' and 'jwt_validation_pubkeys' must be set"), nil
 
 	case config.OIDCClientID != "" && config.OIDCClientSecret == "",
 		config.OIDCClientID == "" && config.OIDCClientSecret != "":
@@ -161,6 +186,20 @@ func (b *jwtAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 	case config.OIDCClientID != "" && config.OIDCDiscoveryURL == "":
 		return logical.ErrorResponse("'oidc_discovery_url' must be set for OIDC"), nil
 
+	case config.JWKSURL != "":
+		jwksCtx, err := createCAContext(ctx, config.JWKSCAPEM)
+		if err != nil {
+			return logical.ErrorResponse(errwrap.Wrapf("error parsing 'jwks_ca_pem': {{err}}", err).Error()), nil
+		}
+
+		keys, err := oidc.FetchKeys(jwksCtx, config.JWKSURL)
+		if err != nil {
+			return logical.ErrorResponse(errwrap.Wrapf("error checking JWKS URL: {{err}}", err).Error()), nil
+		}
+		if len(keys) == 0 {
+			return logical.ErrorResponse("error checking JWKS URL: no keys found"), nil
+		}
+
 	case len(config.JWTValidationPubKeys) != 0:
 		for _, v := range config.JWTValidationPubKeys {
 			if _, err := certutil.ParsePublicKeyPEM([]byte(v)); err != nil {
@@ -194,9 +233,9 @@ func (b *jwtAuthBackend) pathConfigWrite(ctx context.Context, req *logical.Reque
 }
 
 func (b *jwtAuthBackend) createProvider(config *jwtConfig) (*oidc.Provider, error) {
-	oidcCtx, err := b.createOIDCContext(b.providerCtx, config)
+	oidcCtx, err := createCAContext(b.providerCtx, config.OIDCDiscoveryCAPEM)
 	if err != nil {
-		return nil, errwrap.Wrapf("error creating provider: {{err}}", err)
+		return nil, errwrap.Wrapf("error creating provider: could not parse 'oidc_discovery_ca_pem': {{err}}", err)
 	}
 
 	provider, err := oidc.NewProvider(oidcCtx, config.OIDCDiscoveryURL)
@@ -207,16 +246,27 @@ func (b *jwtAuthBackend) createProvider(config *jwtConfig) (*oidc.Provider, erro
 	return provider, nil
 }
 
-// createOIDCContext returns a context with custom TLS client, configured with the root certificates
-// from oidc_discovery_ca_pem. If no certificates are configured, the original context is returned.
-func (b *jwtAuthBackend) createOIDCContext(ctx context.Context, config *jwtConfig) (context.Context, error) {
-	if config.OIDCDiscoveryCAPEM == "" {
+// createKeySet returns a key set fetching the keys from the JWKS URL, which is
+// refreshed in the background until ctx is canceled.
+func createKeySet(ctx context.Context, config *jwtConfig) (oidc.KeySet, error) {
+	jwksCtx, err := createCAContext(ctx, config.JWKSCAPEM)
+	if err != nil {
+		return nil, errwrap.Wrapf("error creating key set: could not parse 'jwks_ca_pem': {{err}}", err)
+	}
+
+	return oidc.NewRemoteKeySetWithRefresh(jwksCtx, config.JWKSURL, jwksRefreshInterval), nil
+}
+
+// createCAContext returns a context with custom TLS client, configured with the root certificates
+// from caPEM. If no certificates are configured, the original context is returned.
+func createCAContext(ctx context.Context, caPEM string) (context.Context, error) {
+	if caPEM == "" {
 		return ctx, nil
 	}
 
 	certPool := x509.NewCertPool()
-	if ok := certPool.AppendCertsFromPEM([]byte(config.OIDCDiscoveryCAPEM)); !ok {
-		return nil, errors.New("could not parse 'oidc_discovery_ca_pem' value successfully")
+	if ok := certPool.AppendCertsFromPEM([]byte(caPEM)); !ok {
+		return nil, errors.New("could not parse CA PEM value successfully")
 	}
 
 	tr := cleanhttp.DefaultPooledTransport()
@@ -237,6 +287,8 @@ func (b *jwtAuthBackend) createOIDCContext(ctx context.Context, config *jwtConfi
 type jwtConfig struct {
 	OIDCDiscoveryURL     string   `json:"oidc_discovery_url"`
 	OIDCDiscoveryCAPEM   string   `json:"oidc_discovery_ca_pem"`
+	JWKSURL              string   `json:"jwks_url"`
+	JWKSCAPEM            string   `json:"jwks_ca_pem"`
 	OIDCClientID         string   `json:"oidc_client_id"`
 	OIDCClientSecret     string   `json:"oidc_client_secret"`
 	JWTValidationPubKeys []string `json:"jwt_validation_pubkeys"`
@@ -254,7 +306,10 @@ Configures the JWT authentication backend.
 	confHelpDesc = `
 The JWT authentication backend validates JWTs (or OIDC) using the configured
 credentials. If using OIDC Discovery, the URL must be provided, along
-with (optionally) the CA cert to use for the connection. If performing JWT
+with (optionally) the CA cert to use for the connection. If using a JWKS URL,
+the URL must be provided, along with (optionally) the CA cert to use for the
+connection; the keys are cached and refreshed in the background, and tokens are
+validated against the key matching their "kid" header. If performing JWT
 validation locally, a set of public keys must be provided.
 `
 )
@@ -11,6 +11,7 @@ import (
 	"github.com/jiangjiali/vault/sdk/helper/errwrap"
 	"github.com/jiangjiali/vault/sdk/helper/jose/jwt"
 	"github.com/jiangjiali/vault/sdk/helper/oidc"
+	"github.com/jiangjiali/vault/sdk/helper/strutil"
 	"github.com/jiangjiali/vault/sdk/logical"
 )
 
@@ -82,74 +83,16 @@ func (b *jwtAuthBackend) pathLogin(ctx context.Context, req *logical.Request, d
 	}
 
 	// Here is where things diverge. If it is using OIDC Discovery, validate
-	// that way; otherwise validate against the locally configured keys. Once
+	// that way; otherwise validate against the locally configured keys or the
+	// keys fetched from the JWKS URL. Once
 	// things are validated, we re-unify the request path when evaluating the
 	// claims.
 	allClaims := map[string]interface{}{}
 	switch {
-	case len(config.ParsedJWTPubKeys) != 0:
-		parsedJWT, err := jwt.ParseSigned(token)
+	case len(config.ParsedJWTPubKeys) != 0, config.JWKSURL != "":
+		allClaims, err = b.verifyJWT(ctx, config, role, token)
 		if err != nil {
-			return logical.ErrorResponse(errwrap.Wrapf("error parsing token: {{err}}", err).Error()), nil
-		}
-
-		claims := jwt.Claims{}
-
-		var valid bool
-		for _, key := range config.ParsedJWTPubKeys {
-			if err := parsedJWT.Claims(key, &claims, &allClaims); err == nil {
-				valid = true
-				break
-			}
-		}
-		if !valid {
-			return logical.ErrorResponse("no known key successfully validated the token signature"), nil
-		}
-
-		// We require notbefore or expiry; if only one is provided, we allow 5 minutes of leeway.
-		if claims.IssuedAt == nil {
-			claims.IssuedAt = new(jwt.NumericDate)
-		}
-		if claims.Expiry == nil {
-			claims.Expiry = new(jwt.NumericDate)
-		}
-		if claims.NotBefore == nil {
-			claims.NotBefore = new(jwt.NumericDate)
-		}
-		if *claims.IssuedAt == 0 && *claims.Expiry == 0 && *claims.NotBefore == 0 {
-			return logical.ErrorResponse("no issue time, notbefore, or expiration time encoded in token"), nil
-		}
-		if *claims.Expiry == 0 {
-			latestStart := *claims.IssuedAt
-			if *claims.NotBefore > *claims.IssuedAt {
-				latestStart = *claims.NotBefore
-			}
-			*claims.Expiry = latestStart + 300
-		}
-		if *claims.NotBefore == 0 {
-			if *claims.IssuedAt != 0 {
-				*claims.NotBefore = *claims.IssuedAt
-			} else {
-				*claims.NotBefore = *claims.Expiry - 300
-			}
-		}
-
-		if len(claims.Audience) > 0 && len(role.BoundAudiences) == 0 {
-			return logical.ErrorResponse("audience claim found in JWT but no audiences bound to the role"), nil
-		}
-
-		expected := jwt.Expected{
-			Issuer:  config.BoundIssuer,
-			Subject: role.BoundSubject,
-			Time:    time.Now(),
-		}
-
-		if err := claims.Validate(expected); err != nil {
-			return logical.ErrorResponse(errwrap.Wrapf("error validating claims: {{err}}", err).Error()), nil
-		}
-
-		if err := validateAudience(role.BoundAudiences, claims.Audience, true); err != nil {
-			return logical.ErrorResponse(errwrap.Wrapf("error validating claims: {{err}}", err).Error()), nil
+			return logical.ErrorResponse(err.Error()), nil
 		}
 
 	case config.OIDCDiscoveryURL != "":
@@ -222,6 +165,116 @@ func (b *jwtAuthBackend) pathLoginRenew(ctx context.Context, req *logical.Reques
 	return resp, nil
 }
 
+// verifyJWT validates the signature of the token against the locally configured
+// keys or the keys fetched from the JWKS URL, and then validates its claims.
+func (b *jwtAuthBackend) verifyJWT(ctx context.Context, config *jwtConfig, role *jwtRole, rawToken string) (map[string]interface{}, error) {
+	allClaims := make(map[string]interface{})
+
+	parsedJWT, err := jwt.ParseSigned(rawToken)
+	if err != nil {
+		return nil, errwrap.Wrapf("error parsing token: {{err}}", err)
+	}
+
+	claims := jwt.Claims{}
+
+	switch {
+	case len(config.ParsedJWTPubKeys) != 0:
+		var valid bool
+		for _, key := range config.ParsedJWTPubKeys {
+			if err := parsedJWT.Claims(key, &claims, &allClaims); err == nil {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, errors.New("no known key successfully validated the token signature")
+		}
+
+	default:
+		if err := validateAlgorithm(config, parsedJWT); err != nil {
+			return nil, err
+		}
+
+		keySet, err := b.getKeySet(config)
+		if err != nil {
+			return nil, errwrap.Wrapf("error getting key set for login operation: {{err}}", err)
+		}
+
+		if _, err := keySet.VerifySignature(ctx, rawToken); err != nil {
+			return nil, errwrap.Wrapf("error validating signature: {{err}}", err)
+		}
+
+		if err := parsedJWT.UnsafeClaimsWithoutVerification(&claims, &allClaims); err != nil {
+			return nil, errwrap.Wrapf("unable to successfully parse all claims from token: {{err}}", err)
+		}
+	}
+
+	// We require notbefore or expiry; if only one is provided, we allow 5 minutes of leeway.
+	if claims.IssuedAt == nil {
+		claims.IssuedAt = new(jwt.NumericDate)
+	}
+	if claims.Expiry == nil {
+		claims.Expiry = new(jwt.NumericDate)
+	}
+	if claims.NotBefore == nil {
+		claims.NotBefore = new(jwt.NumericDate)
+	}
+	if *claims.IssuedAt == 0 && *claims.Expiry == 0 && *claims.NotBefore == 0 {
+		return nil, errors.New("no issue time, notbefore, or expiration time encoded in token")
+	}
+	if *claims.Expiry == 0 {
+		latestStart := *claims.IssuedAt
+		if *claims.NotBefore > *claims.IssuedAt {
+			latestStart = *claims.NotBefore
+		}
+		*claims.Expiry = latestStart + 300
+	}
+	if *claims.NotBefore == 0 {
+		if *claims.IssuedAt != 0 {
+			*claims.NotBefore = *claims.IssuedAt
+		} else {
+			*claims.NotBefore = *claims.Expiry - 300
+		}
+	}
+
+	if len(claims.Audience) > 0 && len(role.BoundAudiences) == 0 {
+		return nil, errors.New("audience claim found in JWT but no audiences bound to the role")
+	}
+
+	expected := jwt.Expected{
+		Issuer:  config.BoundIssuer,
+		Subject: role.BoundSubject,
+		Time:    time.Now(),
+	}
+
+	if err := claims.Validate(expected); err != nil {
+		return nil, errwrap.Wrapf("error validating claims: {{err}}", err)
+	}
+
+	if err := validateAudience(role.BoundAudiences, claims.Audience, true); err != nil {
+		return nil, errwrap.Wrapf("error validating claims: {{err}}", err)
+	}
+
+	return allClaims, nil
+}
+
+// validateAlgorithm checks that the token is signed with one of the supported
+// algorithms, RS256 if none are configured.
+func validateAlgorithm(config *jwtConfig, parsedJWT *jwt.JSONWebToken) error {
+	supportedAlgs := config.JWTSupportedAlgs
+	if len(supportedAlgs) == 0 {
+		supportedAlgs = []string{oidc.RS256}
+	}
+
+	for _, header := range parsedJWT.Headers {
+		if !strutil.StrListContains(supportedAlgs, header.Algorithm) {
+			return fmt.Errorf("token signed with unsupported algorithm %q", header.Algorithm)
+		}
+	}
+
+	return nil
+}
+
 func (b *jwtAuthBackend) verifyOIDCToken(ctx context.Context, config *jwtConfig, role *jwtRole, rawToken string) (map[string]interface{}, error) {
 	allClaims := make(map[string]interface{})
 
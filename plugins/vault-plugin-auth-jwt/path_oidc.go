@@ -2,6 +2,9 @@ package jwtauth
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
@@ -20,6 +23,15 @@ import (
 
 var oidcStateTimeout = 10 * time.Minute
 
+// oidcStatePrefix is the storage prefix of the OAuth states. States are kept in
+// storage so the callback can be handled by any node of the cluster.
+const oidcStatePrefix = "oidc_state/"
+
+// maxOIDCStates caps the number of pending OAuth states. auth_url is
+// unauthenticated and stores a state on every call, so without a cap it could
+// be used to fill the storage.
+const maxOIDCStates = 10000
+
 // OIDC error prefixes. These are searched for specifically by the UI, so any
 // changes to them must be aligned with a UI change.
 const errLoginFailed = "Vault login failed."
@@ -29,9 +41,16 @@ const errTokenVerification = "Token verification failed."
 // oidcState is created when an authURL is requested. The state identifier is
 // passed throughout the OAuth process.
 type oidcState struct {
-	rolename    string
-	nonce       string
-	redirectURI string
+	RoleName     string    `json:"role_name"`
+	Nonce        string    `json:"nonce"`
+	RedirectURI  string    `json:"redirect_uri"`
+	CodeVerifier string    `json:"code_verifier"`
+	CreatedTime  time.Time `json:"created_time"`
+}
+
+// expired returns whether the state can no longer be used to complete a login.
+func (s *oidcState) expired(now time.Time) bool {
+	return now.Sub(s.CreatedTime) > oidcStateTimeout
 }
 
 func pathOIDC(b *jwtAuthBackend) []*framework.Path {
@@ -77,12 +96,15 @@ func pathOIDC(b *jwtAuthBackend) []*framework.Path {
 }
 
 func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	state := b.verifyState(d.Get("state").(string))
+	state, err := b.verifyState(ctx, req.Storage, d.Get("state").(string))
+	if err != nil {
+		return nil, err
+	}
 	if state == nil {
 		return logical.ErrorResponse(errLoginFailed + " Expired or missing OAuth state."), nil
 	}
 
-	roleName := state.rolename
+	roleName := state.RoleName
 	role, err := b.role(ctx, req.Storage, roleName)
 	if err != nil {
 		return nil, err
@@ -108,7 +130,7 @@ func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request,
 		return nil, errwrap.Wrapf("error getting provider for login operation: {{err}}", err)
 	}
 
-	oidcCtx, err := b.createOIDCContext(ctx, config)
+	oidcCtx, err := createCAContext(ctx, config.OIDCDiscoveryCAPEM)
 	if err != nil {
 		return nil, errwrap.Wrapf("error preparing context for login operation: {{err}}", err)
 	}
@@ -116,7 +138,7 @@ func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request,
 	var oauth2Config = oauth2.Config{
 		ClientID:     config.OIDCClientID,
 		ClientSecret: config.OIDCClientSecret,
-		RedirectURL:  state.redirectURI,
+		RedirectURL:  state.RedirectURI,
 		Endpoint:     provider.Endpoint(),
 		Scopes:       []string{oidc.ScopeOpenID},
 	}
@@ -126,7 +148,7 @@ func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request,
 		return logical.ErrorResponse(errLoginFailed + " OAuth code parameter not provided"), nil
 	}
 
-	oauth2Token, err := oauth2Config.Exchange(oidcCtx, code)
+	oauth2Token, err := oauth2Config.Exchange(oidcCtx, code, oauth2.SetAuthURLParam("code_verifier", state.CodeVerifier))
 	if err != nil {
 		return logical.ErrorResponse(errLoginFailed+" Error exchanging oidc code: %q.", err.Error()), nil
 	}
@@ -143,17 +165,20 @@ func (b *jwtAuthBackend) pathCallback(ctx context.Context, req *logical.Request,
 		return logical.ErrorResponse("%s %s", errTokenVerification, err.Error()), nil
 	}
 
-	if allClaims["nonce"] != state.nonce {
+	if allClaims["nonce"] != state.Nonce {
 		return logical.ErrorResponse(errTokenVerification + " Invalid ID token nonce."), nil
 	}
 	delete(allClaims, "nonce")
 
-	// Attempt to fetch information from the /userinfo endpoint and merge it with
-	// the existing claims data. A failure to fetch additional information from this
-	// endpoint will not invalidate the authorization flow.
-	if userinfo, err := provider.UserInfo(oidcCtx, oauth2.StaticTokenSource(oauth2Token)); err == nil {
-		_ = userinfo.Claims(&allClaims)
-	} else {
+	// Attempt to fetch the claims missing from the ID token from the /userinfo
+	// endpoint, so they can be used by the bound claims, claim mappings and
+	// groups claim. A failure only invalidates the authorization flow if the
+	// role requires the userinfo claims.
+	if err := b.fetchUserInfo(oidcCtx, provider, oauth2Token, allClaims); err != nil {
+		if role.OIDCUserInfoClaims {
+			return logical.ErrorResponse("%s %s", errTokenVerification, err.Error()), nil
+		}
+
 		logFunc := b.Logger().Warn
 		if strings.Contains(err.Error(), "user info endpoint is not supported") {
 			logFunc = b.Logger().Info
@@ -269,53 +294,177 @@ func (b *jwtAuthBackend) authURL(ctx context.Context, req *logical.Request, d *f
 		Scopes:       scopes,
 	}
 
-	stateID, nonce, err := b.createState(roleName, redirectURI)
+	state, stateID, err := b.createState(ctx, req.Storage, roleName, redirectURI)
 	if err != nil {
 		logger.Warn("error generating OAuth state", "error", err)
 		return resp, nil
 	}
 
-	resp.Data["auth_url"] = oauth2Config.AuthCodeURL(stateID, oidc.Nonce(nonce))
+	resp.Data["auth_url"] = oauth2Config.AuthCodeURL(stateID,
+		oidc.Nonce(state.Nonce),
+		oauth2.SetAuthURLParam("code_challenge", codeChallengeS256(state.CodeVerifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
 
 	return resp, nil
 }
 
+// fetchUserInfo merges the claims returned by the provider's userinfo endpoint
+// into allClaims. Claims of the verified ID token take precedence.
+func (b *jwtAuthBackend) fetchUserInfo(ctx context.Context, provider *oidc.Provider, token *oauth2.Token, allClaims map[string]interface{}) error {
+	userinfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+	if err != nil {
+		return errwrap.Wrapf("error reading /userinfo endpoint: {{err}}", err)
+	}
+
+	// Per the OIDC spec the userinfo response must be about the same subject
+	// as the ID token, otherwise it can't be trusted.
+	if userinfo.Subject != allClaims["sub"] {
+		return errors.New("sub claim of /userinfo response does not match the ID token")
+	}
+
+	userinfoClaims := make(map[string]interface{})
+	if err := userinfo.Claims(&userinfoClaims); err != nil {
+		return errwrap.Wrapf("unable to parse claims from /userinfo endpoint: {{err}}", err)
+	}
+
+	for k, v := range userinfoClaims {
+		if _, ok := allClaims[k]; !ok {
+			allClaims[k] = v
+		}
+	}
+
+	return nil
+}
+
 // createState make an expiring state object, associated with a random state ID
 // that is passed throughout the OAuth process. A nonce is also included in the
-// auth process, and for simplicity will be identical in length/format as the state ID.
-func (b *jwtAuthBackend) createState(rolename, redirectURI string) (string, string, error) {
-	// Get enough bytes for 2 160-bit IDs (per rfc6749#section-10.10)
-	bytes, err := xxuuid.GenerateRandomBytes(2 * 20)
+// auth process, and for simplicity will be identical in length/format as the
+// state ID, along with a PKCE code verifier.
+func (b *jwtAuthBackend) createState(ctx context.Context, s logical.Storage, rolename, redirectURI string) (*oidcState, string, error) {
+	// Get enough bytes for 2 160-bit IDs (per rfc6749#section-10.10) and a
+	// 256-bit code verifier, which must be at least 43 characters long (per
+	// rfc7636#section-4.1)
+	bytes, err := xxuuid.GenerateRandomBytes(2*20 + 32)
 	if err != nil {
-		return "", "", err
+		return nil, "", err
 	}
 
+	b.stateLock.Lock()
+	if b.pendingStates >= maxOIDCStates {
+		b.stateLock.Unlock()
+		return nil, "", errors.New("too many pending OAuth states")
+	}
+	b.pendingStates++
+	b.stateLock.Unlock()
+
 	stateID := fmt.Sprintf("%x", bytes[:20])
-	nonce := fmt.Sprintf("%x", bytes[20:])
+	state := &oidcState{
+		RoleName:     rolename,
+		Nonce:        fmt.Sprintf("%x", bytes[20:40]),
+		RedirectURI:  redirectURI,
+		CodeVerifier: fmt.Sprintf("%x", bytes[40:]),
+		CreatedTime:  time.Now(),
+	}
 
-	b.oidcStates.SetDefault(stateID, &oidcState{
-		rolename:    rolename,
-		nonce:       nonce,
-		redirectURI: redirectURI,
-	})
+	entry, err := logical.StorageEntryJSON(oidcStatePrefix+stateID, state)
+	if err == nil {
+		err = s.Put(ctx, entry)
+	}
+	if err != nil {
+		b.releaseState()
+		return nil, "", err
+	}
 
-	return stateID, nonce, nil
+	return state, stateID, nil
 }
 
 // verifyState tests whether the provided state ID is valid and returns the
 // associated state object if so. A nil state is returned if the ID is not found
 // or expired. The state should only ever be retrieved once and is deleted as
 // part of this request.
-func (b *jwtAuthBackend) verifyState(stateID string) *oidcState {
-	defer b.oidcStates.Delete(stateID)
+func (b *jwtAuthBackend) verifyState(ctx context.Context, s logical.Storage, stateID string) (*oidcState, error) {
+	if stateID == "" || strings.Contains(stateID, "/") {
+		return nil, nil
+	}
 
-	if stateRaw, ok := b.oidcStates.Get(stateID); ok {
-		return stateRaw.(*oidcState)
+	entry, err := s.Get(ctx, oidcStatePrefix+stateID)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	if err := s.Delete(ctx, oidcStatePrefix+stateID); err != nil {
+		return nil, err
+	}
+	b.releaseState()
+
+	state := new(oidcState)
+	if err := entry.DecodeJSON(state); err != nil {
+		return nil, err
+	}
+	if state.expired(time.Now()) {
+		return nil, nil
+	}
+
+	return state, nil
+}
+
+// tidyStates deletes the states of the logins that were never completed.
+func (b *jwtAuthBackend) tidyStates(ctx context.Context, req *logical.Request) error {
+	stateIDs, err := req.Storage.List(ctx, oidcStatePrefix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	pending := 0
+	for _, stateID := range stateIDs {
+		entry, err := req.Storage.Get(ctx, oidcStatePrefix+stateID)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			continue
+		}
+
+		state := new(oidcState)
+		if err := entry.DecodeJSON(state); err != nil || state.expired(now) {
+			if err := req.Storage.Delete(ctx, oidcStatePrefix+stateID); err != nil {
+				return err
+			}
+			continue
+		}
+		pending++
 	}
 
+	// Resync the count of pending states with the storage, which also counts
+	// the states created by other nodes or before a restart
+	b.stateLock.Lock()
+	b.pendingStates = pending
+	b.stateLock.Unlock()
+
 	return nil
 }
 
+// releaseState accounts for a pending state that was removed.
+func (b *jwtAuthBackend) releaseState() {
+	b.stateLock.Lock()
+	if b.pendingStates > 0 {
+		b.pendingStates--
+	}
+	b.stateLock.Unlock()
+}
+
+// codeChallengeS256 returns the PKCE code challenge of the verifier using the
+// S256 method.
+// Ref: https://tools.ietf.org/html/rfc7636#section-4.2
+func codeChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
 // validRedirect checks whether uri is in allowed using special handling for loopback uris.
 // Ref: https://tools.ietf.org/html/rfc8252#section-7.3
 func validRedirect(uri string, allowed []string) bool {
@@ -107,6 +107,13 @@ authenticate against this role`,
 				Type:        framework.TypeCommaStringSlice,
 				Description: `Comma-separated list of allowed values for redirect_uri`,
 			},
+			"oidc_userinfo_claims": {
+				Type: framework.TypeBool,
+				Description: `Claims missing from the ID token, such as groups, are fetched from the
+provider's userinfo endpoint during OIDC logins. If set, logins fail when the
+userinfo endpoint cannot be read, otherwise the claims are fetched on a best
+effort basis`,
+			},
 		},
 		ExistenceCheck: b.pathRoleExistenceCheck,
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -169,6 +176,7 @@ type jwtRole struct {
 	GroupsClaim         string                        `json:"groups_claim"`
 	OIDCScopes          []string                      `json:"oidc_scopes"`
 	AllowedRedirectURIs []string                      `json:"allowed_redirect_uris"`
+	OIDCUserInfoClaims  bool                          `json:"oidc_userinfo_claims"`
 }
 
 // role takes a storage backend and the name and returns the role's storage
@@ -246,6 +254,7 @@ func (b *jwtAuthBackend) pathRoleRead(ctx context.Context, req *logical.Request,
 			"groups_claim":          role.GroupsClaim,
 			"allowed_redirect_uris": role.AllowedRedirectURIs,
 			"oidc_scopes":           role.OIDCScopes,
+			"oidc_userinfo_claims":  role.OIDCUserInfoClaims,
 		},
 	}
 
@@ -391,6 +400,10 @@ func (b *jwtAuthBackend) pathRoleCreateUpdate(ctx context.Context, req *logical.
 		role.AllowedRedirectURIs = allowedRedirectURIs.([]string)
 	}
 
+	if oidcUserInfoClaims, ok := data.GetOk("oidc_userinfo_claims"); ok {
+		role.OIDCUserInfoClaims = oidcUserInfoClaims.(bool)
+	}
+
 	if role.RoleType == "oidc" && len(role.AllowedRedirectURIs) == 0 {
 		return logical.ErrorResponse(
 			"'allowed_redirect_uris' must be set if 'role_type' is 'oidc' or unspecified."), nil
@@ -68,6 +68,18 @@ type versionedKVBackend struct {
 	// upgradeCancelFunc is used to be able to shut down the upgrade checking
 	// goroutine from cleanup
 	upgradeCancelFunc context.CancelFunc
+
+	// cleaningVersions is an atomic value denoting if the periodic sweep for
+	// versions past their delete_version_after is running.
+	cleaningVersions *uint32
+
+	// lastVersionCleanup is the unix time of the last completed sweep, it is
+	// accessed atomically.
+	lastVersionCleanup *int64
+
+	// events records the changes made to keys for watchers and the events
+	// endpoint.
+	events *eventLog
 }
 
 // Factory will return a logical backend of type versionedKVBackend or
@@ -95,9 +107,12 @@ func VersionedKVFactory(ctx context.Context, conf *logical.BackendConfig) (logic
 	upgradeCtx, upgradeCancelFunc := context.WithCancel(ctx)
 
 	b := &versionedKVBackend{
-		upgrading:         new(uint32),
-		globalConfigLock:  new(sync.RWMutex),
-		upgradeCancelFunc: upgradeCancelFunc,
+		upgrading:          new(uint32),
+		globalConfigLock:   new(sync.RWMutex),
+		upgradeCancelFunc:  upgradeCancelFunc,
+		cleaningVersions:   new(uint32),
+		lastVersionCleanup: new(int64),
+		events:             newEventLog(),
 	}
 	if conf.BackendUUID == "" {
 		return nil, errors.New("could not initialize versioned K/V Store, no UUID was provided")
@@ -126,14 +141,19 @@ func VersionedKVFactory(ctx context.Context, conf *logical.BackendConfig) (logic
 				pathConfig(b),
 				pathData(b),
 				pathMetadata(b),
+				pathSubkeys(b),
+				pathEvents(b),
 				pathDestroy(b),
 			},
 			pathsDelete(b),
+			pathsExport(b),
 
 			// Make sure this stays at the end so that the valid paths are
 			// processed first.
 			pathInvalid(b),
 		),
+
+		PeriodicFunc: b.cleanupDeletedVersions,
 	}
 
 	b.locks = locksutil.CreateLocks()
@@ -159,7 +179,7 @@ func VersionedKVFactory(ctx context.Context, conf *logical.BackendConfig) (logic
 func pathInvalid(b *versionedKVBackend) []*framework.Path {
 	handler := func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 		switch req.Path {
-		case "metadata", "data", "delete", "undelete", "destroy":
+		case "metadata", "data", "subkeys", "delete", "undelete", "destroy", "export", "import":
 			resp := &logical.Response{}
 			resp.AddWarning("Non-listing operations on the root of a K/V v2 mount are not supported.")
 			return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
@@ -169,6 +189,8 @@ func pathInvalid(b *versionedKVBackend) []*framework.Path {
 		switch req.Operation {
 		case logical.CreateOperation, logical.UpdateOperation:
 			subCommand = "put"
+		case logical.PatchOperation:
+			subCommand = "patch"
 		case logical.ReadOperation:
 			subCommand = "get"
 		case logical.ListOperation:
@@ -190,6 +212,7 @@ func pathInvalid(b *versionedKVBackend) []*framework.Path {
 				logical.ReadOperation:   &framework.PathOperation{Callback: handler, Unpublished: true},
 				logical.DeleteOperation: &framework.PathOperation{Callback: handler, Unpublished: true},
 				logical.ListOperation:   &framework.PathOperation{Callback: handler, Unpublished: true},
+				logical.PatchOperation:  &framework.PathOperation{Callback: handler, Unpublished: true},
 			},
 
 			HelpDescription: pathInvalidHelp,
@@ -317,8 +340,9 @@ func (b *versionedKVBackend) config(ctx context.Context, s logical.Storage) (*Co
 	if b.globalConfig != nil {
 		defer b.globalConfigLock.RUnlock()
 		return &Configuration{
-			CasRequired: b.globalConfig.CasRequired,
-			MaxVersions: b.globalConfig.MaxVersions,
+			CasRequired:        b.globalConfig.CasRequired,
+			MaxVersions:        b.globalConfig.MaxVersions,
+			DeleteVersionAfter: b.globalConfig.DeleteVersionAfter,
 		}, nil
 	}
 
@@ -329,8 +353,9 @@ func (b *versionedKVBackend) config(ctx context.Context, s logical.Storage) (*Co
 	// Verify this hasn't already changed
 	if b.globalConfig != nil {
 		return &Configuration{
-			CasRequired: b.globalConfig.CasRequired,
-			MaxVersions: b.globalConfig.MaxVersions,
+			CasRequired:        b.globalConfig.CasRequired,
+			MaxVersions:        b.globalConfig.MaxVersions,
+			DeleteVersionAfter: b.globalConfig.DeleteVersionAfter,
 		}, nil
 	}
 
@@ -455,9 +480,21 @@ you may or may not be able to access certain paths.
     ^destroy/.*$
         Permanently removes one or more versions in the KV store
 
+    ^export/.*$
+        Export the metadata and all versions of a key in the KV store.
+
+    ^import/.*$
+        Import a key previously exported from a KV store.
+
+    ^events/.*$
+        Long-poll the changes made to the keys under a prefix.
+
     ^metadata/.*$
         Configures settings for the KV store
 
+    ^subkeys/.*$
+        Read the structure of a secret without its values.
+
     ^undelete/.*$
         Undeletes one or more versions from the KV store.
 `
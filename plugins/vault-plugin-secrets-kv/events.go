@@ -0,0 +1,149 @@
+package kv
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// eventLogSize is the minimum number of change events kept in memory for
+	// the events endpoint. Clients that fall further behind are told to
+	// resync.
+	eventLogSize = 4096
+
+	// defaultWatchWait is the time a watch or events request blocks when no
+	// wait is provided.
+	defaultWatchWait = 10 * time.Second
+
+	// maxWatchWait caps the time a watch or events request blocks. Requests
+	// hold the core's state lock until they return, they return early when
+	// the node starts to seal or step down. The agent watches with the same
+	// wait, see kvWatchWait in command/agent/cache.
+	maxWatchWait = 15 * time.Second
+)
+
+const (
+	eventTypeWrite          = "write"
+	eventTypeDelete         = "delete"
+	eventTypeUndelete       = "undelete"
+	eventTypeDestroy        = "destroy"
+	eventTypeMetadataDelete = "metadata_delete"
+)
+
+// kvEvent is a single change made to a key.
+type kvEvent struct {
+	Index   uint64
+	Key     string
+	Type    string
+	Version uint64
+	Time    time.Time
+}
+
+// eventLog keeps the most recent changes made through this backend and lets
+// watchers block until the next one happens. It is not persisted, indexes
+// restart from zero when the mount is loaded.
+type eventLog struct {
+	l sync.Mutex
+
+	// index is the index of the last published event.
+	index  uint64
+	events []*kvEvent
+
+	// changeCh is closed and replaced every time an event is published.
+	changeCh chan struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		changeCh: make(chan struct{}),
+	}
+}
+
+// publish records a change to the key and wakes up all watchers.
+func (e *eventLog) publish(key, eventType string, version uint64) {
+	e.l.Lock()
+	defer e.l.Unlock()
+
+	e.index++
+	e.events = append(e.events, &kvEvent{
+		Index:   e.index,
+		Key:     key,
+		Type:    eventType,
+		Version: version,
+		Time:    time.Now(),
+	})
+	// Trim in batches so the slice is not copied on every publish
+	if len(e.events) >= 2*eventLogSize {
+		e.events = append(e.events[:0:0], e.events[len(e.events)-eventLogSize:]...)
+	}
+
+	close(e.changeCh)
+	e.changeCh = make(chan struct{})
+}
+
+// since returns the events published after index that match the filter, along
+// with the current index and a channel that is closed on the next publish.
+// reset is true if events after index are no longer available, or if index is
+// ahead of the log, e.g. because the mount was reloaded.
+func (e *eventLog) since(index uint64, match func(*kvEvent) bool) (events []*kvEvent, current uint64, reset bool, changeCh <-chan struct{}) {
+	e.l.Lock()
+	defer e.l.Unlock()
+
+	current, changeCh = e.index, e.changeCh
+
+	if index > e.index {
+		return nil, current, true, changeCh
+	}
+	if len(e.events) > 0 && index+1 < e.events[0].Index {
+		reset = true
+	}
+
+	i := sort.Search(len(e.events), func(i int) bool {
+		return e.events[i].Index > index
+	})
+	for _, event := range e.events[i:] {
+		if match == nil || match(event) {
+			events = append(events, event)
+		}
+	}
+
+	return events, current, reset, changeCh
+}
+
+// currentIndex returns the index of the last published event.
+func (e *eventLog) currentIndex() uint64 {
+	e.l.Lock()
+	defer e.l.Unlock()
+
+	return e.index
+}
+
+// keyMatcher returns a filter for the events of a single key.
+func keyMatcher(key string) func(*kvEvent) bool {
+	return func(event *kvEvent) bool {
+		return event.Key == key
+	}
+}
+
+// prefixMatcher returns a filter for the events of the keys under prefix.
+func prefixMatcher(prefix string) func(*kvEvent) bool {
+	return func(event *kvEvent) bool {
+		return strings.HasPrefix(event.Key, prefix)
+	}
+}
+
+// watchWait returns the time a request may block given the requested wait in
+// seconds.
+func watchWait(seconds int) time.Duration {
+	wait := time.Duration(seconds) * time.Second
+	switch {
+	case wait <= 0:
+		return defaultWatchWait
+	case wait > maxWatchWait:
+		return maxWatchWait
+	}
+
+	return wait
+}
@@ -0,0 +1,203 @@
+package kv
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/golang/protobuf/ptypes/timestamp"
+
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	"github.com/jiangjiali/vault/sdk/helper/locksutil"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+// versionCleanupInterval is the minimum time between two sweeps of the
+// metadata looking for versions past their delete_version_after.
+const versionCleanupInterval = 10 * time.Minute
+
+// durationToProto converts the number of seconds provided through the API to
+// a protobuf duration. A value of zero or less disables the setting.
+func durationToProto(seconds int) *duration.Duration {
+	if seconds <= 0 {
+		return nil
+	}
+
+	return ptypes.DurationProto(time.Duration(seconds) * time.Second)
+}
+
+// ptypesDurationToString returns the duration in the Go duration format, or an
+// empty string if it is not set.
+func ptypesDurationToString(d *duration.Duration) string {
+	if d == nil {
+		return ""
+	}
+
+	dur, err := ptypes.Duration(d)
+	if err != nil {
+		return ""
+	}
+
+	return dur.String()
+}
+
+// deleteVersionAfter returns the effective time after which versions of the
+// key are automatically deleted. The key's own setting is used unless the
+// backend configuration mandates a shorter one. Zero means versions are never
+// deleted automatically.
+func deleteVersionAfter(meta *KeyMetadata, config *Configuration) time.Duration {
+	var mdur, cdur time.Duration
+	if meta != nil && meta.DeleteVersionAfter != nil {
+		mdur, _ = ptypes.Duration(meta.DeleteVersionAfter)
+	}
+	if config != nil && config.DeleteVersionAfter != nil {
+		cdur, _ = ptypes.Duration(config.DeleteVersionAfter)
+	}
+
+	switch {
+	case mdur > 0 && cdur > 0 && cdur < mdur:
+		return cdur
+	case mdur > 0:
+		return mdur
+	case cdur > 0:
+		return cdur
+	}
+
+	return 0
+}
+
+// versionDeletionTime returns the time the version becomes invalid. Versions
+// that were not given a deletion time when written, e.g. because
+// delete_version_after was set afterwards, expire relative to their creation
+// time. A nil value means the version does not expire.
+func versionDeletionTime(vm *VersionMetadata, dva time.Duration) (*timestamp.Timestamp, error) {
+	if vm.DeletionTime != nil || dva <= 0 || vm.CreatedTime == nil {
+		return vm.DeletionTime, nil
+	}
+
+	createdTime, err := ptypes.Timestamp(vm.CreatedTime)
+	if err != nil {
+		return nil, err
+	}
+
+	return ptypes.TimestampProto(createdTime.Add(dva))
+}
+
+// versionDeleted returns true if the version's deletion time has passed.
+func versionDeleted(vm *VersionMetadata, dva time.Duration) (bool, error) {
+	ts, err := versionDeletionTime(vm, dva)
+	if err != nil {
+		return false, err
+	}
+	if ts == nil {
+		return false, nil
+	}
+
+	deletionTime, err := ptypes.Timestamp(ts)
+	if err != nil {
+		return false, err
+	}
+
+	return deletionTime.Before(time.Now()), nil
+}
+
+// cleanupDeletedVersions is the backend's periodic function. It walks every
+// key and records the deletion time of versions that expired through
+// delete_version_after, so the soft delete is visible in the key metadata even
+// for versions that are never read.
+func (b *versionedKVBackend) cleanupDeletedVersions(ctx context.Context, req *logical.Request) error {
+	if atomic.LoadUint32(b.upgrading) == 1 || b.perfSecondaryCheck() {
+		return nil
+	}
+
+	if time.Since(time.Unix(atomic.LoadInt64(b.lastVersionCleanup), 0)) < versionCleanupInterval {
+		return nil
+	}
+
+	if !atomic.CompareAndSwapUint32(b.cleaningVersions, 0, 1) {
+		return nil
+	}
+	defer atomic.StoreUint32(b.cleaningVersions, 0)
+
+	config, err := b.config(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+
+	wrapper, err := b.getKeyEncryptor(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	err = logical.ScanView(ctx, wrapper.Wrap(req.Storage), func(key string) {
+		keys = append(keys, key)
+	})
+	if err != nil {
+		return errwrap.Wrapf("failed to list keys for version cleanup: {{err}}", err)
+	}
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err := b.cleanupKeyVersions(ctx, req.Storage, config, key); err != nil {
+			b.Logger().Error("failed to clean up deleted versions", "key", key, "error", err)
+		}
+	}
+
+	atomic.StoreInt64(b.lastVersionCleanup, time.Now().Unix())
+
+	return nil
+}
+
+// cleanupKeyVersions stamps the deletion time on the expired versions of a
+// single key.
+func (b *versionedKVBackend) cleanupKeyVersions(ctx context.Context, s logical.Storage, config *Configuration, key string) error {
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		return nil
+	}
+
+	dva := deleteVersionAfter(meta, config)
+	if dva <= 0 {
+		return nil
+	}
+
+	var modified bool
+	for _, vm := range meta.Versions {
+		if vm.DeletionTime != nil || vm.Destroyed {
+			continue
+		}
+
+		deleted, err := versionDeleted(vm, dva)
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			continue
+		}
+
+		vm.DeletionTime, err = versionDeletionTime(vm, dva)
+		if err != nil {
+			return err
+		}
+		modified = true
+	}
+
+	if !modified {
+		return nil
+	}
+
+	return b.writeKeyMetadata(ctx, s, meta)
+}
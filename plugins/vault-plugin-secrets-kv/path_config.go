@@ -24,6 +24,10 @@ func pathConfig(b *versionedKVBackend) *framework.Path {
 				Type:        framework.TypeBool,
 				Description: "If true, the backend will require the cas parameter to be set for each write",
 			},
+			"delete_version_after": {
+				Type:        framework.TypeDurationSecond,
+				Description: "If set, the length of time before a version is deleted. A zero duration disables automatic deletion",
+			},
 		},
 		Operations: map[logical.Operation]framework.OperationHandler{
 			logical.UpdateOperation: &framework.PathOperation{
@@ -54,8 +58,9 @@ func (b *versionedKVBackend) pathConfigRead() framework.OperationFunc {
 
 		return &logical.Response{
 			Data: map[string]interface{}{
-				"max_versions": config.MaxVersions,
-				"cas_required": config.CasRequired,
+				"max_versions":         config.MaxVersions,
+				"cas_required":         config.CasRequired,
+				"delete_version_after": ptypesDurationToString(config.DeleteVersionAfter),
 			},
 		}, nil
 	}
@@ -66,9 +71,10 @@ func (b *versionedKVBackend) pathConfigWrite() framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 		maxRaw, mOk := data.GetOk("max_versions")
 		casRaw, cOk := data.GetOk("cas_required")
+		dvaRaw, dOk := data.GetOk("delete_version_after")
 
 		// Fast path validation
-		if !mOk && !cOk {
+		if !mOk && !cOk && !dOk {
 			return nil, nil
 		}
 
@@ -83,6 +89,9 @@ func (b *versionedKVBackend) pathConfigWrite() framework.OperationFunc {
 		if cOk {
 			config.CasRequired = casRaw.(bool)
 		}
+		if dOk {
+			config.DeleteVersionAfter = durationToProto(dvaRaw.(int))
+		}
 
 		bytes, err := proto.Marshal(config)
 		if err != nil {
@@ -116,4 +125,7 @@ key-value store. This parameter accetps:
     
 	* cas_required (bool) - If true, the backend will require the cas parameter
 	  to be set for each write
+
+	* delete_version_after (duration) - If set, versions are automatically
+	  deleted after this length of time
 `
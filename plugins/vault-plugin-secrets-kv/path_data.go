@@ -6,13 +6,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/timestamp"
 
 	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/jsonutil"
 	"github.com/jiangjiali/vault/sdk/helper/locksutil"
 	"github.com/jiangjiali/vault/sdk/helper/mitchellh/mapstructure"
 	"github.com/jiangjiali/vault/sdk/logical"
@@ -45,12 +45,25 @@ version matches the version specified in the cas parameter.`,
 				Type:        framework.TypeMap,
 				Description: "The contents of the data map will be stored and returned on read.",
 			},
+			"watch": {
+				Type:        framework.TypeBool,
+				Description: "If set during a read, block until the current version is no longer \"index\" or the key changes.",
+			},
+			"index": {
+				Type:        framework.TypeInt,
+				Description: "The version last seen by the client when watching.",
+			},
+			"wait": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Maximum time to block when watching. Defaults to 10s, capped at 15s.",
+			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.UpdateOperation: b.upgradeCheck(b.pathDataWrite()),
 			logical.CreateOperation: b.upgradeCheck(b.pathDataWrite()),
 			logical.ReadOperation:   b.upgradeCheck(b.pathDataRead()),
 			logical.DeleteOperation: b.upgradeCheck(b.pathDataDelete()),
+			logical.PatchOperation:  b.upgradeCheck(b.pathDataPatch()),
 		},
 
 		ExistenceCheck: b.dataExistenceCheck(),
@@ -78,6 +91,16 @@ func (b *versionedKVBackend) pathDataRead() framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
 		key := data.Get("path").(string)
 
+		// A watch blocks until the key changes before reading it. This must
+		// happen before taking the lock so writers are not held up.
+		if data.Get("watch").(bool) {
+			index := uint64(data.Get("index").(int))
+			wait := watchWait(data.Get("wait").(int))
+			if err := b.waitForKeyChange(ctx, req.Storage, key, index, wait); err != nil {
+				return nil, err
+			}
+		}
+
 		lock := locksutil.LockForKey(b.locks, key)
 		lock.RLock()
 		defer lock.RUnlock()
@@ -102,29 +125,36 @@ func (b *versionedKVBackend) pathDataRead() framework.OperationFunc {
 			return nil, nil
 		}
 
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		dva := deleteVersionAfter(meta, config)
+
+		deletionTime, err := versionDeletionTime(vm, dva)
+		if err != nil {
+			return nil, err
+		}
+
 		resp := &logical.Response{
 			Data: map[string]interface{}{
 				"data": nil,
 				"metadata": map[string]interface{}{
 					"version":       verNum,
 					"created_time":  ptypesTimestampToString(vm.CreatedTime),
-					"deletion_time": ptypesTimestampToString(vm.DeletionTime),
+					"deletion_time": ptypesTimestampToString(deletionTime),
 					"destroyed":     vm.Destroyed,
 				},
 			},
 		}
 
 		// If the version has been deleted return metadata with a 404
-		if vm.DeletionTime != nil {
-			deletionTime, err := ptypes.Timestamp(vm.DeletionTime)
-			if err != nil {
-				return nil, err
-			}
-
-			if deletionTime.Before(time.Now()) {
-				return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
-
-			}
+		deleted, err := versionDeleted(vm, dva)
+		if err != nil {
+			return nil, err
+		}
+		if deleted {
+			return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
 		}
 
 		// If the version has been destroyed return metadata with a 404
@@ -133,33 +163,43 @@ func (b *versionedKVBackend) pathDataRead() framework.OperationFunc {
 
 		}
 
-		versionKey, err := b.getVersionKey(ctx, key, verNum, req.Storage)
+		vData, err := b.getVersionData(ctx, req.Storage, key, verNum)
 		if err != nil {
 			return nil, err
 		}
 
-		raw, err := req.Storage.Get(ctx, versionKey)
-		if err != nil {
-			return nil, err
-		}
-		if raw == nil {
-			return nil, errors.New("could not find version data")
-		}
+		resp.Data["data"] = vData
 
-		version := &Version{}
-		if err := proto.Unmarshal(raw.Value, version); err != nil {
-			return nil, err
-		}
+		return resp, nil
+	}
+}
 
-		vData := map[string]interface{}{}
-		if err := json.Unmarshal(version.Data, &vData); err != nil {
-			return nil, err
-		}
+// getVersionData loads and decodes the data stored for a version of the key.
+func (b *versionedKVBackend) getVersionData(ctx context.Context, s logical.Storage, key string, verNum uint64) (map[string]interface{}, error) {
+	versionKey, err := b.getVersionKey(ctx, key, verNum, s)
+	if err != nil {
+		return nil, err
+	}
 
-		resp.Data["data"] = vData
+	raw, err := s.Get(ctx, versionKey)
+	if err != nil {
+		return nil, err
+	}
+	if raw == nil {
+		return nil, errors.New("could not find version data")
+	}
 
-		return resp, nil
+	version := &Version{}
+	if err := proto.Unmarshal(raw.Value, version); err != nil {
+		return nil, err
+	}
+
+	vData := map[string]interface{}{}
+	if err := json.Unmarshal(version.Data, &vData); err != nil {
+		return nil, err
 	}
+
+	return vData, nil
 }
 
 // pathDataWrite handles create and update commands to a kv entry
@@ -204,118 +244,229 @@ func (b *versionedKVBackend) pathDataWrite() framework.OperationFunc {
 			}
 		}
 
-		// Parse options
-		{
-			var casRaw interface{}
-			var casOk bool
-			optionsRaw, ok := data.GetOk("options")
-			if ok {
-				options := optionsRaw.(map[string]interface{})
-
-				// Verify the CAS parameter is valid.
-				casRaw, casOk = options["cas"]
-			}
+		if resp := checkCAS(data, meta, config); resp != nil {
+			return resp, logical.ErrInvalidRequest
+		}
 
-			switch {
-			case casOk:
-				var cas int
-				if err := mapstructure.WeakDecode(casRaw, &cas); err != nil {
-					return logical.ErrorResponse("error parsing check-and-set parameter"), logical.ErrInvalidRequest
-				}
-				if uint64(cas) != meta.CurrentVersion {
-					return logical.ErrorResponse("check-and-set parameter did not match the current version"), logical.ErrInvalidRequest
-				}
-			case config.CasRequired, meta.CasRequired:
-				return logical.ErrorResponse("check-and-set parameter required for this call"), logical.ErrInvalidRequest
-			}
+		return b.writeVersion(ctx, req.Storage, meta, config, marshaledData)
+	}
+}
+
+// pathDataPatch handles patch commands to a kv entry. The data provided is
+// applied as a JSON merge patch to the latest version and the result is
+// written as a new version.
+func (b *versionedKVBackend) pathDataPatch() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		key := data.Get("path").(string)
+		if key == "" {
+			return logical.ErrorResponse("missing path"), nil
+		}
+
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parse the patch before the lock so we can fail early if not set.
+		dataRaw, ok := data.GetOk("data")
+		if !ok {
+			return logical.ErrorResponse("no data provided"), logical.ErrInvalidRequest
 		}
+		patch := dataRaw.(map[string]interface{})
+
+		lock := locksutil.LockForKey(b.locks, key)
+		lock.Lock()
+		defer lock.Unlock()
 
-		// Create a version key for the new version
-		versionKey, err := b.getVersionKey(ctx, key, meta.CurrentVersion+1, req.Storage)
+		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
 		if err != nil {
 			return nil, err
 		}
-		version := &Version{
-			Data:        marshaledData,
-			CreatedTime: ptypes.TimestampNow(),
+
+		// Patching requires an existing version to apply the patch to
+		if meta == nil {
+			return logical.RespondWithStatusCode(nil, req, http.StatusNotFound)
+		}
+
+		if resp := checkCAS(data, meta, config); resp != nil {
+			return resp, logical.ErrInvalidRequest
 		}
 
-		buf, err := proto.Marshal(version)
+		vm := meta.Versions[meta.CurrentVersion]
+		if vm == nil {
+			return logical.RespondWithStatusCode(nil, req, http.StatusNotFound)
+		}
+
+		dva := deleteVersionAfter(meta, config)
+
+		deletionTime, err := versionDeletionTime(vm, dva)
 		if err != nil {
 			return nil, err
 		}
 
-		// Write the new version
-		if err := req.Storage.Put(ctx, &logical.StorageEntry{
-			Key:   versionKey,
-			Value: buf,
-		}); err != nil {
+		// If the latest version has been deleted or destroyed return its
+		// metadata with a 404
+		deleted, err := versionDeleted(vm, dva)
+		if err != nil {
 			return nil, err
 		}
+		if deleted || vm.Destroyed {
+			resp := &logical.Response{
+				Data: map[string]interface{}{
+					"version":       meta.CurrentVersion,
+					"created_time":  ptypesTimestampToString(vm.CreatedTime),
+					"deletion_time": ptypesTimestampToString(deletionTime),
+					"destroyed":     vm.Destroyed,
+				},
+			}
+			return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
+		}
 
-		vm, versionToDelete := meta.AddVersion(version.CreatedTime, nil, config.MaxVersions)
-		err = b.writeKeyMetadata(ctx, req.Storage, meta)
+		current, err := b.getVersionData(ctx, req.Storage, key, meta.CurrentVersion)
 		if err != nil {
 			return nil, err
 		}
 
-		// We create the response here so we can add warnings to it below.
-		resp := &logical.Response{
-			Data: map[string]interface{}{
-				"version":       meta.CurrentVersion,
-				"created_time":  ptypesTimestampToString(vm.CreatedTime),
-				"deletion_time": ptypesTimestampToString(vm.DeletionTime),
-				"destroyed":     vm.Destroyed,
-			},
+		marshaledData, err := json.Marshal(jsonutil.MergePatch(current, patch))
+		if err != nil {
+			return nil, err
 		}
 
-		// Cleanup the version data that is past max version.
-		if versionToDelete > 0 {
-
-			// Create a list of version keys to delete. We will delete from the
-			// back of the array so we can delete the oldest versions
-			// first. If there is an error deleting one of the keys we can
-			// ensure the rest will be deleted on the next go around.
-			var versionKeysToDelete []string
-
-			for i := versionToDelete; i > 0; i-- {
-				versionKey, err := b.getVersionKey(ctx, key, i, req.Storage)
-				if err != nil {
-					resp.AddWarning(fmt.Sprintf("Error occured when cleaning up old versions, these will be cleaned up on next write: %s", err))
-					return resp, nil
-				}
-
-				// We intentionally do not return these errors here. If the get
-				// or delete fail they will be cleaned up on the next write.
-				v, err := req.Storage.Get(ctx, versionKey)
-				if err != nil {
-					resp.AddWarning(fmt.Sprintf("Error occured when cleaning up old versions, these will be cleaned up on next write: %s", err))
-					return resp, nil
-				}
-
-				if v == nil {
-					break
-				}
-
-				// append to the end of the list
-				versionKeysToDelete = append(versionKeysToDelete, versionKey)
+		return b.writeVersion(ctx, req.Storage, meta, config, marshaledData)
+	}
+}
+
+// checkCAS verifies the check-and-set option of a write request against the
+// current version of the key. It returns an error response if the write must
+// be rejected.
+func checkCAS(data *framework.FieldData, meta *KeyMetadata, config *Configuration) *logical.Response {
+	var casRaw interface{}
+	var casOk bool
+	optionsRaw, ok := data.GetOk("options")
+	if ok {
+		options := optionsRaw.(map[string]interface{})
+
+		// Verify the CAS parameter is valid.
+		casRaw, casOk = options["cas"]
+	}
+
+	switch {
+	case casOk:
+		var cas int
+		if err := mapstructure.WeakDecode(casRaw, &cas); err != nil {
+			return logical.ErrorResponse("error parsing check-and-set parameter")
+		}
+		if uint64(cas) != meta.CurrentVersion {
+			return logical.ErrorResponse("check-and-set parameter did not match the current version")
+		}
+	case config.CasRequired, meta.CasRequired:
+		return logical.ErrorResponse("check-and-set parameter required for this call")
+	}
+
+	return nil
+}
+
+// writeVersion stores data as a new version of the key and updates its
+// metadata, cleaning up the versions that fall outside of max_versions. The
+// caller must hold the lock for the key.
+func (b *versionedKVBackend) writeVersion(ctx context.Context, s logical.Storage, meta *KeyMetadata, config *Configuration, marshaledData []byte) (*logical.Response, error) {
+	// Create a version key for the new version
+	versionKey, err := b.getVersionKey(ctx, meta.Key, meta.CurrentVersion+1, s)
+	if err != nil {
+		return nil, err
+	}
+	version := &Version{
+		Data:        marshaledData,
+		CreatedTime: ptypes.TimestampNow(),
+	}
+
+	buf, err := proto.Marshal(version)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the new version
+	if err := s.Put(ctx, &logical.StorageEntry{
+		Key:   versionKey,
+		Value: buf,
+	}); err != nil {
+		return nil, err
+	}
+
+	// Versions written while delete_version_after is in effect carry
+	// their deletion time from the start.
+	var deletionTime *timestamp.Timestamp
+	if dva := deleteVersionAfter(meta, config); dva > 0 {
+		deletionTime, err = versionDeletionTime(&VersionMetadata{CreatedTime: version.CreatedTime}, dva)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vm, versionToDelete := meta.AddVersion(version.CreatedTime, deletionTime, config.MaxVersions)
+	err = b.writeKeyMetadata(ctx, s, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	b.events.publish(meta.Key, eventTypeWrite, meta.CurrentVersion)
+
+	// We create the response here so we can add warnings to it below.
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"version":       meta.CurrentVersion,
+			"created_time":  ptypesTimestampToString(vm.CreatedTime),
+			"deletion_time": ptypesTimestampToString(vm.DeletionTime),
+			"destroyed":     vm.Destroyed,
+		},
+	}
+
+	// Cleanup the version data that is past max version.
+	if versionToDelete > 0 {
+
+		// Create a list of version keys to delete. We will delete from the
+		// back of the array so we can delete the oldest versions
+		// first. If there is an error deleting one of the keys we can
+		// ensure the rest will be deleted on the next go around.
+		var versionKeysToDelete []string
+
+		for i := versionToDelete; i > 0; i-- {
+			versionKey, err := b.getVersionKey(ctx, meta.Key, i, s)
+			if err != nil {
+				resp.AddWarning(fmt.Sprintf("Error occured when cleaning up old versions, these will be cleaned up on next write: %s", err))
+				return resp, nil
 			}
 
-			// Walk the list backwards deleting the oldest versions first. This
-			// allows us to continue the cleanup on next write if an error
-			// occurs during one of the deletes.
-			for i := len(versionKeysToDelete) - 1; i >= 0; i-- {
-				err := req.Storage.Delete(ctx, versionKeysToDelete[i])
-				if err != nil {
-					resp.AddWarning(fmt.Sprintf("Error occured when cleaning up old versions, these will be cleaned up on next write: %s", err))
-					break
-				}
+			// We intentionally do not return these errors here. If the get
+			// or delete fail they will be cleaned up on the next write.
+			v, err := s.Get(ctx, versionKey)
+			if err != nil {
+				resp.AddWarning(fmt.Sprintf("Error occured when cleaning up old versions, these will be cleaned up on next write: %s", err))
+				return resp, nil
+			}
+
+			if v == nil {
+				break
 			}
 
+			// append to the end of the list
+			versionKeysToDelete = append(versionKeysToDelete, versionKey)
+		}
+
+		// Walk the list backwards deleting the oldest versions first. This
+		// allows us to continue the cleanup on next write if an error
+		// occurs during one of the deletes.
+		for i := len(versionKeysToDelete) - 1; i >= 0; i-- {
+			err := s.Delete(ctx, versionKeysToDelete[i])
+			if err != nil {
+				resp.AddWarning(fmt.Sprintf("Error occured when cleaning up old versions, these will be cleaned up on next write: %s", err))
+				break
+			}
 		}
 
-		return resp, nil
 	}
+
+	return resp, nil
 }
 
 func (b *versionedKVBackend) pathDataDelete() framework.OperationFunc {
@@ -341,15 +492,17 @@ func (b *versionedKVBackend) pathDataDelete() framework.OperationFunc {
 			return nil, nil
 		}
 
-		if lv.DeletionTime != nil {
-			deletionTime, err := ptypes.Timestamp(lv.DeletionTime)
-			if err != nil {
-				return nil, err
-			}
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
 
-			if deletionTime.Before(time.Now()) {
-				return nil, nil
-			}
+		deleted, err := versionDeleted(lv, deleteVersionAfter(meta, config))
+		if err != nil {
+			return nil, err
+		}
+		if deleted {
+			return nil, nil
 		}
 
 		lv.DeletionTime = ptypes.TimestampNow()
@@ -359,6 +512,8 @@ func (b *versionedKVBackend) pathDataDelete() framework.OperationFunc {
 			return nil, err
 		}
 
+		b.events.publish(key, eventTypeDelete, meta.CurrentVersion)
+
 		return nil, nil
 	}
 }
@@ -427,7 +582,18 @@ operation for a key creates a new version and does not overwrite the previous
 data.
 
 A read operation will return the latest version for a key unless the "version"
-parameter is set, then it returns the version at that number.
+parameter is set, then it returns the version at that number. Versions older
+than the configured "delete_version_after" are treated as deleted.
+
+A read with "watch" set to true long-polls the key: it blocks until the current
+version is no longer the one given in "index", or the key is written, deleted,
+undeleted or destroyed, or "wait" elapses, and then returns the key as a normal
+read would. The version in the returned metadata is used as the next index.
+
+A patch operation applies the data object as a JSON merge patch (RFC 7396) to
+the latest version and stores the result as a new version. Keys set to null in
+the patch are removed. The key must already exist and the "cas" option is
+honored the same way as for writes.
 
 Delete operations are a soft delete. They will mark the latest version as
 deleted, but the underlying data will not be fully removed. Delete operations
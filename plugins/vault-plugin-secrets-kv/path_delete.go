@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
 
 	"github.com/jiangjiali/vault/sdk/framework"
 	"github.com/jiangjiali/vault/sdk/helper/locksutil"
@@ -79,6 +80,22 @@ func (b *versionedKVBackend) pathUndeleteWrite() framework.OperationFunc {
 			return nil, nil
 		}
 
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		// Undeleted versions get a fresh window if versions of this key are
+		// deleted automatically, otherwise they would expire again right away.
+		var deletionTime *timestamp.Timestamp
+		if dva := deleteVersionAfter(meta, config); dva > 0 {
+			deletionTime, err = ptypes.TimestampProto(time.Now().Add(dva))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		var undeleted []uint64
 		for _, verNum := range versions {
 			// If there is no version or the version is destroyed continue
 			lv := meta.Versions[uint64(verNum)]
@@ -86,13 +103,18 @@ func (b *versionedKVBackend) pathUndeleteWrite() framework.OperationFunc {
 				continue
 			}
 
-			lv.DeletionTime = nil
+			lv.DeletionTime = deletionTime
+			undeleted = append(undeleted, uint64(verNum))
 		}
 		err = b.writeKeyMetadata(ctx, req.Storage, meta)
 		if err != nil {
 			return nil, err
 		}
 
+		for _, verNum := range undeleted {
+			b.events.publish(key, eventTypeUndelete, verNum)
+		}
+
 		return nil, nil
 	}
 }
@@ -119,6 +141,13 @@ func (b *versionedKVBackend) pathDeleteWrite() framework.OperationFunc {
 			return nil, nil
 		}
 
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		dva := deleteVersionAfter(meta, config)
+
+		var deletedVersions []uint64
 		for _, verNum := range versions {
 			// If there is no latest version, or the latest version is already
 			// deleted or destroyed continue
@@ -127,18 +156,16 @@ func (b *versionedKVBackend) pathDeleteWrite() framework.OperationFunc {
 				continue
 			}
 
-			if lv.DeletionTime != nil {
-				deletionTime, err := ptypes.Timestamp(lv.DeletionTime)
-				if err != nil {
-					return nil, err
-				}
-
-				if deletionTime.Before(time.Now()) {
-					continue
-				}
+			deleted, err := versionDeleted(lv, dva)
+			if err != nil {
+				return nil, err
+			}
+			if deleted {
+				continue
 			}
 
 			lv.DeletionTime = ptypes.TimestampNow()
+			deletedVersions = append(deletedVersions, uint64(verNum))
 		}
 
 		err = b.writeKeyMetadata(ctx, req.Storage, meta)
@@ -146,6 +173,10 @@ func (b *versionedKVBackend) pathDeleteWrite() framework.OperationFunc {
 			return nil, err
 		}
 
+		for _, verNum := range deletedVersions {
+			b.events.publish(key, eventTypeDelete, verNum)
+		}
+
 		return nil, nil
 	}
 }
@@ -160,5 +191,7 @@ longer be returned in normal get requests. This operation can be undone.
 const undeleteHelpSyn = `Undeletes one or more versions from the KV store.`
 const undeleteHelpDesc = `
 Undeletes the data for the provided version and path in the key-value store.
-This restores the data, allowing it to be returned on get requests.
+This restores the data, allowing it to be returned on get requests. If the key
+has a "delete_version_after" set, the restored versions are kept for that
+duration from the time they are undeleted.
 `
@@ -53,6 +53,7 @@ func (b *versionedKVBackend) pathDestroyWrite() framework.OperationFunc {
 			return nil, nil
 		}
 
+		var destroyed []uint64
 		for _, verNum := range versions {
 			// If there is no version, or the version is already destroyed,
 			// continue
@@ -62,6 +63,7 @@ func (b *versionedKVBackend) pathDestroyWrite() framework.OperationFunc {
 			}
 
 			lv.Destroyed = true
+			destroyed = append(destroyed, uint64(verNum))
 		}
 
 		// Write the metadata key before deleting the versions
@@ -83,6 +85,10 @@ func (b *versionedKVBackend) pathDestroyWrite() framework.OperationFunc {
 			}
 		}
 
+		for _, verNum := range destroyed {
+			b.events.publish(key, eventTypeDestroy, verNum)
+		}
+
 		return nil, nil
 	}
 }
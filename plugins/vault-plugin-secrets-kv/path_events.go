@@ -0,0 +1,179 @@
+package kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+
+	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/locksutil"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+// pathEvents returns the path configuration for long-polling the changes made
+// to the keys under a prefix.
+func pathEvents(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "events(/" + framework.MatchAllRegex("path") + ")?",
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Prefix of the keys to return events for.",
+			},
+			"index": {
+				Type:        framework.TypeInt,
+				Description: "Return the events after this index, blocking until one is available.",
+			},
+			"wait": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Maximum time to block for new events. Defaults to 10s, capped at 15s.",
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathEventsRead()),
+		},
+
+		HelpSynopsis:    eventsHelpSyn,
+		HelpDescription: eventsHelpDesc,
+	}
+}
+
+// pathEventsRead returns the events for the keys under the prefix that were
+// published after the provided index.
+func (b *versionedKVBackend) pathEventsRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		prefix := data.Get("path").(string)
+		index := uint64(data.Get("index").(int))
+
+		timer := time.NewTimer(watchWait(data.Get("wait").(int)))
+		defer timer.Stop()
+
+		for {
+			events, current, reset, changeCh := b.events.since(index, prefixMatcher(prefix))
+
+			// An index of zero is used to learn the current index without
+			// receiving the buffered history.
+			if index == 0 {
+				events = nil
+			}
+
+			if index == 0 || reset || len(events) > 0 {
+				return eventsResponse(events, current, reset), nil
+			}
+
+			select {
+			case <-changeCh:
+			case <-timer.C:
+				return eventsResponse(nil, current, false), nil
+			case <-logical.DrainChFromContext(ctx):
+				return eventsResponse(nil, current, false), nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+}
+
+func eventsResponse(events []*kvEvent, current uint64, reset bool) *logical.Response {
+	list := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		list = append(list, map[string]interface{}{
+			"index":   event.Index,
+			"path":    event.Key,
+			"type":    event.Type,
+			"version": event.Version,
+			"time":    event.Time.UTC().Format(time.RFC3339Nano),
+		})
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"events": list,
+			"index":  current,
+			"reset":  reset,
+		},
+	}
+}
+
+// waitForKeyChange blocks until the current version of the key is no longer
+// index, the key is changed through this backend, wait elapses or the node
+// starts to seal or step down.
+func (b *versionedKVBackend) waitForKeyChange(ctx context.Context, s logical.Storage, key string, index uint64, wait time.Duration) error {
+	// Take the event index before looking at the key so no change between
+	// the two is missed.
+	eventIndex := b.events.currentIndex()
+
+	lock := locksutil.LockForKey(b.locks, key)
+	lock.RLock()
+	meta, err := b.getKeyMetadata(ctx, s, key)
+	lock.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	var current uint64
+	if meta != nil {
+		current = meta.CurrentVersion
+	}
+	if current != index {
+		return nil
+	}
+
+	// Wake up when the current version expires through delete_version_after,
+	// since that changes what a read returns without any write.
+	if vm := meta.GetVersions()[current]; vm != nil && !vm.Destroyed {
+		config, err := b.config(ctx, s)
+		if err != nil {
+			return err
+		}
+
+		deletionTime, err := versionDeletionTime(vm, deleteVersionAfter(meta, config))
+		if err != nil {
+			return err
+		}
+		if deletionTime != nil {
+			if t, err := ptypes.Timestamp(deletionTime); err == nil {
+				if until := time.Until(t); until > 0 && until < wait {
+					wait = until
+				}
+			}
+		}
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		events, _, reset, changeCh := b.events.since(eventIndex, keyMatcher(key))
+		if reset || len(events) > 0 {
+			return nil
+		}
+
+		select {
+		case <-changeCh:
+		case <-timer.C:
+			return nil
+		case <-logical.DrainChFromContext(ctx):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+const eventsHelpSyn = `Long-poll the changes made to the keys under a prefix.`
+const eventsHelpDesc = `
+This path returns the changes made to the keys under the given prefix, such as
+new versions being written or versions being deleted, undeleted or destroyed.
+
+A read with the "index" parameter returns the events published after that
+index, blocking for up to "wait" until one is available. The returned "index"
+is passed to the next request. An index of 0 returns the current index
+immediately. If "reset" is true the events since the provided index are no
+longer available, e.g. because the mount was reloaded, and the client should
+re-read the keys it is interested in.
+
+Events are kept in memory on the node handling the request and only the most
+recent ones are available.
+`
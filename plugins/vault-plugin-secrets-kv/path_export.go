@@ -0,0 +1,333 @@
+package kv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+
+	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/locksutil"
+	"github.com/jiangjiali/vault/sdk/helper/mitchellh/mapstructure"
+	"github.com/jiangjiali/vault/sdk/helper/parseutil"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+// exportedVersion is used to decode a single version of an imported key
+// record.
+type exportedVersion struct {
+	CreatedTime  string                 `mapstructure:"created_time"`
+	DeletionTime string                 `mapstructure:"deletion_time"`
+	Destroyed    bool                   `mapstructure:"destroyed"`
+	Data         map[string]interface{} `mapstructure:"data"`
+}
+
+// exportedMetadata is used to decode the key metadata of an imported key
+// record.
+type exportedMetadata struct {
+	CurrentVersion     uint64            `mapstructure:"current_version"`
+	OldestVersion      uint64            `mapstructure:"oldest_version"`
+	CreatedTime        string            `mapstructure:"created_time"`
+	UpdatedTime        string            `mapstructure:"updated_time"`
+	MaxVersions        uint32            `mapstructure:"max_versions"`
+	CasRequired        bool              `mapstructure:"cas_required"`
+	DeleteVersionAfter string            `mapstructure:"delete_version_after"`
+	CustomMetadata     map[string]string `mapstructure:"custom_metadata"`
+}
+
+// pathsExport returns the path configuration for exporting and importing the
+// full record of a key, including the data of deleted versions.
+func pathsExport(b *versionedKVBackend) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "export/" + framework.MatchAllRegex("path"),
+			Fields: map[string]*framework.FieldSchema{
+				"path": {
+					Type:        framework.TypeString,
+					Description: "Location of the secret.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: b.upgradeCheck(b.pathExportRead()),
+			},
+
+			HelpSynopsis:    exportHelpSyn,
+			HelpDescription: exportHelpDesc,
+		},
+		{
+			Pattern: "import/" + framework.MatchAllRegex("path"),
+			Fields: map[string]*framework.FieldSchema{
+				"path": {
+					Type:        framework.TypeString,
+					Description: "Location of the secret.",
+				},
+				"metadata": {
+					Type:        framework.TypeMap,
+					Description: "The key metadata, in the format returned by the export endpoint.",
+				},
+				"versions": {
+					Type:        framework.TypeMap,
+					Description: "Map of version number to version, in the format returned by the export endpoint.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: b.upgradeCheck(b.pathImportWrite()),
+				logical.CreateOperation: b.upgradeCheck(b.pathImportWrite()),
+			},
+
+			HelpSynopsis:    importHelpSyn,
+			HelpDescription: importHelpDesc,
+		},
+	}
+}
+
+// pathExportRead returns the metadata and every version of a key.
+func (b *versionedKVBackend) pathExportRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		key := data.Get("path").(string)
+
+		lock := locksutil.LockForKey(b.locks, key)
+		lock.RLock()
+		defer lock.RUnlock()
+
+		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, nil
+		}
+
+		customMetadata := meta.CustomMetadata
+		if customMetadata == nil {
+			customMetadata = map[string]string{}
+		}
+
+		versions := make(map[string]interface{}, len(meta.Versions))
+		for verNum, vm := range meta.Versions {
+			version := map[string]interface{}{
+				"created_time":  ptypesTimestampToString(vm.CreatedTime),
+				"deletion_time": ptypesTimestampToString(vm.DeletionTime),
+				"destroyed":     vm.Destroyed,
+				"data":          nil,
+			}
+
+			if !vm.Destroyed {
+				vData, err := b.getVersionData(ctx, req.Storage, key, verNum)
+				if err != nil {
+					return nil, fmt.Errorf("failed to read version %d: %v", verNum, err)
+				}
+				version["data"] = vData
+			}
+
+			versions[strconv.FormatUint(verNum, 10)] = version
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"current_version":      meta.CurrentVersion,
+					"oldest_version":       meta.OldestVersion,
+					"created_time":         ptypesTimestampToString(meta.CreatedTime),
+					"updated_time":         ptypesTimestampToString(meta.UpdatedTime),
+					"max_versions":         meta.MaxVersions,
+					"cas_required":         meta.CasRequired,
+					"delete_version_after": ptypesDurationToString(meta.DeleteVersionAfter),
+					"custom_metadata":      customMetadata,
+				},
+				"versions": versions,
+			},
+		}, nil
+	}
+}
+
+// pathImportWrite recreates a key from an exported record, keeping the
+// version numbers, timestamps and settings of the original key.
+func (b *versionedKVBackend) pathImportWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		key := data.Get("path").(string)
+		if key == "" {
+			return logical.ErrorResponse("missing path"), nil
+		}
+
+		var exMeta exportedMetadata
+		if err := mapstructure.WeakDecode(data.Get("metadata"), &exMeta); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("error parsing metadata: %v", err)), logical.ErrInvalidRequest
+		}
+		if err := validateCustomMetadata(exMeta.CustomMetadata); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		versionsRaw := data.Get("versions").(map[string]interface{})
+		if len(versionsRaw) == 0 {
+			return logical.ErrorResponse("no versions provided"), logical.ErrInvalidRequest
+		}
+
+		versions := make(map[uint64]*exportedVersion, len(versionsRaw))
+		verNums := make([]uint64, 0, len(versionsRaw))
+		for k, v := range versionsRaw {
+			verNum, err := strconv.ParseUint(k, 10, 64)
+			if err != nil || verNum == 0 {
+				return logical.ErrorResponse(fmt.Sprintf("invalid version number %q", k)), logical.ErrInvalidRequest
+			}
+
+			var version exportedVersion
+			if err := mapstructure.WeakDecode(v, &version); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("error parsing version %d: %v", verNum, err)), logical.ErrInvalidRequest
+			}
+			if !version.Destroyed && version.Data == nil {
+				return logical.ErrorResponse(fmt.Sprintf("no data provided for version %d", verNum)), logical.ErrInvalidRequest
+			}
+
+			versions[verNum] = &version
+			verNums = append(verNums, verNum)
+		}
+		sort.Slice(verNums, func(i, j int) bool { return verNums[i] < verNums[j] })
+
+		now := ptypes.TimestampNow()
+		meta := &KeyMetadata{
+			Key:            key,
+			Versions:       make(map[uint64]*VersionMetadata, len(versions)),
+			CurrentVersion: exMeta.CurrentVersion,
+			OldestVersion:  exMeta.OldestVersion,
+			MaxVersions:    exMeta.MaxVersions,
+			CasRequired:    exMeta.CasRequired,
+			CustomMetadata: exMeta.CustomMetadata,
+		}
+		if meta.CurrentVersion < verNums[len(verNums)-1] {
+			meta.CurrentVersion = verNums[len(verNums)-1]
+		}
+		if meta.OldestVersion > verNums[0] {
+			meta.OldestVersion = verNums[0]
+		}
+
+		var err error
+		if meta.CreatedTime, err = parseTimestamp(exMeta.CreatedTime, now); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid created_time: %v", err)), logical.ErrInvalidRequest
+		}
+		if meta.UpdatedTime, err = parseTimestamp(exMeta.UpdatedTime, now); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid updated_time: %v", err)), logical.ErrInvalidRequest
+		}
+		if exMeta.DeleteVersionAfter != "" {
+			dva, err := parseutil.ParseDurationSecond(exMeta.DeleteVersionAfter)
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid delete_version_after: %v", err)), logical.ErrInvalidRequest
+			}
+			meta.DeleteVersionAfter = durationToProto(int(dva / time.Second))
+		}
+
+		for _, verNum := range verNums {
+			version := versions[verNum]
+
+			vm := &VersionMetadata{
+				Destroyed: version.Destroyed,
+			}
+			if vm.CreatedTime, err = parseTimestamp(version.CreatedTime, now); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid created_time for version %d: %v", verNum, err)), logical.ErrInvalidRequest
+			}
+			if version.DeletionTime != "" {
+				if vm.DeletionTime, err = parseTimestamp(version.DeletionTime, nil); err != nil {
+					return logical.ErrorResponse(fmt.Sprintf("invalid deletion_time for version %d: %v", verNum, err)), logical.ErrInvalidRequest
+				}
+			}
+
+			meta.Versions[verNum] = vm
+		}
+
+		lock := locksutil.LockForKey(b.locks, key)
+		lock.Lock()
+		defer lock.Unlock()
+
+		existing, err := b.getKeyMetadata(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return logical.ErrorResponse(fmt.Sprintf("key %q already exists", key)), logical.ErrInvalidRequest
+		}
+
+		// Write the version data before the metadata so a failed import
+		// never leaves metadata pointing at missing versions.
+		for _, verNum := range verNums {
+			version := versions[verNum]
+			if version.Destroyed {
+				continue
+			}
+
+			marshaledData, err := json.Marshal(version.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			buf, err := proto.Marshal(&Version{
+				Data:         marshaledData,
+				CreatedTime:  meta.Versions[verNum].CreatedTime,
+				DeletionTime: meta.Versions[verNum].DeletionTime,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			versionKey, err := b.getVersionKey(ctx, key, verNum, req.Storage)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := req.Storage.Put(ctx, &logical.StorageEntry{
+				Key:   versionKey,
+				Value: buf,
+			}); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := b.writeKeyMetadata(ctx, req.Storage, meta); err != nil {
+			return nil, err
+		}
+
+		b.events.publish(key, eventTypeWrite, meta.CurrentVersion)
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"current_version": meta.CurrentVersion,
+				"oldest_version":  meta.OldestVersion,
+				"versions":        len(meta.Versions),
+			},
+		}, nil
+	}
+}
+
+// parseTimestamp parses a timestamp in the format produced by
+// ptypesTimestampToString. An empty value results in def.
+func parseTimestamp(s string, def *timestamp.Timestamp) (*timestamp.Timestamp, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return ptypes.TimestampProto(t)
+}
+
+const exportHelpSyn = `Export the metadata and all versions of a key in the KV store.`
+const exportHelpDesc = `
+This path returns the full record of a key: its metadata and settings, and the
+data of every version that has not been destroyed, including deleted versions.
+The record can be loaded into another mount with the import endpoint.
+`
+
+const importHelpSyn = `Import a key previously exported from a KV store.`
+const importHelpDesc = `
+This path recreates a key from the record returned by the export endpoint,
+preserving the version numbers, timestamps, deletion state and the key's
+settings such as max_versions and cas_required. The key must not already exist.
+`
@@ -2,7 +2,9 @@ package kv
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/golang/protobuf/ptypes"
 
@@ -11,6 +13,17 @@ import (
 	"github.com/jiangjiali/vault/sdk/logical"
 )
 
+const (
+	// maxCustomMetadataKeys is the number of custom metadata pairs that can be
+	// stored on a key.
+	maxCustomMetadataKeys = 64
+
+	// maxCustomMetadataKeyLength and maxCustomMetadataValueLength limit the
+	// size of a single custom metadata pair.
+	maxCustomMetadataKeyLength   = 128
+	maxCustomMetadataValueLength = 512
+)
+
 // pathMetadata returns the path configuration for CRUD operations on the
 // metadata endpoint
 func pathMetadata(b *versionedKVBackend) *framework.Path {
@@ -33,6 +46,20 @@ If false, the backend’s configuration will be used.`,
 The number of versions to keep. If not set, the backend’s configured max
 version is used.`,
 			},
+			"delete_version_after": {
+				Type: framework.TypeDurationSecond,
+				Description: `
+The length of time before a version is deleted. If not set, the backend's
+configured delete_version_after is used. Cannot be greater than the backend's
+delete_version_after. A zero duration clears the current setting.`,
+			},
+			"custom_metadata": {
+				Type: framework.TypeKVPairs,
+				Description: `
+User-provided key-value pairs that are used to describe arbitrary and
+version-agnostic information about a secret. Writing this field replaces all
+previously stored pairs.`,
+			},
 		},
 		Callbacks: map[logical.Operation]framework.OperationFunc{
 			logical.UpdateOperation: b.upgradeCheck(b.pathMetadataWrite()),
@@ -92,24 +119,42 @@ func (b *versionedKVBackend) pathMetadataRead() framework.OperationFunc {
 			return nil, nil
 		}
 
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		dva := deleteVersionAfter(meta, config)
+
 		versions := make(map[string]interface{}, len(meta.Versions))
 		for i, v := range meta.Versions {
+			deletionTime, err := versionDeletionTime(v, dva)
+			if err != nil {
+				return nil, err
+			}
+
 			versions[fmt.Sprintf("%d", i)] = map[string]interface{}{
 				"created_time":  ptypesTimestampToString(v.CreatedTime),
-				"deletion_time": ptypesTimestampToString(v.DeletionTime),
+				"deletion_time": ptypesTimestampToString(deletionTime),
 				"destroyed":     v.Destroyed,
 			}
 		}
 
+		customMetadata := meta.CustomMetadata
+		if customMetadata == nil {
+			customMetadata = map[string]string{}
+		}
+
 		return &logical.Response{
 			Data: map[string]interface{}{
-				"versions":        versions,
-				"current_version": meta.CurrentVersion,
-				"oldest_version":  meta.OldestVersion,
-				"created_time":    ptypesTimestampToString(meta.CreatedTime),
-				"updated_time":    ptypesTimestampToString(meta.UpdatedTime),
-				"max_versions":    meta.MaxVersions,
-				"cas_required":    meta.CasRequired,
+				"versions":             versions,
+				"current_version":      meta.CurrentVersion,
+				"oldest_version":       meta.OldestVersion,
+				"created_time":         ptypesTimestampToString(meta.CreatedTime),
+				"updated_time":         ptypesTimestampToString(meta.UpdatedTime),
+				"max_versions":         meta.MaxVersions,
+				"cas_required":         meta.CasRequired,
+				"delete_version_after": ptypesDurationToString(meta.DeleteVersionAfter),
+				"custom_metadata":      customMetadata,
 			},
 		}, nil
 	}
@@ -124,12 +169,20 @@ func (b *versionedKVBackend) pathMetadataWrite() framework.OperationFunc {
 
 		maxRaw, mOk := data.GetOk("max_versions")
 		casRaw, cOk := data.GetOk("cas_required")
+		dvaRaw, dOk := data.GetOk("delete_version_after")
+		customRaw, cmOk := data.GetOk("custom_metadata")
 
 		// Fast path validation
-		if !mOk && !cOk {
+		if !mOk && !cOk && !dOk && !cmOk {
 			return nil, nil
 		}
 
+		if cmOk {
+			if err := validateCustomMetadata(customRaw.(map[string]string)); err != nil {
+				return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+			}
+		}
+
 		config, err := b.config(ctx, req.Storage)
 		if err != nil {
 			return nil, err
@@ -140,6 +193,18 @@ func (b *versionedKVBackend) pathMetadataWrite() framework.OperationFunc {
 			resp = &logical.Response{}
 			resp.AddWarning("\"cas_required\" set to false, but is mandated by backend config. This value will be ignored.")
 		}
+		if dOk && config.DeleteVersionAfter != nil {
+			cdur, err := ptypes.Duration(config.DeleteVersionAfter)
+			if err != nil {
+				return nil, err
+			}
+			if dva := time.Duration(dvaRaw.(int)) * time.Second; dva > cdur {
+				if resp == nil {
+					resp = &logical.Response{}
+				}
+				resp.AddWarning(fmt.Sprintf("\"delete_version_after\" is limited to %s by backend config.", cdur))
+			}
+		}
 
 		lock := locksutil.LockForKey(b.locks, key)
 		lock.Lock()
@@ -165,6 +230,12 @@ func (b *versionedKVBackend) pathMetadataWrite() framework.OperationFunc {
 		if cOk {
 			meta.CasRequired = casRaw.(bool)
 		}
+		if dOk {
+			meta.DeleteVersionAfter = durationToProto(dvaRaw.(int))
+		}
+		if cmOk {
+			meta.CustomMetadata = customRaw.(map[string]string)
+		}
 
 		err = b.writeKeyMetadata(ctx, req.Storage, meta)
 		return resp, err
@@ -210,8 +281,35 @@ func (b *versionedKVBackend) pathMetadataDelete() framework.OperationFunc {
 
 		// Use encrypted key storage to delete the key
 		err = es.Delete(ctx, key)
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
+
+		b.events.publish(key, eventTypeMetadataDelete, meta.CurrentVersion)
+
+		return nil, nil
+	}
+}
+
+// validateCustomMetadata checks the custom metadata provided for a key stays
+// within the allowed number and size of entries.
+func validateCustomMetadata(customMetadata map[string]string) error {
+	if len(customMetadata) > maxCustomMetadataKeys {
+		return fmt.Errorf("custom_metadata may not contain more than %d keys", maxCustomMetadataKeys)
 	}
+
+	for k, v := range customMetadata {
+		switch {
+		case k == "":
+			return errors.New("custom_metadata keys may not be empty")
+		case len(k) > maxCustomMetadataKeyLength:
+			return fmt.Errorf("custom_metadata key %q exceeds the maximum length of %d", k, maxCustomMetadataKeyLength)
+		case len(v) > maxCustomMetadataValueLength:
+			return fmt.Errorf("custom_metadata value for key %q exceeds the maximum length of %d", k, maxCustomMetadataValueLength)
+		}
+	}
+
+	return nil
 }
 
 const metadataHelpSyn = `Allows interaction with key metadata and settings in the KV store.`
@@ -0,0 +1,147 @@
+package kv
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/locksutil"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+// pathSubkeys returns the path configuration for reading the structure of a
+// secret without its values.
+func pathSubkeys(b *versionedKVBackend) *framework.Path {
+	return &framework.Path{
+		Pattern: "subkeys/" + framework.MatchAllRegex("path"),
+		Fields: map[string]*framework.FieldSchema{
+			"path": {
+				Type:        framework.TypeString,
+				Description: "Location of the secret.",
+			},
+			"version": {
+				Type:        framework.TypeInt,
+				Description: "If provided, the subkeys of the version with that number will be returned",
+			},
+			"depth": {
+				Type: framework.TypeInt,
+				Description: `
+The deepest nesting level to provide in the output. If not set or set to 0,
+all nested keys are returned.`,
+			},
+		},
+		Callbacks: map[logical.Operation]framework.OperationFunc{
+			logical.ReadOperation: b.upgradeCheck(b.pathSubkeysRead()),
+		},
+
+		HelpSynopsis:    subkeysHelpSyn,
+		HelpDescription: subkeysHelpDesc,
+	}
+}
+
+// pathSubkeysRead handles read commands to the subkeys of a kv entry
+func (b *versionedKVBackend) pathSubkeysRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+		key := data.Get("path").(string)
+
+		depth := data.Get("depth").(int)
+		if depth < 0 {
+			return logical.ErrorResponse("depth must be a non-negative integer"), logical.ErrInvalidRequest
+		}
+
+		lock := locksutil.LockForKey(b.locks, key)
+		lock.RLock()
+		defer lock.RUnlock()
+
+		meta, err := b.getKeyMetadata(ctx, req.Storage, key)
+		if err != nil {
+			return nil, err
+		}
+		if meta == nil {
+			return nil, nil
+		}
+
+		verNum := meta.CurrentVersion
+		verParam := data.Get("version").(int)
+		if verParam > 0 {
+			verNum = uint64(verParam)
+		}
+
+		vm := meta.Versions[verNum]
+		if vm == nil {
+			return nil, nil
+		}
+
+		config, err := b.config(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		dva := deleteVersionAfter(meta, config)
+
+		deletionTime, err := versionDeletionTime(vm, dva)
+		if err != nil {
+			return nil, err
+		}
+
+		resp := &logical.Response{
+			Data: map[string]interface{}{
+				"subkeys": nil,
+				"metadata": map[string]interface{}{
+					"version":       verNum,
+					"created_time":  ptypesTimestampToString(vm.CreatedTime),
+					"deletion_time": ptypesTimestampToString(deletionTime),
+					"destroyed":     vm.Destroyed,
+				},
+			},
+		}
+
+		// If the version has been deleted or destroyed return metadata with
+		// a 404
+		deleted, err := versionDeleted(vm, dva)
+		if err != nil {
+			return nil, err
+		}
+		if deleted || vm.Destroyed {
+			return logical.RespondWithStatusCode(resp, req, http.StatusNotFound)
+		}
+
+		vData, err := b.getVersionData(ctx, req.Storage, key, verNum)
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Data["subkeys"] = subkeys(vData, depth, 1)
+
+		return resp, nil
+	}
+}
+
+// subkeys returns a copy of the map with every value that is not itself a map
+// replaced by nil. Maps nested deeper than depth are also replaced by nil, a
+// depth of 0 means no limit.
+func subkeys(m map[string]interface{}, depth, level int) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		nested, ok := v.(map[string]interface{})
+		if !ok || (depth > 0 && level >= depth) {
+			out[k] = nil
+			continue
+		}
+
+		out[k] = subkeys(nested, depth, level+1)
+	}
+
+	return out
+}
+
+const subkeysHelpSyn = `Read the structure of a secret entry from the Key-Value store with the values removed.`
+const subkeysHelpDesc = `
+This path provides the subkeys that exist within a secret entry that exists
+at the provided path. The secret entry at this path will be retrieved and
+stripped of all data by replacing underlying values of leaf keys (i.e. non-map
+keys or map keys with no underlying subkeys) with null.
+
+A read operation will return the latest version for a key unless the "version"
+parameter is set, then it returns the version at that number. The "depth"
+parameter limits how deeply nested keys are returned.
+`
@@ -20,6 +20,7 @@ import proto "github.com/golang/protobuf/proto"
 import fmt "fmt"
 import math "math"
 import google_protobuf "github.com/golang/protobuf/ptypes/timestamp"
+import google_protobuf1 "github.com/golang/protobuf/ptypes/duration"
 
 // Reference imports to suppress errors if they are not otherwise used.
 var _ = proto.Marshal
@@ -32,9 +33,11 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+// If values are added to this, be sure to update the config() function
 type Configuration struct {
-	MaxVersions uint32 `protobuf:"varint,1,opt,name=max_versions,json=maxVersions" json:"max_versions,omitempty"`
-	CasRequired bool   `protobuf:"varint,2,opt,name=cas_required,json=casRequired" json:"cas_required,omitempty"`
+	MaxVersions        uint32                     `protobuf:"varint,1,opt,name=max_versions,json=maxVersions" json:"max_versions,omitempty"`
+	CasRequired        bool                       `protobuf:"varint,2,opt,name=cas_required,json=casRequired" json:"cas_required,omitempty"`
+	DeleteVersionAfter *google_protobuf1.Duration `protobuf:"bytes,3,opt,name=delete_version_after,json=deleteVersionAfter" json:"delete_version_after,omitempty"`
 }
 
 func (m *Configuration) Reset()                    { *m = Configuration{} }
@@ -56,6 +59,13 @@ func (m *Configuration) GetCasRequired() bool {
 	return false
 }
 
+func (m *Configuration) GetDeleteVersionAfter() *google_protobuf1.Duration {
+	if m != nil {
+		return m.DeleteVersionAfter
+	}
+	return nil
+}
+
 type VersionMetadata struct {
 	// CreatedTime is when the version was created.
 	CreatedTime *google_protobuf.Timestamp `protobuf:"bytes,1,opt,name=created_time,json=createdTime" json:"created_time,omitempty"`
@@ -116,6 +126,13 @@ type KeyMetadata struct {
 	// CasRequired specifies if the cas parameter is
 	// required for this key
 	CasRequired bool `protobuf:"varint,8,opt,name=cas_required,json=casRequired" json:"cas_required,omitempty"`
+	// DeleteVersionAfter specifies how long a version is
+	// kept before it is automatically soft deleted. If
+	// empty, the configured value for the mount is used.
+	DeleteVersionAfter *google_protobuf1.Duration `protobuf:"bytes,9,opt,name=delete_version_after,json=deleteVersionAfter" json:"delete_version_after,omitempty"`
+	// CustomMetadata is a set of user provided key-value
+	// pairs describing the key, such as its owner.
+	CustomMetadata map[string]string `protobuf:"bytes,10,rep,name=custom_metadata,json=customMetadata" json:"custom_metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 }
 
 func (m *KeyMetadata) Reset()                    { *m = KeyMetadata{} }
@@ -179,6 +196,20 @@ func (m *KeyMetadata) GetCasRequired() bool {
 	return false
 }
 
+func (m *KeyMetadata) GetDeleteVersionAfter() *google_protobuf1.Duration {
+	if m != nil {
+		return m.DeleteVersionAfter
+	}
+	return nil
+}
+
+func (m *KeyMetadata) GetCustomMetadata() map[string]string {
+	if m != nil {
+		return m.CustomMetadata
+	}
+	return nil
+}
+
 type Version struct {
 	// Data is a JSON object with string keys that
 	// represents the user supplied data.
@@ -255,33 +286,38 @@ func init() {
 func init() { proto.RegisterFile("types.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 436 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x93, 0xdf, 0x8a, 0x13, 0x31,
-	0x18, 0xc5, 0x99, 0x3f, 0xbb, 0xdb, 0xfd, 0x32, 0xb3, 0x2b, 0xf1, 0xa6, 0x14, 0xc5, 0x3a, 0x20,
-	0xd6, 0x9b, 0x59, 0x58, 0x6f, 0x54, 0x58, 0xbc, 0x10, 0x2f, 0x44, 0x04, 0x09, 0xae, 0xb7, 0x35,
-	0xdb, 0x7c, 0x2d, 0x43, 0xdb, 0xc9, 0x98, 0x64, 0xca, 0xce, 0xc3, 0xf8, 0x0c, 0xbe, 0x82, 0x8f,
-	0x26, 0xc9, 0x24, 0xdd, 0xb5, 0x0a, 0xa5, 0x78, 0x17, 0x4e, 0xcf, 0x09, 0xbf, 0x9e, 0x39, 0x01,
-	0x62, 0xba, 0x06, 0x75, 0xd9, 0x28, 0x69, 0x24, 0x8d, 0x97, 0x9b, 0xd1, 0x93, 0x85, 0x94, 0x8b,
-	0x15, 0x5e, 0x38, 0xe5, 0xa6, 0x9d, 0x5f, 0x98, 0x6a, 0x8d, 0xda, 0xf0, 0x75, 0xd3, 0x9b, 0x8a,
-	0x6b, 0xc8, 0xdf, 0xc9, 0x7a, 0x5e, 0x2d, 0x5a, 0xc5, 0x4d, 0x25, 0x6b, 0xfa, 0x14, 0xb2, 0x35,
-	0xbf, 0x9d, 0x6e, 0x50, 0xe9, 0x4a, 0xd6, 0x7a, 0x18, 0x8d, 0xa3, 0x49, 0xce, 0xc8, 0x9a, 0xdf,
-	0x7e, 0xf5, 0x92, 0xb5, 0xcc, 0xb8, 0x9e, 0x2a, 0xfc, 0xde, 0x56, 0x0a, 0xc5, 0x30, 0x1e, 0x47,
-	0x93, 0x01, 0x23, 0x33, 0xae, 0x99, 0x97, 0x8a, 0x9f, 0x11, 0x9c, 0x7b, 0xff, 0x27, 0x34, 0x5c,
-	0x70, 0xc3, 0xe9, 0x15, 0x64, 0x33, 0x85, 0xdc, 0xa0, 0x98, 0x5a, 0x0a, 0x77, 0x33, 0xb9, 0x1c,
-	0x95, 0x3d, 0x62, 0x19, 0x10, 0xcb, 0x2f, 0x01, 0x91, 0x11, 0xef, 0xb7, 0x0a, 0x7d, 0x0b, 0xb9,
-	0xc0, 0x15, 0x5a, 0xc8, 0x3e, 0x1f, 0xef, 0xcd, 0x67, 0x21, 0xe0, 0x2e, 0x78, 0x04, 0xa7, 0x02,
-	0xb5, 0x51, 0xb2, 0x43, 0x31, 0x4c, 0x1c, 0xf3, 0x9d, 0x50, 0xfc, 0x4a, 0x80, 0x7c, 0xc4, 0x6e,
-	0x4b, 0xfb, 0x00, 0x92, 0x25, 0x76, 0x0e, 0xf2, 0x94, 0xd9, 0x23, 0x7d, 0x0d, 0x83, 0x6d, 0x2b,
-	0xf1, 0x38, 0x99, 0x90, 0xcb, 0xc7, 0xe5, 0x72, 0x53, 0xde, 0x0b, 0x95, 0xa1, 0xa2, 0xf7, 0xb5,
-	0x51, 0x1d, 0xdb, 0xda, 0xe9, 0x73, 0x38, 0x9f, 0xb5, 0x4a, 0x61, 0x6d, 0x42, 0xb1, 0x0e, 0x20,
-	0x65, 0x67, 0x5e, 0xf6, 0x41, 0xfa, 0x0c, 0xce, 0xe4, 0xca, 0x42, 0x6d, 0x7d, 0xa9, 0xf3, 0xe5,
-	0xbd, 0x1a, 0x6c, 0xbb, 0x55, 0x1e, 0x1d, 0x56, 0xe5, 0x15, 0x64, 0x6d, 0x23, 0xee, 0xe2, 0xc7,
-	0xfb, 0xe3, 0xde, 0xef, 0xe2, 0xbb, 0x13, 0x39, 0xd9, 0x3f, 0x91, 0xc1, 0x5f, 0x13, 0x19, 0x7d,
-	0x86, 0xfc, 0x8f, 0xba, 0xee, 0x37, 0x9e, 0xf6, 0x8d, 0xbf, 0x80, 0xa3, 0x0d, 0x5f, 0xb5, 0xe1,
-	0x53, 0x3f, 0xb4, 0x75, 0xef, 0xac, 0x8a, 0xf5, 0x8e, 0x37, 0xf1, 0xab, 0xa8, 0xf8, 0x11, 0xc1,
-	0x49, 0x68, 0x88, 0x42, 0x6a, 0x7f, 0x76, 0xb7, 0x65, 0x2c, 0xfd, 0xe7, 0x00, 0xe3, 0xff, 0x1c,
-	0x60, 0x72, 0xd8, 0x00, 0x8b, 0x6f, 0x40, 0xae, 0x9b, 0x85, 0xe2, 0x02, 0x3f, 0xd4, 0x73, 0x69,
-	0x71, 0xb4, 0xe1, 0xea, 0x90, 0xf7, 0xe0, 0xfd, 0x0e, 0xc7, 0xfe, 0x43, 0x59, 0xa3, 0x7f, 0x7d,
-	0xee, 0x7c, 0x73, 0xec, 0x42, 0x2f, 0x7f, 0x07, 0x00, 0x00, 0xff, 0xff, 0xa2, 0xd2, 0xb5, 0x3c,
-	0x08, 0x04, 0x00, 0x00,
+	// 524 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x54, 0x5d, 0x6f, 0xd3, 0x30,
+	0x14, 0x55, 0xd2, 0x76, 0x6b, 0x6f, 0xda, 0x0e, 0x79, 0x7b, 0x28, 0x15, 0x1f, 0xa5, 0x08, 0x51,
+	0x5e, 0x32, 0xa9, 0xbc, 0x00, 0xd2, 0x84, 0xa6, 0xc1, 0x03, 0x1a, 0x48, 0xc8, 0x02, 0x5e, 0x83,
+	0x97, 0xdc, 0x56, 0x51, 0x9b, 0x38, 0x38, 0x4e, 0xb5, 0xfc, 0x18, 0x78, 0xe0, 0x0f, 0xf0, 0x17,
+	0x91, 0x1d, 0xbb, 0xeb, 0x42, 0xa5, 0x52, 0x78, 0x73, 0x4f, 0xcf, 0xb9, 0xf7, 0xf8, 0xde, 0xe3,
+	0x80, 0x27, 0xcb, 0x0c, 0x73, 0x3f, 0x13, 0x5c, 0x72, 0xe2, 0x2e, 0x56, 0xc3, 0x87, 0x73, 0xce,
+	0xe7, 0x4b, 0x3c, 0xd5, 0xc8, 0x55, 0x31, 0x3b, 0x95, 0x71, 0x82, 0xb9, 0x64, 0x49, 0x56, 0x91,
+	0x86, 0x0f, 0xea, 0x84, 0xa8, 0x10, 0x4c, 0xc6, 0x3c, 0xad, 0xfe, 0x1f, 0xff, 0x74, 0xa0, 0x77,
+	0xc1, 0xd3, 0x59, 0x3c, 0x37, 0x38, 0x79, 0x04, 0xdd, 0x84, 0x5d, 0x07, 0x2b, 0x14, 0x79, 0xcc,
+	0xd3, 0x7c, 0xe0, 0x8c, 0x9c, 0x49, 0x8f, 0x7a, 0x09, 0xbb, 0xfe, 0x62, 0x20, 0x45, 0x09, 0x59,
+	0x1e, 0x08, 0xfc, 0x56, 0xc4, 0x02, 0xa3, 0x81, 0x3b, 0x72, 0x26, 0x6d, 0xea, 0x85, 0x2c, 0xa7,
+	0x06, 0x22, 0x97, 0x70, 0x12, 0xe1, 0x12, 0x25, 0xda, 0x42, 0x01, 0x9b, 0x49, 0x14, 0x83, 0xc6,
+	0xc8, 0x99, 0x78, 0xd3, 0xbb, 0x7e, 0x65, 0xcb, 0xb7, 0xb6, 0xfc, 0x37, 0xa6, 0x3d, 0x25, 0x95,
+	0xcc, 0xf4, 0x3a, 0x57, 0xa2, 0xf1, 0x2f, 0x07, 0x8e, 0x0c, 0xf0, 0x01, 0x25, 0x8b, 0x98, 0x64,
+	0xe4, 0x0c, 0xba, 0xa1, 0x40, 0x26, 0x31, 0x0a, 0xd4, 0x9d, 0xb5, 0x4d, 0x6f, 0x3a, 0xfc, 0xa3,
+	0xf0, 0x27, 0x3b, 0x10, 0xea, 0x19, 0xbe, 0x42, 0xc8, 0x6b, 0xe8, 0xe9, 0x46, 0xca, 0x99, 0xd6,
+	0xbb, 0x3b, 0xf5, 0x5d, 0x2b, 0xd0, 0x05, 0xee, 0x41, 0x27, 0xc2, 0x5c, 0x0a, 0x5e, 0x62, 0xa4,
+	0x6f, 0xd5, 0xa6, 0x37, 0xc0, 0xf8, 0x47, 0x0b, 0xbc, 0x4b, 0x2c, 0xd7, 0x6e, 0xef, 0x40, 0x63,
+	0x81, 0xa5, 0x36, 0xd9, 0xa1, 0xea, 0x48, 0x5e, 0x42, 0x7b, 0x3d, 0x62, 0x77, 0xd4, 0x98, 0x78,
+	0xd3, 0xfb, 0xfe, 0x62, 0xe5, 0x6f, 0x88, 0x7c, 0x3b, 0xef, 0xb7, 0xa9, 0x14, 0x25, 0x5d, 0xd3,
+	0xc9, 0x53, 0x38, 0x0a, 0x0b, 0x21, 0x30, 0x95, 0x76, 0xb8, 0xda, 0x40, 0x93, 0xf6, 0x0d, 0x6c,
+	0x84, 0xe4, 0x09, 0xf4, 0xf9, 0x52, 0x99, 0x5a, 0xf3, 0x9a, 0x9a, 0xd7, 0xab, 0x50, 0x4b, 0xab,
+	0x8f, 0xb2, 0xb5, 0xdf, 0x28, 0xcf, 0xa0, 0x5b, 0x64, 0xd1, 0x8d, 0xfc, 0x60, 0xb7, 0xdc, 0xf0,
+	0xb5, 0xbc, 0x9e, 0xb7, 0xc3, 0xdd, 0x79, 0x6b, 0xff, 0x7d, 0xde, 0x3a, 0xff, 0x90, 0x37, 0xf2,
+	0x5e, 0x0d, 0x38, 0x97, 0x3c, 0x09, 0x12, 0xb3, 0x8b, 0x01, 0xe8, 0x15, 0x3d, 0xae, 0xaf, 0xe8,
+	0x42, 0xd3, 0xec, 0xcf, 0x6a, 0x51, 0xfd, 0xf0, 0x16, 0x38, 0xfc, 0x08, 0xbd, 0x5b, 0x9b, 0xdc,
+	0x0c, 0x43, 0xb3, 0x0a, 0xc3, 0x33, 0x68, 0xad, 0xd8, 0xb2, 0xb0, 0x29, 0x3c, 0x56, 0x6d, 0x6a,
+	0x81, 0xa7, 0x15, 0xe3, 0x95, 0xfb, 0xc2, 0x19, 0x9e, 0xc3, 0xf1, 0x96, 0xc6, 0x5b, 0x42, 0x76,
+	0xb2, 0x59, 0xb7, 0xb3, 0x51, 0x62, 0xfc, 0xdd, 0x81, 0x43, 0xbb, 0x7f, 0x02, 0x4d, 0x7d, 0x47,
+	0x25, 0xec, 0xd2, 0xe6, 0xd6, 0xe7, 0xe5, 0xfe, 0xe7, 0xf3, 0x6a, 0xec, 0xf7, 0xbc, 0xc6, 0x5f,
+	0xc1, 0xfb, 0x9c, 0xcd, 0x05, 0x8b, 0xf0, 0x5d, 0x3a, 0xe3, 0xca, 0x4e, 0x2e, 0x99, 0xd8, 0xe7,
+	0xb5, 0x1b, 0xbe, 0xb6, 0xa3, 0x6e, 0xc8, 0x53, 0x34, 0x1f, 0x2a, 0x7d, 0xbe, 0x3a, 0xd0, 0xa2,
+	0xe7, 0xbf, 0x07, 0x00, 0x2d, 0xa8, 0x36, 0x6f, 0x54, 0x05, 0x00, 0x00,
 }
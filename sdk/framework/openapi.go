@@ -87,6 +87,7 @@ type OASPathItem struct {
 	Get    *OASOperation `json:"get,omitempty"`
 	Post   *OASOperation `json:"post,omitempty"`
 	Delete *OASOperation `json:"delete,omitempty"`
+	Patch  *OASOperation `json:"patch,omitempty"`
 }
 
 // NewOASOperation creates an empty OpenAPI Operations object.
@@ -290,8 +291,9 @@ func documentPath(p *Path, specialPaths *logical.Paths, backendType logical.Back
 			op.Description = props.Description
 			op.Deprecated = props.Deprecated
 
-			// Add any fields not present in the path as body parameters for POST.
-			if opType == logical.CreateOperation || opType == logical.UpdateOperation {
+			// Add any fields not present in the path as body parameters for POST
+			// and PATCH.
+			if opType == logical.CreateOperation || opType == logical.UpdateOperation || opType == logical.PatchOperation {
 				s := &OASSchema{
 					Type:       "object",
 					Properties: make(map[string]*OASSchema),
@@ -330,11 +332,16 @@ func documentPath(p *Path, specialPaths *logical.Paths, backendType logical.Back
 					s.Example = props.Examples[0].Data
 				}
 
-				// Set the final request body. Only JSON request data is supported.
+				// Set the final request body. Only JSON request data is supported,
+				// as a JSON merge patch for PATCH.
 				if len(s.Properties) > 0 || s.Example != nil {
+					contentType := "application/json"
+					if opType == logical.PatchOperation {
+						contentType = "application/merge-patch+json"
+					}
 					op.RequestBody = &OASRequestBody{
 						Content: OASContent{
-							"application/json": &OASMediaTypeObject{
+							contentType: &OASMediaTypeObject{
 								Schema: s,
 							},
 						},
@@ -414,6 +421,8 @@ func documentPath(p *Path, specialPaths *logical.Paths, backendType logical.Back
 				pi.Get = op
 			case logical.DeleteOperation:
 				pi.Delete = op
+			case logical.PatchOperation:
+				pi.Patch = op
 			}
 		}
 
@@ -637,7 +646,7 @@ func (d *OASDocument) CreateOperationIDs(context string) {
 
 	for _, path := range paths {
 		pi := d.Paths[path]
-		for _, method := range []string{"get", "post", "delete"} {
+		for _, method := range []string{"get", "post", "delete", "patch"} {
 			var oasOperation *OASOperation
 			switch method {
 			case "get":
@@ -646,6 +655,8 @@ func (d *OASDocument) CreateOperationIDs(context string) {
 				oasOperation = pi.Post
 			case "delete":
 				oasOperation = pi.Delete
+			case "patch":
+				oasOperation = pi.Patch
 			}
 
 			if oasOperation == nil {
@@ -1,6 +1,7 @@
 package identity
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strings"
@@ -15,12 +16,25 @@ var (
 	ErrTemplateValueNotFound         = errors.New("no value could be found for one of the template directives")
 )
 
+const (
+	// ACLTemplating substitutes the directives with their plain values, as
+	// used by ACL policies. This is the default mode.
+	ACLTemplating = iota
+
+	// JSONTemplating substitutes the directives with JSON values, so the
+	// rendered string can be decoded as a JSON document. Directives without a
+	// value are rendered as null, and the whole metadata, group names and group
+	// IDs of the entity can be referenced.
+	JSONTemplating
+)
+
 type PopulateStringInput struct {
 	ValidityCheckOnly bool
 	String            string
 	Entity            *Entity
 	Groups            []*Group
 	Namespace         *namespace.Namespace
+	Mode              int
 }
 
 func PopulateString(p *PopulateStringInput) (bool, string, error) {
@@ -61,7 +75,14 @@ func PopulateString(p *PopulateStringInput) (bool, string, error) {
 		case 2:
 			subst = true
 			if !p.ValidityCheckOnly {
-				tmplStr, err := performTemplating(p.Namespace, strings.TrimSpace(splitPiece[0]), p.Entity, p.Groups)
+				var tmplStr string
+				var err error
+				switch p.Mode {
+				case JSONTemplating:
+					tmplStr, err = performJSONTemplating(p.Namespace, strings.TrimSpace(splitPiece[0]), p.Entity, p.Groups)
+				default:
+					tmplStr, err = performTemplating(p.Namespace, strings.TrimSpace(splitPiece[0]), p.Entity, p.Groups)
+				}
 				if err != nil {
 					return false, "", err
 				}
@@ -212,3 +233,48 @@ func performTemplating(ns *namespace.Namespace, input string, entity *Entity, gr
 
 	return "", ErrTemplateValueNotFound
 }
+
+func performJSONTemplating(ns *namespace.Namespace, input string, entity *Entity, groups []*Group) (string, error) {
+	var value interface{}
+
+	switch input {
+	case "identity.entity.metadata":
+		if entity == nil {
+			return "", ErrNoEntityAttachedToToken
+		}
+		value = entity.Metadata
+		if entity.Metadata == nil {
+			value = map[string]string{}
+		}
+
+	case "identity.entity.groups.ids", "identity.entity.groups.names":
+		if entity == nil {
+			return "", ErrNoEntityAttachedToToken
+		}
+		list := make([]string, 0, len(groups))
+		for _, group := range groups {
+			if input == "identity.entity.groups.ids" {
+				list = append(list, group.ID)
+			} else {
+				list = append(list, group.Name)
+			}
+		}
+		value = list
+
+	default:
+		str, err := performTemplating(ns, input, entity, groups)
+		switch err {
+		case nil:
+			value = str
+		case ErrTemplateValueNotFound, ErrNoGroupsAttachedToToken:
+		default:
+			return "", err
+		}
+	}
+
+	out, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
@@ -0,0 +1,33 @@
+package jsonutil
+
+// MergePatch applies a JSON merge patch, as described in RFC 7396, to the
+// decoded JSON document target and returns the result. Keys set to nil in the
+// patch are removed from the target, objects are merged recursively and any
+// other value replaces the target value. Neither input is modified.
+func MergePatch(target, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetMap, ok := target.(map[string]interface{})
+	if !ok {
+		targetMap = map[string]interface{}{}
+	}
+
+	result := make(map[string]interface{}, len(targetMap)+len(patchMap))
+	for k, v := range targetMap {
+		result[k] = v
+	}
+
+	for k, v := range patchMap {
+		if v == nil {
+			delete(result, k)
+			continue
+		}
+
+		result[k] = MergePatch(result[k], v)
+	}
+
+	return result
+}
@@ -23,6 +23,12 @@ import (
 // updated.
 const keysExpiryDelta = 30 * time.Second
 
+// minRefreshInterval is the minimum time between two fetches of the remote keys
+// triggered by tokens signed with a key that is not in the cache. Providers may
+// rotate keys at any time, but an unknown kid must not allow a client to make us
+// hammer the remote server.
+const minRefreshInterval = 30 * time.Second
+
 // NewRemoteKeySet returns a KeySet that can validate JSON web tokens by using HTTP
 // GETs to fetch JSON web token sets hosted at a remote URL. This is automatically
 // used by NewProvider using the URLs returned by OpenID Connect discovery, but is
@@ -37,6 +43,24 @@ func NewRemoteKeySet(ctx context.Context, jwksURL string) KeySet {
 	return newRemoteKeySet(ctx, jwksURL, time.Now)
 }
 
+// NewRemoteKeySetWithRefresh is like NewRemoteKeySet, but the returned KeySet also
+// fetches the keys immediately and then every interval in the background until the
+// context is canceled. Keys rotated by the provider are then known before the first
+// token signed with them is presented, and the cached keys remain usable while the
+// remote server is unavailable.
+func NewRemoteKeySetWithRefresh(ctx context.Context, jwksURL string, interval time.Duration) KeySet {
+	r := newRemoteKeySet(ctx, jwksURL, time.Now)
+	go r.refresh(interval)
+	return r
+}
+
+// FetchKeys fetches the JSON web key set hosted at jwksURL once, without caching
+// it. It can be used to check that a URL serves a valid key set.
+func FetchKeys(ctx context.Context, jwksURL string) ([]jose.JSONWebKey, error) {
+	keys, _, err := fetchKeys(ctx, jwksURL, time.Now)
+	return keys, err
+}
+
 func newRemoteKeySet(ctx context.Context, jwksURL string, now func() time.Time) *remoteKeySet {
 	if now == nil {
 		now = time.Now
@@ -59,6 +83,9 @@ type remoteKeySet struct {
 	// A set of cached keys and their expiry.
 	cachedKeys []jose.JSONWebKey
 	expiry     time.Time
+
+	// lastFetch is the time of the last attempt to fetch the remote keys.
+	lastFetch time.Time
 }
 
 // inflight is used to wait on some in-flight request from multiple goroutines.
@@ -109,27 +136,38 @@ func (r *remoteKeySet) verify(ctx context.Context, jws *jose.JSONWebSignature) (
 		break
 	}
 
-	keys, expiry := r.keysFromCache()
+	keys, expiry, lastFetch := r.keysFromCache()
 
 	// Don't check expiry yet. This optimizes for when the provider is unavailable.
-	for _, key := range keys {
-		if keyID == "" || key.KeyID == keyID {
-			if payload, err := jws.Verify(&key); err == nil {
-				return payload, nil
-			}
-		}
+	payload, err := verifyWithKeys(jws, keys, keyID)
+	if err == nil {
+		return payload, nil
 	}
 
-	if !r.now().Add(keysExpiryDelta).After(expiry) {
-		// Keys haven't expired, don't refresh.
-		return nil, errors.New("failed to verify id token signature")
+	// Refresh the keys if they have expired, or if the token was signed with a
+	// key we don't know about since the provider may have rotated its keys.
+	now := r.now()
+	expired := now.Add(keysExpiryDelta).After(expiry)
+	unknownKey := keyID != "" && !hasKeyID(keys, keyID) && now.Sub(lastFetch) >= minRefreshInterval
+	if !expired && !unknownKey {
+		return nil, err
 	}
 
-	keys, err := r.keysFromRemote(ctx)
+	keys, err = r.keysFromRemote(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("fetching keys %v", err)
 	}
 
+	return verifyWithKeys(jws, keys, keyID)
+}
+
+// verifyWithKeys verifies the signature with the key matching keyID, or with any of
+// the keys if the token doesn't name one.
+func verifyWithKeys(jws *jose.JSONWebSignature, keys []jose.JSONWebKey, keyID string) ([]byte, error) {
+	if keyID != "" && !hasKeyID(keys, keyID) {
+		return nil, fmt.Errorf("no key in the key set matches the kid %q of the token", keyID)
+	}
+
 	for _, key := range keys {
 		if keyID == "" || key.KeyID == keyID {
 			if payload, err := jws.Verify(&key); err == nil {
@@ -140,10 +178,36 @@ func (r *remoteKeySet) verify(ctx context.Context, jws *jose.JSONWebSignature) (
 	return nil, errors.New("failed to verify id token signature")
 }
 
-func (r *remoteKeySet) keysFromCache() (keys []jose.JSONWebKey, expiry time.Time) {
+func hasKeyID(keys []jose.JSONWebKey, keyID string) bool {
+	for _, key := range keys {
+		if key.KeyID == keyID {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh fetches the remote keys every interval until the context is canceled.
+// Failures are ignored, the previously cached keys are kept until a fetch succeeds.
+func (r *remoteKeySet) refresh(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		r.keysFromRemote(r.ctx)
+
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *remoteKeySet) keysFromCache() (keys []jose.JSONWebKey, expiry, lastFetch time.Time) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	return r.cachedKeys, r.expiry
+	return r.cachedKeys, r.expiry, r.lastFetch
 }
 
 // keysFromRemote syncs the key set from the remote set, records the values in the
@@ -169,6 +233,7 @@ func (r *remoteKeySet) keysFromRemote(ctx context.Context) ([]jose.JSONWebKey, e
 			r.mu.Lock()
 			defer r.mu.Unlock()
 
+			r.lastFetch = r.now()
 			if err == nil {
 				r.cachedKeys = keys
 				r.expiry = expiry
@@ -190,12 +255,16 @@ func (r *remoteKeySet) keysFromRemote(ctx context.Context) ([]jose.JSONWebKey, e
 }
 
 func (r *remoteKeySet) updateKeys() ([]jose.JSONWebKey, time.Time, error) {
-	req, err := http.NewRequest("GET", r.jwksURL, nil)
+	return fetchKeys(r.ctx, r.jwksURL, r.now)
+}
+
+func fetchKeys(ctx context.Context, jwksURL string, now func() time.Time) ([]jose.JSONWebKey, time.Time, error) {
+	req, err := http.NewRequest("GET", jwksURL, nil)
 	if err != nil {
 		return nil, time.Time{}, fmt.Errorf("oidc: can't create request: %v", err)
 	}
 
-	resp, err := doRequest(r.ctx, req)
+	resp, err := doRequest(ctx, req)
 	if err != nil {
 		return nil, time.Time{}, fmt.Errorf("oidc: get keys failed %v", err)
 	}
@@ -218,7 +287,7 @@ func (r *remoteKeySet) updateKeys() ([]jose.JSONWebKey, time.Time, error) {
 
 	// If the server doesn't provide cache control headers, assume the
 	// keys expire immediately.
-	expiry := r.now()
+	expiry := now()
 
 	_, e, err := cacheutil.CachableResponse(req, resp, cacheutil.Options{})
 	if err == nil && e.After(expiry) {
@@ -0,0 +1,93 @@
+package zcrypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// GCM密文格式：魔数 | 盐 | 随机数 | 密文
+var gcmMagic = []byte("ZGCM1")
+
+const (
+	gcmSaltSize = 16
+	gcmKeySize  = 32
+)
+
+// 使用密码派生的密钥进行AES-GCM加密
+func GcmEncrypt(data []byte, pwd string) ([]byte, error) {
+	if len(pwd) == 0 {
+		return nil, errors.New("缺少密码")
+	}
+
+	salt := make([]byte, gcmSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := newGcm(pwd, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(gcmMagic)+len(salt)+len(nonce)+len(data)+aead.Overhead())
+	out = append(out, gcmMagic...)
+	out = append(out, salt...)
+	out = append(out, nonce...)
+
+	return aead.Seal(out, nonce, data, gcmMagic), nil
+}
+
+// 解密GcmEncrypt生成的密文，密码错误或数据被篡改时返回错误
+func GcmDecrypt(data []byte, pwd string) ([]byte, error) {
+	if len(pwd) == 0 {
+		return nil, errors.New("缺少密码")
+	}
+
+	if !bytes.HasPrefix(data, gcmMagic) || len(data) < len(gcmMagic)+gcmSaltSize {
+		return nil, errors.New("密文格式错误")
+	}
+	data = data[len(gcmMagic):]
+
+	aead, err := newGcm(pwd, data[:gcmSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[gcmSaltSize:]
+
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("密文格式错误")
+	}
+
+	out, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], gcmMagic)
+	if err != nil {
+		return nil, errors.New("密码错误或数据已损坏")
+	}
+
+	return out, nil
+}
+
+// 使用scrypt从密码派生32位密钥
+func newGcm(pwd string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(pwd), salt, 1<<15, 8, 1, gcmKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
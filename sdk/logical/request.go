@@ -1,7 +1,9 @@
 package logical
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 )
@@ -81,6 +83,16 @@ type Request struct {
 	// Storage can be used to durably store and retrieve state.
 	Storage Storage `json:"-" sentinel:""`
 
+	// ResponseWriter, when set, is where the backend streams the body of the
+	// response, which the HTTP front end then does not send. It is only set
+	// on the requests of the paths that stream their response.
+	ResponseWriter *HTTPResponseWriter `json:"-" sentinel:""`
+
+	// RequestReader, when set, is the raw body of the request, which the HTTP
+	// front end then leaves unparsed. It is only set on the requests of the
+	// paths that stream their request.
+	RequestReader io.ReadCloser `json:"-" sentinel:""`
+
 	// Secret will be non-nil only for Revoke and Renew operations
 	// to represent the secret that was returned prior.
 	Secret *Secret `json:"secret" structs:"secret" mapstructure:"secret" sentinel:""`
@@ -284,6 +296,7 @@ const (
 	UpdateOperation                   = "update"
 	DeleteOperation                   = "delete"
 	ListOperation                     = "list"
+	PatchOperation                    = "patch"
 	HelpOperation                     = "help"
 	AliasLookaheadOperation           = "alias-lookahead"
 
@@ -294,3 +307,22 @@ const (
 )
 
 type MFACreds map[string][]string
+
+type drainChKeyType struct{}
+
+// drainChKey is the key used for the context to store the drain channel.
+var drainChKey = drainChKeyType{}
+
+// ContextWithDrainCh returns a copy of ctx carrying a channel that is closed
+// when the node starts to seal or step down. Sealing waits for the in-flight
+// requests, so a request blocking for changes should return once it is closed.
+func ContextWithDrainCh(ctx context.Context, ch <-chan struct{}) context.Context {
+	return context.WithValue(ctx, drainChKey, ch)
+}
+
+// DrainChFromContext returns the channel stored by ContextWithDrainCh, or nil
+// if there is none, which blocks forever.
+func DrainChFromContext(ctx context.Context) <-chan struct{} {
+	ch, _ := ctx.Value(drainChKey).(<-chan struct{})
+	return ch
+}
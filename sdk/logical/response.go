@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"sync/atomic"
 
 	"github.com/jiangjiali/vault/sdk/helper/wrapping"
 )
@@ -177,3 +179,40 @@ func RespondWithStatusCode(resp *Response, req *Request, code int) (*Response, e
 
 	return ret, nil
 }
+
+// HTTPResponseWriter is the writer of the body of a streamed response. It
+// tracks whether the body was written, in which case the HTTP front end
+// sends nothing more.
+type HTTPResponseWriter struct {
+	http.ResponseWriter
+	written uint32
+}
+
+// NewHTTPResponseWriter returns an HTTPResponseWriter for w
+func NewHTTPResponseWriter(w http.ResponseWriter) *HTTPResponseWriter {
+	return &HTTPResponseWriter{
+		ResponseWriter: w,
+	}
+}
+
+// Write writes the body of the response
+func (w *HTTPResponseWriter) Write(bytes []byte) (int, error) {
+	atomic.StoreUint32(&w.written, 1)
+	return w.ResponseWriter.Write(bytes)
+}
+
+// WriteHeader sends the header of the response with the status code
+func (w *HTTPResponseWriter) WriteHeader(statusCode int) {
+	atomic.StoreUint32(&w.written, 1)
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Written returns whether the response was written
+func (w *HTTPResponseWriter) Written() bool {
+	return atomic.LoadUint32(&w.written) == 1
+}
+
+// Unwrap returns the underlying writer, see http.ResponseController
+func (w *HTTPResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,566 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "github.com/jiangjiali/vault/sdk/helper/bbolt"
+	"github.com/jiangjiali/vault/sdk/helper/consts"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/sdk/helper/parseutil"
+	"github.com/jiangjiali/vault/sdk/physical"
+)
+
+const (
+	// databaseFile is the name of the database file in the configured path
+	databaseFile = "vault.db"
+
+	defaultCompactionInterval  = 1 * time.Hour
+	defaultCompactionThreshold = 0.5
+
+	// minCompactionSize avoids rewriting small databases where the reclaimed
+	// space is not worth the write
+	minCompactionSize = 16 * 1024 * 1024
+
+	// compactionTxMaxSize bounds the size of the transactions used to copy the
+	// entries during compaction
+	compactionTxMaxSize = 64 * 1024 * 1024
+)
+
+var dataBucket = []byte("data")
+
+// errClosed is returned when the database could not be reopened after a
+// compaction.
+var errClosed = errors.New("database is closed")
+
+// Verify BoltBackend satisfies the correct interfaces
+var _ physical.Backend = (*BoltBackend)(nil)
+var _ physical.Transactional = (*BoltBackend)(nil)
+
+// BoltBackend is a physical backend that stores the entries in a single
+// embedded B+tree database file. Every write, including a transaction, is
+// committed atomically.
+type BoltBackend struct {
+	// l guards db, which is swapped during compaction. Operations hold the
+	// read lock, the database serializes the write transactions itself.
+	l          sync.RWMutex
+	db         *bolt.DB
+	compactL   sync.Mutex
+	dirtyL     sync.Mutex
+	dirty      map[string]struct{}
+	path       string
+	logger     log.Logger
+	permitPool *physical.PermitPool
+
+	noSync              bool
+	syncInterval        time.Duration
+	compactionInterval  time.Duration
+	compactionThreshold float64
+}
+
+// NewBoltBackend constructs a BoltBackend storing its database file in the
+// configured directory.
+func NewBoltBackend(conf map[string]string, logger log.Logger) (physical.Backend, error) {
+	path, ok := conf["path"]
+	if !ok {
+		return nil, fmt.Errorf("'path' must be set")
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, errwrap.Wrapf("failed to create database directory: {{err}}", err)
+	}
+
+	b := &BoltBackend{
+		path:                filepath.Join(path, databaseFile),
+		logger:              logger,
+		permitPool:          physical.NewPermitPool(physical.DefaultParallelOperations),
+		compactionInterval:  defaultCompactionInterval,
+		compactionThreshold: defaultCompactionThreshold,
+	}
+
+	var err error
+	if raw, ok := conf["no_sync"]; ok {
+		if b.noSync, err = parseutil.ParseBool(raw); err != nil {
+			return nil, errwrap.Wrapf("failed parsing no_sync parameter: {{err}}", err)
+		}
+	}
+	if raw, ok := conf["sync_interval"]; ok {
+		if b.syncInterval, err = parseutil.ParseDurationSecond(raw); err != nil {
+			return nil, errwrap.Wrapf("failed parsing sync_interval parameter: {{err}}", err)
+		}
+		if !b.noSync {
+			return nil, fmt.Errorf("'sync_interval' requires 'no_sync' to be set")
+		}
+	}
+	if raw, ok := conf["compaction_interval"]; ok {
+		if b.compactionInterval, err = parseutil.ParseDurationSecond(raw); err != nil {
+			return nil, errwrap.Wrapf("failed parsing compaction_interval parameter: {{err}}", err)
+		}
+	}
+	if raw, ok := conf["compaction_threshold"]; ok {
+		if b.compactionThreshold, err = strconv.ParseFloat(raw, 64); err != nil {
+			return nil, errwrap.Wrapf("failed parsing compaction_threshold parameter: {{err}}", err)
+		}
+		if b.compactionThreshold <= 0 || b.compactionThreshold >= 1 {
+			return nil, fmt.Errorf("'compaction_threshold' must be between 0 and 1")
+		}
+	}
+	if raw, ok := conf["max_parallel"]; ok {
+		maxParInt, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed parsing max_parallel parameter: {{err}}", err)
+		}
+		b.permitPool = physical.NewPermitPool(maxParInt)
+	}
+
+	if b.db, err = b.open(); err != nil {
+		return nil, err
+	}
+
+	if b.noSync {
+		logger.Warn("fsync is disabled, the last writes may be lost on a crash", "sync_interval", b.syncInterval)
+	}
+	if b.syncInterval > 0 {
+		go b.runSync()
+	}
+	if b.compactionInterval > 0 {
+		go b.runCompaction()
+	}
+
+	return b, nil
+}
+
+func (b *BoltBackend) open() (*bolt.DB, error) {
+	db, err := bolt.Open(b.path, 0600, &bolt.Options{
+		Timeout: 1 * time.Second,
+		NoSync:  b.noSync,
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to open %q: {{err}}", b.path), err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dataBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Put is used to insert or update an entry
+func (b *BoltBackend) Put(ctx context.Context, entry *physical.Entry) error {
+	return b.Transaction(ctx, []*physical.TxnEntry{
+		{
+			Operation: physical.PutOperation,
+			Entry:     entry,
+		},
+	})
+}
+
+// Get is used to fetch an entry
+func (b *BoltBackend) Get(ctx context.Context, key string) (*physical.Entry, error) {
+	if err := validateKey(key); err != nil {
+		return nil, err
+	}
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.db == nil {
+		return nil, errClosed
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var entry *physical.Entry
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(dataBucket).Get([]byte(key)); value != nil {
+			// The value is only valid for the life of the transaction
+			entry = &physical.Entry{
+				Key:   key,
+				Value: append([]byte(nil), value...),
+			}
+		}
+		return nil
+	})
+	return entry, err
+}
+
+// Delete is used to permanently delete an entry
+func (b *BoltBackend) Delete(ctx context.Context, key string) error {
+	return b.Transaction(ctx, []*physical.TxnEntry{
+		{
+			Operation: physical.DeleteOperation,
+			Entry: &physical.Entry{
+				Key: key,
+			},
+		},
+	})
+}
+
+// List is used to list all the keys under a given prefix, up to the next
+// prefix.
+func (b *BoltBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := validateKey(prefix); err != nil {
+		return nil, err
+	}
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.db == nil {
+		return nil, errClosed
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+
+	var keys []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(dataBucket).Cursor()
+		k, _ := cursor.Seek([]byte(prefix))
+		for k != nil && bytes.HasPrefix(k, []byte(prefix)) {
+			key := string(k[len(prefix):])
+			i := strings.Index(key, "/")
+			if i == -1 {
+				keys = append(keys, key)
+				k, _ = cursor.Next()
+				continue
+			}
+
+			// Skip every key under the folder at once, '0' is the
+			// character right after '/'
+			keys = append(keys, key[:i+1])
+			k, _ = cursor.Seek([]byte(prefix + key[:i] + "0"))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Transaction applies all the operations in a single database transaction,
+// either all of them are committed or none is.
+func (b *BoltBackend) Transaction(ctx context.Context, txns []*physical.TxnEntry) error {
+	if len(txns) == 0 {
+		return nil
+	}
+	for _, txn := range txns {
+		if err := validateKey(txn.Entry.Key); err != nil {
+			return err
+		}
+	}
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.db == nil {
+		return errClosed
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	b.markDirty(txns)
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dataBucket)
+		for _, txn := range txns {
+			var err error
+			switch txn.Operation {
+			case physical.PutOperation:
+				err = bucket.Put([]byte(txn.Entry.Key), txn.Entry.Value)
+			case physical.DeleteOperation:
+				err = bucket.Delete([]byte(txn.Entry.Key))
+			default:
+				err = fmt.Errorf("%q is not a supported transaction operation", txn.Operation)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Compact rewrites the database file without its free pages. The entries are
+// copied in short read transactions while the writes go on, the keys written
+// in the meantime are tracked and copied again once the operations are
+// blocked, right before the compacted file replaces the database.
+func (b *BoltBackend) Compact(ctx context.Context) error {
+	b.compactL.Lock()
+	defer b.compactL.Unlock()
+
+	// Track the writes before the copy starts, the writes that did not see
+	// the tracking are over once the lock is acquired
+	b.l.Lock()
+	if b.db == nil {
+		b.l.Unlock()
+		return errClosed
+	}
+	b.dirtyL.Lock()
+	b.dirty = make(map[string]struct{})
+	b.dirtyL.Unlock()
+	b.l.Unlock()
+
+	defer func() {
+		b.dirtyL.Lock()
+		b.dirty = nil
+		b.dirtyL.Unlock()
+	}()
+
+	tmpPath := b.path + ".compact"
+	if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	dst, err := bolt.Open(tmpPath, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return errwrap.Wrapf("failed to create compacted database: {{err}}", err)
+	}
+	if err := b.copyEntries(ctx, dst); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return errwrap.Wrapf("failed to compact database: {{err}}", err)
+	}
+
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if err := b.copyDirty(dst); err != nil {
+		dst.Close()
+		os.Remove(tmpPath)
+		return errwrap.Wrapf("failed to compact database: {{err}}", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := b.db.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	b.db = nil
+
+	renameErr := os.Rename(tmpPath, b.path)
+	if renameErr != nil {
+		os.Remove(tmpPath)
+	}
+
+	// Reopen whichever file is in place, the original one if the rename
+	// failed
+	if b.db, err = b.open(); err != nil {
+		b.logger.Error("failed to reopen database after compaction", "error", err)
+		return err
+	}
+	if renameErr != nil {
+		return errwrap.Wrapf("failed to replace database with the compacted one: {{err}}", renameErr)
+	}
+
+	return nil
+}
+
+// copyEntries copies the entries of the database to dst, up to
+// compactionTxMaxSize bytes per transaction. Each batch is read in its own
+// transaction so that a long copy does not keep the database from growing.
+func (b *BoltBackend) copyEntries(ctx context.Context, dst *bolt.DB) error {
+	err := dst.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dataBucket)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var last []byte
+	for done := false; !done; {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		b.l.RLock()
+		if b.db == nil {
+			b.l.RUnlock()
+			return errClosed
+		}
+		err := b.db.View(func(src *bolt.Tx) error {
+			return dst.Update(func(tx *bolt.Tx) error {
+				bucket := tx.Bucket(dataBucket)
+				cursor := src.Bucket(dataBucket).Cursor()
+
+				var k, v []byte
+				if last == nil {
+					k, v = cursor.First()
+				} else if k, v = cursor.Seek(last); k != nil && bytes.Equal(k, last) {
+					k, v = cursor.Next()
+				}
+
+				var size int64
+				for ; k != nil; k, v = cursor.Next() {
+					if size > 0 && size+int64(len(k)+len(v)) > compactionTxMaxSize {
+						return nil
+					}
+					if err := bucket.Put(k, v); err != nil {
+						return err
+					}
+					size += int64(len(k) + len(v))
+					last = append(last[:0], k...)
+				}
+				done = true
+				return nil
+			})
+		})
+		b.l.RUnlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyDirty copies the current state of the keys written since the compaction
+// started to dst. The caller must hold the write lock.
+func (b *BoltBackend) copyDirty(dst *bolt.DB) error {
+	b.dirtyL.Lock()
+	defer b.dirtyL.Unlock()
+
+	if len(b.dirty) == 0 {
+		return nil
+	}
+
+	return b.db.View(func(src *bolt.Tx) error {
+		return dst.Update(func(tx *bolt.Tx) error {
+			from := src.Bucket(dataBucket)
+			bucket := tx.Bucket(dataBucket)
+			for key := range b.dirty {
+				var err error
+				if v := from.Get([]byte(key)); v != nil {
+					err = bucket.Put([]byte(key), v)
+				} else {
+					err = bucket.Delete([]byte(key))
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// markDirty records the keys of the operations while a compaction copies the
+// entries, so that they are copied again before the database is swapped.
+func (b *BoltBackend) markDirty(txns []*physical.TxnEntry) {
+	b.dirtyL.Lock()
+	defer b.dirtyL.Unlock()
+
+	if b.dirty == nil {
+		return
+	}
+	for _, txn := range txns {
+		b.dirty[txn.Entry.Key] = struct{}{}
+	}
+}
+
+// needsCompaction returns whether the free space of the database file is above
+// the compaction threshold.
+func (b *BoltBackend) needsCompaction() (bool, error) {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.db == nil {
+		return false, errClosed
+	}
+
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return false, err
+	}
+	if fi.Size() < minCompactionSize {
+		return false, nil
+	}
+
+	stats := b.db.Stats()
+	free := int64(stats.FreePageN+stats.PendingPageN) * int64(b.db.Info().PageSize)
+	return float64(free)/float64(fi.Size()) >= b.compactionThreshold, nil
+}
+
+func (b *BoltBackend) runCompaction() {
+	ticker := time.NewTicker(b.compactionInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		compact, err := b.needsCompaction()
+		if err != nil {
+			b.logger.Error("failed to check database free space", "error", err)
+			continue
+		}
+		if !compact {
+			continue
+		}
+
+		start := time.Now()
+		if err := b.Compact(context.Background()); err != nil {
+			b.logger.Error("database compaction failed", "error", err)
+			continue
+		}
+		b.logger.Info("compacted database", "duration", time.Since(start))
+	}
+}
+
+func (b *BoltBackend) runSync() {
+	ticker := time.NewTicker(b.syncInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		b.l.RLock()
+		var err error
+		if b.db != nil {
+			err = b.db.Sync()
+		}
+		b.l.RUnlock()
+		if err != nil {
+			b.logger.Error("failed to sync database", "error", err)
+		}
+	}
+}
+
+func validateKey(key string) error {
+	if strings.Contains(key, "..") {
+		return consts.ErrPathContainsParentReferences
+	}
+	return nil
+}
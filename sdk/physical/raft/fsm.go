@@ -0,0 +1,243 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	bolt "github.com/jiangjiali/vault/sdk/helper/bbolt"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/sdk/helper/raft"
+	"github.com/jiangjiali/vault/sdk/physical"
+)
+
+const (
+	putOp    = "put"
+	deleteOp = "delete"
+)
+
+var dataBucket = []byte("data")
+
+// Verify FSM satisfies the correct interfaces
+var _ raft.FSM = (*FSM)(nil)
+
+// LogOperation is a single storage operation carried by a raft log entry.
+type LogOperation struct {
+	OpType string `json:"op"`
+	Key    string `json:"key"`
+	Value  []byte `json:"value,omitempty"`
+}
+
+// LogData is the payload of a raft log entry. All the operations of an entry
+// are applied to the FSM atomically.
+type LogData struct {
+	Operations []*LogOperation `json:"operations"`
+}
+
+// FSM is the raft finite state machine. It stores the storage entries in a
+// bolt database so they can be read without going through raft.
+type FSM struct {
+	db     *bolt.DB
+	logger log.Logger
+}
+
+// NewFSM opens or creates the bolt database at the given path.
+func NewFSM(path string, logger log.Logger) (*FSM, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(dataBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &FSM{
+		db:     db,
+		logger: logger,
+	}, nil
+}
+
+// Close closes the underlying database.
+func (f *FSM) Close() error {
+	return f.db.Close()
+}
+
+// Get returns the entry stored locally for the key.
+func (f *FSM) Get(ctx context.Context, key string) (*physical.Entry, error) {
+	var entry *physical.Entry
+	err := f.db.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket(dataBucket).Get([]byte(key)); value != nil {
+			entry = &physical.Entry{
+				Key:   key,
+				Value: append([]byte(nil), value...),
+			}
+		}
+		return nil
+	})
+	return entry, err
+}
+
+// List returns the keys stored locally under the prefix, up to the next
+// prefix.
+func (f *FSM) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := f.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(dataBucket).Cursor()
+		for k, _ := cursor.Seek([]byte(prefix)); k != nil && bytes.HasPrefix(k, []byte(prefix)); k, _ = cursor.Next() {
+			key := string(k[len(prefix):])
+			if i := strings.Index(key, "/"); i != -1 {
+				// Keys are sorted, so entries under the same folder are
+				// adjacent
+				key = key[:i+1]
+				if len(keys) > 0 && keys[len(keys)-1] == key {
+					continue
+				}
+			}
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// Apply applies a committed raft log entry to the FSM. It returns the error
+// of the operations, if any.
+func (f *FSM) Apply(l *raft.Log) interface{} {
+	var data LogData
+	if err := json.Unmarshal(l.Data, &data); err != nil {
+		f.logger.Error("failed to decode log entry", "index", l.Index, "error", err)
+		return err
+	}
+
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(dataBucket)
+		for _, op := range data.Operations {
+			var err error
+			switch op.OpType {
+			case putOp:
+				err = bucket.Put([]byte(op.Key), op.Value)
+			case deleteOp:
+				err = bucket.Delete([]byte(op.Key))
+			default:
+				err = fmt.Errorf("unknown operation type %q", op.OpType)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		f.logger.Error("failed to apply log entry", "index", l.Index, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// Snapshot returns a snapshot of the entries as of the last applied log
+// entry. Apply is not called concurrently, so the read transaction opened
+// here captures exactly that state.
+func (f *FSM) Snapshot() (raft.FSMSnapshot, error) {
+	tx, err := f.db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fsmSnapshot{
+		tx: tx,
+	}, nil
+}
+
+// Restore replaces all the entries of the FSM with those of the snapshot.
+func (f *FSM) Restore(rc io.ReadCloser) error {
+	defer rc.Close()
+
+	err := f.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(dataBucket); err != nil {
+			return err
+		}
+		bucket, err := tx.CreateBucket(dataBucket)
+		if err != nil {
+			return err
+		}
+
+		return readSnapshot(rc, func(entry *physical.Entry) error {
+			return bucket.Put([]byte(entry.Key), entry.Value)
+		})
+	})
+	if err != nil {
+		f.logger.Error("failed to restore snapshot", "error", err)
+		return errwrap.Wrapf("failed to restore snapshot: {{err}}", err)
+	}
+
+	f.logger.Info("snapshot restored")
+	return nil
+}
+
+// fsmSnapshot writes the entries visible to the read transaction as a stream
+// of JSON objects.
+type fsmSnapshot struct {
+	tx *bolt.Tx
+}
+
+func (s *fsmSnapshot) Persist(sink raft.SnapshotSink) error {
+	err := writeSnapshot(s.tx, sink)
+	if err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s *fsmSnapshot) Release() {
+	s.tx.Rollback()
+}
+
+type snapshotEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+func writeSnapshot(tx *bolt.Tx, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	return tx.Bucket(dataBucket).ForEach(func(k, v []byte) error {
+		return enc.Encode(&snapshotEntry{
+			Key:   string(k),
+			Value: v,
+		})
+	})
+}
+
+func readSnapshot(r io.Reader, f func(*physical.Entry) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var entry snapshotEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.Key == "" {
+			return errors.New("snapshot contains an entry without a key")
+		}
+
+		if err := f(&physical.Entry{Key: entry.Key, Value: entry.Value}); err != nil {
+			return err
+		}
+	}
+}
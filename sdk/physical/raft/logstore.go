@@ -0,0 +1,196 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	bolt "github.com/jiangjiali/vault/sdk/helper/bbolt"
+	"github.com/jiangjiali/vault/sdk/helper/msgpack/codec"
+	"github.com/jiangjiali/vault/sdk/helper/raft"
+)
+
+var (
+	logsBucket   = []byte("logs")
+	stableBucket = []byte("conf")
+
+	// errKeyNotFound is returned by the stable store for missing keys, the
+	// raft library compares the error message to detect this case.
+	errKeyNotFound = errors.New("not found")
+)
+
+// Verify LogStore satisfies the correct interfaces
+var _ raft.LogStore = (*LogStore)(nil)
+var _ raft.StableStore = (*LogStore)(nil)
+
+// LogStore stores the raft log and the raft stable state, such as the
+// current term and the last vote, in a bolt database.
+type LogStore struct {
+	db *bolt.DB
+}
+
+// NewLogStore opens or creates the bolt database at the given path.
+func NewLogStore(path string) (*LogStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(logsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(stableBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &LogStore{
+		db: db,
+	}, nil
+}
+
+// Close closes the underlying database.
+func (s *LogStore) Close() error {
+	return s.db.Close()
+}
+
+// FirstIndex returns the first index written, 0 for no entries.
+func (s *LogStore) FirstIndex() (uint64, error) {
+	var index uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if first, _ := tx.Bucket(logsBucket).Cursor().First(); first != nil {
+			index = bytesToUint64(first)
+		}
+		return nil
+	})
+	return index, err
+}
+
+// LastIndex returns the last index written, 0 for no entries.
+func (s *LogStore) LastIndex() (uint64, error) {
+	var index uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if last, _ := tx.Bucket(logsBucket).Cursor().Last(); last != nil {
+			index = bytesToUint64(last)
+		}
+		return nil
+	})
+	return index, err
+}
+
+// GetLog gets the log entry at the given index.
+func (s *LogStore) GetLog(index uint64, log *raft.Log) error {
+	var value []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(logsBucket).Get(uint64ToBytes(index)); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if value == nil {
+		return raft.ErrLogNotFound
+	}
+
+	return decodeMsgpack(value, log)
+}
+
+// StoreLog stores a single log entry.
+func (s *LogStore) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores the log entries in a single transaction.
+func (s *LogStore) StoreLogs(logs []*raft.Log) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(logsBucket)
+		for _, log := range logs {
+			value, err := encodeMsgpack(log)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(uint64ToBytes(log.Index), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteRange deletes the log entries between min and max, inclusive.
+func (s *LogStore) DeleteRange(min, max uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(logsBucket).Cursor()
+		for k, _ := cursor.Seek(uint64ToBytes(min)); k != nil; k, _ = cursor.Next() {
+			if bytesToUint64(k) > max {
+				break
+			}
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Set stores a key of the stable state.
+func (s *LogStore) Set(key []byte, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(stableBucket).Put(key, value)
+	})
+}
+
+// Get returns the value of a key of the stable state.
+func (s *LogStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(stableBucket).Get(key); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	if value == nil {
+		return nil, errKeyNotFound
+	}
+	return value, nil
+}
+
+// SetUint64 stores an integer key of the stable state.
+func (s *LogStore) SetUint64(key []byte, value uint64) error {
+	return s.Set(key, uint64ToBytes(value))
+}
+
+// GetUint64 returns the value of an integer key of the stable state.
+func (s *LogStore) GetUint64(key []byte) (uint64, error) {
+	value, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return bytesToUint64(value), nil
+}
+
+func decodeMsgpack(buf []byte, out interface{}) error {
+	handle := codec.MsgpackHandle{}
+	return codec.NewDecoder(bytes.NewReader(buf), &handle).Decode(out)
+}
+
+func encodeMsgpack(in interface{}) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	handle := codec.MsgpackHandle{}
+	err := codec.NewEncoder(buf, &handle).Encode(in)
+	return buf.Bytes(), err
+}
+
+func bytesToUint64(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+func uint64ToBytes(u uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, u)
+	return buf
+}
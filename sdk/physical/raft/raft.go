@@ -0,0 +1,728 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/sdk/helper/raft"
+	"github.com/jiangjiali/vault/sdk/helper/xxuuid"
+	"github.com/jiangjiali/vault/sdk/physical"
+)
+
+const (
+	// defaultRaftAddr is the address the raft transport listens on when
+	// none is configured, next to the default API and cluster ports.
+	defaultRaftAddr = "127.0.0.1:8202"
+
+	// applyTimeout bounds the time a write waits to be committed.
+	applyTimeout = 10 * time.Second
+
+	// snapshotsRetained is the number of raft snapshots kept on disk.
+	snapshotsRetained = 2
+
+	raftTransportTimeout = 10 * time.Second
+	raftTransportMaxPool = 3
+)
+
+// ErrNotLeader is returned for writes made on a node that is not the raft
+// leader. In a Vault cluster only the active node, which holds the raft
+// leadership, writes to storage.
+var ErrNotLeader = errors.New("raft storage: not the leader")
+
+// ErrNotInitialized is returned for writes made before the node has
+// bootstrapped or joined a cluster and started raft.
+var ErrNotInitialized = errors.New("raft storage: cluster is not running")
+
+// Verify RaftBackend satisfies the correct interfaces
+var _ physical.Backend = (*RaftBackend)(nil)
+var _ physical.Transactional = (*RaftBackend)(nil)
+var _ physical.HABackend = (*RaftBackend)(nil)
+var _ physical.Lock = (*RaftLock)(nil)
+
+// RaftBackend is a physical backend replicated to the other nodes of the
+// cluster through raft. Entries are stored locally in a bolt database, so
+// reads never leave the node, while writes are committed through the raft
+// log and are only accepted on the leader.
+type RaftBackend struct {
+	logger log.Logger
+
+	// l protects the raft state, which is created when the cluster is set
+	// up and released when it is torn down.
+	l sync.RWMutex
+
+	fsm       *FSM
+	logStore  *LogStore
+	snapStore raft.SnapshotStore
+
+	raft        *raft.Raft
+	transport   *raft.NetworkTransport
+	leaderLost  chan struct{}
+	monitorStop chan struct{}
+
+	dataDir   string
+	localID   string
+	raftAddr  string
+	tlsConfig *tlsConfigFiles
+
+	snapshotThreshold uint64
+	trailingLogs      uint64
+}
+
+type tlsConfigFiles struct {
+	certFile, keyFile, caFile string
+}
+
+// Peer is a member of the raft cluster.
+type Peer struct {
+	ID      string `json:"node_id"`
+	Address string `json:"address"`
+}
+
+// RaftServer describes a member of the raft configuration.
+type RaftServer struct {
+	NodeID  string `json:"node_id"`
+	Address string `json:"address"`
+	Leader  bool   `json:"leader"`
+	Voter   bool   `json:"voter"`
+}
+
+// NewRaftBackend constructs a RaftBackend storing its data under the
+// configured path.
+func NewRaftBackend(conf map[string]string, logger log.Logger) (physical.Backend, error) {
+	path, ok := conf["path"]
+	if !ok {
+		return nil, fmt.Errorf("'path' must be set")
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, errwrap.Wrapf("failed to create raft data directory: {{err}}", err)
+	}
+
+	localID, err := nodeID(conf, path)
+	if err != nil {
+		return nil, err
+	}
+
+	raftAddr := conf["raft_addr"]
+	if raftAddr == "" {
+		raftAddr = defaultRaftAddr
+	}
+
+	tlsFiles := &tlsConfigFiles{
+		certFile: conf["tls_cert_file"],
+		keyFile:  conf["tls_key_file"],
+		caFile:   conf["tls_ca_file"],
+	}
+	// Validate the files up front rather than on unseal
+	tlsConfig, err := loadTLSConfig(tlsFiles.certFile, tlsFiles.keyFile, tlsFiles.caFile)
+	if err != nil {
+		return nil, err
+	}
+	// Anyone reaching the raft address could append to the log without the
+	// mutual TLS, so it is only optional for a node reachable locally
+	if tlsConfig == nil && !loopbackAddr(raftAddr) {
+		return nil, errors.New("'tls_cert_file', 'tls_key_file' and 'tls_ca_file' must be set when 'raft_addr' is not a loopback address")
+	}
+
+	var snapshotThreshold, trailingLogs uint64
+	if raw, ok := conf["snapshot_threshold"]; ok {
+		if snapshotThreshold, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			return nil, errwrap.Wrapf("failed parsing snapshot_threshold parameter: {{err}}", err)
+		}
+	}
+	if raw, ok := conf["trailing_logs"]; ok {
+		if trailingLogs, err = strconv.ParseUint(raw, 10, 64); err != nil {
+			return nil, errwrap.Wrapf("failed parsing trailing_logs parameter: {{err}}", err)
+		}
+	}
+
+	fsm, err := NewFSM(filepath.Join(path, "vault.db"), logger.Named("fsm"))
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to open fsm database: {{err}}", err)
+	}
+
+	logStore, err := NewLogStore(filepath.Join(path, "raft.db"))
+	if err != nil {
+		fsm.Close()
+		return nil, errwrap.Wrapf("failed to open raft log database: {{err}}", err)
+	}
+
+	snapStore, err := raft.NewFileSnapshotStoreWithLogger(path, snapshotsRetained, logger.Named("snapshot").StandardLogger(nil))
+	if err != nil {
+		fsm.Close()
+		logStore.Close()
+		return nil, errwrap.Wrapf("failed to create snapshot store: {{err}}", err)
+	}
+
+	return &RaftBackend{
+		logger:            logger,
+		fsm:               fsm,
+		logStore:          logStore,
+		snapStore:         snapStore,
+		dataDir:           path,
+		localID:           localID,
+		raftAddr:          raftAddr,
+		tlsConfig:         tlsFiles,
+		snapshotThreshold: snapshotThreshold,
+		trailingLogs:      trailingLogs,
+	}, nil
+}
+
+// loopbackAddr returns whether the host of addr is a loopback address.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// nodeID returns the configured node ID, or the one generated the first time
+// the backend was created with this data directory.
+func nodeID(conf map[string]string, path string) (string, error) {
+	if id := conf["node_id"]; id != "" {
+		return id, nil
+	}
+
+	idPath := filepath.Join(path, "node-id")
+	raw, err := ioutil.ReadFile(idPath)
+	switch {
+	case err == nil:
+		return strings.TrimSpace(string(raw)), nil
+	case !os.IsNotExist(err):
+		return "", errwrap.Wrapf("failed to read node id: {{err}}", err)
+	}
+
+	id, err := xxuuid.GenerateUUID()
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(idPath, []byte(id), 0600); err != nil {
+		return "", errwrap.Wrapf("failed to write node id: {{err}}", err)
+	}
+	return id, nil
+}
+
+// NodeID returns the ID of this node in the raft configuration.
+func (b *RaftBackend) NodeID() string {
+	return b.localID
+}
+
+// RaftAddr returns the address other nodes use to reach this node.
+func (b *RaftBackend) RaftAddr() string {
+	return b.raftAddr
+}
+
+// Initialized reports whether the node has raft state, i.e. whether it was
+// bootstrapped or has joined a cluster.
+func (b *RaftBackend) Initialized() (bool, error) {
+	return raft.HasExistingState(b.logStore, b.logStore, b.snapStore)
+}
+
+func (b *RaftBackend) raftConfig() *raft.Config {
+	config := raft.DefaultConfig()
+	config.LocalID = raft.ServerID(b.localID)
+	config.Logger = b.logger.Named("raft")
+	if b.snapshotThreshold > 0 {
+		config.SnapshotThreshold = b.snapshotThreshold
+	}
+	if b.trailingLogs > 0 {
+		config.TrailingLogs = b.trailingLogs
+	}
+	return config
+}
+
+// Bootstrap writes the initial raft configuration with the given peers. It
+// is used once, on the node initializing a new cluster.
+func (b *RaftBackend) Bootstrap(ctx context.Context, peers []Peer) error {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if b.raft != nil {
+		return errors.New("raft storage: cluster is already running")
+	}
+
+	configuration := raft.Configuration{}
+	for _, peer := range peers {
+		configuration.Servers = append(configuration.Servers, raft.Server{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(peer.ID),
+			Address:  raft.ServerAddress(peer.Address),
+		})
+	}
+
+	return raft.BootstrapCluster(b.raftConfig(), b.logStore, b.logStore, b.snapStore, nil, configuration)
+}
+
+// SetupCluster starts raft on this node. The node either has existing state
+// or is waiting to be added to a cluster by its leader.
+func (b *RaftBackend) SetupCluster(ctx context.Context) error {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if b.raft != nil {
+		return nil
+	}
+
+	tlsConfig, err := loadTLSConfig(b.tlsConfig.certFile, b.tlsConfig.keyFile, b.tlsConfig.caFile)
+	if err != nil {
+		return err
+	}
+
+	stream, err := newStreamLayer(b.raftAddr, tlsConfig)
+	if err != nil {
+		return errwrap.Wrapf("failed to listen on raft address: {{err}}", err)
+	}
+	transport := raft.NewNetworkTransportWithConfig(&raft.NetworkTransportConfig{
+		Logger:  b.logger.Named("transport").StandardLogger(nil),
+		Stream:  stream,
+		MaxPool: raftTransportMaxPool,
+		Timeout: raftTransportTimeout,
+	})
+
+	notifyCh := make(chan bool, 1)
+	config := b.raftConfig()
+	config.NotifyCh = notifyCh
+
+	r, err := raft.NewRaft(config, b.fsm, b.logStore, b.logStore, b.snapStore, transport)
+	if err != nil {
+		transport.Close()
+		return errwrap.Wrapf("failed to start raft: {{err}}", err)
+	}
+
+	b.raft = r
+	b.transport = transport
+	b.monitorStop = make(chan struct{})
+	go b.monitorLeadership(notifyCh, b.monitorStop)
+
+	b.logger.Info("raft started", "node_id", b.localID, "address", b.raftAddr)
+	return nil
+}
+
+// TeardownCluster stops raft on this node. Entries can still be read from
+// the local database.
+func (b *RaftBackend) TeardownCluster() error {
+	b.l.Lock()
+	r, transport, monitorStop := b.raft, b.transport, b.monitorStop
+	b.l.Unlock()
+
+	if r == nil {
+		return nil
+	}
+
+	// Shut down without holding the lock, raft notifies the leadership
+	// monitor on the way out
+	err := r.Shutdown().Error()
+	transport.Close()
+	close(monitorStop)
+
+	b.l.Lock()
+	if b.leaderLost != nil {
+		close(b.leaderLost)
+		b.leaderLost = nil
+	}
+	b.raft = nil
+	b.transport = nil
+	b.l.Unlock()
+
+	b.logger.Info("raft stopped")
+	return err
+}
+
+// monitorLeadership tracks the leadership of this node, leaderLost is open
+// for as long as the node is the leader.
+func (b *RaftBackend) monitorLeadership(notifyCh <-chan bool, stopCh <-chan struct{}) {
+	for {
+		select {
+		case isLeader := <-notifyCh:
+			b.l.Lock()
+			switch {
+			case isLeader && b.leaderLost == nil:
+				b.leaderLost = make(chan struct{})
+				b.logger.Info("acquired raft leadership")
+			case !isLeader && b.leaderLost != nil:
+				close(b.leaderLost)
+				b.leaderLost = nil
+				b.logger.Info("lost raft leadership")
+			}
+			b.l.Unlock()
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// IsLeader reports whether this node is the raft leader.
+func (b *RaftBackend) IsLeader() bool {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	return b.raft != nil && b.raft.State() == raft.Leader
+}
+
+// WaitForLeader blocks until this node is the raft leader or the context is
+// done.
+func (b *RaftBackend) WaitForLeader(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for !b.IsLeader() {
+		select {
+		case <-ctx.Done():
+			return errwrap.Wrapf("raft storage: timed out waiting for leadership: {{err}}", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// AddPeer adds a voter to the raft configuration.
+func (b *RaftBackend) AddPeer(ctx context.Context, peerID, addr string) error {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.raft == nil {
+		return ErrNotInitialized
+	}
+
+	b.logger.Info("adding raft peer", "node_id", peerID, "address", addr)
+	return b.raft.AddVoter(raft.ServerID(peerID), raft.ServerAddress(addr), 0, applyTimeout).Error()
+}
+
+// RemovePeer removes a server from the raft configuration.
+func (b *RaftBackend) RemovePeer(ctx context.Context, peerID string) error {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.raft == nil {
+		return ErrNotInitialized
+	}
+
+	b.logger.Info("removing raft peer", "node_id", peerID)
+	return b.raft.RemoveServer(raft.ServerID(peerID), 0, applyTimeout).Error()
+}
+
+// GetConfiguration returns the servers of the raft configuration.
+func (b *RaftBackend) GetConfiguration(ctx context.Context) ([]*RaftServer, error) {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.raft == nil {
+		return nil, ErrNotInitialized
+	}
+
+	future := b.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+
+	leader := b.raft.Leader()
+	var servers []*RaftServer
+	for _, server := range future.Configuration().Servers {
+		servers = append(servers, &RaftServer{
+			NodeID:  string(server.ID),
+			Address: string(server.Address),
+			Leader:  server.Address == leader,
+			Voter:   server.Suffrage == raft.Voter,
+		})
+	}
+	return servers, nil
+}
+
+// Peers returns the members of the raft configuration.
+func (b *RaftBackend) Peers(ctx context.Context) ([]Peer, error) {
+	servers, err := b.GetConfiguration(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	peers := make([]Peer, 0, len(servers))
+	for _, server := range servers {
+		peers = append(peers, Peer{
+			ID:      server.NodeID,
+			Address: server.Address,
+		})
+	}
+	return peers, nil
+}
+
+// Snapshot writes the entries stored on this node to w.
+func (b *RaftBackend) Snapshot(w io.Writer) error {
+	snapshot, err := b.fsm.Snapshot()
+	if err != nil {
+		return err
+	}
+	defer snapshot.Release()
+
+	return writeSnapshot(snapshot.(*fsmSnapshot).tx, w)
+}
+
+// RestoreSnapshot replaces the entries of the whole cluster with those of the
+// snapshot. It must be called on the leader.
+func (b *RaftBackend) RestoreSnapshot(ctx context.Context, r io.Reader) error {
+	// Validate the snapshot and spool it to disk, raft needs to know its size
+	// up front
+	f, err := ioutil.TempFile(b.dataDir, "restore-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	enc := json.NewEncoder(f)
+	err = readSnapshot(r, func(entry *physical.Entry) error {
+		return enc.Encode(&snapshotEntry{
+			Key:   entry.Key,
+			Value: entry.Value,
+		})
+	})
+	if err != nil {
+		return errwrap.Wrapf("invalid snapshot: {{err}}", err)
+	}
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.raft == nil {
+		return ErrNotInitialized
+	}
+	if b.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	meta := &raft.SnapshotMeta{
+		Version: raft.SnapshotVersionMax,
+		Size:    size,
+	}
+	return b.raft.Restore(meta, f, 0)
+}
+
+// Put is used to insert or update an entry
+func (b *RaftBackend) Put(ctx context.Context, entry *physical.Entry) error {
+	return b.applyLog(ctx, &LogData{
+		Operations: []*LogOperation{
+			{
+				OpType: putOp,
+				Key:    entry.Key,
+				Value:  entry.Value,
+			},
+		},
+	})
+}
+
+// Get is used to fetch an entry
+func (b *RaftBackend) Get(ctx context.Context, key string) (*physical.Entry, error) {
+	return b.fsm.Get(ctx, key)
+}
+
+// Delete is used to permanently delete an entry
+func (b *RaftBackend) Delete(ctx context.Context, key string) error {
+	return b.applyLog(ctx, &LogData{
+		Operations: []*LogOperation{
+			{
+				OpType: deleteOp,
+				Key:    key,
+			},
+		},
+	})
+}
+
+// List is used to list all the keys under a given prefix, up to the next
+// prefix.
+func (b *RaftBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	return b.fsm.List(ctx, prefix)
+}
+
+// Transaction applies all the operations in a single raft log entry, so they
+// are committed and applied atomically.
+func (b *RaftBackend) Transaction(ctx context.Context, txns []*physical.TxnEntry) error {
+	data := &LogData{
+		Operations: make([]*LogOperation, 0, len(txns)),
+	}
+	for _, txn := range txns {
+		op := &LogOperation{
+			Key: txn.Entry.Key,
+		}
+		switch txn.Operation {
+		case physical.PutOperation:
+			op.OpType = putOp
+			op.Value = txn.Entry.Value
+		case physical.DeleteOperation:
+			op.OpType = deleteOp
+		default:
+			return fmt.Errorf("%q is not a supported transaction operation", txn.Operation)
+		}
+		data.Operations = append(data.Operations, op)
+	}
+
+	return b.applyLog(ctx, data)
+}
+
+// applyLog commits the operations through raft and waits for them to be
+// applied to the local FSM.
+func (b *RaftBackend) applyLog(ctx context.Context, data *LogData) error {
+	if len(data.Operations) == 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	cmd, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.raft == nil {
+		return ErrNotInitialized
+	}
+	if b.raft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+
+	future := b.raft.Apply(cmd, applyTimeout)
+	if err := future.Error(); err != nil {
+		return err
+	}
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+
+	return nil
+}
+
+// HAEnabled indicates whether the HA functionality should be exposed.
+func (b *RaftBackend) HAEnabled() bool {
+	return true
+}
+
+// LockWith is used for mutual exclusion based on the given key. The lock can
+// only be held by the raft leader.
+func (b *RaftBackend) LockWith(key, value string) (physical.Lock, error) {
+	return &RaftLock{
+		key:   key,
+		value: value,
+		b:     b,
+	}, nil
+}
+
+// leaderLostCh returns the channel closed when the leadership is lost, or nil
+// if this node is not the leader.
+func (b *RaftBackend) leaderLostCh() <-chan struct{} {
+	b.l.RLock()
+	defer b.l.RUnlock()
+
+	if b.raft == nil || b.leaderLost == nil {
+		return nil
+	}
+	return b.leaderLost
+}
+
+// RaftLock implements the physical.Lock interface on top of the raft
+// leadership. The node holding the leadership writes the lock value through
+// raft so that the other nodes can find the active node.
+type RaftLock struct {
+	key   string
+	value string
+	b     *RaftBackend
+}
+
+// Lock blocks until this node is the raft leader, then records the lock
+// value. The returned channel is closed when the leadership is lost.
+func (l *RaftLock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		if leaderLost := l.b.leaderLostCh(); leaderLost != nil {
+			err := l.b.Put(context.Background(), &physical.Entry{
+				Key:   l.key,
+				Value: []byte(l.value),
+			})
+			if err == nil {
+				return leaderLost, nil
+			}
+			l.b.logger.Error("failed to write lock value", "error", err)
+		}
+
+		select {
+		case <-stopCh:
+			return nil, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Unlock clears the lock value and hands the leadership over to another
+// node, if there is one, so that it can become active.
+func (l *RaftLock) Unlock() error {
+	if l.b.leaderLostCh() == nil {
+		return nil
+	}
+
+	if err := l.b.Delete(context.Background(), l.key); err != nil {
+		return err
+	}
+
+	l.b.l.RLock()
+	defer l.b.l.RUnlock()
+	if l.b.raft == nil {
+		return nil
+	}
+
+	future := l.b.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+	if len(future.Configuration().Servers) < 2 {
+		return nil
+	}
+	if err := l.b.raft.LeadershipTransfer().Error(); err != nil {
+		l.b.logger.Warn("failed to transfer raft leadership", "error", err)
+	}
+	return nil
+}
+
+// Value returns the value of the lock and if it is held.
+func (l *RaftLock) Value() (bool, string, error) {
+	entry, err := l.b.Get(context.Background(), l.key)
+	if err != nil {
+		return false, "", err
+	}
+	if entry == nil {
+		return false, "", nil
+	}
+	return true, string(entry.Value), nil
+}
@@ -0,0 +1,131 @@
+package raft
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	"github.com/jiangjiali/vault/sdk/helper/raft"
+)
+
+// Verify streamLayer satisfies the correct interfaces
+var _ raft.StreamLayer = (*streamLayer)(nil)
+
+// streamLayer carries the raft traffic over TCP, wrapped in mutual TLS unless
+// the raft address is a loopback address and no certificate is configured.
+type streamLayer struct {
+	listener  net.Listener
+	advertise net.Addr
+	tlsConfig *tls.Config
+}
+
+func newStreamLayer(addr string, tlsConfig *tls.Config) (*streamLayer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpAddr, ok := listener.Addr().(*net.TCPAddr)
+	if !ok || tcpAddr.IP.IsUnspecified() {
+		listener.Close()
+		return nil, fmt.Errorf("raft address %q is not advertisable", addr)
+	}
+
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	return &streamLayer{
+		listener:  listener,
+		advertise: tcpAddr,
+		tlsConfig: tlsConfig,
+	}, nil
+}
+
+// Dial implements the raft.StreamLayer interface.
+func (l *streamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if l.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", string(address), l.tlsConfig)
+	}
+	return dialer.Dial("tcp", string(address))
+}
+
+// Accept implements the net.Listener interface.
+func (l *streamLayer) Accept() (net.Conn, error) {
+	return l.listener.Accept()
+}
+
+// Close implements the net.Listener interface.
+func (l *streamLayer) Close() error {
+	return l.listener.Close()
+}
+
+// Addr implements the net.Listener interface.
+func (l *streamLayer) Addr() net.Addr {
+	return l.advertise
+}
+
+// loadTLSConfig builds the TLS configuration shared by the raft listener and
+// dialer. Peers present the same certificate and verify each other against the
+// configured CA, without checking the host name since peers are addressed by IP.
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	switch {
+	case certFile == "" && keyFile == "" && caFile == "":
+		return nil, nil
+	case certFile == "" || keyFile == "" || caFile == "":
+		return nil, errors.New("'tls_cert_file', 'tls_key_file' and 'tls_ca_file' must be set together")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to load raft TLS certificate: {{err}}", err)
+	}
+
+	caPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to read raft TLS CA: {{err}}", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificates found in raft TLS CA")
+	}
+
+	verify := func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no peer certificate presented")
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		intermediates := x509.NewCertPool()
+		for _, raw := range rawCerts[1:] {
+			c, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			intermediates.AddCert(c)
+		}
+		_, err = leaf.Verify(x509.VerifyOptions{
+			Roots:         pool,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+		})
+		return err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		// Verification is done by VerifyPeerCertificate for both sides
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verify,
+		MinVersion:            tls.VersionTLS12,
+	}, nil
+}
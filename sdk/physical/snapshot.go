@@ -0,0 +1,398 @@
+package physical
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// SnapshotVersion is the version of the format of the snapshots
+const SnapshotVersion = 1
+
+const (
+	snapshotMetaFile    = "meta.json"
+	snapshotEntriesFile = "entries.json"
+	snapshotSumsFile    = "SHA256SUMS"
+
+	// snapshotMaxFileSize bounds the size of the metadata and checksums
+	// files of the snapshots, which are read in memory
+	snapshotMaxFileSize = 1 << 20
+)
+
+// SnapshotMeta describes a snapshot of the storage
+type SnapshotMeta struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Entries   int       `json:"entries"`
+}
+
+type snapshotEntry struct {
+	Key      string `json:"key"`
+	Value    []byte `json:"value"`
+	SealWrap bool   `json:"seal_wrap,omitempty"`
+}
+
+// Snapshot is a snapshot of the entries of a backend taken by TakeSnapshot.
+// The entries are spooled to a temporary file, so that the archive of the
+// snapshot can be written once the backend is released.
+type Snapshot struct {
+	Meta *SnapshotMeta
+
+	entries *os.File
+	size    int64
+	sum     []byte
+}
+
+// TakeSnapshot reads the entries of the backend whose keys are kept. The
+// values are kept as they are stored, so the entries written through the
+// barrier stay encrypted. The snapshot must be closed once written.
+//
+// The backend must not be written to while the snapshot is taken for it to
+// be consistent.
+func TakeSnapshot(ctx context.Context, b Backend, keep func(key string) bool) (*Snapshot, error) {
+	entries, err := ioutil.TempFile("", "vault-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{
+		Meta: &SnapshotMeta{
+			Version:   SnapshotVersion,
+			CreatedAt: time.Now().UTC(),
+		},
+		entries: entries,
+	}
+
+	h := sha256.New()
+	counter := &countingWriter{}
+	buf := bufio.NewWriter(io.MultiWriter(entries, h, counter))
+	enc := json.NewEncoder(buf)
+	err = walkKeys(ctx, b, "", func(key string) error {
+		if keep != nil && !keep(key) {
+			return nil
+		}
+		entry, err := b.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+
+		s.Meta.Entries++
+		return enc.Encode(&snapshotEntry{
+			Key:      entry.Key,
+			Value:    entry.Value,
+			SealWrap: entry.SealWrap,
+		})
+	})
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	s.size = counter.n
+	s.sum = h.Sum(nil)
+	return s, nil
+}
+
+// Archive writes the archive of the snapshot, a gzipped tarball holding the
+// metadata of the snapshot, the entries, and the SHA-256 checksums of both
+func (s *Snapshot) Archive(w io.Writer) error {
+	metaJSON, err := json.Marshal(s.Meta)
+	if err != nil {
+		return err
+	}
+	metaSum := sha256.Sum256(metaJSON)
+	sums := fmt.Sprintf("%x  %s\n%x  %s\n", metaSum, snapshotMetaFile, s.sum, snapshotEntriesFile)
+
+	if _, err := s.entries.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	files := []struct {
+		name string
+		size int64
+		r    io.Reader
+	}{
+		{snapshotMetaFile, int64(len(metaJSON)), bytes.NewReader(metaJSON)},
+		{snapshotEntriesFile, s.size, s.entries},
+		{snapshotSumsFile, int64(len(sums)), strings.NewReader(sums)},
+	}
+	for _, file := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    file.name,
+			Mode:    0600,
+			Size:    file.size,
+			ModTime: s.Meta.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, file.r); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Close removes the entries spooled by the snapshot
+func (s *Snapshot) Close() error {
+	s.entries.Close()
+	return os.Remove(s.entries.Name())
+}
+
+// WriteSnapshot takes a snapshot of the backend and writes its archive, see
+// TakeSnapshot and Snapshot.Archive
+func WriteSnapshot(ctx context.Context, b Backend, w io.Writer, keep func(key string) bool) (*SnapshotMeta, error) {
+	s, err := TakeSnapshot(ctx, b, keep)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if err := s.Archive(w); err != nil {
+		return nil, err
+	}
+	return s.Meta, nil
+}
+
+// ReadSnapshot reads an archive written by Snapshot.Archive and calls fn with
+// each of its entries, one at a time. The checksums of the archive are only
+// verified once all of it is read, so the entries cannot be relied upon
+// before ReadSnapshot returns without error.
+func ReadSnapshot(r io.Reader, fn func(entry *Entry) error) (*SnapshotMeta, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	var meta *SnapshotMeta
+	var sums []byte
+	var entries int
+	fileSums := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := fileSums[hdr.Name]; ok {
+			return nil, fmt.Errorf("duplicate file %q in the snapshot", hdr.Name)
+		}
+
+		h := sha256.New()
+		file := io.TeeReader(tr, h)
+		switch hdr.Name {
+		case snapshotMetaFile:
+			data, err := readSnapshotFile(file)
+			if err != nil {
+				return nil, err
+			}
+			meta = new(SnapshotMeta)
+			if err := json.Unmarshal(data, meta); err != nil {
+				return nil, err
+			}
+			if meta.Version != SnapshotVersion {
+				return nil, fmt.Errorf("unsupported snapshot version %d", meta.Version)
+			}
+
+		case snapshotEntriesFile:
+			dec := json.NewDecoder(file)
+			for {
+				var entry snapshotEntry
+				err := dec.Decode(&entry)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				if entry.Key == "" {
+					return nil, errors.New("snapshot contains an entry without a key")
+				}
+				entries++
+				if fn == nil {
+					continue
+				}
+				err = fn(&Entry{
+					Key:      entry.Key,
+					Value:    entry.Value,
+					SealWrap: entry.SealWrap,
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+
+		case snapshotSumsFile:
+			if sums, err = readSnapshotFile(file); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected file %q in the snapshot", hdr.Name)
+		}
+		if _, err := io.Copy(ioutil.Discard, file); err != nil {
+			return nil, err
+		}
+		fileSums[hdr.Name] = h.Sum(nil)
+	}
+
+	if sums == nil {
+		return nil, errors.New("snapshot has no checksums")
+	}
+	verified := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			return nil, errors.New("invalid checksums in the snapshot")
+		}
+		sum, ok := fileSums[fields[1]]
+		if !ok {
+			return nil, fmt.Errorf("snapshot is missing %q", fields[1])
+		}
+		expected, err := hex.DecodeString(fields[0])
+		if err != nil {
+			return nil, errors.New("invalid checksums in the snapshot")
+		}
+		if subtle.ConstantTimeCompare(expected, sum) != 1 {
+			return nil, fmt.Errorf("checksum mismatch for %q", fields[1])
+		}
+		verified[fields[1]] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !verified[snapshotMetaFile] || !verified[snapshotEntriesFile] {
+		return nil, errors.New("snapshot is missing the checksums of its files")
+	}
+	if entries != meta.Entries {
+		return nil, fmt.Errorf("snapshot has %d entries, expected %d", entries, meta.Entries)
+	}
+
+	return meta, nil
+}
+
+// readSnapshotFile reads a file of a snapshot other than its entries
+func readSnapshotFile(r io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(r, snapshotMaxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > snapshotMaxFileSize {
+		return nil, errors.New("snapshot file is too large")
+	}
+	return data, nil
+}
+
+// RestoreSnapshot replaces the entries of the backend whose keys are kept
+// with the entries of an archive written by Snapshot.Archive: the entries of
+// the snapshot are written and the other kept entries are deleted. The
+// archive is verified before the backend is written. It returns the keys it
+// wrote or deleted.
+func RestoreSnapshot(ctx context.Context, b Backend, r io.ReadSeeker, keep func(key string) bool) (*SnapshotMeta, []string, error) {
+	if _, err := ReadSnapshot(r, nil); err != nil {
+		return nil, nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+
+	var keys []string
+	restored := make(map[string]struct{})
+	meta, err := ReadSnapshot(r, func(entry *Entry) error {
+		if keep != nil && !keep(entry.Key) {
+			return nil
+		}
+		if err := b.Put(ctx, entry); err != nil {
+			return err
+		}
+		restored[entry.Key] = struct{}{}
+		keys = append(keys, entry.Key)
+		return nil
+	})
+	if err != nil {
+		return nil, keys, err
+	}
+
+	var stale []string
+	err = walkKeys(ctx, b, "", func(key string) error {
+		if keep != nil && !keep(key) {
+			return nil
+		}
+		if _, ok := restored[key]; !ok {
+			stale = append(stale, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, keys, err
+	}
+	for _, key := range stale {
+		if err := b.Delete(ctx, key); err != nil {
+			return nil, keys, err
+		}
+		keys = append(keys, key)
+	}
+
+	return meta, keys, nil
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// walkKeys calls fn with every key of the backend under the prefix
+func walkKeys(ctx context.Context, b Backend, prefix string, fn func(key string) error) error {
+	keys, err := b.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasSuffix(key, "/") {
+			if err := walkKeys(ctx, b, prefix+key, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(prefix + key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/xxuuid"
+	"github.com/jiangjiali/vault/sdk/physical"
+)
+
+const (
+	// lockTTL is how long a lock stays held without being renewed
+	lockTTL = 15 * time.Second
+
+	// lockRenewInterval is how often the holder renews the lock
+	lockRenewInterval = 5 * time.Second
+
+	// lockRetryInterval is how often a lock is attempted while it is held
+	// by another node
+	lockRetryInterval = 1 * time.Second
+)
+
+// Verify SQLLock satisfies the correct interfaces
+var _ physical.Lock = (*SQLLock)(nil)
+
+// SQLLock is a lock stored as a row of the HA table. The row expires unless
+// its holder renews it, so a node that stops leaves the lock to the others.
+type SQLLock struct {
+	backend  *SQLBackend
+	key      string
+	value    string
+	identity string
+
+	l      sync.Mutex
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// HAEnabled indicates whether the HA functionality should be exposed.
+func (b *SQLBackend) HAEnabled() bool {
+	return b.haEnabled
+}
+
+// LockWith is used for mutual exclusion based on the given key.
+func (b *SQLBackend) LockWith(key, value string) (physical.Lock, error) {
+	identity, err := xxuuid.GenerateUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLLock{
+		backend:  b,
+		key:      key,
+		value:    value,
+		identity: identity,
+	}, nil
+}
+
+// Lock blocks until the lock is acquired or the stop channel is closed. The
+// returned channel is closed when the lock is lost.
+func (l *SQLLock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	if l.stopCh != nil {
+		return nil, errors.New("lock already held")
+	}
+
+	for {
+		acquired, err := l.tryAcquire()
+		if err != nil {
+			l.backend.logger.Error("failed to acquire lock", "key", l.key, "error", err)
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-stopCh:
+			return nil, nil
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	leaderLost := make(chan struct{})
+	l.stopCh = make(chan struct{})
+	l.doneCh = make(chan struct{})
+	go l.renew(leaderLost, l.stopCh, l.doneCh)
+
+	return leaderLost, nil
+}
+
+// Unlock releases the lock.
+func (l *SQLLock) Unlock() error {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	if l.stopCh == nil {
+		return nil
+	}
+	close(l.stopCh)
+	<-l.doneCh
+	l.stopCh = nil
+	l.doneCh = nil
+
+	_, err := l.backend.db.Exec(l.backend.lockReleaseQuery, l.key, l.identity)
+	return err
+}
+
+// Value returns whether the lock is held, and its value if so.
+func (l *SQLLock) Value() (bool, string, error) {
+	var value sql.NullString
+	err := l.backend.db.QueryRow(l.backend.lockValueQuery, l.key).Scan(&value)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, "", nil
+	case err != nil:
+		return false, "", err
+	}
+	return true, value.String, nil
+}
+
+func (l *SQLLock) tryAcquire() (bool, error) {
+	res, err := l.backend.db.Exec(l.backend.lockAcquireQuery, l.key, l.identity, l.value, lockTTL.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// renew keeps the lock held until it is stopped. The leaderLost channel is
+// closed when another node holds the lock, or when the lock could not be
+// renewed before it expired.
+func (l *SQLLock) renew(leaderLost chan struct{}, stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+
+	ticker := time.NewTicker(lockRenewInterval)
+	defer ticker.Stop()
+
+	lastRenewal := time.Now()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), lockRenewInterval)
+		res, err := l.backend.db.ExecContext(ctx, l.backend.lockRenewQuery, lockTTL.Milliseconds(), l.key, l.identity)
+		cancel()
+
+		var n int64
+		if err == nil {
+			n, err = res.RowsAffected()
+		}
+		switch {
+		case err != nil:
+			l.backend.logger.Error("failed to renew lock", "key", l.key, "error", err)
+			// Give up before the row expires, another node may take the
+			// lock right after
+			if time.Since(lastRenewal) < lockTTL-lockRenewInterval {
+				continue
+			}
+		case n == 1:
+			lastRenewal = time.Now()
+			continue
+		}
+
+		l.backend.logger.Warn("lock lost", "key", l.key)
+		close(leaderLost)
+		<-stopCh
+		return
+	}
+}
@@ -0,0 +1,55 @@
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	_ "github.com/jiangjiali/vault/sdk/helper/pq"
+	"github.com/jiangjiali/vault/sdk/physical"
+)
+
+var postgresDialect = &dialect{
+	numbered:  true,
+	valueType: "BYTEA",
+	// The C collation compares bytewise whatever the database locale is
+	textType:  `TEXT COLLATE "C"`,
+	strpos:    "strpos",
+	nowMillis: "CAST(EXTRACT(EPOCH FROM clock_timestamp()) * 1000 AS BIGINT)",
+}
+
+// NewPostgreSQLBackend constructs a SQLBackend storing the entries in a
+// PostgreSQL database. HA is enabled unless ha_enabled is set to false.
+func NewPostgreSQLBackend(conf map[string]string, logger log.Logger) (physical.Backend, error) {
+	connURL := os.Getenv("VAULT_PG_CONNECTION_URL")
+	if connURL == "" {
+		var ok bool
+		connURL, ok = conf["connection_url"]
+		if !ok || connURL == "" {
+			return nil, fmt.Errorf("'connection_url' must be set")
+		}
+	}
+
+	db, err := sql.Open("postgres", connURL)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to connect to postgres: {{err}}", err)
+	}
+	if raw, ok := conf["max_idle_connections"]; ok {
+		maxIdle, err := strconv.Atoi(raw)
+		if err != nil {
+			db.Close()
+			return nil, errwrap.Wrapf("failed parsing max_idle_connections parameter: {{err}}", err)
+		}
+		db.SetMaxIdleConns(maxIdle)
+	}
+
+	b, err := newSQLBackend(db, postgresDialect, conf, true, logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
+}
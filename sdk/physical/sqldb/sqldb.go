@@ -0,0 +1,361 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jiangjiali/vault/sdk/helper/consts"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/sdk/helper/parseutil"
+	"github.com/jiangjiali/vault/sdk/physical"
+)
+
+const (
+	defaultTable   = "vault_kv_store"
+	defaultHATable = "vault_ha_locks"
+)
+
+var tableNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Verify SQLBackend satisfies the correct interfaces
+var _ physical.Backend = (*SQLBackend)(nil)
+var _ physical.Transactional = (*SQLBackend)(nil)
+var _ physical.HABackend = (*SQLBackend)(nil)
+
+// dialect holds what differs between the supported databases.
+type dialect struct {
+	// numbered is set when the database expects $1, $2... placeholders
+	// rather than ?
+	numbered bool
+
+	// valueType is the column type of the entry values
+	valueType string
+
+	// textType is the column type of the paths, it must compare bytewise so
+	// that the list range queries match the keys by prefix
+	textType string
+
+	// strpos is the function returning the position of a substring
+	strpos string
+
+	// nowMillis is the expression of the current time of the database, in
+	// milliseconds since the epoch
+	nowMillis string
+}
+
+// SQLBackend is a physical backend that stores the entries in a table of a
+// relational database. Every key is split into its folder and its name so that
+// listing a folder is an indexed query.
+type SQLBackend struct {
+	db         *sql.DB
+	dialect    *dialect
+	logger     log.Logger
+	permitPool *physical.PermitPool
+	haEnabled  bool
+
+	getQuery         string
+	putQuery         string
+	deleteQuery      string
+	listKeysQuery    string
+	listFoldersQuery string
+
+	lockAcquireQuery string
+	lockRenewQuery   string
+	lockReleaseQuery string
+	lockValueQuery   string
+}
+
+// newSQLBackend creates the tables if they do not exist and prepares the
+// queries of the backend.
+func newSQLBackend(db *sql.DB, d *dialect, conf map[string]string, haDefault bool, logger log.Logger) (*SQLBackend, error) {
+	table := defaultTable
+	if raw, ok := conf["table"]; ok {
+		table = raw
+	}
+	haTable := defaultHATable
+	if raw, ok := conf["ha_table"]; ok {
+		haTable = raw
+	}
+	for _, name := range []string{table, haTable} {
+		if !tableNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid table name %q", name)
+		}
+	}
+
+	haEnabled := haDefault
+	if raw, ok := conf["ha_enabled"]; ok {
+		var err error
+		if haEnabled, err = parseutil.ParseBool(raw); err != nil {
+			return nil, errwrap.Wrapf("failed parsing ha_enabled parameter: {{err}}", err)
+		}
+	}
+
+	maxParInt := physical.DefaultParallelOperations
+	if raw, ok := conf["max_parallel"]; ok {
+		var err error
+		if maxParInt, err = strconv.Atoi(raw); err != nil {
+			return nil, errwrap.Wrapf("failed parsing max_parallel parameter: {{err}}", err)
+		}
+		if logger.IsDebug() {
+			logger.Debug("max_parallel set", "max_parallel", maxParInt)
+		}
+	}
+
+	b := &SQLBackend{
+		db:         db,
+		dialect:    d,
+		logger:     logger,
+		permitPool: physical.NewPermitPool(maxParInt),
+		haEnabled:  haEnabled,
+
+		getQuery: d.rebind("SELECT value FROM " + table + " WHERE path = ? AND key = ?"),
+		putQuery: d.rebind("INSERT INTO " + table + " (path, key, value) VALUES (?, ?, ?)" +
+			" ON CONFLICT (path, key) DO UPDATE SET value = excluded.value"),
+		deleteQuery:   d.rebind("DELETE FROM " + table + " WHERE path = ? AND key = ?"),
+		listKeysQuery: d.rebind("SELECT key FROM " + table + " WHERE path = ?"),
+		listFoldersQuery: d.rebind("SELECT DISTINCT substr(path, ?, " + d.strpos + "(substr(path, ?), '/'))" +
+			" FROM " + table + " WHERE path > ? AND path < ?"),
+
+		lockAcquireQuery: d.rebind("INSERT INTO " + haTable + " (ha_key, ha_identity, ha_value, valid_until)" +
+			" VALUES (?, ?, ?, " + d.nowMillis + " + ?)" +
+			" ON CONFLICT (ha_key) DO UPDATE SET ha_identity = excluded.ha_identity," +
+			" ha_value = excluded.ha_value, valid_until = excluded.valid_until" +
+			" WHERE " + haTable + ".valid_until < " + d.nowMillis +
+			" OR " + haTable + ".ha_identity = excluded.ha_identity"),
+		lockRenewQuery: d.rebind("UPDATE " + haTable + " SET valid_until = " + d.nowMillis + " + ?" +
+			" WHERE ha_key = ? AND ha_identity = ?"),
+		lockReleaseQuery: d.rebind("DELETE FROM " + haTable + " WHERE ha_key = ? AND ha_identity = ?"),
+		lockValueQuery: d.rebind("SELECT ha_value FROM " + haTable +
+			" WHERE ha_key = ? AND valid_until > " + d.nowMillis),
+	}
+
+	schema := []string{
+		"CREATE TABLE IF NOT EXISTS " + table + " (" +
+			"path " + d.textType + " NOT NULL, " +
+			"key " + d.textType + " NOT NULL, " +
+			"value " + d.valueType + ", " +
+			"PRIMARY KEY (path, key))",
+		"CREATE TABLE IF NOT EXISTS " + haTable + " (" +
+			"ha_key " + d.textType + " NOT NULL PRIMARY KEY, " +
+			"ha_identity " + d.textType + " NOT NULL, " +
+			"ha_value " + d.textType + ", " +
+			"valid_until BIGINT NOT NULL)",
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, errwrap.Wrapf("failed to create table: {{err}}", err)
+		}
+	}
+
+	return b, nil
+}
+
+// rebind replaces the ? placeholders of the query with numbered ones when the
+// database expects them.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// splitKey returns the folder of the key, with leading and trailing slashes,
+// and its name.
+func splitKey(key string) (string, string) {
+	i := strings.LastIndex(key, "/")
+	if i == -1 {
+		return "/", key
+	}
+	return "/" + key[:i+1], key[i+1:]
+}
+
+// execer is satisfied by both sql.DB and sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+func (b *SQLBackend) put(ctx context.Context, e execer, entry *physical.Entry) error {
+	path, key := splitKey(entry.Key)
+	if _, err := e.ExecContext(ctx, b.putQuery, path, key, entry.Value); err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("failed to put %q: {{err}}", entry.Key), err)
+	}
+	return nil
+}
+
+func (b *SQLBackend) delete(ctx context.Context, e execer, fullKey string) error {
+	path, key := splitKey(fullKey)
+	if _, err := e.ExecContext(ctx, b.deleteQuery, path, key); err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("failed to delete %q: {{err}}", fullKey), err)
+	}
+	return nil
+}
+
+// Put is used to insert or update an entry
+func (b *SQLBackend) Put(ctx context.Context, entry *physical.Entry) error {
+	if err := validateKey(entry.Key); err != nil {
+		return err
+	}
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	return b.put(ctx, b.db, entry)
+}
+
+// Get is used to fetch an entry
+func (b *SQLBackend) Get(ctx context.Context, fullKey string) (*physical.Entry, error) {
+	if err := validateKey(fullKey); err != nil {
+		return nil, err
+	}
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	path, key := splitKey(fullKey)
+
+	var value []byte
+	err := b.db.QueryRowContext(ctx, b.getQuery, path, key).Scan(&value)
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to get %q: {{err}}", fullKey), err)
+	}
+
+	return &physical.Entry{
+		Key:   fullKey,
+		Value: value,
+	}, nil
+}
+
+// Delete is used to permanently delete an entry
+func (b *SQLBackend) Delete(ctx context.Context, fullKey string) error {
+	if err := validateKey(fullKey); err != nil {
+		return err
+	}
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	return b.delete(ctx, b.db, fullKey)
+}
+
+// List is used to list all the keys under a given prefix, up to the next
+// prefix.
+func (b *SQLBackend) List(ctx context.Context, prefix string) ([]string, error) {
+	if err := validateKey(prefix); err != nil {
+		return nil, err
+	}
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	folder := "/" + prefix
+	if !strings.HasSuffix(folder, "/") {
+		folder += "/"
+	}
+
+	var keys []string
+	rows, err := b.db.QueryContext(ctx, b.listKeysQuery, folder)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to list keys: {{err}}", err)
+	}
+	keys, err = scanStrings(rows, keys)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to list keys: {{err}}", err)
+	}
+
+	// Every path under the folder is between the folder and the folder with
+	// its trailing slash replaced by '0', the next character
+	start := len(folder) + 1
+	upper := folder[:len(folder)-1] + "0"
+	rows, err = b.db.QueryContext(ctx, b.listFoldersQuery, start, start, folder, upper)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to list folders: {{err}}", err)
+	}
+	keys, err = scanStrings(rows, keys)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to list folders: {{err}}", err)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func scanStrings(rows *sql.Rows, out []string) ([]string, error) {
+	defer rows.Close()
+
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// Transaction applies all the operations in a single database transaction,
+// either all of them are committed or none is.
+func (b *SQLBackend) Transaction(ctx context.Context, txns []*physical.TxnEntry) error {
+	if len(txns) == 0 {
+		return nil
+	}
+	for _, txn := range txns {
+		if err := validateKey(txn.Entry.Key); err != nil {
+			return err
+		}
+	}
+
+	b.permitPool.Acquire()
+	defer b.permitPool.Release()
+
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errwrap.Wrapf("failed to begin transaction: {{err}}", err)
+	}
+	defer tx.Rollback()
+
+	for _, txn := range txns {
+		switch txn.Operation {
+		case physical.PutOperation:
+			err = b.put(ctx, tx, txn.Entry)
+		case physical.DeleteOperation:
+			err = b.delete(ctx, tx, txn.Entry.Key)
+		default:
+			err = fmt.Errorf("%q is not a supported transaction operation", txn.Operation)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errwrap.Wrapf("failed to commit transaction: {{err}}", err)
+	}
+	return nil
+}
+
+func validateKey(key string) error {
+	if strings.Contains(key, "..") {
+		return consts.ErrPathContainsParentReferences
+	}
+	return nil
+}
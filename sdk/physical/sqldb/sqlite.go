@@ -0,0 +1,61 @@
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	_ "github.com/jiangjiali/vault/sdk/helper/sqlite3"
+	"github.com/jiangjiali/vault/sdk/physical"
+)
+
+var sqliteDialect = &dialect{
+	valueType: "BLOB",
+	textType:  "TEXT",
+	strpos:    "instr",
+	nowMillis: "CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER)",
+}
+
+// NewSQLiteBackend constructs a SQLBackend storing the entries in a SQLite
+// database file. HA is disabled unless ha_enabled is set, it only makes sense
+// for several servers sharing the file on the same host.
+func NewSQLiteBackend(conf map[string]string, logger log.Logger) (physical.Backend, error) {
+	path, ok := conf["path"]
+	if !ok || path == "" {
+		return nil, fmt.Errorf("'path' must be set")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errwrap.Wrapf("failed to create database directory: {{err}}", err)
+	}
+
+	// Write transactions take the database lock up front so that concurrent
+	// ones wait on the busy timeout instead of failing when upgrading
+	params := url.Values{}
+	params.Set("_busy_timeout", "5000")
+	params.Set("_journal_mode", "WAL")
+	params.Set("_synchronous", "FULL")
+	params.Set("_txlock", "immediate")
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to open sqlite database: {{err}}", err)
+	}
+
+	b, err := newSQLBackend(db, sqliteDialect, conf, false, logger)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	// The entries are encrypted by the barrier, but the file should still
+	// only be readable by the server
+	if err := os.Chmod(path, 0600); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
+}
@@ -5,9 +5,12 @@ import (
 	"crypto/sha256"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/jiangjiali/vault/audit"
+	"github.com/jiangjiali/vault/sdk/helper/consts"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
 	"github.com/jiangjiali/vault/sdk/helper/jsonutil"
 	"github.com/jiangjiali/vault/sdk/helper/namespace"
 	"github.com/jiangjiali/vault/sdk/helper/salt"
@@ -93,6 +96,11 @@ func (c *Core) enableAudit(ctx context.Context, entry *MountEntry, updateStorage
 	view.setReadOnlyErr(logical.ErrSetupReadOnly)
 	defer view.setReadOnlyErr(origViewReadOnlyErr)
 
+	options, err := parseAuditDeviceOptions(entry.Options)
+	if err != nil {
+		return err
+	}
+
 	// Lookup the new backend
 	backend, err := c.newAuditBackend(ctx, entry, view, entry.Options)
 	if err != nil {
@@ -114,6 +122,9 @@ func (c *Core) enableAudit(ctx context.Context, entry *MountEntry, updateStorage
 
 	if updateStorage {
 		if err := c.persistAudit(ctx, newTable, entry.Local); err != nil {
+			if closer, ok := backend.(audit.Closer); ok {
+				closer.Close()
+			}
 			return errors.New("failed to update audit table")
 		}
 	}
@@ -121,7 +132,7 @@ func (c *Core) enableAudit(ctx context.Context, entry *MountEntry, updateStorage
 	c.audit = newTable
 
 	// Register the backend
-	c.auditBroker.Register(entry.Path, backend, view, entry.Local)
+	c.auditBroker.Register(entry.Path, backend, view, entry.Local, options)
 	if c.logger.IsInfo() {
 		c.logger.Info("enabled audit backend", "path", entry.Path, "type", entry.Type)
 	}
@@ -268,7 +279,9 @@ func (c *Core) loadAudits(ctx context.Context) error {
 		return nil
 	}
 
-	if err := c.persistAudit(ctx, c.audit, false); err != nil {
+	// A performance secondary only writes its local table
+	localOnly := c.ReplicationState().HasState(consts.ReplicationPerformanceSecondary)
+	if err := c.persistAudit(ctx, c.audit, localOnly); err != nil {
 		return errLoadAuditFailed
 	}
 	return nil
@@ -351,6 +364,7 @@ func (c *Core) setupAudits(ctx context.Context) error {
 	brokerLogger := c.baseLogger.Named("audit")
 	c.AddLogger(brokerLogger)
 	broker := NewAuditBroker(brokerLogger)
+	broker.router = c.router
 
 	c.auditLock.Lock()
 	defer c.auditLock.Unlock()
@@ -372,6 +386,12 @@ func (c *Core) setupAudits(ctx context.Context) error {
 			view.setReadOnlyErr(origViewReadOnlyErr)
 		})
 
+		options, err := parseAuditDeviceOptions(entry.Options)
+		if err != nil {
+			c.logger.Error("failed to parse audit entry options", "path", entry.Path, "error", err)
+			continue
+		}
+
 		// Initialize the backend
 		backend, err := c.newAuditBackend(ctx, entry, view, entry.Options)
 		if err != nil {
@@ -384,7 +404,7 @@ func (c *Core) setupAudits(ctx context.Context) error {
 		}
 
 		// Mount the backend
-		broker.Register(entry.Path, backend, view, entry.Local)
+		broker.Register(entry.Path, backend, view, entry.Local, options)
 
 		successCount++
 	}
@@ -410,6 +430,10 @@ func (c *Core) teardownAudits() error {
 		}
 	}
 
+	if c.auditBroker != nil {
+		c.auditBroker.Close()
+	}
+
 	c.audit = nil
 	c.auditBroker = nil
 	return nil
@@ -493,6 +517,18 @@ func (c *Core) newAuditBackend(ctx context.Context, entry *MountEntry, view logi
 				auditLogger.Debug("syslog backend options", "path", entry.Path, "facility", entry.Options["facility"], "tag", entry.Options["tag"])
 			}
 		}
+	case "webhook":
+		if auditLogger.IsDebug() {
+			if entry.Options != nil {
+				auditLogger.Debug("webhook backend options", "path", entry.Path, "url", entry.Options["url"], "buffer path", entry.Options["buffer_path"])
+			}
+		}
+	case "kafka":
+		if auditLogger.IsDebug() {
+			if entry.Options != nil {
+				auditLogger.Debug("kafka backend options", "path", entry.Path, "address", entry.Options["address"], "topic", entry.Options["topic"], "buffer path", entry.Options["buffer_path"])
+			}
+		}
 	}
 
 	return be, err
@@ -505,3 +541,51 @@ func defaultAuditTable() *MountTable {
 	}
 	return table
 }
+
+// auditDeviceOptions are the options of an audit device which are applied by
+// the broker before the device formats the entries
+type auditDeviceOptions struct {
+	// filter selects the entries logged by the device
+	filter *audit.Filter
+
+	// fields are the fields excluded or hashed
+	fields *audit.FieldRules
+
+	// raw is whether the device logs entries without hashing them
+	raw bool
+}
+
+func parseAuditDeviceOptions(options map[string]string) (*auditDeviceOptions, error) {
+	var opts auditDeviceOptions
+
+	if expr := strings.TrimSpace(options["filter"]); expr != "" {
+		filter, err := audit.ParseFilter(expr)
+		if err != nil {
+			return nil, errwrap.Wrapf("invalid filter: {{err}}", err)
+		}
+		opts.filter = filter
+	}
+
+	fields, err := audit.ParseFieldRules(options["exclude_fields"], options["hmac_fields"])
+	if err != nil {
+		return nil, err
+	}
+	opts.fields = fields
+
+	if raw, ok := options["log_raw"]; ok {
+		// The device validates the value
+		opts.raw, _ = strconv.ParseBool(raw)
+	}
+
+	return &opts, nil
+}
+
+// apply returns the input to log with the field rules of the device applied
+func (o *auditDeviceOptions) apply(ctx context.Context, backend audit.Backend, in *audit.LogInput) (*audit.LogInput, error) {
+	if o.fields == nil {
+		return in, nil
+	}
+	return o.fields.Apply(in, o.raw, func(data string) (string, error) {
+		return backend.GetHash(ctx, data)
+	})
+}
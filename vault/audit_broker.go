@@ -16,6 +16,7 @@ type backendEntry struct {
 	backend audit.Backend
 	view    *BarrierView
 	local   bool
+	options *auditDeviceOptions
 }
 
 // AuditBroker is used to provide a single ingest interface to auditable
@@ -24,6 +25,9 @@ type AuditBroker struct {
 	sync.RWMutex
 	backends map[string]backendEntry
 	logger   log.Logger
+
+	// router resolves the mounts of the requests for the filters
+	router *Router
 }
 
 // NewAuditBroker creates a new audit broker
@@ -36,21 +40,50 @@ func NewAuditBroker(log log.Logger) *AuditBroker {
 }
 
 // Register is used to add new audit backend to the broker
-func (a *AuditBroker) Register(name string, b audit.Backend, v *BarrierView, local bool) {
+func (a *AuditBroker) Register(name string, b audit.Backend, v *BarrierView, local bool, options *auditDeviceOptions) {
 	a.Lock()
 	defer a.Unlock()
+	if options == nil {
+		options = &auditDeviceOptions{}
+	}
 	a.backends[name] = backendEntry{
 		backend: b,
 		view:    v,
 		local:   local,
+		options: options,
 	}
 }
 
 // Deregister is used to remove an audit backend from the broker
 func (a *AuditBroker) Deregister(name string) {
 	a.Lock()
-	defer a.Unlock()
+	be, ok := a.backends[name]
 	delete(a.backends, name)
+	a.Unlock()
+
+	if ok {
+		a.closeBackend(name, be.backend)
+	}
+}
+
+// Close releases the resources of the registered backends, which are left
+// registered. It is called when the vault is sealed.
+func (a *AuditBroker) Close() {
+	a.RLock()
+	defer a.RUnlock()
+	for name, be := range a.backends {
+		a.closeBackend(name, be.backend)
+	}
+}
+
+func (a *AuditBroker) closeBackend(name string, b audit.Backend) {
+	closer, ok := b.(audit.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		a.logger.Error("failed to close audit backend", "path", name, "error", err)
+	}
 }
 
 // IsRegistered is used to check if a given audit backend is registered
@@ -119,9 +152,22 @@ func (a *AuditBroker) LogRequest(ctx context.Context, in *audit.LogInput, header
 		in.Request.Headers = headers
 	}()
 
-	// Ensure at least one backend logs
+	// Ensure at least one backend logs, unless the filters of all of them
+	// exclude the request
 	anyLogged := false
+	anyWanted := false
+	var filterValues map[string]string
 	for name, be := range a.backends {
+		if be.options.filter != nil {
+			if filterValues == nil {
+				filterValues = a.filterValues(ctx, "request", in)
+			}
+			if !be.options.filter.Evaluate(filterValues) {
+				continue
+			}
+		}
+		anyWanted = true
+
 		in.Request.Headers = nil
 		transHeaders, thErr := headersConfig.ApplyConfig(ctx, headers, be.backend.GetHash)
 		if thErr != nil {
@@ -130,8 +176,14 @@ func (a *AuditBroker) LogRequest(ctx context.Context, in *audit.LogInput, header
 		}
 		in.Request.Headers = transHeaders
 
+		logInput, fErr := be.options.apply(ctx, be.backend, in)
+		if fErr != nil {
+			a.logger.Error("backend failed to apply field rules", "backend", name, "error", fErr)
+			continue
+		}
+
 		start := time.Now()
-		lrErr := be.backend.LogRequest(ctx, in)
+		lrErr := be.backend.LogRequest(ctx, logInput)
 		metrics.MeasureSince([]string{"audit", name, "log_request"}, start)
 		if lrErr != nil {
 			a.logger.Error("backend failed to log request", "backend", name, "error", lrErr)
@@ -139,7 +191,7 @@ func (a *AuditBroker) LogRequest(ctx context.Context, in *audit.LogInput, header
 			anyLogged = true
 		}
 	}
-	if !anyLogged && len(a.backends) > 0 {
+	if !anyLogged && anyWanted {
 		retErr = multierror.Append(retErr, fmt.Errorf("no audit backend succeeded in logging the request"))
 	}
 
@@ -175,9 +227,22 @@ func (a *AuditBroker) LogResponse(ctx context.Context, in *audit.LogInput, heade
 		in.Request.Headers = headers
 	}()
 
-	// Ensure at least one backend logs
+	// Ensure at least one backend logs, unless the filters of all of them
+	// exclude the response
 	anyLogged := false
+	anyWanted := false
+	var filterValues map[string]string
 	for name, be := range a.backends {
+		if be.options.filter != nil {
+			if filterValues == nil {
+				filterValues = a.filterValues(ctx, "response", in)
+			}
+			if !be.options.filter.Evaluate(filterValues) {
+				continue
+			}
+		}
+		anyWanted = true
+
 		in.Request.Headers = nil
 		transHeaders, thErr := headersConfig.ApplyConfig(ctx, headers, be.backend.GetHash)
 		if thErr != nil {
@@ -186,8 +251,14 @@ func (a *AuditBroker) LogResponse(ctx context.Context, in *audit.LogInput, heade
 		}
 		in.Request.Headers = transHeaders
 
+		logInput, fErr := be.options.apply(ctx, be.backend, in)
+		if fErr != nil {
+			a.logger.Error("backend failed to apply field rules", "backend", name, "error", fErr)
+			continue
+		}
+
 		start := time.Now()
-		lrErr := be.backend.LogResponse(ctx, in)
+		lrErr := be.backend.LogResponse(ctx, logInput)
 		metrics.MeasureSince([]string{"audit", name, "log_response"}, start)
 		if lrErr != nil {
 			a.logger.Error("backend failed to log response", "backend", name, "error", lrErr)
@@ -195,13 +266,27 @@ func (a *AuditBroker) LogResponse(ctx context.Context, in *audit.LogInput, heade
 			anyLogged = true
 		}
 	}
-	if !anyLogged && len(a.backends) > 0 {
+	if !anyLogged && anyWanted {
 		retErr = multierror.Append(retErr, fmt.Errorf("no audit backend succeeded in logging the response"))
 	}
 
 	return retErr.ErrorOrNil()
 }
 
+// filterValues returns the values the filters of the devices are evaluated
+// against. Requests are logged before being routed, so their mount is looked up
+// when they do not carry it yet.
+func (a *AuditBroker) filterValues(ctx context.Context, entryType string, in *audit.LogInput) map[string]string {
+	values := audit.FilterValues(ctx, entryType, in)
+	if values["mount_path"] == "" && a.router != nil && in.Request != nil {
+		values["mount_path"] = a.router.MatchingMount(ctx, in.Request.Path)
+		if entry := a.router.MatchingMountEntry(ctx, in.Request.Path); entry != nil {
+			values["mount_type"] = entry.Type
+		}
+	}
+	return values
+}
+
 func (a *AuditBroker) Invalidate(ctx context.Context, key string) {
 	// For now we ignore the key as this would only apply to salts. We just
 	// sort of brute force it on each one.
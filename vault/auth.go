@@ -90,9 +90,13 @@ func (c *Core) enableCredentialInternal(ctx context.Context, entry *MountEntry,
 		}
 	}
 
-	// Ensure the token backend is a singleton
+	// Ensure the token backend is a singleton within the namespace
 	if entry.Type == "token" {
-		return fmt.Errorf("token credential backend cannot be instantiated")
+		for _, ent := range c.auth.Entries {
+			if ent.Type == "token" && ent.NamespaceID == ns.ID {
+				return fmt.Errorf("token credential backend cannot be instantiated")
+			}
+		}
 	}
 
 	// Check for conflicts according to the router
@@ -482,7 +486,13 @@ func (c *Core) loadCredentials(ctx context.Context) error {
 		return nil
 	}
 
-	if err := c.persistAuth(ctx, c.auth, nil); err != nil {
+	// A performance secondary only writes its local table
+	var local *bool
+	if c.ReplicationState().HasState(consts.ReplicationPerformanceSecondary) {
+		local = new(bool)
+		*local = true
+	}
+	if err := c.persistAuth(ctx, c.auth, local); err != nil {
 		c.logger.Error("failed to persist auth table", "error", err)
 		return errLoadAuthFailed
 	}
@@ -599,9 +609,9 @@ func (c *Core) setupCredentials(ctx context.Context) error {
 		// writes during the construction of the backend.
 		view.setReadOnlyErr(logical.ErrSetupReadOnly)
 		if strutil.StrListContains(singletonMounts, entry.Type) {
-			go func() {
-				defer view.setReadOnlyErr(origViewReadOnlyErr)
-			}()
+			// The view is reset once all mounts are set up, before the
+			// stores built on top of them are
+			defer view.setReadOnlyErr(origViewReadOnlyErr)
 		} else {
 			c.postUnsealFuncs = append(c.postUnsealFuncs, func() {
 				view.setReadOnlyErr(origViewReadOnlyErr)
@@ -664,7 +674,7 @@ func (c *Core) setupCredentials(ctx context.Context) error {
 		}
 
 		// Check if this is the token store
-		if entry.Type == "token" {
+		if entry.Type == "token" && entry.Namespace().ID == namespace.RootNamespaceID {
 			c.tokenStore = backend.(*TokenStore)
 
 			// At some point when this isn't beta we may persist this but for
@@ -721,6 +731,12 @@ func (c *Core) newCredentialBackend(ctx context.Context, entry *MountEntry, sysV
 		t = alias
 	}
 
+	// The token store of a namespace is the one of the root namespace, which
+	// scopes the tokens by their namespace
+	if t == "token" && entry.Namespace().ID != namespace.RootNamespaceID {
+		return c.tokenStore, nil
+	}
+
 	f, ok := c.credentialBackends[t]
 	if !ok {
 		f = plugin.Factory
@@ -129,6 +129,10 @@ type SecurityBarrier interface {
 	// For replication we must send over the keyring, so this must be available
 	Keyring() (*Keyring, error)
 
+	// SetKeyring replaces the keys with the ones of a keyring sent over by
+	// replication. The master key is kept and the keyring persisted with it.
+	SetKeyring(context.Context, *Keyring) error
+
 	// SecurityBarrier must provide the storage APIs
 	logical.Storage
 
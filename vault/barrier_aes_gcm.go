@@ -676,6 +676,31 @@ func (b *AESGCMBarrier) SetMasterKey(key []byte) error {
 	return nil
 }
 
+// SetKeyring replaces the keys with the ones of the keyring, keeping the
+// master key, and persists the keyring
+func (b *AESGCMBarrier) SetKeyring(ctx context.Context, keyring *Keyring) error {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	if b.sealed {
+		return ErrBarrierSealed
+	}
+
+	newKeyring := keyring.SetMasterKey(b.keyring.MasterKey())
+	if err := b.persistKeyring(ctx, newKeyring); err != nil {
+		return err
+	}
+
+	// Swap the keyrings, the terms of the new one may hold other keys
+	oldKeyring := b.keyring
+	b.keyring = newKeyring
+	b.cacheLock.Lock()
+	b.cache = make(map[uint32]cipher.AEAD)
+	b.cacheLock.Unlock()
+	oldKeyring.Zeroize(false)
+	return nil
+}
+
 // Performs common tasks related to updating the master key; note that the lock
 // must be held before calling this function
 func (b *AESGCMBarrier) updateMasterKeyCommon(key []byte) (*Keyring, error) {
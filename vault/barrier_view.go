@@ -3,7 +3,9 @@ package vault
 import (
 	"context"
 	"errors"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/jiangjiali/vault/sdk/logical"
 )
@@ -20,6 +22,47 @@ type BarrierView struct {
 	readOnlyErr     error
 	readOnlyErrLock sync.RWMutex
 	iCheck          interface{}
+
+	// sealWrapPaths holds the keys of the view written seal wrapped
+	sealWrapPaths atomic.Value
+}
+
+// sealWrapPaths matches the keys of a view that are seal wrapped. The paths
+// are exact unless they end with '/', in which case they are prefixes.
+type sealWrapPaths struct {
+	all      bool
+	exact    map[string]struct{}
+	prefixes []string
+}
+
+func newSealWrapPaths(all bool, paths []string) *sealWrapPaths {
+	s := &sealWrapPaths{
+		all:   all,
+		exact: make(map[string]struct{}),
+	}
+	for _, path := range paths {
+		if strings.HasSuffix(path, "/") {
+			s.prefixes = append(s.prefixes, path)
+			continue
+		}
+		s.exact[path] = struct{}{}
+	}
+	return s
+}
+
+func (s *sealWrapPaths) match(key string) bool {
+	if s.all {
+		return true
+	}
+	if _, ok := s.exact[key]; ok {
+		return true
+	}
+	for _, prefix := range s.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
 }
 
 // NewBarrierView takes an underlying security barrier and returns
@@ -46,6 +89,18 @@ func (v *BarrierView) getReadOnlyErr() error {
 	return v.readOnlyErr
 }
 
+// setSealWrapPaths sets the keys of the view that are seal wrapped: all of them
+// if all is set, or the ones matching the given paths.
+func (v *BarrierView) setSealWrapPaths(all bool, paths []string) {
+	v.sealWrapPaths.Store(newSealWrapPaths(all, paths))
+}
+
+// sealWrapped returns whether the key is seal wrapped.
+func (v *BarrierView) sealWrapped(key string) bool {
+	paths, ok := v.sealWrapPaths.Load().(*sealWrapPaths)
+	return ok && paths.match(key)
+}
+
 func (v *BarrierView) Prefix() string {
 	return v.storage.Prefix()
 }
@@ -73,6 +128,14 @@ func (v *BarrierView) Put(ctx context.Context, entry *logical.StorageEntry) erro
 		}
 	}
 
+	if !entry.SealWrap && v.sealWrapped(entry.Key) {
+		entry = &logical.StorageEntry{
+			Key:      entry.Key,
+			Value:    entry.Value,
+			SealWrap: true,
+		}
+	}
+
 	return v.storage.Put(ctx, entry)
 }
 
@@ -37,6 +37,7 @@ import (
 	"github.com/jiangjiali/vault/sdk/logical"
 	"github.com/jiangjiali/vault/sdk/physical"
 	"github.com/jiangjiali/vault/shamir"
+	"github.com/jiangjiali/vault/vault/replication"
 	"github.com/jiangjiali/vault/vault/seal"
 )
 
@@ -171,6 +172,18 @@ type Core struct {
 	// physical backend is the un-trusted backend with durable data
 	physical physical.Backend
 
+	// underlyingPhysical is the configured physical backend, without the
+	// cache and seal unwrapping layers
+	underlyingPhysical physical.Backend
+
+	// raftInfo is set while the node is joining a raft cluster
+	raftInfo *raftInformation
+
+	// pendingRaftPeers holds the bootstrap challenges given to nodes joining
+	// the raft cluster
+	pendingRaftPeers     map[string]*pendingRaftPeer
+	pendingRaftPeersLock sync.Mutex
+
 	// seal is our seal, for seal configuration information
 	seal Seal
 
@@ -282,6 +295,9 @@ type Core struct {
 	// identityStore is used to manage client entities
 	identityStore *IdentityStore
 
+	// namespaceStore keeps the namespaces created under the root namespace
+	namespaceStore *NamespaceStore
+
 	// metricsCh is used to stop the metrics streaming
 	metricsCh chan struct{}
 
@@ -299,6 +315,11 @@ type Core struct {
 
 	// cachingDisabled indicates whether caches are disabled
 	cachingDisabled bool
+
+	// sealWrapDisabled indicates whether the entries flagged SealWrap are
+	// stored without the extra encryption of the seal
+	sealWrapDisabled bool
+
 	// Cache stores the actual cache; we always have this but may bypass it if
 	// disabled
 	physicalCache physical.ToggleablePurgemonster
@@ -366,6 +387,33 @@ type Core struct {
 	replicationState           *uint32
 	activeNodeReplicationState *uint32
 
+	// replicationLog logs the writes of the storage shipped to the
+	// secondaries, and replicationStorage is the storage beneath it, which a
+	// secondary applies the writes of its primary to
+	replicationLog     *replicationLog
+	replicationStorage physical.Backend
+
+	// replicationLock guards the replication state of the cluster and the
+	// primaries and secondaries running it, keyed by mode
+	replicationLock        sync.RWMutex
+	replicationClusters    replication.Clusters
+	replicationPrimaries   map[string]*replicationPrimary
+	replicationSecondaries map[string]*replicationSecondary
+
+	// replicationReloading is set while the state of the active node is set
+	// up again from the replicated storage, which keeps replication running.
+	// It is guarded by the state lock.
+	replicationReloading bool
+
+	// storageLock keeps the storage from being written while a snapshot is
+	// taken or restored. The writes hold it for reading, the snapshots and
+	// restores for writing.
+	storageLock sync.RWMutex
+
+	// autoSnapshots takes the automatic snapshots of the storage, it is nil
+	// when they are not configured
+	autoSnapshots *autoSnapshots
+
 	// uiConfig contains UI configuration
 	uiConfig *UIConfig
 
@@ -385,6 +433,12 @@ type Core struct {
 	activeContext           context.Context
 	activeContextCancelFunc *atomic.Value
 
+	// drainContext is cancelled as soon as the core starts to seal or step
+	// down, before it waits for the in-flight requests, so that the requests
+	// blocking for changes return early
+	drainContext           context.Context
+	drainContextCancelFunc *atomic.Value
+
 	// Stores the sealunwrapper for downgrade needs
 	sealUnwrapper physical.Backend
 
@@ -498,6 +552,9 @@ type CoreConfig struct {
 	MetricsHelper *metricsutil.MetricsHelper
 
 	CounterSyncInterval time.Duration
+
+	// AutoSnapshot configures the automatic snapshots of the storage
+	AutoSnapshot *AutoSnapshotConfig
 }
 
 func (c *CoreConfig) Clone() *CoreConfig {
@@ -531,6 +588,7 @@ func (c *CoreConfig) Clone() *CoreConfig {
 		DisableIndexing:           c.DisableIndexing,
 		AllLoggers:                c.AllLoggers,
 		CounterSyncInterval:       c.CounterSyncInterval,
+		AutoSnapshot:              c.AutoSnapshot,
 	}
 }
 
@@ -579,6 +637,8 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		entCore:                      entCore{},
 		devToken:                     conf.DevToken,
 		physical:                     conf.Physical,
+		underlyingPhysical:           conf.Physical,
+		pendingRaftPeers:             make(map[string]*pendingRaftPeer),
 		redirectAddr:                 conf.RedirectAddr,
 		clusterAddr:                  conf.ClusterAddr,
 		seal:                         conf.Seal,
@@ -590,6 +650,7 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		defaultLeaseTTL:              conf.DefaultLeaseTTL,
 		maxLeaseTTL:                  conf.MaxLeaseTTL,
 		cachingDisabled:              conf.DisableCache,
+		sealWrapDisabled:             conf.DisableSealWrap,
 		clusterName:                  conf.ClusterName,
 		clusterPeerClusterAddrsCache: cache.New(3*HeartbeatInterval, time.Second),
 		enableMlock:                  !conf.DisableMlock,
@@ -603,8 +664,11 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		activeNodeReplicationState:   new(uint32),
 		keepHALockOnStepDown:         new(uint32),
 		replicationFailure:           new(uint32),
+		replicationPrimaries:         make(map[string]*replicationPrimary),
+		replicationSecondaries:       make(map[string]*replicationSecondary),
 		disablePerfStandby:           true,
 		activeContextCancelFunc:      new(atomic.Value),
+		drainContextCancelFunc:       new(atomic.Value),
 		allLoggers:                   conf.AllLoggers,
 		builtinRegistry:              conf.BuiltinRegistry,
 		neverBecomeActive:            new(uint32),
@@ -627,6 +691,7 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 	c.clusterLeaderParams.Store((*ClusterLeaderParams)(nil))
 
 	c.activeContextCancelFunc.Store((context.CancelFunc)(nil))
+	c.drainContextCancelFunc.Store((context.CancelFunc)(nil))
 
 	if conf.ClusterCipherSuites != "" {
 		suites, err := tlsutil.ParseCiphers(conf.ClusterCipherSuites)
@@ -642,6 +707,15 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 		Enabled: new(uint32),
 	}
 
+	if conf.AutoSnapshot != nil {
+		if err := conf.AutoSnapshot.validate(); err != nil {
+			return nil, errwrap.Wrapf("invalid automatic snapshots configuration: {{err}}", err)
+		}
+		autoSnapshotsLogger := conf.Logger.Named("snapshot-auto")
+		c.AddLogger(autoSnapshotsLogger)
+		c.autoSnapshots = newAutoSnapshots(c, conf.AutoSnapshot, autoSnapshotsLogger)
+	}
+
 	if c.seal == nil {
 		c.seal = NewDefaultSeal()
 	}
@@ -744,6 +818,8 @@ func NewCore(conf *CoreConfig) (*Core, error) {
 	uiStoragePrefix := systemBarrierPrefix + "ui"
 	c.uiConfig = NewUIConfig(conf.EnableUI, physical.NewView(c.physical, uiStoragePrefix), NewBarrierView(c.barrier, uiStoragePrefix))
 
+	c.namespaceStore = NewNamespaceStore(c)
+
 	return c, nil
 }
 
@@ -815,13 +891,16 @@ func (c *Core) unseal(key []byte, useRecoveryKeys bool) (bool, error) {
 	ctx := context.Background()
 
 	// Explicitly check for init status. This also checks if the seal
-	// configuration is valid (i.e. non-nil).
-	init, err := c.Initialized(ctx)
-	if err != nil {
-		return false, err
-	}
-	if !init {
-		return false, ErrNotInit
+	// configuration is valid (i.e. non-nil). Nodes joining a raft cluster
+	// are initialized once unsealed.
+	if !c.isRaftUnseal() {
+		init, err := c.Initialized(ctx)
+		if err != nil {
+			return false, err
+		}
+		if !init {
+			return false, ErrNotInit
+		}
 	}
 
 	// Verify the key length
@@ -849,6 +928,9 @@ func (c *Core) unseal(key []byte, useRecoveryKeys bool) (bool, error) {
 		return false, err
 	}
 	if masterKey != nil {
+		if c.isRaftUnseal() {
+			return c.unsealRaftJoin(ctx, masterKey)
+		}
 		return c.unsealInternal(ctx, masterKey)
 	}
 
@@ -880,9 +962,12 @@ func (c *Core) unsealPart(ctx context.Context, seal Seal, key []byte, useRecover
 
 	var config *SealConfig
 	var err error
-	if seal.RecoveryKeySupported() && (useRecoveryKeys || c.migrationSeal != nil) {
+	switch {
+	case c.isRaftUnseal():
+		config = c.raftInfo.leaderBarrierConfig
+	case seal.RecoveryKeySupported() && (useRecoveryKeys || c.migrationSeal != nil):
 		config, err = seal.RecoveryConfig(ctx)
-	} else {
+	default:
 		config, err = seal.BarrierConfig(ctx)
 	}
 	if err != nil {
@@ -1036,6 +1121,12 @@ func (c *Core) unsealPart(ctx context.Context, seal Seal, key []byte, useRecover
 			return nil, errors.New("unhandled migration case (shamir to shamir)")
 		}
 
+		// The entries wrapped with the previous seal are rewrapped with the
+		// new one on unseal
+		if err := c.markSealWrapMigrated(ctx); err != nil {
+			return nil, err
+		}
+
 		// At this point we've swapped things around and need to ensure we
 		// don't migrate again
 		c.migrationSeal = nil
@@ -1076,6 +1167,13 @@ func (c *Core) unsealInternal(ctx context.Context, masterKey []byte) (bool, erro
 		c.logger.Info("vault is unsealed")
 	}
 
+	// Take part in the raft cluster while unsealed
+	if err := c.startRaftStorage(ctx); err != nil {
+		c.barrier.Seal()
+		c.logger.Warn("vault is sealed")
+		return false, err
+	}
+
 	if err := preUnsealInternal(ctx, c); err != nil {
 		return false, err
 	}
@@ -1322,6 +1420,14 @@ func (c *Core) UIHeaders() (http.Header, error) {
 
 // sealInternal is an internal method used to seal the vault.  It does not do
 // any authorization checking.
+// drainRequests tells the requests blocking for changes to return, so that
+// sealing or stepping down does not wait for them.
+func (c *Core) drainRequests() {
+	if cancel := c.drainContextCancelFunc.Load().(context.CancelFunc); cancel != nil {
+		cancel()
+	}
+}
+
 func (c *Core) sealInternal() error {
 	return c.sealInternalWithOptions(true, false)
 }
@@ -1334,6 +1440,8 @@ func (c *Core) sealInternalWithOptions(grabStateLock, keepHALock bool) error {
 
 	c.logger.Info("marked as sealed")
 
+	c.drainRequests()
+
 	// Clear forwarding clients
 	c.requestForwardingConnectionLock.Lock()
 	c.clearForwardingClients()
@@ -1414,6 +1522,8 @@ func (c *Core) sealInternalWithOptions(grabStateLock, keepHALock bool) error {
 		return err
 	}
 
+	c.stopRaftStorage()
+
 	if c.ha != nil {
 		sd, ok := c.ha.(physical.ServiceDiscovery)
 		if ok {
@@ -1460,6 +1570,9 @@ func (s standardUnsealStrategy) unseal(ctx context.Context, logger log.Logger, c
 	if err := c.setupPluginCatalog(ctx); err != nil {
 		return err
 	}
+	if err := c.setupNamespaces(ctx); err != nil {
+		return err
+	}
 	if err := c.loadMounts(ctx); err != nil {
 		return err
 	}
@@ -1488,6 +1601,9 @@ func (s standardUnsealStrategy) unseal(ctx context.Context, logger log.Logger, c
 		if err := c.setupExpiration(expireLeaseStrategyRevoke); err != nil {
 			return err
 		}
+		if err := c.setupSealWrap(ctx); err != nil {
+			return err
+		}
 		if err := c.loadAudits(ctx); err != nil {
 			return err
 		}
@@ -1506,6 +1622,9 @@ func (s standardUnsealStrategy) unseal(ctx context.Context, logger log.Logger, c
 	} else {
 		c.auditBroker = NewAuditBroker(c.logger)
 	}
+	if err := c.startAutoSnapshots(ctx); err != nil {
+		return err
+	}
 
 	if c.clusterListener != nil && (c.ha != nil || shouldStartClusterListener(c)) {
 		if err := c.startForwarding(ctx); err != nil {
@@ -1536,6 +1655,10 @@ func (c *Core) postUnseal(ctx context.Context, ctxCancelFunc context.CancelFunc,
 	c.activeContext = ctx
 	c.activeContextCancelFunc.Store(ctxCancelFunc)
 
+	drainCtx, drainCtxCancel := context.WithCancel(context.Background())
+	c.drainContext = drainCtx
+	c.drainContextCancelFunc.Store(drainCtxCancel)
+
 	defer func() {
 		if retErr != nil {
 			ctxCancelFunc()
@@ -1556,6 +1679,15 @@ func (c *Core) postUnseal(ctx context.Context, ctxCancelFunc context.CancelFunc,
 		c.seal.SetRecoveryConfig(ctx, nil)
 	}
 
+	c.setupSealWrapper()
+
+	// Re-encrypt the keys protected by the seal if its key was rotated
+	if autoSeal, ok := c.seal.(*autoSeal); ok {
+		if err := autoSeal.UpgradeKeys(ctx); err != nil {
+			return err
+		}
+	}
+
 	if err := unsealer.unseal(ctx, c.logger, c); err != nil {
 		return err
 	}
@@ -1616,9 +1748,13 @@ func (c *Core) preSeal() error {
 	if err := c.stopRollback(); err != nil {
 		result = multierror.Append(result, errwrap.Wrapf("error stopping rollback: {{err}}", err))
 	}
+	if err := c.stopAutoSnapshots(); err != nil {
+		result = multierror.Append(result, errwrap.Wrapf("error stopping automatic snapshots: {{err}}", err))
+	}
 	if err := c.unloadMounts(context.Background()); err != nil {
 		result = multierror.Append(result, errwrap.Wrapf("error unloading mounts: {{err}}", err))
 	}
+	c.teardownNamespaces()
 	if err := enterprisePreSeal(c); err != nil {
 		result = multierror.Append(result, err)
 	}
@@ -1629,22 +1765,10 @@ func (c *Core) preSeal() error {
 	return result
 }
 
-func enterprisePostUnsealImpl(c *Core) error {
-	return nil
-}
-
 func enterprisePreSealImpl(c *Core) error {
 	return nil
 }
 
-func startReplicationImpl(c *Core) error {
-	return nil
-}
-
-func stopReplicationImpl(c *Core) error {
-	return nil
-}
-
 // emitMetrics is used to periodically expose metrics while running
 func (c *Core) emitMetrics(stopCh chan struct{}) {
 	emitTimer := time.Tick(time.Second)
@@ -1707,18 +1831,6 @@ func (c *Core) AuditedHeadersConfig() *AuditedHeadersConfig {
 	return c.auditedHeaders
 }
 
-func waitUntilWALShippedImpl(ctx context.Context, c *Core, index uint64) bool {
-	return true
-}
-
-func lastWALImpl(c *Core) uint64 {
-	return 0
-}
-
-func lastRemoteWALImpl(c *Core) uint64 {
-	return 0
-}
-
 func (c *Core) PhysicalSealConfigs(ctx context.Context) (*SealConfig, *SealConfig, error) {
 	pe, err := c.physical.Get(ctx, barrierSealConfigPath)
 	if err != nil {
@@ -1806,6 +1918,12 @@ func (c *Core) IsDRSecondary() bool {
 	return c.ReplicationState().HasState(consts.ReplicationDRSecondary)
 }
 
+// IsPerfSecondary returns whether the cluster is a performance replication
+// secondary, which forwards its writes to its primary
+func (c *Core) IsPerfSecondary() bool {
+	return c.ReplicationState().HasState(consts.ReplicationPerformanceSecondary)
+}
+
 func (c *Core) AddLogger(logger log.Logger) {
 	c.allLoggersLock.Lock()
 	defer c.allLoggersLock.Unlock()
@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/jiangjiali/vault/sdk/helper/cache"
-	"github.com/jiangjiali/vault/sdk/helper/namespace"
 	"github.com/jiangjiali/vault/sdk/logical"
 	"github.com/jiangjiali/vault/sdk/physical"
 	"github.com/jiangjiali/vault/vault/replication"
@@ -39,6 +38,11 @@ func coreInit(c *Core, conf *CoreConfig) error {
 	if !conf.DisableKeyEncodingChecks {
 		c.physical = physical.NewStorageEncoding(c.physical)
 	}
+
+	// Log the writes shipped to the secondaries
+	c.replicationStorage = c.physical
+	c.replicationLog = newReplicationLog()
+	c.physical = newReplicatedBackend(c, c.physical, c.replicationLog)
 	return nil
 }
 
@@ -79,11 +83,7 @@ func loadMFAConfigs(context.Context, *Core) error { return nil }
 
 func shouldStartClusterListener(*Core) bool { return true }
 
-func hasNamespaces(*Core) bool { return false }
-
-func (c *Core) namepaceByPath(string) *namespace.Namespace {
-	return namespace.RootNamespace
-}
+func hasNamespaces(*Core) bool { return true }
 
 func (c *Core) setupReplicatedClusterPrimary(*replication.Cluster) error { return nil }
 
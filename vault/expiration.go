@@ -1479,8 +1479,11 @@ func (m *ExpirationManager) loadEntryInternal(ctx context.Context, leaseID strin
 		// the lazy loaded restore process
 		m.restoreLoaded.Store(le.LeaseID, struct{}{})
 
-		// Setup revocation timer
-		m.updatePending(le, le.ExpireTime.Sub(time.Now()))
+		// Setup revocation timer, the leases replicated to a performance
+		// secondary are revoked by its primary
+		if !m.core.replicatedLease(le.LeaseID) {
+			m.updatePending(le, le.ExpireTime.Sub(time.Now()))
+		}
 	}
 	return le, nil
 }
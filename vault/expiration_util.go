@@ -3,27 +3,37 @@
 package vault
 
 import (
+	"fmt"
+
 	"github.com/jiangjiali/vault/sdk/helper/errwrap"
 	"github.com/jiangjiali/vault/sdk/helper/namespace"
 	"github.com/jiangjiali/vault/sdk/logical"
 )
 
-func (m *ExpirationManager) leaseView(*namespace.Namespace) *BarrierView {
-	return m.idView
+func (m *ExpirationManager) leaseView(ns *namespace.Namespace) *BarrierView {
+	if ns.ID == namespace.RootNamespaceID {
+		return m.idView
+	}
+	return m.core.namespaceSystemView(ns).SubView(expirationSubPath + leaseViewPrefix)
 }
 
-func (m *ExpirationManager) tokenIndexView(*namespace.Namespace) *BarrierView {
-	return m.tokenView
+func (m *ExpirationManager) tokenIndexView(ns *namespace.Namespace) *BarrierView {
+	if ns.ID == namespace.RootNamespaceID {
+		return m.tokenView
+	}
+	return m.core.namespaceSystemView(ns).SubView(expirationSubPath + tokenViewPrefix)
 }
 
 func (m *ExpirationManager) collectLeases() (map[*namespace.Namespace][]string, int, error) {
 	leaseCount := 0
 	existing := make(map[*namespace.Namespace][]string)
-	keys, err := logical.CollectKeys(m.quitContext, m.leaseView(namespace.RootNamespace))
-	if err != nil {
-		return nil, 0, errwrap.Wrapf("failed to scan for leases: {{err}}", err)
+	for _, ns := range m.core.allNamespaces() {
+		keys, err := logical.CollectKeys(m.quitContext, m.leaseView(ns))
+		if err != nil {
+			return nil, 0, errwrap.Wrapf(fmt.Sprintf("failed to scan for leases of namespace %q: {{err}}", ns.Path), err)
+		}
+		existing[ns] = keys
+		leaseCount += len(keys)
 	}
-	existing[namespace.RootNamespace] = keys
-	leaseCount += len(keys)
 	return existing, leaseCount, nil
 }
@@ -3,14 +3,19 @@ package vault
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
 	"fmt"
 
+	"github.com/jiangjiali/vault/sdk/helper/base62"
 	"github.com/jiangjiali/vault/sdk/helper/consts"
 	"github.com/jiangjiali/vault/sdk/helper/errwrap"
 	"github.com/jiangjiali/vault/sdk/helper/pgpkeys"
 	"github.com/jiangjiali/vault/sdk/helper/xor"
 	"github.com/jiangjiali/vault/sdk/helper/xxuuid"
+	"github.com/jiangjiali/vault/sdk/logical"
 	"github.com/jiangjiali/vault/shamir"
 )
 
@@ -23,7 +28,7 @@ var (
 
 	// GenerateDROperationTokenStrategy is the strategy used to generate a
 	// DR operational token
-	GenerateDROperationTokenStrategy GenerateRootStrategy = generateStandardRootToken{}
+	GenerateDROperationTokenStrategy GenerateRootStrategy = generateDROperationToken{}
 )
 
 // GenerateRootStrategy allows us to swap out the strategy we want to use to
@@ -54,6 +59,52 @@ func (g generateStandardRootToken) generate(ctx context.Context, c *Core) (strin
 	return te.ID, cleanupFunc, nil
 }
 
+// generateDROperationToken implements the GenerateRootStrategy and is in
+// charge of creating the token authorizing the replication operations of a
+// DR secondary, which cannot use the replicated tokens. Only its hash is
+// stored and a new token replaces the previous one.
+type generateDROperationToken struct{}
+
+func (g generateDROperationToken) generate(ctx context.Context, c *Core) (string, func(), error) {
+	id, err := base62.Random(TokenLength)
+	if err != nil {
+		return "", nil, err
+	}
+	token := fmt.Sprintf("s.%s", id)
+
+	hash := sha256.Sum256([]byte(token))
+	if err := c.barrier.Put(ctx, &logical.StorageEntry{
+		Key:   coreDROperationTokenPath,
+		Value: hash[:],
+	}); err != nil {
+		c.logger.Error("failed to store dr operation token", "error", err)
+		return "", nil, err
+	}
+
+	cleanupFunc := func() {
+		c.barrier.Delete(ctx, coreDROperationTokenPath)
+	}
+
+	return token, cleanupFunc, nil
+}
+
+// checkDROperationToken verifies a token generated with the DR operation
+// token strategy
+func (c *Core) checkDROperationToken(ctx context.Context, token string) error {
+	if token == "" {
+		return errors.New("missing dr operation token")
+	}
+	entry, err := c.barrier.Get(ctx, coreDROperationTokenPath)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256([]byte(token))
+	if entry == nil || subtle.ConstantTimeCompare(entry.Value, hash[:]) != 1 {
+		return logical.ErrPermissionDenied
+	}
+	return nil
+}
+
 // GenerateRootConfig holds the configuration for a root generation
 // command.
 type GenerateRootConfig struct {
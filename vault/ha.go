@@ -554,6 +554,8 @@ func (c *Core) waitForLeadership(newLeaderCh chan func(), manualStepDownCh, stop
 			c.logger.Warn("stepping down from active operation to standby")
 		}
 
+		c.drainRequests()
+
 		// Stop Active Duty
 		{
 			// Spawn this in a go routine so we can cancel the context and
@@ -76,6 +76,12 @@ func NewIdentityStore(ctx context.Context, core *Core, config *logical.BackendCo
 		BackendType: logical.TypeLogical,
 		Paths:       iStore.paths(),
 		Invalidate:  iStore.Invalidate,
+		PathsSpecial: &logical.Paths{
+			Unauthenticated: []string{
+				"oidc/.well-known/*",
+			},
+		},
+		PeriodicFunc: iStore.oidcPeriodicFunc,
 	}
 
 	err = iStore.Setup(ctx, config)
@@ -94,6 +100,7 @@ func (i *IdentityStore) paths() []*framework.Path {
 		groupPaths(i),
 		lookupPaths(i),
 		upgradePaths(i),
+		oidcPaths(i),
 	)
 }
 
@@ -0,0 +1,1055 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	"github.com/jiangjiali/vault/sdk/helper/identity"
+	"github.com/jiangjiali/vault/sdk/helper/jose"
+	"github.com/jiangjiali/vault/sdk/helper/namespace"
+	"github.com/jiangjiali/vault/sdk/helper/strutil"
+	"github.com/jiangjiali/vault/sdk/helper/xxuuid"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+const (
+	// Storage paths of the OIDC identity tokens
+	oidcTokensPrefix     = "oidc_tokens/"
+	oidcConfigStorageKey = oidcTokensPrefix + "config"
+	namedKeyConfigPath   = oidcTokensPrefix + "named_keys/"
+	publicKeysConfigPath = oidcTokensPrefix + "public_keys/"
+	roleConfigPath       = oidcTokensPrefix + "roles/"
+
+	defaultOIDCRotationPeriod  = 24 * time.Hour
+	defaultOIDCVerificationTTL = 24 * time.Hour
+	defaultOIDCTokenTTL        = 24 * time.Hour
+	defaultOIDCAlgorithm       = "RS256"
+)
+
+var (
+	supportedOIDCAlgs = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+	// reservedOIDCClaims are set by Vault and cannot be overridden by the
+	// template of a role
+	reservedOIDCClaims = []string{"iat", "aud", "exp", "iss", "sub", "namespace"}
+
+	// oidcTemplateDirectiveRegEx matches the templating directives of a role
+	// template
+	oidcTemplateDirectiveRegEx = regexp.MustCompile(`{{[^{}]*}}`)
+)
+
+// oidcConfig is the configuration of the identity token issuer.
+type oidcConfig struct {
+	Issuer string `json:"issuer"`
+}
+
+// namedKey is a rotatable key used to sign the identity tokens of the roles
+// referencing it.
+type namedKey struct {
+	Name            string           `json:"name"`
+	Algorithm       string           `json:"signing_algorithm"`
+	VerificationTTL time.Duration    `json:"verification_ttl"`
+	RotationPeriod  time.Duration    `json:"rotation_period"`
+	KeyRing         []*expireableKey `json:"key_ring"`
+	SigningKey      *jose.JSONWebKey `json:"signing_key"`
+	NextRotation    time.Time        `json:"next_rotation"`
+}
+
+// expireableKey is a public key of a named key. The key currently used for
+// signing has no expiration, previous keys are kept for verification_ttl after
+// being rotated out.
+type expireableKey struct {
+	KeyID    string    `json:"key_id"`
+	ExpireAt time.Time `json:"expire_at"`
+}
+
+// oidcRole determines the claims and the signing key of the identity tokens
+// generated for an entity.
+type oidcRole struct {
+	Name     string        `json:"name"`
+	Key      string        `json:"key"`
+	Template string        `json:"template"`
+	TTL      time.Duration `json:"ttl"`
+	ClientID string        `json:"client_id"`
+}
+
+func oidcPaths(i *IdentityStore) []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "oidc/config/?$",
+			Fields: map[string]*framework.FieldSchema{
+				"issuer": {
+					Type: framework.TypeString,
+					Description: `Issuer URL to be used in the iss claim of the token. If not set, Vault's
+api_addr will be used. The issuer is a case sensitive URL using the https
+scheme that contains scheme, host, and optionally, port number and path
+components, but no query or fragment components.`,
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation:   i.pathOIDCReadConfig(),
+				logical.UpdateOperation: i.pathOIDCUpdateConfig(),
+			},
+
+			HelpSynopsis:    strings.TrimSpace(oidcHelp["oidc-config"][0]),
+			HelpDescription: strings.TrimSpace(oidcHelp["oidc-config"][1]),
+		},
+		{
+			Pattern: "oidc/key/" + framework.GenericNameRegex("name") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the key",
+				},
+				"rotation_period": {
+					Type:        framework.TypeDurationSecond,
+					Description: "How often to generate a new keypair. Defaults to 24h.",
+				},
+				"verification_ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Controls how long the public portion of a key will be available for verification after being rotated. Defaults to 24h.",
+				},
+				"algorithm": {
+					Type:        framework.TypeString,
+					Description: fmt.Sprintf("Signing algorithm to use. One of %s. Defaults to %s.", strings.Join(supportedOIDCAlgs, ", "), defaultOIDCAlgorithm),
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: i.pathOIDCCreateUpdateKey(),
+				logical.ReadOperation:   i.pathOIDCReadKey(),
+				logical.DeleteOperation: i.pathOIDCDeleteKey(),
+			},
+
+			HelpSynopsis:    strings.TrimSpace(oidcHelp["oidc-key"][0]),
+			HelpDescription: strings.TrimSpace(oidcHelp["oidc-key"][1]),
+		},
+		{
+			Pattern: "oidc/key/" + framework.GenericNameRegex("name") + "/rotate/?$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the key",
+				},
+				"verification_ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "Controls how long the public portion of the rotated key will be available for verification. Defaults to the verification_ttl of the key.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: i.pathOIDCRotateKey(),
+			},
+
+			HelpSynopsis:    strings.TrimSpace(oidcHelp["oidc-key-rotate"][0]),
+			HelpDescription: strings.TrimSpace(oidcHelp["oidc-key-rotate"][1]),
+		},
+		{
+			Pattern: "oidc/key/?$",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: i.pathOIDCList(namedKeyConfigPath),
+			},
+
+			HelpSynopsis:    strings.TrimSpace(oidcHelp["oidc-key-list"][0]),
+			HelpDescription: strings.TrimSpace(oidcHelp["oidc-key-list"][1]),
+		},
+		{
+			Pattern: "oidc/role/" + framework.GenericNameRegex("name") + "$",
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the role",
+				},
+				"key": {
+					Type:        framework.TypeString,
+					Description: "The OIDC key to use for generating tokens. The specified key must already exist.",
+				},
+				"template": {
+					Type:        framework.TypeString,
+					Description: "The template string to use for generating tokens. This may be in string-ified JSON.",
+				},
+				"ttl": {
+					Type:        framework.TypeDurationSecond,
+					Description: "TTL of the tokens generated against the role. Defaults to 24h.",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.UpdateOperation: i.pathOIDCCreateUpdateRole(),
+				logical.ReadOperation:   i.pathOIDCReadRole(),
+				logical.DeleteOperation: i.pathOIDCDeleteRole(),
+			},
+
+			HelpSynopsis:    strings.TrimSpace(oidcHelp["oidc-role"][0]),
+			HelpDescription: strings.TrimSpace(oidcHelp["oidc-role"][1]),
+		},
+		{
+			Pattern: "oidc/role/?$",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: i.pathOIDCList(roleConfigPath),
+			},
+
+			HelpSynopsis:    strings.TrimSpace(oidcHelp["oidc-role-list"][0]),
+			HelpDescription: strings.TrimSpace(oidcHelp["oidc-role-list"][1]),
+		},
+		{
+			Pattern: "oidc/token/" + framework.GenericNameRegex("name"),
+			Fields: map[string]*framework.FieldSchema{
+				"name": {
+					Type:        framework.TypeString,
+					Description: "Name of the role",
+				},
+			},
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: i.pathOIDCGenerateToken(),
+			},
+
+			HelpSynopsis:    strings.TrimSpace(oidcHelp["oidc-token"][0]),
+			HelpDescription: strings.TrimSpace(oidcHelp["oidc-token"][1]),
+		},
+		{
+			Pattern: "oidc/.well-known/openid-configuration/?$",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: i.pathOIDCDiscovery(),
+			},
+
+			HelpSynopsis:    strings.TrimSpace(oidcHelp["oidc-discovery"][0]),
+			HelpDescription: strings.TrimSpace(oidcHelp["oidc-discovery"][1]),
+		},
+		{
+			Pattern: "oidc/.well-known/keys/?$",
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ReadOperation: i.pathOIDCReadPublicKeys(),
+			},
+
+			HelpSynopsis:    strings.TrimSpace(oidcHelp["oidc-keys"][0]),
+			HelpDescription: strings.TrimSpace(oidcHelp["oidc-keys"][1]),
+		},
+	}
+}
+
+func (i *IdentityStore) pathOIDCReadConfig() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		config, err := i.getOIDCConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"issuer": config.Issuer,
+			},
+		}, nil
+	}
+}
+
+func (i *IdentityStore) pathOIDCUpdateConfig() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		i.oidcLock.Lock()
+		defer i.oidcLock.Unlock()
+
+		config, err := i.getOIDCConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		if issuerRaw, ok := d.GetOk("issuer"); ok {
+			issuer := issuerRaw.(string)
+			if issuer != "" {
+				u, err := url.Parse(issuer)
+				if err != nil {
+					return logical.ErrorResponse("invalid issuer: %s", err.Error()), nil
+				}
+				if u.Scheme == "" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+					return logical.ErrorResponse("invalid issuer, must contain a scheme and a host and no query or fragment components"), nil
+				}
+			}
+			config.Issuer = strings.TrimSuffix(issuer, "/")
+		}
+
+		entry, err := logical.StorageEntryJSON(oidcConfigStorageKey, config)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, req.Storage.Put(ctx, entry)
+	}
+}
+
+func (i *IdentityStore) pathOIDCCreateUpdateKey() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		name := d.Get("name").(string)
+
+		i.oidcLock.Lock()
+		defer i.oidcLock.Unlock()
+
+		key, err := i.getNamedKey(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+
+		create := key == nil
+		if create {
+			key = &namedKey{
+				Name:            name,
+				Algorithm:       defaultOIDCAlgorithm,
+				RotationPeriod:  defaultOIDCRotationPeriod,
+				VerificationTTL: defaultOIDCVerificationTTL,
+			}
+		}
+
+		if rotationPeriodRaw, ok := d.GetOk("rotation_period"); ok {
+			key.RotationPeriod = time.Duration(rotationPeriodRaw.(int)) * time.Second
+		}
+		if key.RotationPeriod < time.Minute {
+			return logical.ErrorResponse("rotation_period must be at least one minute"), nil
+		}
+
+		if verificationTTLRaw, ok := d.GetOk("verification_ttl"); ok {
+			key.VerificationTTL = time.Duration(verificationTTLRaw.(int)) * time.Second
+		}
+		if key.VerificationTTL <= 0 {
+			return logical.ErrorResponse("verification_ttl must be positive"), nil
+		}
+
+		if algorithmRaw, ok := d.GetOk("algorithm"); ok {
+			algorithm := algorithmRaw.(string)
+			if !strutil.StrListContains(supportedOIDCAlgs, algorithm) {
+				return logical.ErrorResponse("unknown signing algorithm %q", algorithm), nil
+			}
+			// The algorithm of an existing key takes effect on its next
+			// rotation, until then tokens are signed with the algorithm of
+			// the current signing key
+			key.Algorithm = algorithm
+		}
+
+		// Tokens must remain verifiable for as long as they are valid
+		roles, err := i.rolesByKey(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			if role.TTL > key.VerificationTTL {
+				return logical.ErrorResponse("verification_ttl cannot be shorter than the ttl of role %q", role.Name), nil
+			}
+		}
+
+		if create {
+			return nil, key.rotate(ctx, req.Storage, 0)
+		}
+
+		if now := time.Now(); key.NextRotation.After(now.Add(key.RotationPeriod)) {
+			key.NextRotation = now.Add(key.RotationPeriod)
+		}
+
+		return nil, i.putNamedKey(ctx, req.Storage, key)
+	}
+}
+
+func (i *IdentityStore) pathOIDCReadKey() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		i.oidcLock.RLock()
+		defer i.oidcLock.RUnlock()
+
+		key, err := i.getNamedKey(ctx, req.Storage, d.Get("name").(string))
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, nil
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"rotation_period":  int64(key.RotationPeriod.Seconds()),
+				"verification_ttl": int64(key.VerificationTTL.Seconds()),
+				"algorithm":        key.Algorithm,
+				"next_rotation":    key.NextRotation.Format(time.RFC3339),
+			},
+		}, nil
+	}
+}
+
+func (i *IdentityStore) pathOIDCDeleteKey() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		name := d.Get("name").(string)
+
+		i.oidcLock.Lock()
+		defer i.oidcLock.Unlock()
+
+		roles, err := i.rolesByKey(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(roles) > 0 {
+			names := make([]string, 0, len(roles))
+			for _, role := range roles {
+				names = append(names, role.Name)
+			}
+			return logical.ErrorResponse("unable to delete key %q because it is currently referenced by these roles: %s", name, strings.Join(names, ", ")), logical.ErrInvalidRequest
+		}
+
+		key, err := i.getNamedKey(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return nil, nil
+		}
+
+		for _, ek := range key.KeyRing {
+			if err := req.Storage.Delete(ctx, publicKeysConfigPath+ek.KeyID); err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, req.Storage.Delete(ctx, namedKeyConfigPath+name)
+	}
+}
+
+func (i *IdentityStore) pathOIDCRotateKey() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		name := d.Get("name").(string)
+
+		i.oidcLock.Lock()
+		defer i.oidcLock.Unlock()
+
+		key, err := i.getNamedKey(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return logical.ErrorResponse("no named key found at %q", name), logical.ErrInvalidRequest
+		}
+
+		verificationTTL := key.VerificationTTL
+		if verificationTTLRaw, ok := d.GetOk("verification_ttl"); ok {
+			verificationTTL = time.Duration(verificationTTLRaw.(int)) * time.Second
+		}
+
+		return nil, key.rotate(ctx, req.Storage, verificationTTL)
+	}
+}
+
+func (i *IdentityStore) pathOIDCList(prefix string) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		keys, err := req.Storage.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+
+		return logical.ListResponse(keys), nil
+	}
+}
+
+func (i *IdentityStore) pathOIDCCreateUpdateRole() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		name := d.Get("name").(string)
+
+		i.oidcLock.Lock()
+		defer i.oidcLock.Unlock()
+
+		role, err := i.getOIDCRole(ctx, req.Storage, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			clientID, err := xxuuid.GenerateUUID()
+			if err != nil {
+				return nil, err
+			}
+			role = &oidcRole{
+				Name:     name,
+				TTL:      defaultOIDCTokenTTL,
+				ClientID: clientID,
+			}
+		}
+
+		if keyRaw, ok := d.GetOk("key"); ok {
+			role.Key = keyRaw.(string)
+		}
+		if role.Key == "" {
+			return logical.ErrorResponse("the key parameter is required"), nil
+		}
+
+		if templateRaw, ok := d.GetOk("template"); ok {
+			role.Template = templateRaw.(string)
+		}
+		if err := validateOIDCTemplate(role.Template); err != nil {
+			return logical.ErrorResponse("invalid template: %s", err.Error()), nil
+		}
+
+		if ttlRaw, ok := d.GetOk("ttl"); ok {
+			role.TTL = time.Duration(ttlRaw.(int)) * time.Second
+		}
+		if role.TTL <= 0 {
+			return logical.ErrorResponse("ttl must be positive"), nil
+		}
+
+		key, err := i.getNamedKey(ctx, req.Storage, role.Key)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil {
+			return logical.ErrorResponse("key %q does not exist", role.Key), nil
+		}
+		if role.TTL > key.VerificationTTL {
+			return logical.ErrorResponse("a role's token ttl cannot be longer than the verification_ttl of the key it references"), nil
+		}
+
+		entry, err := logical.StorageEntryJSON(roleConfigPath+name, role)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, req.Storage.Put(ctx, entry)
+	}
+}
+
+func (i *IdentityStore) pathOIDCReadRole() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		role, err := i.getOIDCRole(ctx, req.Storage, d.Get("name").(string))
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return nil, nil
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"key":       role.Key,
+				"template":  role.Template,
+				"ttl":       int64(role.TTL.Seconds()),
+				"client_id": role.ClientID,
+			},
+		}, nil
+	}
+}
+
+func (i *IdentityStore) pathOIDCDeleteRole() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		i.oidcLock.Lock()
+		defer i.oidcLock.Unlock()
+
+		return nil, req.Storage.Delete(ctx, roleConfigPath+d.Get("name").(string))
+	}
+}
+
+// pathOIDCGenerateToken returns an identity token for the entity of the
+// requesting token, signed by the key of the role.
+func (i *IdentityStore) pathOIDCGenerateToken() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		ns, err := namespace.FromContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		roleName := d.Get("name").(string)
+
+		i.oidcLock.RLock()
+		defer i.oidcLock.RUnlock()
+
+		role, err := i.getOIDCRole(ctx, req.Storage, roleName)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil {
+			return logical.ErrorResponse("role %q not found", roleName), nil
+		}
+
+		key, err := i.getNamedKey(ctx, req.Storage, role.Key)
+		if err != nil {
+			return nil, err
+		}
+		if key == nil || key.SigningKey == nil {
+			return logical.ErrorResponse("key %q not found", role.Key), nil
+		}
+
+		if req.EntityID == "" {
+			return logical.ErrorResponse("no entity associated with the request's token"), nil
+		}
+		entity, err := i.MemDBEntityByID(req.EntityID, true)
+		if err != nil {
+			return nil, err
+		}
+		if entity == nil {
+			return logical.ErrorResponse("entity %q not found", req.EntityID), nil
+		}
+		if entity.Disabled {
+			return logical.ErrorResponse("entity %q is disabled", req.EntityID), nil
+		}
+
+		issuer, err := i.oidcIssuer(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		claims := map[string]interface{}{
+			"iss":       issuer,
+			"sub":       entity.ID,
+			"aud":       role.ClientID,
+			"namespace": ns.ID,
+			"iat":       now.Unix(),
+			"exp":       now.Add(role.TTL).Unix(),
+		}
+
+		if role.Template != "" {
+			directGroups, inheritedGroups, err := i.groupsByEntityID(entity.ID)
+			if err != nil {
+				return nil, errwrap.Wrapf("failed to fetch group memberships: {{err}}", err)
+			}
+
+			templateClaims, err := renderOIDCTemplate(role.Template, ns, entity, append(directGroups, inheritedGroups...))
+			if err != nil {
+				return logical.ErrorResponse("error populating template of role %q: %s", roleName, err.Error()), nil
+			}
+			for k, v := range templateClaims {
+				if !strutil.StrListContains(reservedOIDCClaims, k) {
+					claims[k] = v
+				}
+			}
+		}
+
+		token, err := key.signPayload(claims)
+		if err != nil {
+			return nil, errwrap.Wrapf("error signing token: {{err}}", err)
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"token":     token,
+				"client_id": role.ClientID,
+				"ttl":       int64(role.TTL.Seconds()),
+			},
+		}, nil
+	}
+}
+
+// pathOIDCDiscovery returns the OpenID Connect discovery document of the
+// issuer.
+func (i *IdentityStore) pathOIDCDiscovery() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		issuer, err := i.oidcIssuer(ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+
+		discovery := map[string]interface{}{
+			"issuer":                                issuer,
+			"jwks_uri":                              issuer + "/.well-known/keys",
+			"response_types_supported":              []string{"id_token"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": supportedOIDCAlgs,
+		}
+
+		return oidcRawResponse(discovery)
+	}
+}
+
+// pathOIDCReadPublicKeys returns the public keys relying parties use to verify
+// the identity tokens.
+func (i *IdentityStore) pathOIDCReadPublicKeys() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		keyIDs, err := req.Storage.List(ctx, publicKeysConfigPath)
+		if err != nil {
+			return nil, err
+		}
+
+		jwks := &jose.JSONWebKeySet{
+			Keys: make([]jose.JSONWebKey, 0, len(keyIDs)),
+		}
+		for _, keyID := range keyIDs {
+			entry, err := req.Storage.Get(ctx, publicKeysConfigPath+keyID)
+			if err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				continue
+			}
+
+			var key jose.JSONWebKey
+			if err := entry.DecodeJSON(&key); err != nil {
+				return nil, err
+			}
+			jwks.Keys = append(jwks.Keys, key)
+		}
+
+		return oidcRawResponse(jwks)
+	}
+}
+
+func oidcRawResponse(body interface{}) (*logical.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPStatusCode:  200,
+			logical.HTTPRawBody:     data,
+			logical.HTTPContentType: "application/json",
+		},
+	}, nil
+}
+
+// oidcPeriodicFunc rotates the keys that are due and removes the public keys
+// whose verification period is over.
+func (i *IdentityStore) oidcPeriodicFunc(ctx context.Context, req *logical.Request) error {
+	names, err := req.Storage.List(ctx, namedKeyConfigPath)
+	if err != nil {
+		return err
+	}
+
+	i.oidcLock.Lock()
+	defer i.oidcLock.Unlock()
+
+	now := time.Now()
+	for _, name := range names {
+		key, err := i.getNamedKey(ctx, req.Storage, name)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			continue
+		}
+
+		if !now.Before(key.NextRotation) {
+			i.logger.Debug("rotating oidc key", "name", name)
+			if err := key.rotate(ctx, req.Storage, key.VerificationTTL); err != nil {
+				return err
+			}
+		}
+
+		var keyRing []*expireableKey
+		for _, ek := range key.KeyRing {
+			if ek.ExpireAt.IsZero() || now.Before(ek.ExpireAt) {
+				keyRing = append(keyRing, ek)
+				continue
+			}
+			if err := req.Storage.Delete(ctx, publicKeysConfigPath+ek.KeyID); err != nil {
+				return err
+			}
+		}
+		if len(keyRing) != len(key.KeyRing) {
+			key.KeyRing = keyRing
+			if err := i.putNamedKey(ctx, req.Storage, key); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// rotate generates a new signing key, and schedules the expiration of the
+// public key of the previous one after verificationTTL.
+func (k *namedKey) rotate(ctx context.Context, s logical.Storage, verificationTTL time.Duration) error {
+	privateKey, err := generateOIDCKey(k.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	keyID, err := xxuuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+
+	signingKey := &jose.JSONWebKey{
+		Key:       privateKey,
+		KeyID:     keyID,
+		Algorithm: k.Algorithm,
+		Use:       "sig",
+	}
+
+	publicKey := signingKey.Public()
+	entry, err := logical.StorageEntryJSON(publicKeysConfigPath+keyID, publicKey)
+	if err != nil {
+		return err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, ek := range k.KeyRing {
+		if ek.ExpireAt.IsZero() {
+			ek.ExpireAt = now.Add(verificationTTL)
+		}
+	}
+	k.KeyRing = append(k.KeyRing, &expireableKey{KeyID: keyID})
+	k.SigningKey = signingKey
+	k.NextRotation = now.Add(k.RotationPeriod)
+
+	entry, err = logical.StorageEntryJSON(namedKeyConfigPath+k.Name, k)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+// signPayload signs the claims with the current signing key, using the
+// algorithm the key was generated for rather than the configured one, which
+// only applies from the next rotation.
+func (k *namedKey) signPayload(claims map[string]interface{}) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.SignatureAlgorithm(k.SigningKey.Algorithm),
+		Key:       k.SigningKey,
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		return "", err
+	}
+
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+
+	return signature.CompactSerialize()
+}
+
+func generateOIDCKey(algorithm string) (interface{}, error) {
+	switch algorithm {
+	case "RS256", "RS384", "RS512":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	}
+
+	return nil, fmt.Errorf("unknown signing algorithm %q", algorithm)
+}
+
+// validateOIDCTemplate checks that the template is a JSON object once its
+// directives are populated and that it doesn't set reserved claims.
+func validateOIDCTemplate(template string) error {
+	if template == "" {
+		return nil
+	}
+
+	if _, _, err := identity.PopulateString(&identity.PopulateStringInput{
+		ValidityCheckOnly: true,
+		String:            template,
+		Mode:              identity.JSONTemplating,
+	}); err != nil {
+		return err
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal([]byte(oidcTemplateDirectiveRegEx.ReplaceAllString(template, "null")), &claims); err != nil {
+		return errors.New("template must be a JSON object")
+	}
+
+	for k := range claims {
+		if strutil.StrListContains(reservedOIDCClaims, k) {
+			return fmt.Errorf("top level key %q cannot be set, reserved claims are %s", k, strings.Join(reservedOIDCClaims, ", "))
+		}
+	}
+
+	return nil
+}
+
+// renderOIDCTemplate populates the template with the entity and its groups and
+// returns the resulting claims. Claims without a value are left out.
+func renderOIDCTemplate(template string, ns *namespace.Namespace, entity *identity.Entity, groups []*identity.Group) (map[string]interface{}, error) {
+	_, populated, err := identity.PopulateString(&identity.PopulateStringInput{
+		String:    template,
+		Entity:    entity,
+		Groups:    groups,
+		Namespace: ns,
+		Mode:      identity.JSONTemplating,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal([]byte(populated), &claims); err != nil {
+		return nil, errwrap.Wrapf("populated template is not a JSON object: {{err}}", err)
+	}
+
+	pruneNullClaims(claims)
+
+	return claims, nil
+}
+
+func pruneNullClaims(claims map[string]interface{}) {
+	for k, v := range claims {
+		switch v := v.(type) {
+		case nil:
+			delete(claims, k)
+		case map[string]interface{}:
+			pruneNullClaims(v)
+		}
+	}
+}
+
+func (i *IdentityStore) getOIDCConfig(ctx context.Context, s logical.Storage) (*oidcConfig, error) {
+	entry, err := s.Get(ctx, oidcConfigStorageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	config := new(oidcConfig)
+	if entry == nil {
+		return config, nil
+	}
+	if err := entry.DecodeJSON(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// oidcIssuer returns the configured issuer, or the address of the identity
+// OIDC endpoints of this cluster.
+func (i *IdentityStore) oidcIssuer(ctx context.Context, s logical.Storage) (string, error) {
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	config, err := i.getOIDCConfig(ctx, s)
+	if err != nil {
+		return "", err
+	}
+
+	issuer := config.Issuer
+	if issuer == "" {
+		issuer = strings.TrimSuffix(i.core.redirectAddr, "/")
+	}
+
+	return issuer + "/v1/" + ns.Path + "identity/oidc", nil
+}
+
+func (i *IdentityStore) getNamedKey(ctx context.Context, s logical.Storage, name string) (*namedKey, error) {
+	entry, err := s.Get(ctx, namedKeyConfigPath+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	key := new(namedKey)
+	if err := entry.DecodeJSON(key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (i *IdentityStore) putNamedKey(ctx context.Context, s logical.Storage, key *namedKey) error {
+	entry, err := logical.StorageEntryJSON(namedKeyConfigPath+key.Name, key)
+	if err != nil {
+		return err
+	}
+
+	return s.Put(ctx, entry)
+}
+
+func (i *IdentityStore) getOIDCRole(ctx context.Context, s logical.Storage, name string) (*oidcRole, error) {
+	entry, err := s.Get(ctx, roleConfigPath+name)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	role := new(oidcRole)
+	if err := entry.DecodeJSON(role); err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+// rolesByKey returns the roles referencing the named key.
+func (i *IdentityStore) rolesByKey(ctx context.Context, s logical.Storage, key string) ([]*oidcRole, error) {
+	names, err := s.List(ctx, roleConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var roles []*oidcRole
+	for _, name := range names {
+		role, err := i.getOIDCRole(ctx, s, name)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && role.Key == key {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles, nil
+}
+
+var oidcHelp = map[string][2]string{
+	"oidc-config": {
+		"OIDC configuration",
+		"Update OIDC configuration in the identity backend",
+	},
+	"oidc-key": {
+		"CRUD operations for OIDC keys.",
+		"Create, Read, Update, and Delete OIDC named keys.",
+	},
+	"oidc-key-rotate": {
+		"Rotate a named OIDC key.",
+		"Rotate a named OIDC key, the previous public key remains available for verification for verification_ttl.",
+	},
+	"oidc-key-list": {
+		"List OIDC keys",
+		"List all named OIDC keys",
+	},
+	"oidc-role": {
+		"CRUD operations on OIDC Roles",
+		`Create, Read, Update, and Delete OIDC Roles. A role binds a named key to a
+template of claims populated with the metadata and group memberships of the
+entity the token is generated for, e.g.
+
+{
+  "email": {{identity.entity.metadata.email}},
+  "groups": {{identity.entity.groups.names}}
+}`,
+	},
+	"oidc-role-list": {
+		"List configured OIDC roles",
+		"List all configured OIDC roles in the identity backend.",
+	},
+	"oidc-token": {
+		"Generate an OIDC token",
+		"Generate an OIDC token against a configured role. The vault token used to call this path must have a corresponding entity.",
+	},
+	"oidc-discovery": {
+		"Query OIDC configurations",
+		"Query this path to retrieve the configured OIDC Issuer and Keys endpoints, response types, subject types, and signing algorithms used by the OIDC backend.",
+	},
+	"oidc-keys": {
+		"Retrieve public keys",
+		"Query this path to retrieve the public portion of keys used to sign OIDC tokens. Clients can use this to validate the authenticity of the OIDC token claims.",
+	},
+}
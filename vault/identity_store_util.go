@@ -2065,3 +2065,54 @@ func (i *IdentityStore) handleAliasListCommon(ctx context.Context, groupAlias bo
 
 	return logical.ListResponseWithInfo(aliasIDs, aliasInfo), nil
 }
+
+// deleteNamespaceIdentities deletes the entities and groups of the namespace
+// of the context, when the namespace is deleted.
+func (i *IdentityStore) deleteNamespaceIdentities(ctx context.Context) error {
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	var groupIDs []string
+	txn := i.db.Txn(false)
+	iter, err := txn.Get(groupsTable, "namespace_id", ns.ID)
+	if err != nil {
+		return errwrap.Wrapf("failed to lookup groups using namespace ID: {{err}}", err)
+	}
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		groupIDs = append(groupIDs, raw.(*identity.Group).ID)
+	}
+	for _, groupID := range groupIDs {
+		if _, err := i.handleGroupDeleteCommon(ctx, groupID, true); err != nil {
+			return err
+		}
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	txn = i.db.Txn(true)
+	defer txn.Abort()
+
+	iter, err = txn.Get(entitiesTable, "namespace_id", ns.ID)
+	if err != nil {
+		return errwrap.Wrapf("failed to fetch iterator for entities in memdb: {{err}}", err)
+	}
+	var entities []*identity.Entity
+	for raw := iter.Next(); raw != nil; raw = iter.Next() {
+		entity, err := raw.(*identity.Entity).Clone()
+		if err != nil {
+			return err
+		}
+		entities = append(entities, entity)
+	}
+	for _, entity := range entities {
+		if err := i.handleEntityDeleteCommon(ctx, txn, entity); err != nil {
+			return err
+		}
+	}
+
+	txn.Commit()
+	return nil
+}
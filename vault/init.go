@@ -145,6 +145,15 @@ func (c *Core) Initialize(ctx context.Context, initParams *InitParams) (*InitRes
 		return nil, errwrap.Wrapf("error initializing seal: {{err}}", err)
 	}
 
+	// With raft storage this node becomes the first member of the cluster,
+	// raft runs until the node is sealed again at the end of the init
+	if err := c.bootstrapRaftStorage(ctx); err != nil {
+		c.logger.Error("failed to bootstrap raft storage", "error", err)
+		c.stopRaftStorage()
+		return nil, errwrap.Wrapf("failed to bootstrap raft storage: {{err}}", err)
+	}
+	defer c.stopRaftStorage()
+
 	barrierKey, barrierUnsealKeys, err := c.generateShares(barrierConfig)
 	if err != nil {
 		c.logger.Error("error generating shares", "error", err)
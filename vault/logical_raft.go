@@ -0,0 +1,335 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+// raftStoragePaths returns the paths used to manage the raft storage
+// cluster.
+func (b *SystemBackend) raftStoragePaths() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "storage/raft/bootstrap/challenge",
+
+			Fields: map[string]*framework.FieldSchema{
+				"server_id": {
+					Type:        framework.TypeString,
+					Description: "ID of the node asking to join the cluster.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleRaftBootstrapChallengeWrite(),
+					Summary:  "Create a challenge for a node joining the raft cluster.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysRaftHelp["raft-bootstrap-challenge"][0]),
+			HelpDescription: strings.TrimSpace(sysRaftHelp["raft-bootstrap-challenge"][1]),
+		},
+		{
+			Pattern: "storage/raft/bootstrap/answer",
+
+			Fields: map[string]*framework.FieldSchema{
+				"server_id": {
+					Type:        framework.TypeString,
+					Description: "ID of the node joining the cluster.",
+				},
+				"answer": {
+					Type:        framework.TypeString,
+					Description: "Base64 encoded answer to the challenge.",
+				},
+				"raft_addr": {
+					Type:        framework.TypeString,
+					Description: "Address of the raft transport of the node.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleRaftBootstrapAnswerWrite(),
+					Summary:  "Add a node that answered its challenge to the raft cluster.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysRaftHelp["raft-bootstrap-answer"][0]),
+			HelpDescription: strings.TrimSpace(sysRaftHelp["raft-bootstrap-answer"][1]),
+		},
+		{
+			Pattern: "storage/raft/configuration",
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleRaftConfigurationGet(),
+					Summary:  "Return the members of the raft cluster.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysRaftHelp["raft-configuration"][0]),
+			HelpDescription: strings.TrimSpace(sysRaftHelp["raft-configuration"][1]),
+		},
+		{
+			Pattern: "storage/raft/remove-peer",
+
+			Fields: map[string]*framework.FieldSchema{
+				"server_id": {
+					Type:        framework.TypeString,
+					Description: "ID of the node to remove from the cluster.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleRaftRemovePeerUpdate(),
+					Summary:  "Remove a node from the raft cluster.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysRaftHelp["raft-remove-peer"][0]),
+			HelpDescription: strings.TrimSpace(sysRaftHelp["raft-remove-peer"][1]),
+		},
+		{
+			Pattern: "storage/raft/snapshot",
+
+			Fields: map[string]*framework.FieldSchema{
+				"snapshot": {
+					Type:        framework.TypeString,
+					Description: "Base64 encoded snapshot to restore.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleRaftSnapshotRead(),
+					Summary:  "Return a snapshot of the storage.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleRaftSnapshotWrite(),
+					Summary:  "Restore a snapshot of the storage.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysRaftHelp["raft-snapshot"][0]),
+			HelpDescription: strings.TrimSpace(sysRaftHelp["raft-snapshot"][1]),
+		},
+	}
+}
+
+func (b *SystemBackend) handleRaftBootstrapChallengeWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if b.Core.getRaftBackend() == nil {
+			return logical.ErrorResponse("raft storage is not in use"), logical.ErrInvalidRequest
+		}
+
+		serverID := d.Get("server_id").(string)
+		if serverID == "" {
+			return logical.ErrorResponse("no server id provided"), logical.ErrInvalidRequest
+		}
+
+		challenge, sealConfig, err := b.Core.raftBootstrapChallenge(ctx, serverID)
+		if err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"challenge":   base64.StdEncoding.EncodeToString(challenge),
+				"seal_config": sealConfig,
+			},
+		}, nil
+	}
+}
+
+func (b *SystemBackend) handleRaftBootstrapAnswerWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if b.Core.getRaftBackend() == nil {
+			return logical.ErrorResponse("raft storage is not in use"), logical.ErrInvalidRequest
+		}
+
+		serverID := d.Get("server_id").(string)
+		if serverID == "" {
+			return logical.ErrorResponse("no server id provided"), logical.ErrInvalidRequest
+		}
+		raftAddr := d.Get("raft_addr").(string)
+		if raftAddr == "" {
+			return logical.ErrorResponse("no raft address provided"), logical.ErrInvalidRequest
+		}
+		answer, err := base64.StdEncoding.DecodeString(d.Get("answer").(string))
+		if err != nil || len(answer) == 0 {
+			return logical.ErrorResponse("invalid answer given"), logical.ErrInvalidRequest
+		}
+
+		peers, err := b.Core.raftBootstrapAnswer(ctx, serverID, answer, raftAddr)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+
+		b.Backend.Logger().Info("added node to the raft cluster", "server_id", serverID, "raft_addr", raftAddr)
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"peers": peers,
+			},
+		}, nil
+	}
+}
+
+func (b *SystemBackend) handleRaftConfigurationGet() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		raftBackend := b.Core.getRaftBackend()
+		if raftBackend == nil {
+			return logical.ErrorResponse("raft storage is not in use"), logical.ErrInvalidRequest
+		}
+
+		servers, err := raftBackend.GetConfiguration(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"servers": servers,
+			},
+		}, nil
+	}
+}
+
+func (b *SystemBackend) handleRaftRemovePeerUpdate() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		raftBackend := b.Core.getRaftBackend()
+		if raftBackend == nil {
+			return logical.ErrorResponse("raft storage is not in use"), logical.ErrInvalidRequest
+		}
+
+		serverID := d.Get("server_id").(string)
+		if serverID == "" {
+			return logical.ErrorResponse("no server id provided"), logical.ErrInvalidRequest
+		}
+		if serverID == raftBackend.NodeID() {
+			return logical.ErrorResponse("cannot remove the active node, step it down first"), logical.ErrInvalidRequest
+		}
+
+		if err := raftBackend.RemovePeer(ctx, serverID); err != nil {
+			return nil, err
+		}
+
+		b.Backend.Logger().Info("removed node from the raft cluster", "server_id", serverID)
+		return nil, nil
+	}
+}
+
+func (b *SystemBackend) handleRaftSnapshotRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		raftBackend := b.Core.getRaftBackend()
+		if raftBackend == nil {
+			return logical.ErrorResponse("raft storage is not in use"), logical.ErrInvalidRequest
+		}
+
+		var buf bytes.Buffer
+		if err := raftBackend.Snapshot(&buf); err != nil {
+			return nil, err
+		}
+
+		return &logical.Response{
+			Data: map[string]interface{}{
+				logical.HTTPStatusCode:  200,
+				logical.HTTPRawBody:     buf.Bytes(),
+				logical.HTTPContentType: "application/octet-stream",
+			},
+		}, nil
+	}
+}
+
+func (b *SystemBackend) handleRaftSnapshotWrite() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		raftBackend := b.Core.getRaftBackend()
+		if raftBackend == nil {
+			return logical.ErrorResponse("raft storage is not in use"), logical.ErrInvalidRequest
+		}
+
+		snapshot, err := base64.StdEncoding.DecodeString(d.Get("snapshot").(string))
+		if err != nil {
+			return logical.ErrorResponse("snapshot is not valid base64"), logical.ErrInvalidRequest
+		}
+		if len(snapshot) == 0 {
+			return logical.ErrorResponse("no snapshot provided"), logical.ErrInvalidRequest
+		}
+
+		if err := raftBackend.RestoreSnapshot(ctx, bytes.NewReader(snapshot)); err != nil {
+			return nil, err
+		}
+		b.Backend.Logger().Info("restored raft snapshot, stepping down to reload the restored state")
+
+		// The in-memory state of the active node no longer matches the
+		// storage, stepping down rebuilds it on the next active node
+		if err := b.Core.stepDownAfterRestore(); err != nil {
+			return nil, err
+		}
+
+		return nil, nil
+	}
+}
+
+// stepDownAfterRestore makes the active node give up its active state so that
+// it is set up again from the restored storage.
+func (c *Core) stepDownAfterRestore() error {
+	if c.ha == nil || c.standby {
+		return errors.New("snapshot restored but the node is not active")
+	}
+
+	select {
+	case c.manualStepDownCh <- struct{}{}:
+	default:
+		c.logger.Warn("manual step-down operation already queued")
+	}
+	return nil
+}
+
+var sysRaftHelp = map[string][2]string{
+	"raft-bootstrap-challenge": {
+		"Creates a challenge for a node joining the raft cluster.",
+		`
+A node joining the cluster with "sys/storage/raft/join" calls this endpoint on
+the active node. The returned challenge can only be answered by a node unsealed
+with the unseal keys of the cluster.
+		`,
+	},
+	"raft-bootstrap-answer": {
+		"Adds a node that answered its challenge to the raft cluster.",
+		`
+The joining node sends the decrypted challenge and the address of its raft
+transport. On success the node is added as a voter and the members of the
+cluster are returned.
+		`,
+	},
+	"raft-configuration": {
+		"Returns the members of the raft cluster.",
+		"",
+	},
+	"raft-remove-peer": {
+		"Removes a node from the raft cluster.",
+		`
+The node stops receiving updates and no longer counts towards the quorum. It
+has to be wiped before it can join the cluster again.
+		`,
+	},
+	"raft-snapshot": {
+		"Saves or restores a snapshot of the storage.",
+		`
+A read returns a snapshot of all the storage entries. The entries are encrypted
+by the barrier, so the snapshot can only be used with the same unseal keys.
+
+A write restores the base64 encoded "snapshot" on the whole cluster, replacing
+all the storage entries. The active node steps down afterwards so that its
+state is reloaded from the restored storage.
+		`,
+	},
+}
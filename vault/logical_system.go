@@ -29,6 +29,7 @@ import (
 	"github.com/jiangjiali/vault/sdk/helper/mitchellh/mapstructure"
 	"github.com/jiangjiali/vault/sdk/helper/namespace"
 	"github.com/jiangjiali/vault/sdk/helper/parseutil"
+	"github.com/jiangjiali/vault/sdk/helper/pathmanager"
 	"github.com/jiangjiali/vault/sdk/helper/strutil"
 	"github.com/jiangjiali/vault/sdk/helper/wrapping"
 	"github.com/jiangjiali/vault/sdk/helper/xxuuid"
@@ -105,6 +106,10 @@ func NewSystemBackend(core *Core, logger log.Logger) *SystemBackend {
 				"leases/revoke-prefix/*",
 				"leases/revoke-force/*",
 				"leases/lookup/*",
+				"storage/raft/remove-peer",
+				"storage/raft/snapshot",
+				"storage/snapshot",
+				"storage/snapshot-restore",
 			},
 
 			Unauthenticated: []string{
@@ -135,6 +140,8 @@ func NewSystemBackend(core *Core, logger log.Logger) *SystemBackend {
 				"rekey-recovery-key/init",
 				"rekey-recovery-key/update",
 				"rekey-recovery-key/verify",
+				"storage/raft/bootstrap/challenge",
+				"storage/raft/bootstrap/answer",
 			},
 
 			LocalStorage: []string{
@@ -154,6 +161,7 @@ func NewSystemBackend(core *Core, logger log.Logger) *SystemBackend {
 	b.Backend.Paths = append(b.Backend.Paths, b.mountPaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.authPaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.leasePaths()...)
+	b.Backend.Paths = append(b.Backend.Paths, b.namespacePaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.policyPaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.wrappingPaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.toolsPaths()...)
@@ -161,6 +169,8 @@ func NewSystemBackend(core *Core, logger log.Logger) *SystemBackend {
 	b.Backend.Paths = append(b.Backend.Paths, b.internalPaths()...)
 	b.Backend.Paths = append(b.Backend.Paths, b.remountPath())
 	b.Backend.Paths = append(b.Backend.Paths, b.metricsPath())
+	b.Backend.Paths = append(b.Backend.Paths, b.raftStoragePaths()...)
+	b.Backend.Paths = append(b.Backend.Paths, b.storageSnapshotPaths()...)
 
 	if core.rawEnabled {
 		b.Backend.Paths = append(b.Backend.Paths, &framework.Path{
@@ -215,6 +225,50 @@ type SystemBackend struct {
 	logger    log.Logger
 }
 
+// namespaceSysPaths are the paths of the system backend which can be used in
+// a namespace other than the root. The rest of them configure the whole
+// server.
+var namespaceSysPaths = pathmanager.New()
+
+func init() {
+	namespaceSysPaths.AddPaths([]string{
+		"auth",
+		"capabilities",
+		"internal/ui/mounts",
+		"internal/ui/namespaces",
+		"internal/ui/resultant-acl",
+		"leases/",
+		"!leases/tidy",
+		"mounts",
+		"namespaces",
+		"policies/acl",
+		"policy",
+		"remount",
+		"renew",
+		"revoke",
+		"tools/",
+		"wrapping/",
+	})
+}
+
+// HandleRequest restricts the requests made in a namespace other than the
+// root to the paths managing the namespace.
+func (b *SystemBackend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	switch req.Operation {
+	case logical.HelpOperation, logical.RollbackOperation:
+	default:
+		ns, err := namespace.FromContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if ns.ID != namespace.RootNamespaceID && !namespaceSysPaths.HasPath(req.Path) {
+			return logical.ErrorResponse(fmt.Sprintf("path %q is only available in the root namespace", "sys/"+req.Path)), logical.ErrInvalidRequest
+		}
+	}
+
+	return b.Backend.HandleRequest(ctx, req)
+}
+
 // handleCORSRead returns the current CORS configuration
 func (b *SystemBackend) handleCORSRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	corsConf := b.Core.corsConfig
@@ -1877,6 +1931,111 @@ func (b *SystemBackend) handleDisableAuth(ctx context.Context, req *logical.Requ
 	return nil, nil
 }
 
+// handleNamespacesList handles the "/sys/namespaces" endpoint to list the
+// child namespaces of the request namespace
+func (b *SystemBackend) handleNamespacesList(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	keyInfo := make(map[string]interface{})
+	for _, child := range b.Core.namespaceChildren(ns, false) {
+		key := strings.TrimPrefix(child.Path, ns.Path)
+		keys = append(keys, key)
+		keyInfo[key] = namespaceResponseData(child)
+	}
+
+	return logical.ListResponseWithInfo(keys, keyInfo), nil
+}
+
+// handleNamespacesRead handles the "/sys/namespaces/<path>" endpoint to look
+// up a namespace
+func (b *SystemBackend) handleNamespacesRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nsPath := namespace.Canonicalize(data.Get("path").(string))
+	if nsPath == "" {
+		return logical.ErrorResponse("missing namespace path"), logical.ErrInvalidRequest
+	}
+
+	child := b.Core.NamespaceByPath(ns.Path + nsPath)
+	if child == nil {
+		return nil, nil
+	}
+
+	return &logical.Response{
+		Data: namespaceResponseData(child),
+	}, nil
+}
+
+// handleNamespacesSet handles the "/sys/namespaces/<path>" endpoint to create
+// a namespace. The parents of a nested namespace must already exist.
+func (b *SystemBackend) handleNamespacesSet(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nsPath := namespace.Canonicalize(data.Get("path").(string))
+	if nsPath == "" {
+		return logical.ErrorResponse("missing namespace path"), logical.ErrInvalidRequest
+	}
+
+	segments := strings.Split(strings.TrimSuffix(nsPath, "/"), "/")
+	parentPath := ns.Path + strings.Join(segments[:len(segments)-1], "/")
+	parent := b.Core.NamespaceByPath(parentPath)
+	if parent == nil {
+		return logical.ErrorResponse(fmt.Sprintf("parent namespace %q does not exist", namespace.Canonicalize(parentPath))), logical.ErrInvalidRequest
+	}
+
+	child, err := b.Core.createNamespace(ctx, parent, segments[len(segments)-1])
+	if err != nil {
+		return handleError(err)
+	}
+
+	return &logical.Response{
+		Data: namespaceResponseData(child),
+	}, nil
+}
+
+// handleNamespacesDelete handles the "/sys/namespaces/<path>" endpoint to
+// delete a namespace along with everything it contains
+func (b *SystemBackend) handleNamespacesDelete(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	ns, err := namespace.FromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nsPath := namespace.Canonicalize(data.Get("path").(string))
+	if nsPath == "" {
+		return logical.ErrorResponse("missing namespace path"), logical.ErrInvalidRequest
+	}
+
+	child := b.Core.NamespaceByPath(ns.Path + nsPath)
+	if child == nil {
+		return nil, nil
+	}
+
+	if err := b.Core.deleteNamespace(ctx, child); err != nil {
+		b.Backend.Logger().Error("delete namespace failed", "path", child.Path, "error", err)
+		return handleError(err)
+	}
+
+	return nil, nil
+}
+
+func namespaceResponseData(ns *namespace.Namespace) map[string]interface{} {
+	return map[string]interface{}{
+		"id":   ns.ID,
+		"path": ns.Path,
+	}
+}
+
 // handlePoliciesList handles /sys/policy/ and /sys/policies/<type> endpoints to provide the enabled policies
 func (b *SystemBackend) handlePoliciesList(policyType PolicyType) framework.OperationFunc {
 	return func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
@@ -2907,7 +3066,8 @@ func hasMountAccess(ctx context.Context, acl *ACL, path string) bool {
 			perms.CapabilitiesBitmap&ListCapabilityInt > 0,
 			perms.CapabilitiesBitmap&ReadCapabilityInt > 0,
 			perms.CapabilitiesBitmap&SudoCapabilityInt > 0,
-			perms.CapabilitiesBitmap&UpdateCapabilityInt > 0:
+			perms.CapabilitiesBitmap&UpdateCapabilityInt > 0,
+			perms.CapabilitiesBitmap&PatchCapabilityInt > 0:
 
 			aclCapabilitiesGiven = true
 
@@ -3168,6 +3328,9 @@ func (b *SystemBackend) pathInternalUIResultantACL(ctx context.Context, req *log
 		if perms.CapabilitiesBitmap&UpdateCapabilityInt > 0 {
 			capabilities = append(capabilities, UpdateCapability)
 		}
+		if perms.CapabilitiesBitmap&PatchCapabilityInt > 0 {
+			capabilities = append(capabilities, PatchCapability)
+		}
 
 		// If "deny" is explicitly set or if the path has no capabilities at all,
 		// set the path capabilities to "deny"
@@ -3284,7 +3447,7 @@ func (b *SystemBackend) pathInternalOpenAPI(ctx context.Context, req *logical.Re
 
 				// Add tags to all of the operations if necessary
 				if tag != "" {
-					for _, op := range []*framework.OASOperation{obj.Get, obj.Post, obj.Delete} {
+					for _, op := range []*framework.OASOperation{obj.Get, obj.Post, obj.Delete, obj.Patch} {
 						// TODO: a special override for identity is used used here because the backend
 						// is currently categorized as "secret", which will likely change. Also of interest
 						// is removing all tag handling here and providing the mount information to OpenAPI.
@@ -3702,6 +3865,38 @@ Example: you might have an OAuth backend for GitHub, and one for Google Apps.
 		`The options to pass into the backend. Should be a json object with string keys and values.`,
 	},
 
+	"namespaces-list": {
+		`List the child namespaces.`,
+		`
+This path responds to the following HTTP methods.
+
+    LIST /
+        List the namespaces directly nested in the request namespace.
+		`,
+	},
+
+	"namespaces": {
+		`Create, look up and delete namespaces.`,
+		`
+This path responds to the following HTTP methods.
+
+    GET /<path>
+        Retrieve the ID and full path of the namespace.
+
+    PUT /<path>
+        Create the namespace. The parents of a nested namespace must exist.
+
+    DELETE /<path>
+        Delete the namespace along with its child namespaces, mounts,
+        tokens, leases, policies and identities.
+		`,
+	},
+
+	"namespace-path": {
+		`The path of the namespace, relative to the request namespace.`,
+		"",
+	},
+
 	"policy-list": {
 		`List the configured access control policies.`,
 		`
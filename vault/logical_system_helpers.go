@@ -17,7 +17,15 @@ var (
 	invalidateMFAConfig = func(context.Context, *SystemBackend, string) {}
 
 	sysInvalidate = func(b *SystemBackend) func(context.Context, string) {
-		return nil
+		return func(ctx context.Context, key string) {
+			// The policies written by the primary of a performance secondary
+			switch {
+			case strings.HasPrefix(key, policyACLSubPath):
+				if b.Core.policyStore != nil {
+					b.Core.policyStore.invalidate(ctx, strings.TrimPrefix(key, policyACLSubPath), PolicyTypeACL)
+				}
+			}
+		}
 	}
 
 	getSystemSchemas = func() []func() *memdb.TableSchema { return nil }
@@ -51,7 +59,16 @@ var (
 				return nil, logical.ErrPermissionDenied
 			}
 
-			return logical.ListResponse([]string{""}), nil
+			ns, err := namespace.FromContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+
+			var keys []string
+			for _, child := range b.Core.namespaceChildren(ns, true) {
+				keys = append(keys, strings.TrimPrefix(child.Path, ns.Path))
+			}
+			return logical.ListResponse(keys), nil
 		}
 	}
 
@@ -68,21 +85,7 @@ var (
 	}
 
 	entPaths = func(b *SystemBackend) []*framework.Path {
-		return []*framework.Path{
-			{
-				Pattern: "replication/status",
-				Callbacks: map[logical.Operation]framework.OperationFunc{
-					logical.ReadOperation: func(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
-						resp := &logical.Response{
-							Data: map[string]interface{}{
-								"mode": "disabled",
-							},
-						}
-						return resp, nil
-					},
-				},
-			},
-		}
+		return b.replicationPaths()
 	}
 
 	checkRaw = func(b *SystemBackend, path string) error { return nil }
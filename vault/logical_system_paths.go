@@ -1260,6 +1260,50 @@ For example, enable the "foo" auth method will make it accessible at /auth/foo.`
 	}
 }
 
+func (b *SystemBackend) namespacePaths() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "namespaces/?$",
+
+			Callbacks: map[logical.Operation]framework.OperationFunc{
+				logical.ListOperation: b.handleNamespacesList,
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["namespaces-list"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["namespaces-list"][1]),
+		},
+
+		{
+			Pattern: "namespaces/(?P<path>.+)",
+
+			Fields: map[string]*framework.FieldSchema{
+				"path": {
+					Type:        framework.TypeString,
+					Description: strings.TrimSpace(sysHelp["namespace-path"][0]),
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleNamespacesRead,
+					Summary:  "Retrieve the namespace at the given path.",
+				},
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleNamespacesSet,
+					Summary:  "Create a namespace at the given path.",
+				},
+				logical.DeleteOperation: &framework.PathOperation{
+					Callback: b.handleNamespacesDelete,
+					Summary:  "Delete the namespace at the given path and everything it contains.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysHelp["namespaces"][0]),
+			HelpDescription: strings.TrimSpace(sysHelp["namespaces"][1]),
+		},
+	}
+}
+
 func (b *SystemBackend) policyPaths() []*framework.Path {
 	return []*framework.Path{
 		{
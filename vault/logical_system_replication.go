@@ -0,0 +1,545 @@
+package vault
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/jiangjiali/vault/api"
+	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/helper/wrapping"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+// replicationPaths returns the paths used to manage the replication of the
+// cluster, for each mode of replication
+func (b *SystemBackend) replicationPaths() []*framework.Path {
+	paths := []*framework.Path{
+		{
+			Pattern: "replication/status",
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleReplicationStatusAll(),
+					Summary:  "Return the status of the replication of the cluster.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-status"][0]),
+			HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-status"][1]),
+		},
+	}
+
+	for _, mode := range replicationModes {
+		prefix := "replication/" + mode.name + "/"
+		paths = append(paths, []*framework.Path{
+			{
+				Pattern: prefix + "status",
+
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.ReadOperation: &framework.PathOperation{
+						Callback: b.handleReplicationStatus(mode),
+						Summary:  "Return the status of the " + mode.name + " replication of the cluster.",
+					},
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-status"][0]),
+				HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-status"][1]),
+			},
+			{
+				Pattern: prefix + "primary/enable",
+
+				Fields: map[string]*framework.FieldSchema{
+					"primary_cluster_addr": {
+						Type:        framework.TypeString,
+						Description: "Cluster address the secondaries connect to, defaults to the cluster address of the node.",
+					},
+				},
+
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: &framework.PathOperation{
+						Callback: b.handleReplicationPrimaryEnable(mode),
+						Summary:  "Make the cluster a " + mode.name + " replication primary.",
+					},
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-primary-enable"][0]),
+				HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-primary-enable"][1]),
+			},
+			{
+				Pattern: prefix + "primary/secondary-token",
+
+				Fields: map[string]*framework.FieldSchema{
+					"id": {
+						Type:        framework.TypeString,
+						Description: "Identifier of the secondary.",
+					},
+					"ttl": {
+						Type:        framework.TypeDurationSecond,
+						Default:     1800,
+						Description: "TTL of the activation token.",
+					},
+				},
+
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: &framework.PathOperation{
+						Callback: b.handleReplicationSecondaryToken(mode),
+						Summary:  "Create an activation token for a " + mode.name + " replication secondary.",
+					},
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-secondary-token"][0]),
+				HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-secondary-token"][1]),
+			},
+			{
+				Pattern: prefix + "primary/revoke-secondary",
+
+				Fields: map[string]*framework.FieldSchema{
+					"id": {
+						Type:        framework.TypeString,
+						Description: "Identifier of the secondary.",
+					},
+				},
+
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: &framework.PathOperation{
+						Callback: b.handleReplicationRevokeSecondary(mode),
+						Summary:  "Revoke a " + mode.name + " replication secondary.",
+					},
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-revoke-secondary"][0]),
+				HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-revoke-secondary"][1]),
+			},
+			{
+				Pattern: prefix + "primary/demote",
+
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: &framework.PathOperation{
+						Callback: b.handleReplicationPrimaryDemote(mode),
+						Summary:  "Make a " + mode.name + " replication primary a secondary.",
+					},
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-primary-demote"][0]),
+				HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-primary-demote"][1]),
+			},
+			{
+				Pattern: prefix + "primary/disable",
+
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: &framework.PathOperation{
+						Callback: b.handleReplicationDisable(mode),
+						Summary:  "Disable " + mode.name + " replication on a primary.",
+					},
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-disable"][0]),
+				HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-disable"][1]),
+			},
+			{
+				Pattern: prefix + "secondary/enable",
+
+				Fields: replicationActivationFields(false),
+
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: &framework.PathOperation{
+						Callback: b.handleReplicationSecondaryEnable(mode),
+						Summary:  "Make the cluster a " + mode.name + " replication secondary.",
+					},
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-secondary-enable"][0]),
+				HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-secondary-enable"][1]),
+			},
+			{
+				Pattern: prefix + "secondary/promote",
+
+				Fields: map[string]*framework.FieldSchema{
+					"primary_cluster_addr": {
+						Type:        framework.TypeString,
+						Description: "Cluster address the secondaries connect to, defaults to the cluster address of the node.",
+					},
+					"dr_operation_token": {
+						Type:        framework.TypeString,
+						Description: "DR operation token, required on a disaster recovery secondary.",
+					},
+				},
+
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: &framework.PathOperation{
+						Callback: b.handleReplicationSecondaryPromote(mode),
+						Summary:  "Make a " + mode.name + " replication secondary the primary.",
+					},
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-secondary-promote"][0]),
+				HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-secondary-promote"][1]),
+			},
+			{
+				Pattern: prefix + "secondary/update-primary",
+
+				Fields: replicationActivationFields(true),
+
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: &framework.PathOperation{
+						Callback: b.handleReplicationUpdatePrimary(mode),
+						Summary:  "Point a " + mode.name + " replication secondary to another primary.",
+					},
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-update-primary"][0]),
+				HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-update-primary"][1]),
+			},
+			{
+				Pattern: prefix + "secondary/disable",
+
+				Operations: map[logical.Operation]framework.OperationHandler{
+					logical.UpdateOperation: &framework.PathOperation{
+						Callback: b.handleReplicationDisable(mode),
+						Summary:  "Disable " + mode.name + " replication on a secondary.",
+					},
+				},
+
+				HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-disable"][0]),
+				HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-disable"][1]),
+			},
+		}...)
+	}
+
+	paths = append(paths, &framework.Path{
+		Pattern: "replication/dr/secondary/operation-token/delete",
+
+		Fields: map[string]*framework.FieldSchema{
+			"dr_operation_token": {
+				Type:        framework.TypeString,
+				Description: "DR operation token to delete.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.handleReplicationDROperationTokenDelete(),
+				Summary:  "Delete the DR operation token.",
+			},
+		},
+
+		HelpSynopsis:    strings.TrimSpace(sysReplicationHelp["replication-operation-token-delete"][0]),
+		HelpDescription: strings.TrimSpace(sysReplicationHelp["replication-operation-token-delete"][1]),
+	})
+
+	return paths
+}
+
+// replicationActivationFields returns the fields of the paths activating a
+// secondary with a token
+func replicationActivationFields(drOperationToken bool) map[string]*framework.FieldSchema {
+	fields := map[string]*framework.FieldSchema{
+		"token": {
+			Type:        framework.TypeString,
+			Description: "Activation token created on the primary.",
+		},
+		"primary_api_addr": {
+			Type:        framework.TypeString,
+			Description: "API address of the primary, defaults to the address in the activation token.",
+		},
+		"ca_file": {
+			Type:        framework.TypeString,
+			Description: "Path to a CA certificate verifying the API address of the primary.",
+		},
+		"ca_path": {
+			Type:        framework.TypeString,
+			Description: "Path to a directory of CA certificates verifying the API address of the primary.",
+		},
+	}
+	if drOperationToken {
+		fields["dr_operation_token"] = &framework.FieldSchema{
+			Type:        framework.TypeString,
+			Description: "DR operation token, required on a disaster recovery secondary.",
+		}
+	}
+	return fields
+}
+
+func (b *SystemBackend) handleReplicationStatusAll() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		data := make(map[string]interface{}, len(replicationModes))
+		for _, mode := range replicationModes {
+			status, err := b.Core.replicationStatus(ctx, mode)
+			if err != nil {
+				return nil, err
+			}
+			data[mode.name] = status
+		}
+		return &logical.Response{
+			Data: data,
+		}, nil
+	}
+}
+
+func (b *SystemBackend) handleReplicationStatus(mode *replicationMode) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		status, err := b.Core.replicationStatus(ctx, mode)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{
+			Data: status,
+		}, nil
+	}
+}
+
+func (b *SystemBackend) handleReplicationPrimaryEnable(mode *replicationMode) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		addr := d.Get("primary_cluster_addr").(string)
+		if err := b.Core.enableReplicationPrimary(ctx, mode, addr); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, nil
+	}
+}
+
+func (b *SystemBackend) handleReplicationSecondaryToken(mode *replicationMode) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		id := d.Get("id").(string)
+		if id == "" {
+			return logical.ErrorResponse("no secondary id provided"), logical.ErrInvalidRequest
+		}
+		ttl := time.Duration(d.Get("ttl").(int)) * time.Second
+		if ttl <= 0 {
+			return logical.ErrorResponse("ttl must be positive"), logical.ErrInvalidRequest
+		}
+
+		activation, err := b.Core.replicationSecondaryToken(ctx, mode, id)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		b.Backend.Logger().Info("created secondary activation token", "mode", mode.name, "id", id)
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"mode":                 activation.Mode,
+				"id":                   activation.ID,
+				"cluster_id":           activation.ClusterID,
+				"primary_cluster_addr": activation.PrimaryClusterAddr,
+				"ca_cert":              activation.CACert,
+				"client_cert":          activation.ClientCert,
+				"client_key":           activation.ClientKey,
+			},
+			WrapInfo: &wrapping.ResponseWrapInfo{
+				TTL:    ttl,
+				Format: "jwt",
+			},
+		}, nil
+	}
+}
+
+func (b *SystemBackend) handleReplicationRevokeSecondary(mode *replicationMode) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		id := d.Get("id").(string)
+		if id == "" {
+			return logical.ErrorResponse("no secondary id provided"), logical.ErrInvalidRequest
+		}
+		if err := b.Core.revokeReplicationSecondary(ctx, mode, id); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		b.Backend.Logger().Info("revoked secondary", "mode", mode.name, "id", id)
+		return nil, nil
+	}
+}
+
+func (b *SystemBackend) handleReplicationPrimaryDemote(mode *replicationMode) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if err := b.Core.demoteReplicationPrimary(ctx, mode); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, nil
+	}
+}
+
+func (b *SystemBackend) handleReplicationDisable(mode *replicationMode) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if err := b.Core.disableReplication(ctx, mode); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, nil
+	}
+}
+
+// replicationActivationFromRequest unwraps the activation token of the
+// request from the primary
+func replicationActivationFromRequest(d *framework.FieldData) (*replicationActivation, *logical.Response, error) {
+	token := d.Get("token").(string)
+	if token == "" {
+		return nil, logical.ErrorResponse("no activation token provided"), logical.ErrInvalidRequest
+	}
+
+	var tlsConfig *api.TLSConfig
+	caFile, caPath := d.Get("ca_file").(string), d.Get("ca_path").(string)
+	if caFile != "" || caPath != "" {
+		tlsConfig = &api.TLSConfig{
+			CACert: caFile,
+			CAPath: caPath,
+		}
+	}
+
+	activation, err := unwrapReplicationActivation(token, d.Get("primary_api_addr").(string), tlsConfig)
+	if err != nil {
+		return nil, logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+	}
+	return activation, nil, nil
+}
+
+func (b *SystemBackend) handleReplicationSecondaryEnable(mode *replicationMode) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		activation, resp, err := replicationActivationFromRequest(d)
+		if err != nil {
+			return resp, err
+		}
+		if err := b.Core.enableReplicationSecondary(ctx, mode, activation); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+
+		return &logical.Response{
+			Warnings: []string{
+				"The storage of the cluster is being replaced by the one of the primary, " +
+					"including its tokens; the unseal keys of the cluster are kept",
+			},
+		}, nil
+	}
+}
+
+// checkReplicationDROperationToken verifies the DR operation token of the
+// request when the cluster is a disaster recovery secondary, which serves
+// no authenticated requests
+func (b *SystemBackend) checkReplicationDROperationToken(ctx context.Context, mode *replicationMode, d *framework.FieldData) error {
+	if mode.performance || !b.Core.IsDRSecondary() {
+		return nil
+	}
+	return b.Core.checkDROperationToken(ctx, d.Get("dr_operation_token").(string))
+}
+
+func (b *SystemBackend) handleReplicationSecondaryPromote(mode *replicationMode) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if err := b.checkReplicationDROperationToken(ctx, mode, d); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+
+		addr := d.Get("primary_cluster_addr").(string)
+		if err := b.Core.promoteReplicationSecondary(ctx, mode, addr); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, nil
+	}
+}
+
+func (b *SystemBackend) handleReplicationUpdatePrimary(mode *replicationMode) framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if err := b.checkReplicationDROperationToken(ctx, mode, d); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+
+		activation, resp, err := replicationActivationFromRequest(d)
+		if err != nil {
+			return resp, err
+		}
+		if err := b.Core.updateReplicationPrimary(ctx, mode, activation); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrInvalidRequest
+		}
+		return nil, nil
+	}
+}
+
+func (b *SystemBackend) handleReplicationDROperationTokenDelete() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if err := b.Core.checkDROperationToken(ctx, d.Get("dr_operation_token").(string)); err != nil {
+			return logical.ErrorResponse(err.Error()), logical.ErrPermissionDenied
+		}
+		if err := b.Core.barrier.Delete(ctx, coreDROperationTokenPath); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+}
+
+var sysReplicationHelp = map[string][2]string{
+	"replication-status": {
+		"Returns the status of the replication of the cluster.",
+		`
+A primary lists its known and connected secondaries and the last write of its
+log. A secondary reports its connection to the primary and the last write of
+the primary it applied.
+		`,
+	},
+	"replication-primary-enable": {
+		"Makes the cluster a replication primary.",
+		`
+A certificate authority is created for the mode of replication; the
+secondaries connect to the cluster address of the primary with client
+certificates it signs.
+		`,
+	},
+	"replication-secondary-token": {
+		"Creates an activation token for a secondary.",
+		`
+The token is a response wrapping token holding the client certificate of the
+secondary. Creating a token for an existing identifier revokes the previous
+certificate.
+		`,
+	},
+	"replication-revoke-secondary": {
+		"Revokes a secondary.",
+		`
+The secondary is disconnected at its next heartbeat and can no longer
+connect to the primary.
+		`,
+	},
+	"replication-primary-demote": {
+		"Makes a primary a secondary.",
+		`
+The cluster stops accepting writes of the replicated storage and keeps no
+primary until it is pointed to one with "secondary/update-primary".
+		`,
+	},
+	"replication-disable": {
+		"Disables the mode of replication on the cluster.",
+		`
+A primary forgets its secondaries. A secondary keeps the storage it copied
+and becomes a standalone cluster.
+		`,
+	},
+	"replication-secondary-enable": {
+		"Makes the cluster a secondary.",
+		`
+The activation token is unwrapped from the primary, then the storage of the
+cluster is replaced by a copy of the storage of the primary, except for the
+unseal keys and the local state of the cluster. A disaster recovery secondary
+serves no requests but the replication ones; a performance secondary serves
+the reads and forwards the writes to the primary.
+		`,
+	},
+	"replication-secondary-promote": {
+		"Makes a secondary the primary.",
+		`
+The cluster starts accepting writes and serving secondaries with a new
+certificate authority; the other secondaries are pointed to it with new
+activation tokens. A disaster recovery secondary requires a DR operation
+token, generated with "sys/replication/dr/secondary/generate-operation-token".
+		`,
+	},
+	"replication-update-primary": {
+		"Points a secondary to another primary.",
+		`
+The secondary copies the storage of the new primary if the writes it applied
+are not in the log of the new primary.
+		`,
+	},
+	"replication-operation-token-delete": {
+		"Deletes the DR operation token.",
+		`
+The token can no longer be used to promote or update the secondary, a new one
+is generated with "sys/replication/dr/secondary/generate-operation-token".
+		`,
+	},
+}
@@ -0,0 +1,143 @@
+package vault
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jiangjiali/vault/sdk/framework"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+// storageSnapshotPaths returns the paths used to save and restore snapshots
+// of the storage, whatever its backend
+func (b *SystemBackend) storageSnapshotPaths() []*framework.Path {
+	return []*framework.Path{
+		{
+			Pattern: "storage/snapshot",
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleStorageSnapshotRead(),
+					Summary:  "Return a snapshot of the storage.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysSnapshotHelp["snapshot"][0]),
+			HelpDescription: strings.TrimSpace(sysSnapshotHelp["snapshot"][1]),
+		},
+		{
+			Pattern: "storage/snapshot-restore",
+
+			Fields: map[string]*framework.FieldSchema{
+				"force": {
+					Type:        framework.TypeBool,
+					Description: "Restore the snapshot even if it was taken with other unseal keys.",
+				},
+			},
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.UpdateOperation: &framework.PathOperation{
+					Callback: b.handleStorageSnapshotRestore(),
+					Summary:  "Restore a snapshot of the storage.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysSnapshotHelp["snapshot-restore"][0]),
+			HelpDescription: strings.TrimSpace(sysSnapshotHelp["snapshot-restore"][1]),
+		},
+		{
+			Pattern: "storage/snapshot-auto/status",
+
+			Operations: map[logical.Operation]framework.OperationHandler{
+				logical.ReadOperation: &framework.PathOperation{
+					Callback: b.handleStorageSnapshotAutoStatus(),
+					Summary:  "Return the status of the automatic snapshots of the storage.",
+				},
+			},
+
+			HelpSynopsis:    strings.TrimSpace(sysSnapshotHelp["snapshot-auto-status"][0]),
+			HelpDescription: strings.TrimSpace(sysSnapshotHelp["snapshot-auto-status"][1]),
+		},
+	}
+}
+
+func (b *SystemBackend) handleStorageSnapshotRead() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if req.ResponseWriter == nil {
+			return logical.ErrorResponse("the snapshot can only be read over HTTP"), logical.ErrInvalidRequest
+		}
+
+		req.ResponseWriter.Header().Set("Content-Type", "application/octet-stream")
+		meta, err := b.Core.snapshot(ctx, req.ResponseWriter)
+		if err != nil {
+			return nil, err
+		}
+
+		b.Backend.Logger().Info("saved snapshot of the storage", "entries", meta.Entries)
+		return nil, nil
+	}
+}
+
+func (b *SystemBackend) handleStorageSnapshotRestore() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if req.RequestReader == nil {
+			return logical.ErrorResponse("no snapshot provided"), logical.ErrInvalidRequest
+		}
+
+		meta, err := b.Core.restoreSnapshot(ctx, req.RequestReader, d.Get("force").(bool))
+		if err != nil {
+			return nil, err
+		}
+		b.Backend.Logger().Info("restored snapshot of the storage", "entries", meta.Entries, "created_at", meta.CreatedAt)
+		return nil, nil
+	}
+}
+
+func (b *SystemBackend) handleStorageSnapshotAutoStatus() framework.OperationFunc {
+	return func(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+		if b.Core.autoSnapshots == nil {
+			return logical.ErrorResponse("automatic snapshots are not configured"), logical.ErrInvalidRequest
+		}
+
+		return &logical.Response{
+			Data: b.Core.autoSnapshots.statusData(),
+		}, nil
+	}
+}
+
+var sysSnapshotHelp = map[string][2]string{
+	"snapshot": {
+		"Returns a snapshot of the storage.",
+		`
+The snapshot is a gzipped tarball of all the storage entries along with their
+SHA-256 checksums, streamed as the response. The writes to the storage wait
+while the entries are read into a temporary file of the node, not while the
+snapshot is sent. The entries are saved as they are stored, encrypted by the
+barrier, so the snapshot can only be used with the same unseal keys. The state
+of the HA and of replication is left out.
+		`,
+	},
+	"snapshot-restore": {
+		"Restores a snapshot of the storage.",
+		`
+The snapshot, sent as the raw body of the request, replaces all the storage
+entries except the state of the HA and of replication. The body is not bound by
+the maximum request size, it is written to a temporary file of the node and
+verified before the storage is replaced. A snapshot taken with other unseal keys
+is refused unless the "force" query parameter is set, in which case the cluster
+has to be unsealed with the unseal keys of the snapshot afterwards. The active
+node then steps down, or reloads its state when the storage is not highly
+available, so that its state is set up again from the restored storage.
+		`,
+	},
+	"snapshot-auto-status": {
+		"Returns the status of the automatic snapshots of the storage.",
+		`
+The active node takes the snapshots on the schedule of the "snapshot_auto" stanza
+of the server configuration, and carries on with the schedule of the previous
+active node. The response holds the configuration, the time of the next
+snapshot, the outcome of the last ones and the snapshot files retained.
+"healthy" is false when the last snapshot failed.
+		`,
+	},
+}
@@ -958,8 +958,14 @@ func (c *Core) loadMounts(ctx context.Context) error {
 		return nil
 	}
 
-	// Persist both mount tables
-	if err := c.persistMounts(ctx, c.mounts, nil); err != nil {
+	// Persist both mount tables, a performance secondary only writes its
+	// local one
+	var local *bool
+	if c.ReplicationState().HasState(consts.ReplicationPerformanceSecondary) {
+		local = new(bool)
+		*local = true
+	}
+	if err := c.persistMounts(ctx, c.mounts, local); err != nil {
 		c.logger.Error("failed to persist mount table", "error", err)
 		return errLoadMountsFailed
 	}
@@ -1074,9 +1080,9 @@ func (c *Core) setupMounts(ctx context.Context) error {
 		// writes during the construction of the backend.
 		view.setReadOnlyErr(logical.ErrSetupReadOnly)
 		if strutil.StrListContains(singletonMounts, entry.Type) {
-			go func() {
-				defer view.setReadOnlyErr(origReadOnlyErr)
-			}()
+			// The view is reset once all mounts are set up, before the
+			// stores built on top of them are
+			defer view.setReadOnlyErr(origReadOnlyErr)
 		} else {
 			c.postUnsealFuncs = append(c.postUnsealFuncs, func() {
 				view.setReadOnlyErr(origReadOnlyErr)
@@ -1180,6 +1186,17 @@ func (c *Core) newLogicalBackend(ctx context.Context, entry *MountEntry, sysView
 		t = alias
 	}
 
+	// The system backend and identity store of a namespace are the ones of
+	// the root namespace, which scope the requests by their namespace
+	if entry.Namespace().ID != namespace.RootNamespaceID {
+		switch t {
+		case systemMountType:
+			return c.systemBackend, nil
+		case identityMountType:
+			return c.identityStore, nil
+		}
+	}
+
 	f, ok := c.logicalBackends[t]
 	if !ok {
 		f = plugin.Factory
@@ -1382,16 +1399,20 @@ func (c *Core) singletonMountTables() (mounts, auth *MountTable) {
 }
 
 func (c *Core) setCoreBackend(entry *MountEntry, backend logical.Backend, view *BarrierView) {
-	switch entry.Type {
-	case systemMountType:
-		c.systemBackend = backend.(*SystemBackend)
-		c.systemBarrierView = view
-	case publicMountType:
+	root := entry.Namespace().ID == namespace.RootNamespaceID
+	switch {
+	case entry.Type == publicMountType:
 		ch := backend.(*PublicBackend)
 		ch.saltUUID = entry.UUID
 		ch.storageView = view
-		c.publicBackend = ch
-	case identityMountType:
+		if root {
+			c.publicBackend = ch
+		}
+	case !root:
+	case entry.Type == systemMountType:
+		c.systemBackend = backend.(*SystemBackend)
+		c.systemBarrierView = view
+	case entry.Type == identityMountType:
 		c.identityStore = backend.(*IdentityStore)
 	}
 }
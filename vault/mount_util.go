@@ -20,23 +20,27 @@ func clearIgnoredPaths(context.Context, *Core, logical.Backend, string) error {
 
 // ViewPath returns storage prefix for the view
 func (e *MountEntry) ViewPath() string {
+	// The storage of the mounts of a namespace is under the namespace ID
+	var prefix string
+	if e.namespace != nil && e.namespace.ID != namespace.RootNamespaceID {
+		prefix = namespaceBarrierPrefix + e.namespace.ID + "/"
+	}
+
 	switch e.Type {
 	case systemMountType:
-		return systemBarrierPrefix
+		return prefix + systemBarrierPrefix
 	case "token":
-		return path.Join(systemBarrierPrefix, tokenSubPath) + "/"
+		return prefix + path.Join(systemBarrierPrefix, tokenSubPath) + "/"
 	}
 
 	switch e.Table {
 	case mountTableType:
-		return backendBarrierPrefix + e.UUID + "/"
+		return prefix + backendBarrierPrefix + e.UUID + "/"
 	case credentialTableType:
-		return credentialBarrierPrefix + e.UUID + "/"
+		return prefix + credentialBarrierPrefix + e.UUID + "/"
 	case auditTableType:
 		return auditBarrierPrefix + e.UUID + "/"
 	}
 
 	panic("invalid mount entry")
 }
-
-func verifyNamespace(*Core, *namespace.Namespace, *MountEntry) error { return nil }
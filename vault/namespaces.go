@@ -2,17 +2,436 @@ package vault
 
 import (
 	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 
+	"github.com/jiangjiali/vault/sdk/helper/base62"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	"github.com/jiangjiali/vault/sdk/helper/jsonutil"
 	"github.com/jiangjiali/vault/sdk/helper/namespace"
+	"github.com/jiangjiali/vault/sdk/helper/strutil"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+const (
+	// coreNamespacePath is the prefix under which the namespace records are
+	// stored, keyed by namespace ID
+	coreNamespacePath = "core/namespaces/"
+
+	// namespaceBarrierPrefix is the prefix of the storage of the namespaces.
+	// The storage of a namespace is laid out under its ID like the one of
+	// the root namespace is at the top of the barrier.
+	namespaceBarrierPrefix = "namespaces/"
+
+	// namespaceIDLength is the length of the generated namespace IDs
+	namespaceIDLength = 5
 )
 
 var (
 	NamespaceByID = namespaceByID
+
+	// namespaceNameRegex is the format of a path segment of a namespace
+	namespaceNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	// reservedNamespaceNames cannot be used as namespace names since they
+	// collide with the paths mounted in every namespace
+	reservedNamespaceNames = []string{
+		namespace.RootNamespaceID,
+		"sys",
+		"audit",
+		"auth",
+		"public",
+		"identity",
+	}
 )
 
+// NamespaceStore keeps the namespaces created under the root namespace. The
+// root namespace is not stored.
+type NamespaceStore struct {
+	core *Core
+	view *BarrierView
+
+	// lock protects the indexes below
+	lock   sync.RWMutex
+	byID   map[string]*namespace.Namespace
+	byPath map[string]*namespace.Namespace
+
+	// modifyLock serializes the creation and deletion of namespaces
+	modifyLock sync.Mutex
+}
+
+// NewNamespaceStore creates the namespace store of the core. The namespaces
+// are loaded when the core is unsealed.
+func NewNamespaceStore(c *Core) *NamespaceStore {
+	return &NamespaceStore{
+		core:   c,
+		view:   NewBarrierView(c.barrier, coreNamespacePath),
+		byID:   make(map[string]*namespace.Namespace),
+		byPath: make(map[string]*namespace.Namespace),
+	}
+}
+
+// setupNamespaces loads the namespaces, which has to be done before the mount
+// tables referencing them are loaded.
+func (c *Core) setupNamespaces(ctx context.Context) error {
+	ns := c.namespaceStore
+	keys, err := logical.CollectKeys(ctx, ns.view)
+	if err != nil {
+		return errwrap.Wrapf("failed to list namespaces: {{err}}", err)
+	}
+
+	byID := make(map[string]*namespace.Namespace, len(keys))
+	byPath := make(map[string]*namespace.Namespace, len(keys))
+	for _, key := range keys {
+		entry, err := ns.view.Get(ctx, key)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to read namespace %q: {{err}}", key), err)
+		}
+		if entry == nil {
+			continue
+		}
+
+		n := new(namespace.Namespace)
+		if err := jsonutil.DecodeJSON(entry.Value, n); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to decode namespace %q: {{err}}", key), err)
+		}
+		byID[n.ID] = n
+		byPath[n.Path] = n
+	}
+
+	ns.lock.Lock()
+	ns.byID = byID
+	ns.byPath = byPath
+	ns.lock.Unlock()
+
+	if len(byID) > 0 {
+		c.logger.Info("loaded namespaces", "count", len(byID))
+	}
+	return nil
+}
+
+// teardownNamespaces is used to reverse setupNamespaces when the vault is
+// being sealed.
+func (c *Core) teardownNamespaces() {
+	ns := c.namespaceStore
+	ns.lock.Lock()
+	ns.byID = make(map[string]*namespace.Namespace)
+	ns.byPath = make(map[string]*namespace.Namespace)
+	ns.lock.Unlock()
+}
+
 func namespaceByID(ctx context.Context, nsID string, c *Core) (*namespace.Namespace, error) {
 	if nsID == namespace.RootNamespaceID {
 		return namespace.RootNamespace, nil
 	}
-	return nil, namespace.ErrNoNamespace
+	if c == nil || c.namespaceStore == nil {
+		return nil, namespace.ErrNoNamespace
+	}
+
+	ns := c.namespaceStore
+	ns.lock.RLock()
+	defer ns.lock.RUnlock()
+	return ns.byID[nsID], nil
+}
+
+// NamespaceByPath returns the namespace with the given path, or nil if there is
+// none.
+func (c *Core) NamespaceByPath(nsPath string) *namespace.Namespace {
+	nsPath = namespace.Canonicalize(nsPath)
+	if nsPath == "" {
+		return namespace.RootNamespace
+	}
+
+	ns := c.namespaceStore
+	ns.lock.RLock()
+	defer ns.lock.RUnlock()
+	return ns.byPath[nsPath]
+}
+
+// LongestNamespacePrefix returns the deepest namespace whose path is a prefix
+// of the given request path, which is the root namespace if the path does not
+// start with the path of a namespace.
+func (c *Core) LongestNamespacePrefix(reqPath string) *namespace.Namespace {
+	ns := c.namespaceStore
+	ns.lock.RLock()
+	defer ns.lock.RUnlock()
+
+	// Namespaces only exist below their parent, so the path is walked one
+	// segment at a time
+	match := namespace.RootNamespace
+	var nsPath string
+	for {
+		idx := strings.Index(reqPath[len(nsPath):], "/")
+		if idx < 0 {
+			return match
+		}
+		nsPath = reqPath[:len(nsPath)+idx+1]
+		child, ok := ns.byPath[nsPath]
+		if !ok {
+			return match
+		}
+		match = child
+	}
+}
+
+// namespaceChildren returns the namespaces nested in the given one, only the
+// direct children unless recursive is set. They are sorted by path.
+func (c *Core) namespaceChildren(parent *namespace.Namespace, recursive bool) []*namespace.Namespace {
+	ns := c.namespaceStore
+	ns.lock.RLock()
+	defer ns.lock.RUnlock()
+
+	var children []*namespace.Namespace
+	for nsPath, child := range ns.byPath {
+		if nsPath == parent.Path || !strings.HasPrefix(nsPath, parent.Path) {
+			continue
+		}
+		rel := strings.TrimSuffix(strings.TrimPrefix(nsPath, parent.Path), "/")
+		if !recursive && strings.Contains(rel, "/") {
+			continue
+		}
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Path < children[j].Path
+	})
+	return children
+}
+
+// allNamespaces returns the root namespace followed by every other namespace.
+func (c *Core) allNamespaces() []*namespace.Namespace {
+	return append([]*namespace.Namespace{namespace.RootNamespace}, c.namespaceChildren(namespace.RootNamespace, true)...)
+}
+
+// namespaceSystemView returns the view of the system storage of the
+// namespace, under which its tokens, leases and policies are stored.
+func (c *Core) namespaceSystemView(ns *namespace.Namespace) *BarrierView {
+	if ns.ID == namespace.RootNamespaceID {
+		return c.systemBarrierView
+	}
+	return NewBarrierView(c.barrier, namespaceBarrierPrefix+ns.ID+"/"+systemBarrierPrefix)
+}
+
+// validateNamespaceName checks that a path segment can name a namespace.
+func validateNamespaceName(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("namespace name cannot be empty")
+	case !namespaceNameRegex.MatchString(name):
+		return fmt.Errorf("namespace name %q may only contain letters, digits, '_' and '-'", name)
+	case strutil.StrListContains(reservedNamespaceNames, strings.ToLower(name)):
+		return fmt.Errorf("namespace name %q is reserved", name)
+	}
+	return nil
+}
+
+// createNamespace creates the namespace named name in the parent namespace,
+// along with the mounts every namespace has: the system backend, the per-token
+// storage, the identity store and the token store. The existing namespace is
+// returned if there is one at the path.
+func (c *Core) createNamespace(ctx context.Context, parent *namespace.Namespace, name string) (*namespace.Namespace, error) {
+	if err := validateNamespaceName(name); err != nil {
+		return nil, logical.CodedError(400, err.Error())
+	}
+
+	store := c.namespaceStore
+	store.modifyLock.Lock()
+	defer store.modifyLock.Unlock()
+
+	nsPath := parent.Path + name + "/"
+	if existing := c.NamespaceByPath(nsPath); existing != nil {
+		return existing, nil
+	}
+
+	// The namespace would shadow the mounts of the parent under its path
+	parentCtx := namespace.ContextWithNamespace(ctx, parent)
+	if match := c.router.MountConflict(parentCtx, name+"/"); match != "" {
+		return nil, logical.CodedError(409, fmt.Sprintf("path is already in use at %s", match))
+	}
+
+	var id string
+	for {
+		var err error
+		id, err = base62.Random(namespaceIDLength)
+		if err != nil {
+			return nil, err
+		}
+		if existing, _ := NamespaceByID(ctx, id, c); existing == nil {
+			break
+		}
+	}
+	ns := &namespace.Namespace{
+		ID:   id,
+		Path: nsPath,
+	}
+
+	value, err := jsonutil.EncodeJSON(ns)
+	if err != nil {
+		return nil, err
+	}
+	if err := store.view.Put(ctx, &logical.StorageEntry{
+		Key:   ns.ID,
+		Value: value,
+	}); err != nil {
+		return nil, errwrap.Wrapf("failed to persist namespace: {{err}}", err)
+	}
+
+	store.lock.Lock()
+	store.byID[ns.ID] = ns
+	store.byPath[ns.Path] = ns
+	store.lock.Unlock()
+
+	if err := c.setupNamespaceMounts(ctx, ns); err != nil {
+		c.logger.Error("failed to set up namespace, removing it", "namespace", ns.Path, "error", err)
+		if err := c.deleteNamespaceInternal(ctx, ns); err != nil {
+			c.logger.Error("failed to remove namespace", "namespace", ns.Path, "error", err)
+		}
+		return nil, err
+	}
+
+	c.logger.Info("created namespace", "namespace", ns.Path, "namespace_id", ns.ID)
+	return ns, nil
+}
+
+// setupNamespaceMounts mounts the required backends in a new namespace and
+// stores its default policies.
+func (c *Core) setupNamespaceMounts(ctx context.Context, ns *namespace.Namespace) error {
+	nsCtx := namespace.ContextWithNamespace(ctx, ns)
+
+	for _, entry := range c.requiredMountTable().Entries {
+		if err := c.mountInternal(nsCtx, entry, MountTableUpdateStorage); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to mount %q: {{err}}", entry.Path), err)
+		}
+	}
+	for _, entry := range c.defaultAuthTable().Entries {
+		if entry.Type == "token" {
+			entry.Config.TokenType = logical.TokenTypeDefaultService
+		}
+		if err := c.enableCredentialInternal(nsCtx, entry, MountTableUpdateStorage); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to enable %q: {{err}}", credentialRoutePrefix+entry.Path), err)
+		}
+	}
+
+	return c.policyStore.loadDefaultPolicies(nsCtx)
+}
+
+// deleteNamespace deletes the namespace and everything it contains: its child
+// namespaces, mounts, tokens, leases, policies and identities.
+func (c *Core) deleteNamespace(ctx context.Context, ns *namespace.Namespace) error {
+	store := c.namespaceStore
+	store.modifyLock.Lock()
+	defer store.modifyLock.Unlock()
+
+	return c.deleteNamespaceInternal(ctx, ns)
+}
+
+func (c *Core) deleteNamespaceInternal(ctx context.Context, ns *namespace.Namespace) error {
+	for _, child := range c.namespaceChildren(ns, false) {
+		if err := c.deleteNamespaceInternal(ctx, child); err != nil {
+			return err
+		}
+	}
+
+	c.logger.Info("deleting namespace", "namespace", ns.Path, "namespace_id", ns.ID)
+	nsCtx := namespace.ContextWithNamespace(ctx, ns)
+
+	// The auth methods and secrets engines are removed first, revoking the
+	// tokens and leases they issued. The token store and system backend go
+	// next, revoking the remaining tokens and leases, which need the
+	// per-token storage removed last.
+	var entries []*MountEntry
+	c.authLock.RLock()
+	for _, entry := range c.auth.Entries {
+		if entry.NamespaceID == ns.ID {
+			entries = append(entries, entry)
+		}
+	}
+	c.authLock.RUnlock()
+	c.mountsLock.RLock()
+	for _, entry := range c.mounts.Entries {
+		if entry.NamespaceID == ns.ID {
+			entries = append(entries, entry)
+		}
+	}
+	c.mountsLock.RUnlock()
+
+	order := func(entry *MountEntry) int {
+		switch entry.Type {
+		case "token":
+			return 1
+		case systemMountType:
+			return 2
+		case identityMountType:
+			return 3
+		case publicMountType:
+			return 4
+		}
+		return 0
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return order(entries[i]) < order(entries[j])
+	})
+
+	for _, entry := range entries {
+		var err error
+		switch entry.Table {
+		case credentialTableType:
+			err = c.disableCredentialInternal(nsCtx, entry.Path, MountTableUpdateStorage)
+		default:
+			err = c.unmountInternal(nsCtx, entry.Path, MountTableUpdateStorage)
+		}
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to remove %q of namespace %q: {{err}}", entry.Path, ns.Path), err)
+		}
+	}
+
+	if c.identityStore != nil {
+		if err := c.identityStore.deleteNamespaceIdentities(nsCtx); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to delete identities of namespace %q: {{err}}", ns.Path), err)
+		}
+	}
+	if c.policyStore != nil {
+		c.policyStore.invalidateNamespace(ns)
+	}
+	if c.tokenStore != nil {
+		c.tokenStore.invalidateNamespaceSalt(ns)
+	}
+
+	// Remove whatever is left of the storage of the namespace
+	view := NewBarrierView(c.barrier, namespaceBarrierPrefix+ns.ID+"/")
+	if err := logical.ClearViewWithLogging(ctx, view, c.logger.Named("namespaces.deletion").With("namespace", ns.Path)); err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("failed to clear storage of namespace %q: {{err}}", ns.Path), err)
+	}
+
+	store := c.namespaceStore
+	if err := store.view.Delete(ctx, ns.ID); err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("failed to delete namespace %q: {{err}}", ns.Path), err)
+	}
+	store.lock.Lock()
+	delete(store.byID, ns.ID)
+	delete(store.byPath, ns.Path)
+	store.lock.Unlock()
+
+	c.logger.Info("deleted namespace", "namespace", ns.Path, "namespace_id", ns.ID)
+	return nil
+}
+
+// verifyNamespace ensures that a mount does not take the path of a child
+// namespace of the namespace it is mounted in.
+func verifyNamespace(c *Core, ns *namespace.Namespace, entry *MountEntry) error {
+	if entry.Table == credentialTableType {
+		return nil
+	}
+
+	name := strings.SplitN(strings.TrimPrefix(entry.Path, "/"), "/", 2)[0]
+	if name == "" {
+		return nil
+	}
+	if child := c.NamespaceByPath(ns.Path + name); child != nil {
+		return logical.CodedError(409, fmt.Sprintf("path is already in use by namespace %s", child.Path))
+	}
+	return nil
 }
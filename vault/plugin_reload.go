@@ -189,6 +189,9 @@ func (c *Core) reloadBackendCommon(ctx context.Context, entry *MountEntry, isAut
 		if paths != nil {
 			re.rootPaths.Store(pathsToRadix(paths.Root))
 			re.loginPaths.Store(pathsToRadix(paths.Unauthenticated))
+			if view, ok := re.storageView.(*BarrierView); ok {
+				view.setSealWrapPaths(entry.SealWrap, paths.SealWrapStorage)
+			}
 		}
 	}
 
@@ -841,6 +841,18 @@ func (ps *PolicyStore) loadACLPolicyInternal(ctx context.Context, policyName, po
 	return ps.setPolicyInternal(ctx, policy)
 }
 
+// loadDefaultPolicies loads the default ACL policies in the namespace of the
+// context, when the namespace is created.
+func (ps *PolicyStore) loadDefaultPolicies(ctx context.Context) error {
+	if err := ps.loadACLPolicyInternal(ctx, defaultPolicyName, defaultPolicy); err != nil {
+		return err
+	}
+	if err := ps.loadACLPolicyInternal(ctx, responseWrappingPolicyName, responseWrappingPolicy); err != nil {
+		return err
+	}
+	return ps.loadACLPolicyInternal(ctx, controlGroupPolicyName, controlGroupPolicy)
+}
+
 func (ps *PolicyStore) sanitizeName(name string) string {
 	return strings.ToLower(strings.TrimSpace(name))
 }
@@ -0,0 +1,473 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/cleanhttp"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	"github.com/jiangjiali/vault/sdk/helper/jsonutil"
+	"github.com/jiangjiali/vault/sdk/logical"
+	raftstorage "github.com/jiangjiali/vault/sdk/physical/raft"
+)
+
+const (
+	// raftLeadershipTimeout bounds the time the node initializing a raft
+	// cluster waits to be elected.
+	raftLeadershipTimeout = 30 * time.Second
+
+	// raftJoinDataTimeout bounds the time a joining node waits for the
+	// keyring to be replicated from the leader.
+	raftJoinDataTimeout = 2 * time.Minute
+
+	// raftChallengeTTL is how long a bootstrap challenge can be answered.
+	raftChallengeTTL = 30 * time.Minute
+
+	// raftMaxPendingPeers caps the number of unanswered bootstrap
+	// challenges, which can be requested without authentication.
+	raftMaxPendingPeers = 64
+)
+
+// RaftJoinParams are the parameters used by a node to join a raft cluster.
+type RaftJoinParams struct {
+	LeaderAPIAddr string
+	LeaderCACert  string
+}
+
+// raftInformation holds the state of a node that asked to join a raft cluster
+// until it is unsealed with the cluster's unseal keys.
+type raftInformation struct {
+	challenge           []byte
+	leaderClient        *http.Client
+	leaderAPIAddr       string
+	leaderBarrierConfig *SealConfig
+}
+
+// pendingRaftPeer is a bootstrap challenge issued by the active node.
+type pendingRaftPeer struct {
+	answer    []byte
+	createdAt time.Time
+}
+
+// getRaftBackend returns the raft storage backend, or nil if a different
+// storage is in use.
+func (c *Core) getRaftBackend() *raftstorage.RaftBackend {
+	raftBackend, _ := c.underlyingPhysical.(*raftstorage.RaftBackend)
+	return raftBackend
+}
+
+// isRaftUnseal reports whether the node is waiting for the unseal keys to
+// complete joining a raft cluster.
+func (c *Core) isRaftUnseal() bool {
+	return c.raftInfo != nil
+}
+
+// RaftJoinSealConfig returns the seal configuration of the cluster this node is
+// joining, or nil if no join is in progress.
+func (c *Core) RaftJoinSealConfig() *SealConfig {
+	c.stateLock.RLock()
+	defer c.stateLock.RUnlock()
+
+	if c.raftInfo == nil {
+		return nil
+	}
+	return c.raftInfo.leaderBarrierConfig
+}
+
+// startRaftStorage starts raft when it is used as storage, the node takes part
+// in the cluster while it is unsealed.
+func (c *Core) startRaftStorage(ctx context.Context) error {
+	raftBackend := c.getRaftBackend()
+	if raftBackend == nil {
+		return nil
+	}
+
+	if err := raftBackend.SetupCluster(ctx); err != nil {
+		c.logger.Error("failed to start raft storage", "error", err)
+		return err
+	}
+	return nil
+}
+
+// stopRaftStorage stops raft when it is used as storage.
+func (c *Core) stopRaftStorage() {
+	raftBackend := c.getRaftBackend()
+	if raftBackend == nil {
+		return
+	}
+
+	if err := raftBackend.TeardownCluster(); err != nil {
+		c.logger.Error("failed to stop raft storage", "error", err)
+	}
+}
+
+// bootstrapRaftStorage creates a single node raft cluster and waits for this
+// node to be elected so that the initialization can be written.
+func (c *Core) bootstrapRaftStorage(ctx context.Context) error {
+	raftBackend := c.getRaftBackend()
+	if raftBackend == nil {
+		return nil
+	}
+
+	if c.raftInfo != nil {
+		return errors.New("cannot initialize while joining a raft cluster")
+	}
+
+	init, err := raftBackend.Initialized()
+	if err != nil {
+		return err
+	}
+	if !init {
+		peers := []raftstorage.Peer{
+			{
+				ID:      raftBackend.NodeID(),
+				Address: raftBackend.RaftAddr(),
+			},
+		}
+		if err := raftBackend.Bootstrap(ctx, peers); err != nil {
+			return errwrap.Wrapf("failed to bootstrap raft cluster: {{err}}", err)
+		}
+	}
+
+	if err := raftBackend.SetupCluster(ctx); err != nil {
+		return err
+	}
+
+	leaderCtx, cancel := context.WithTimeout(ctx, raftLeadershipTimeout)
+	defer cancel()
+	return raftBackend.WaitForLeader(leaderCtx)
+}
+
+// JoinRaftCluster asks the active node of a raft cluster for a bootstrap
+// challenge. The join completes when the node is unsealed with the unseal keys
+// of the cluster, which are needed to answer the challenge.
+func (c *Core) JoinRaftCluster(ctx context.Context, params *RaftJoinParams) error {
+	raftBackend := c.getRaftBackend()
+	if raftBackend == nil {
+		return errors.New("raft storage is not in use")
+	}
+	if params.LeaderAPIAddr == "" {
+		return errors.New("leader API address is required")
+	}
+	if c.seal.StoredKeysSupported() {
+		return errors.New("joining a raft cluster requires a shamir seal")
+	}
+
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	init, err := raftBackend.Initialized()
+	if err != nil {
+		return err
+	}
+	if init {
+		return errors.New("node is already part of a raft cluster")
+	}
+	if init, err := c.Initialized(ctx); err != nil {
+		return err
+	} else if init {
+		return ErrAlreadyInit
+	}
+
+	client, err := raftLeaderClient(params.LeaderCACert)
+	if err != nil {
+		return err
+	}
+	leaderAddr := strings.TrimSuffix(params.LeaderAPIAddr, "/")
+
+	var resp struct {
+		Data struct {
+			Challenge  string      `json:"challenge"`
+			SealConfig *SealConfig `json:"seal_config"`
+		} `json:"data"`
+	}
+	err = raftLeaderRequest(ctx, client, leaderAddr+"/v1/sys/storage/raft/bootstrap/challenge", map[string]interface{}{
+		"server_id": raftBackend.NodeID(),
+	}, &resp)
+	if err != nil {
+		return errwrap.Wrapf("failed to get raft bootstrap challenge: {{err}}", err)
+	}
+
+	challenge, err := base64.StdEncoding.DecodeString(resp.Data.Challenge)
+	if err != nil {
+		return errwrap.Wrapf("invalid raft bootstrap challenge: {{err}}", err)
+	}
+	if resp.Data.SealConfig == nil {
+		return errors.New("leader did not return its seal configuration")
+	}
+	if err := resp.Data.SealConfig.Validate(); err != nil {
+		return errwrap.Wrapf("invalid leader seal configuration: {{err}}", err)
+	}
+
+	c.unlockInfo = nil
+	c.raftInfo = &raftInformation{
+		challenge:           challenge,
+		leaderClient:        client,
+		leaderAPIAddr:       leaderAddr,
+		leaderBarrierConfig: resp.Data.SealConfig,
+	}
+
+	c.logger.Info("raft join requested, waiting for the unseal keys", "leader_api_addr", leaderAddr)
+	return nil
+}
+
+// unsealRaftJoin completes a raft join with the master key recovered from the
+// unseal keys: it answers the challenge, waits for the data to be replicated
+// and unseals the node.
+// N.B.: This must be called with the state write lock held.
+func (c *Core) unsealRaftJoin(ctx context.Context, masterKey []byte) (bool, error) {
+	raftBackend := c.getRaftBackend()
+	raftInfo := c.raftInfo
+
+	answer, err := raftDecryptChallenge(masterKey, raftInfo.challenge)
+	if err != nil {
+		memzero(masterKey)
+		return false, errwrap.Wrapf("failed to answer raft bootstrap challenge, the unseal keys may be incorrect: {{err}}", err)
+	}
+
+	// Raft has to be running before the active node adds this node as a voter,
+	// otherwise the configuration change cannot be committed
+	if err := raftBackend.SetupCluster(ctx); err != nil {
+		memzero(masterKey)
+		return false, err
+	}
+
+	var resp struct {
+		Data struct {
+			Peers []raftstorage.Peer `json:"peers"`
+		} `json:"data"`
+	}
+	err = raftLeaderRequest(ctx, raftInfo.leaderClient, raftInfo.leaderAPIAddr+"/v1/sys/storage/raft/bootstrap/answer", map[string]interface{}{
+		"server_id": raftBackend.NodeID(),
+		"answer":    base64.StdEncoding.EncodeToString(answer),
+		"raft_addr": raftBackend.RaftAddr(),
+	}, &resp)
+	if err != nil {
+		memzero(masterKey)
+		raftBackend.TeardownCluster()
+		return false, errwrap.Wrapf("failed to send raft bootstrap answer: {{err}}", err)
+	}
+	c.logger.Info("joined raft cluster", "peers", len(resp.Data.Peers))
+
+	// The challenge has been used, unsealing again requires a new join
+	c.raftInfo = nil
+
+	if err := c.waitForRaftData(ctx); err != nil {
+		memzero(masterKey)
+		return false, err
+	}
+
+	return c.unsealInternal(ctx, masterKey)
+}
+
+// waitForRaftData waits until the keyring and the seal configuration have been
+// replicated to this node.
+func (c *Core) waitForRaftData(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, raftJoinDataTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		init, err := c.barrier.Initialized(ctx)
+		if err == nil && init {
+			entry, err := c.physical.Get(ctx, barrierSealConfigPath)
+			if err == nil && entry != nil {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.New("timed out waiting for the data to be replicated from the raft leader")
+		case <-ticker.C:
+		}
+	}
+}
+
+// raftBootstrapChallenge creates a challenge for a node asking to join the
+// cluster. The answer is encrypted with the master key so that only nodes
+// unsealed with the cluster's unseal keys can return it.
+func (c *Core) raftBootstrapChallenge(ctx context.Context, serverID string) ([]byte, *SealConfig, error) {
+	keyring, err := c.barrier.Keyring()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	answer := make([]byte, 32)
+	if _, err := rand.Read(answer); err != nil {
+		return nil, nil, err
+	}
+
+	challenge, err := raftEncryptChallenge(keyring.MasterKey(), answer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sealConfig, err := c.seal.BarrierConfig(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.pendingRaftPeersLock.Lock()
+	defer c.pendingRaftPeersLock.Unlock()
+
+	now := time.Now()
+	for id, peer := range c.pendingRaftPeers {
+		if now.Sub(peer.createdAt) > raftChallengeTTL {
+			delete(c.pendingRaftPeers, id)
+		}
+	}
+	// The challenge of a joining node cannot be replaced by another caller
+	// using its ID
+	if _, ok := c.pendingRaftPeers[serverID]; ok {
+		return nil, nil, logical.CodedError(http.StatusConflict, fmt.Sprintf("a bootstrap challenge is already pending for server %q, retry once it expires", serverID))
+	}
+	if len(c.pendingRaftPeers) >= raftMaxPendingPeers {
+		return nil, nil, logical.CodedError(http.StatusTooManyRequests, "too many pending bootstrap challenges")
+	}
+	c.pendingRaftPeers[serverID] = &pendingRaftPeer{
+		answer:    answer,
+		createdAt: now,
+	}
+
+	return challenge, sealConfig, nil
+}
+
+// raftBootstrapAnswer checks the answer of a joining node and adds it to the
+// raft configuration.
+func (c *Core) raftBootstrapAnswer(ctx context.Context, serverID string, answer []byte, addr string) ([]raftstorage.Peer, error) {
+	raftBackend := c.getRaftBackend()
+	if raftBackend == nil {
+		return nil, errors.New("raft storage is not in use")
+	}
+
+	// A wrong answer leaves the challenge in place, so that it cannot be
+	// used to discard the challenge of a joining node
+	c.pendingRaftPeersLock.Lock()
+	peer, ok := c.pendingRaftPeers[serverID]
+	switch {
+	case !ok || time.Since(peer.createdAt) > raftChallengeTTL:
+		c.pendingRaftPeersLock.Unlock()
+		return nil, errors.New("no pending challenge for the server")
+	case subtle.ConstantTimeCompare(peer.answer, answer) != 1:
+		c.pendingRaftPeersLock.Unlock()
+		return nil, errors.New("invalid answer given")
+	}
+	delete(c.pendingRaftPeers, serverID)
+	c.pendingRaftPeersLock.Unlock()
+
+	if err := raftBackend.AddPeer(ctx, serverID, addr); err != nil {
+		return nil, err
+	}
+
+	return raftBackend.Peers(ctx)
+}
+
+func raftEncryptChallenge(key, plaintext []byte) ([]byte, error) {
+	gcm, err := raftChallengeAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func raftDecryptChallenge(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := raftChallengeAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("challenge is too short")
+	}
+	nonce := ciphertext[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, ciphertext[gcm.NonceSize():], nil)
+}
+
+func raftChallengeAEAD(key []byte) (cipher.AEAD, error) {
+	aesCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create cipher: {{err}}", err)
+	}
+	return cipher.NewGCM(aesCipher)
+}
+
+// raftLeaderClient returns the client used to reach the leader, trusting the
+// given CA if any.
+func raftLeaderClient(caCert string) (*http.Client, error) {
+	client := cleanhttp.DefaultClient()
+	client.Timeout = 30 * time.Second
+
+	if caCert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(caCert)) {
+			return nil, errors.New("no certificates found in the leader CA certificate")
+		}
+		client.Transport.(*http.Transport).TLSClientConfig = &tls.Config{
+			RootCAs:    pool,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+
+	return client, nil
+}
+
+// raftLeaderRequest sends an unauthenticated request to the leader API and
+// decodes the response.
+func raftLeaderRequest(ctx context.Context, client *http.Client, url string, body map[string]interface{}, out interface{}) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Errors []string `json:"errors"`
+		}
+		if jsonutil.DecodeJSON(respBody, &errResp) == nil && len(errResp.Errors) > 0 {
+			return fmt.Errorf("leader returned %d: %s", resp.StatusCode, strings.Join(errResp.Errors, ", "))
+		}
+		return fmt.Errorf("leader returned %d", resp.StatusCode)
+	}
+
+	return jsonutil.DecodeJSON(respBody, out)
+}
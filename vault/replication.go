@@ -0,0 +1,881 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jiangjiali/vault/api"
+	"github.com/jiangjiali/vault/sdk/helper/consts"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	squarejwt "github.com/jiangjiali/vault/sdk/helper/jose/jwt"
+	"github.com/jiangjiali/vault/sdk/helper/jsonutil"
+	"github.com/jiangjiali/vault/sdk/helper/mitchellh/mapstructure"
+	"github.com/jiangjiali/vault/sdk/helper/xxuuid"
+	"github.com/jiangjiali/vault/sdk/logical"
+	"github.com/jiangjiali/vault/sdk/physical"
+	"github.com/jiangjiali/vault/vault/replication"
+)
+
+// replicationMode describes a mode of replication. Disaster recovery
+// replicates the whole storage to a secondary that serves no requests until
+// it is promoted. Performance replication leaves out the local mounts and the
+// leases, and its secondaries serve the reads and forward the writes to the
+// primary.
+type replicationMode struct {
+	name              string
+	alpn              string
+	infoPath          string
+	secondariesPrefix string
+	performance       bool
+
+	primary       consts.ReplicationState
+	secondary     consts.ReplicationState
+	bootstrapping consts.ReplicationState
+	disabled      consts.ReplicationState
+}
+
+var (
+	drReplicationMode = &replicationMode{
+		name:              "dr",
+		alpn:              DRReplicationALPN,
+		infoPath:          consts.CoreReplicatedClusterInfoPathDR,
+		secondariesPrefix: consts.CoreReplicatedClusterSecondariesPrefixDR,
+		primary:           consts.ReplicationDRPrimary,
+		secondary:         consts.ReplicationDRSecondary,
+		bootstrapping:     consts.ReplicationDRBootstrapping,
+		disabled:          consts.ReplicationDRDisabled,
+	}
+
+	performanceReplicationMode = &replicationMode{
+		name:              "performance",
+		alpn:              PerformanceReplicationALPN,
+		infoPath:          consts.CoreReplicatedClusterInfoPath,
+		secondariesPrefix: consts.CoreReplicatedClusterSecondariesPrefix,
+		performance:       true,
+		primary:           consts.ReplicationPerformancePrimary,
+		secondary:         consts.ReplicationPerformanceSecondary,
+		bootstrapping:     consts.ReplicationPerformanceBootstrapping,
+		disabled:          consts.ReplicationPerformanceDisabled,
+	}
+
+	replicationModes = []*replicationMode{drReplicationMode, performanceReplicationMode}
+)
+
+// cluster returns the replication state of the local cluster for the mode,
+// nil when the mode is disabled. The replication lock must be held.
+func (m *replicationMode) cluster(c *Core) *replication.Cluster {
+	if m.performance {
+		return c.replicationClusters.Performance
+	}
+	return c.replicationClusters.DR
+}
+
+func (m *replicationMode) setCluster(c *Core, cluster *replication.Cluster) {
+	if m.performance {
+		c.replicationClusters.Performance = cluster
+	} else {
+		c.replicationClusters.DR = cluster
+	}
+}
+
+// replicationActivation is the data of an activation token, which a
+// secondary unwraps from its primary
+type replicationActivation struct {
+	Mode               string `json:"mode" mapstructure:"mode"`
+	ID                 string `json:"id" mapstructure:"id"`
+	ClusterID          string `json:"cluster_id" mapstructure:"cluster_id"`
+	PrimaryClusterAddr string `json:"primary_cluster_addr" mapstructure:"primary_cluster_addr"`
+	CACert             string `json:"ca_cert" mapstructure:"ca_cert"`
+	ClientCert         string `json:"client_cert" mapstructure:"client_cert"`
+	ClientKey          string `json:"client_key" mapstructure:"client_key"`
+}
+
+func enterprisePostUnsealImpl(c *Core) error {
+	return c.loadReplicationClusters(c.activeContext)
+}
+
+// loadReplicationClusters reads the replication state of the local cluster
+// and caches it, before the mounts are set up according to it
+func (c *Core) loadReplicationClusters(ctx context.Context) error {
+	c.replicationLock.Lock()
+	defer c.replicationLock.Unlock()
+
+	for _, mode := range replicationModes {
+		entry, err := c.barrier.Get(ctx, mode.infoPath)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to read %s replication state: {{err}}", mode.name), err)
+		}
+		var cluster *replication.Cluster
+		if entry != nil {
+			cluster = new(replication.Cluster)
+			if err := jsonutil.DecodeJSON(entry.Value, cluster); err != nil {
+				return errwrap.Wrapf(fmt.Sprintf("failed to decode %s replication state: {{err}}", mode.name), err)
+			}
+		}
+		mode.setCluster(c, cluster)
+	}
+	c.updateReplicationState()
+	return nil
+}
+
+// persistReplicationCluster stores the replication state of the mode
+func (c *Core) persistReplicationCluster(ctx context.Context, mode *replicationMode, cluster *replication.Cluster) error {
+	if cluster == nil {
+		if err := c.barrier.Delete(ctx, mode.infoPath); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to delete %s replication state: {{err}}", mode.name), err)
+		}
+		return nil
+	}
+
+	value, err := jsonutil.EncodeJSON(cluster)
+	if err != nil {
+		return err
+	}
+	if err := c.barrier.Put(ctx, &logical.StorageEntry{
+		Key:   mode.infoPath,
+		Value: value,
+	}); err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("failed to store %s replication state: {{err}}", mode.name), err)
+	}
+	return nil
+}
+
+// updateReplicationState caches the replication state of the modes. The
+// replication lock must be held.
+func (c *Core) updateReplicationState() {
+	var state consts.ReplicationState
+	for _, mode := range replicationModes {
+		cluster := mode.cluster(c)
+		switch {
+		case cluster == nil:
+			state.AddState(mode.disabled)
+		case cluster.State.HasState(mode.primary):
+			state.AddState(mode.primary)
+		case cluster.State.HasState(mode.secondary):
+			state.AddState(mode.secondary)
+			if s, ok := c.replicationSecondaries[mode.name]; ok {
+				if s.bootstrapping() {
+					state.AddState(mode.bootstrapping)
+				}
+			} else if cluster.State.HasState(mode.bootstrapping) {
+				state.AddState(mode.bootstrapping)
+			}
+		}
+	}
+	c.setReplicationState(state)
+}
+
+// setReplicationState caches the replication state, keeping the flags of
+// the performance standbys
+func (c *Core) setReplicationState(state consts.ReplicationState) {
+	if c.ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		state.AddState(consts.ReplicationPerformanceStandby)
+	}
+	atomic.StoreUint32(c.replicationState, uint32(state))
+}
+
+// setReplicationStateFlag sets or clears a flag of the cached replication
+// state
+func (c *Core) setReplicationStateFlag(flag consts.ReplicationState, set bool) {
+	for {
+		old := atomic.LoadUint32(c.replicationState)
+		state := consts.ReplicationState(old)
+		if set {
+			state.AddState(flag)
+		} else {
+			state.ClearState(flag)
+		}
+		if atomic.CompareAndSwapUint32(c.replicationState, old, uint32(state)) {
+			return
+		}
+	}
+}
+
+func startReplicationImpl(c *Core) error {
+	if c.replicationReloading {
+		return nil
+	}
+
+	c.replicationLock.Lock()
+	defer c.replicationLock.Unlock()
+
+	for _, mode := range replicationModes {
+		cluster := mode.cluster(c)
+		switch {
+		case cluster == nil:
+		case cluster.State.HasState(mode.primary):
+			if err := c.startReplicationPrimary(mode, cluster); err != nil {
+				c.logger.Error("failed to start replication primary", "mode", mode.name, "error", err)
+			}
+		case cluster.State.HasState(mode.secondary):
+			if err := c.startReplicationSecondary(mode, cluster); err != nil {
+				c.logger.Error("failed to start replication secondary", "mode", mode.name, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+func stopReplicationImpl(c *Core) error {
+	if c.replicationReloading {
+		return nil
+	}
+
+	c.replicationLock.Lock()
+	primaries := c.replicationPrimaries
+	secondaries := c.replicationSecondaries
+	c.replicationPrimaries = make(map[string]*replicationPrimary)
+	c.replicationSecondaries = make(map[string]*replicationSecondary)
+	c.replicationLock.Unlock()
+
+	// The secondaries are stopped without the lock as they persist their
+	// position on the way out
+	for _, p := range primaries {
+		c.stopReplicationPrimary(p)
+	}
+	for _, s := range secondaries {
+		s.stop()
+	}
+	return nil
+}
+
+func lastWALImpl(c *Core) uint64 {
+	_, index := c.replicationLog.position()
+	return index
+}
+
+func lastRemoteWALImpl(c *Core) uint64 {
+	c.replicationLock.RLock()
+	s := c.replicationSecondaries[performanceReplicationMode.name]
+	c.replicationLock.RUnlock()
+	if s == nil {
+		return 0
+	}
+	_, index := s.position()
+	return index
+}
+
+func waitUntilWALShippedImpl(ctx context.Context, c *Core, index uint64) bool {
+	c.replicationLock.RLock()
+	s := c.replicationSecondaries[performanceReplicationMode.name]
+	c.replicationLock.RUnlock()
+	if s == nil {
+		return true
+	}
+	return s.waitForIndex(ctx, index)
+}
+
+// reloadState tears down and sets up again the state of the active node
+// loaded from the storage, once replication or the restore of a snapshot
+// changed it under it
+func (c *Core) reloadState() {
+	c.stateLock.Lock()
+	defer c.stateLock.Unlock()
+
+	if c.Sealed() || c.standby {
+		return
+	}
+
+	c.logger.Info("reloading the state from the storage")
+	ctx := c.activeContext
+	ctxCancel := c.activeContextCancelFunc.Load().(context.CancelFunc)
+
+	// The primaries and secondaries keep running, so that the streams and
+	// the requests forwarded to the primary are not cut
+	c.replicationReloading = true
+	if err := c.preSeal(); err != nil {
+		c.logger.Error("pre-seal teardown failed", "error", err)
+	}
+	err := c.postUnseal(ctx, ctxCancel, standardUnsealStrategy{})
+	c.replicationReloading = false
+	if err != nil {
+		c.logger.Error("failed to reload the state from the storage, sealing", "error", err)
+		if err := c.sealInternalWithOptions(false, false); err != nil {
+			c.logger.Error("failed to seal", "error", err)
+		}
+	}
+}
+
+// enableReplicationPrimary makes the local cluster a primary of the mode
+func (c *Core) enableReplicationPrimary(ctx context.Context, mode *replicationMode, primaryClusterAddr string) error {
+	c.replicationLock.Lock()
+	defer c.replicationLock.Unlock()
+
+	if mode.cluster(c) != nil {
+		return fmt.Errorf("%s replication is already enabled", mode.name)
+	}
+	if c.IsDRSecondary() {
+		return errors.New("a disaster recovery secondary cannot be a primary")
+	}
+	if c.clusterListener == nil {
+		return errors.New("replication requires the cluster listener, set a cluster address")
+	}
+	if primaryClusterAddr == "" {
+		primaryClusterAddr = c.clusterAddr
+	}
+
+	clusterID, err := xxuuid.GenerateUUID()
+	if err != nil {
+		return err
+	}
+	cluster := &replication.Cluster{
+		State:              mode.primary,
+		ClusterID:          clusterID,
+		PrimaryClusterAddr: primaryClusterAddr,
+	}
+	if err := generateReplicationCA(cluster); err != nil {
+		return err
+	}
+	if err := c.persistReplicationCluster(ctx, mode, cluster); err != nil {
+		return err
+	}
+
+	mode.setCluster(c, cluster)
+	c.updateReplicationState()
+	c.logger.Info("enabled replication primary", "mode", mode.name, "cluster_id", clusterID)
+	return c.startReplicationPrimary(mode, cluster)
+}
+
+// generateReplicationCA creates the certificate authority of a primary
+func generateReplicationCA(cluster *replication.Cluster) error {
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	host := fmt.Sprintf("rep-%s", cluster.ClusterID)
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+	if err != nil {
+		return err
+	}
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName: host,
+		},
+		DNSNames: []string{host},
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement | x509.KeyUsageCertSign,
+		SerialNumber:          serial,
+		NotBefore:             time.Now().Add(-30 * time.Second),
+		NotAfter:              time.Now().Add(262980 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return errwrap.Wrapf("unable to generate replication certificate: {{err}}", err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	cluster.CACert = certBytes
+	cluster.CAKey = keyBytes
+	return nil
+}
+
+// issueSecondaryCertificate signs the client certificate of a secondary
+func issueSecondaryCertificate(cluster *replication.Cluster, id string) ([]byte, *ecdsa.PrivateKey, *big.Int, error) {
+	caCert, err := x509.ParseCertificate(cluster.CACert)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	caKey, err := x509.ParseECPrivateKey(cluster.CAKey)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 63))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName: id,
+		},
+		DNSNames:     []string{id},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageKeyAgreement,
+		SerialNumber: serial,
+		NotBefore:    time.Now().Add(-30 * time.Second),
+		NotAfter:     caCert.NotAfter,
+	}
+	certBytes, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, nil, errwrap.Wrapf("unable to generate secondary certificate: {{err}}", err)
+	}
+	return certBytes, key, serial, nil
+}
+
+// replicationSecondaryToken registers a secondary and returns the data of
+// its activation token
+func (c *Core) replicationSecondaryToken(ctx context.Context, mode *replicationMode, id string) (*replicationActivation, error) {
+	c.replicationLock.RLock()
+	defer c.replicationLock.RUnlock()
+
+	cluster := mode.cluster(c)
+	if cluster == nil || !cluster.State.HasState(mode.primary) {
+		return nil, fmt.Errorf("the cluster is not a %s replication primary", mode.name)
+	}
+
+	certBytes, key, serial, err := issueSecondaryCertificate(cluster, id)
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	secondary := &replication.Secondary{
+		ID:           id,
+		SerialNumber: serial.Text(16),
+		IssueTime:    time.Now(),
+	}
+	value, err := jsonutil.EncodeJSON(secondary)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.barrier.Put(ctx, &logical.StorageEntry{
+		Key:   mode.secondariesPrefix + id,
+		Value: value,
+	}); err != nil {
+		return nil, errwrap.Wrapf("failed to store secondary: {{err}}", err)
+	}
+
+	return &replicationActivation{
+		Mode:               mode.name,
+		ID:                 id,
+		ClusterID:          cluster.ClusterID,
+		PrimaryClusterAddr: cluster.PrimaryClusterAddr,
+		CACert:             string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cluster.CACert})),
+		ClientCert:         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certBytes})),
+		ClientKey:          string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})),
+	}, nil
+}
+
+// loadReplicationSecondary reads a secondary registered on the primary
+func (c *Core) loadReplicationSecondary(ctx context.Context, mode *replicationMode, id string) (*replication.Secondary, error) {
+	entry, err := c.barrier.Get(ctx, mode.secondariesPrefix+id)
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	secondary := new(replication.Secondary)
+	if err := jsonutil.DecodeJSON(entry.Value, secondary); err != nil {
+		return nil, err
+	}
+	return secondary, nil
+}
+
+// listReplicationSecondaries returns the identifiers of the secondaries
+// registered on the primary
+func (c *Core) listReplicationSecondaries(ctx context.Context, mode *replicationMode) ([]string, error) {
+	return c.barrier.List(ctx, mode.secondariesPrefix)
+}
+
+// revokeReplicationSecondary removes a secondary, which is disconnected the
+// next time its stream is checked
+func (c *Core) revokeReplicationSecondary(ctx context.Context, mode *replicationMode, id string) error {
+	c.replicationLock.RLock()
+	defer c.replicationLock.RUnlock()
+
+	cluster := mode.cluster(c)
+	if cluster == nil || !cluster.State.HasState(mode.primary) {
+		return fmt.Errorf("the cluster is not a %s replication primary", mode.name)
+	}
+	return c.barrier.Delete(ctx, mode.secondariesPrefix+id)
+}
+
+// unwrapReplicationActivation unwraps an activation token from the primary.
+// The address of the primary is taken from the token unless given.
+func unwrapReplicationActivation(token, primaryAPIAddr string, tlsConfig *api.TLSConfig) (*replicationActivation, error) {
+	if primaryAPIAddr == "" {
+		parsed, err := squarejwt.ParseSigned(token)
+		if err != nil {
+			return nil, errwrap.Wrapf("activation token could not be parsed: {{err}}", err)
+		}
+		claims := make(map[string]interface{})
+		if err := parsed.UnsafeClaimsWithoutVerification(&claims); err != nil {
+			return nil, errwrap.Wrapf("activation token could not be parsed: {{err}}", err)
+		}
+		primaryAPIAddr, _ = claims["addr"].(string)
+		if primaryAPIAddr == "" {
+			return nil, errors.New("activation token has no address of the primary, set primary_api_addr")
+		}
+	}
+
+	config := api.DefaultConfig()
+	if config.Error != nil {
+		return nil, config.Error
+	}
+	config.Address = primaryAPIAddr
+	if tlsConfig != nil {
+		if err := config.ConfigureTLS(tlsConfig); err != nil {
+			return nil, err
+		}
+	}
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	client.SetToken("")
+	client.SetNamespace("")
+
+	secret, err := client.Logical().Unwrap(token)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to unwrap activation token: {{err}}", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.New("activation token has no data")
+	}
+
+	activation := new(replicationActivation)
+	if err := mapstructure.Decode(secret.Data, activation); err != nil {
+		return nil, err
+	}
+	return activation, nil
+}
+
+// enableReplicationSecondary makes the local cluster a secondary of the
+// primary which issued the activation token. The storage is replaced by the
+// one of the primary once it is copied.
+func (c *Core) enableReplicationSecondary(ctx context.Context, mode *replicationMode, activation *replicationActivation) error {
+	c.replicationLock.Lock()
+	defer c.replicationLock.Unlock()
+
+	if mode.cluster(c) != nil {
+		return fmt.Errorf("%s replication is already enabled", mode.name)
+	}
+	if !mode.performance && c.replicationClusters.Performance != nil {
+		return errors.New("performance replication must be disabled to become a disaster recovery secondary")
+	}
+	if c.IsDRSecondary() {
+		return errors.New("a disaster recovery secondary cannot be a performance secondary")
+	}
+
+	cluster, err := activation.cluster(mode)
+	if err != nil {
+		return err
+	}
+	if err := c.persistReplicationCluster(ctx, mode, cluster); err != nil {
+		return err
+	}
+
+	mode.setCluster(c, cluster)
+	c.updateReplicationState()
+	c.logger.Info("enabled replication secondary", "mode", mode.name, "cluster_id", cluster.ClusterID, "primary_cluster_addr", cluster.PrimaryClusterAddr)
+	return c.startReplicationSecondary(mode, cluster)
+}
+
+// cluster returns the replication state of a secondary activated with the
+// token
+func (a *replicationActivation) cluster(mode *replicationMode) (*replication.Cluster, error) {
+	if a.Mode != mode.name {
+		return nil, fmt.Errorf("activation token is for %s replication", a.Mode)
+	}
+
+	decode := func(name, value string) ([]byte, error) {
+		block, _ := pem.Decode([]byte(value))
+		if block == nil {
+			return nil, fmt.Errorf("activation token has no valid %s", name)
+		}
+		return block.Bytes, nil
+	}
+	caCert, err := decode("ca_cert", a.CACert)
+	if err != nil {
+		return nil, err
+	}
+	clientCert, err := decode("client_cert", a.ClientCert)
+	if err != nil {
+		return nil, err
+	}
+	clientKey, err := decode("client_key", a.ClientKey)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tls.X509KeyPair(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: clientCert}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: clientKey})); err != nil {
+		return nil, errwrap.Wrapf("activation token has no valid client certificate: {{err}}", err)
+	}
+
+	return &replication.Cluster{
+		State:              mode.secondary | mode.bootstrapping,
+		ClusterID:          a.ClusterID,
+		PrimaryClusterAddr: a.PrimaryClusterAddr,
+		CACert:             caCert,
+		SecondaryID:        a.ID,
+		ClientCert:         clientCert,
+		ClientKey:          clientKey,
+	}, nil
+}
+
+// updateReplicationPrimary points a secondary to another primary, such as
+// a promoted secondary of the same primary
+func (c *Core) updateReplicationPrimary(ctx context.Context, mode *replicationMode, activation *replicationActivation) error {
+	c.replicationLock.Lock()
+	defer c.replicationLock.Unlock()
+
+	old := mode.cluster(c)
+	if old == nil || !old.State.HasState(mode.secondary) {
+		return fmt.Errorf("the cluster is not a %s replication secondary", mode.name)
+	}
+	cluster, err := activation.cluster(mode)
+	if err != nil {
+		return err
+	}
+
+	c.stopReplicationSecondary(mode)
+	if err := c.persistReplicationCluster(ctx, mode, cluster); err != nil {
+		return err
+	}
+
+	mode.setCluster(c, cluster)
+	c.updateReplicationState()
+	c.logger.Info("updated replication primary", "mode", mode.name, "cluster_id", cluster.ClusterID, "primary_cluster_addr", cluster.PrimaryClusterAddr)
+	return c.startReplicationSecondary(mode, cluster)
+}
+
+// promoteReplicationSecondary makes a secondary the primary of the mode. The
+// log starts a new epoch so that the secondaries, including the former
+// primary once demoted, copy the storage of the new primary.
+func (c *Core) promoteReplicationSecondary(ctx context.Context, mode *replicationMode, primaryClusterAddr string) error {
+	c.replicationLock.Lock()
+	defer c.replicationLock.Unlock()
+
+	old := mode.cluster(c)
+	if old == nil || !old.State.HasState(mode.secondary) {
+		return fmt.Errorf("the cluster is not a %s replication secondary", mode.name)
+	}
+	if c.clusterListener == nil {
+		return errors.New("replication requires the cluster listener, set a cluster address")
+	}
+	if bootstrapping := c.ReplicationState().HasState(mode.bootstrapping); bootstrapping {
+		return errors.New("the secondary has not copied the storage of the primary yet")
+	}
+	if primaryClusterAddr == "" {
+		primaryClusterAddr = c.clusterAddr
+	}
+
+	c.stopReplicationSecondary(mode)
+	cluster := &replication.Cluster{
+		State:              mode.primary,
+		ClusterID:          old.ClusterID,
+		PrimaryClusterAddr: primaryClusterAddr,
+	}
+	if err := generateReplicationCA(cluster); err != nil {
+		return err
+	}
+	if err := c.persistReplicationCluster(ctx, mode, cluster); err != nil {
+		return err
+	}
+	c.replicationLog.reset()
+
+	mode.setCluster(c, cluster)
+	c.updateReplicationState()
+	c.logger.Info("promoted replication secondary", "mode", mode.name, "cluster_id", cluster.ClusterID)
+	if err := c.startReplicationPrimary(mode, cluster); err != nil {
+		return err
+	}
+
+	// The state of the former secondary was never set up as a writer
+	go c.reloadState()
+	return nil
+}
+
+// demoteReplicationPrimary makes a primary a secondary with no primary,
+// which stops serving requests and writes until it is pointed to the new
+// primary with update-primary
+func (c *Core) demoteReplicationPrimary(ctx context.Context, mode *replicationMode) error {
+	c.replicationLock.Lock()
+	defer c.replicationLock.Unlock()
+
+	old := mode.cluster(c)
+	if old == nil || !old.State.HasState(mode.primary) {
+		return fmt.Errorf("the cluster is not a %s replication primary", mode.name)
+	}
+	if mode.performance && c.IsDRSecondary() {
+		return errors.New("a disaster recovery secondary cannot be demoted")
+	}
+
+	c.stopReplicationPrimaryMode(mode)
+	cluster := &replication.Cluster{
+		State:     mode.secondary,
+		ClusterID: old.ClusterID,
+	}
+	if err := c.persistReplicationCluster(ctx, mode, cluster); err != nil {
+		return err
+	}
+
+	mode.setCluster(c, cluster)
+	c.updateReplicationState()
+	c.logger.Info("demoted replication primary", "mode", mode.name, "cluster_id", cluster.ClusterID)
+	go c.reloadState()
+	return nil
+}
+
+// disableReplication turns off the mode of replication on the local
+// cluster. A former secondary keeps the storage it copied and becomes a
+// standalone cluster.
+func (c *Core) disableReplication(ctx context.Context, mode *replicationMode) error {
+	c.replicationLock.Lock()
+	defer c.replicationLock.Unlock()
+
+	old := mode.cluster(c)
+	if old == nil {
+		return fmt.Errorf("%s replication is not enabled", mode.name)
+	}
+
+	c.stopReplicationPrimaryMode(mode)
+	c.stopReplicationSecondary(mode)
+	if err := c.persistReplicationCluster(ctx, mode, nil); err != nil {
+		return err
+	}
+	if old.State.HasState(mode.primary) {
+		keys, err := c.listReplicationSecondaries(ctx, mode)
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			if err := c.barrier.Delete(ctx, mode.secondariesPrefix+key); err != nil {
+				return err
+			}
+		}
+	}
+
+	mode.setCluster(c, nil)
+	c.updateReplicationState()
+	c.logger.Info("disabled replication", "mode", mode.name)
+	if old.State.HasState(mode.secondary) {
+		go c.reloadState()
+	}
+	return nil
+}
+
+// replicationStatus returns the status of a mode of replication
+func (c *Core) replicationStatus(ctx context.Context, mode *replicationMode) (map[string]interface{}, error) {
+	c.replicationLock.RLock()
+	defer c.replicationLock.RUnlock()
+
+	cluster := mode.cluster(c)
+	if cluster == nil {
+		return map[string]interface{}{
+			"mode": "disabled",
+		}, nil
+	}
+
+	state := c.ReplicationState()
+	status := map[string]interface{}{
+		"cluster_id":           cluster.ClusterID,
+		"primary_cluster_addr": cluster.PrimaryClusterAddr,
+	}
+	if mode.performance {
+		status["mode"] = state.GetPerformanceString()
+	} else {
+		status["mode"] = state.GetDRString()
+	}
+
+	switch {
+	case cluster.State.HasState(mode.primary):
+		epoch, index := c.replicationLog.position()
+		status["mode"] = "primary"
+		status["epoch"] = epoch
+		status["last_wal"] = index
+
+		keys, err := c.listReplicationSecondaries(ctx, mode)
+		if err != nil {
+			return nil, err
+		}
+		if keys == nil {
+			keys = []string{}
+		}
+		status["known_secondaries"] = keys
+		if p, ok := c.replicationPrimaries[mode.name]; ok {
+			status["secondaries"] = p.connectionStatus()
+			status["state"] = "running"
+		} else {
+			status["state"] = "stopped"
+		}
+
+	case cluster.State.HasState(mode.secondary):
+		status["secondary_id"] = cluster.SecondaryID
+		if s, ok := c.replicationSecondaries[mode.name]; ok {
+			for k, v := range s.status() {
+				status[k] = v
+			}
+		} else {
+			status["state"] = "idle"
+		}
+	}
+	return status, nil
+}
+
+// checkReplicationState rejects the requests a secondary cannot serve: a
+// disaster recovery secondary only serves the replication endpoints, and a
+// secondary copying the storage of its primary has none to serve from. The
+// logins of performance secondaries are forwarded to the primary as their
+// tokens are replicated.
+func (c *Core) checkReplicationState(ctx context.Context, req *logical.Request) error {
+	state := c.ReplicationState()
+	if !state.HasState(consts.ReplicationDRSecondary | consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+	if strings.HasPrefix(req.Path, "sys/replication/") {
+		return nil
+	}
+
+	switch {
+	case state.HasState(consts.ReplicationDRSecondary):
+		return logical.CodedError(400, "path disabled in replication DR secondary mode")
+	case state.HasState(consts.ReplicationPerformanceBootstrapping):
+		return logical.CodedError(503, "the performance secondary is copying the storage of its primary")
+	case !strings.HasPrefix(req.Path, "sys/") && c.router.LoginPath(ctx, req.Path):
+		return logical.ErrPerfStandbyPleaseForward
+	}
+	return nil
+}
+
+// walkStorage calls fn for every key of the physical storage under the
+// prefix
+func walkStorage(ctx context.Context, b physical.Backend, prefix string, fn func(string) error) error {
+	keys, err := b.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if strings.HasSuffix(key, "/") {
+			if err := walkStorage(ctx, b, prefix+key, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(prefix + key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
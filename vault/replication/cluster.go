@@ -1,15 +1,50 @@
-// +build !enterprise
-
 package replication
 
-import "github.com/jiangjiali/vault/sdk/helper/consts"
+import (
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/consts"
+)
 
+// Cluster is the replication state of the local cluster for one mode of
+// replication. It is stored at the replicated cluster info path of the mode,
+// which is never replicated itself.
 type Cluster struct {
-	State              consts.ReplicationState
-	ClusterID          string
-	PrimaryClusterAddr string
+	State              consts.ReplicationState `json:"state"`
+	ClusterID          string                  `json:"cluster_id"`
+	PrimaryClusterAddr string                  `json:"primary_cluster_addr"`
+
+	// CACert is the certificate authority of the primary, which the
+	// primary serves replication with and signs the client certificates of
+	// the secondaries with. Its key is only known to the primary.
+	CACert []byte `json:"ca_cert"`
+	CAKey  []byte `json:"ca_key,omitempty"`
+
+	// The identifier of a secondary and the client certificate it connects
+	// to the primary with
+	SecondaryID string `json:"secondary_id,omitempty"`
+	ClientCert  []byte `json:"client_cert,omitempty"`
+	ClientKey   []byte `json:"client_key,omitempty"`
+
+	// Epoch and Index are the position in the log of the primary up to which
+	// a secondary has applied the writes
+	Epoch string `json:"epoch,omitempty"`
+	Index uint64 `json:"index,omitempty"`
+}
+
+// Secondary is a secondary allowed to connect to a primary. It is stored
+// under the replicated cluster secondaries prefix of the mode.
+type Secondary struct {
+	ID string `json:"id"`
+
+	// SerialNumber is the serial number of the client certificate issued to
+	// the secondary, so that issuing a new activation token for the same
+	// identifier invalidates the previous certificate
+	SerialNumber string    `json:"serial_number"`
+	IssueTime    time.Time `json:"issue_time"`
 }
 
+// Clusters is the replication state of the local cluster
 type Clusters struct {
 	DR          *Cluster
 	Performance *Cluster
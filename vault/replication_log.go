@@ -0,0 +1,264 @@
+package vault
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/jiangjiali/vault/sdk/helper/consts"
+	"github.com/jiangjiali/vault/sdk/helper/namespace"
+	"github.com/jiangjiali/vault/sdk/helper/xxuuid"
+	"github.com/jiangjiali/vault/sdk/logical"
+	"github.com/jiangjiali/vault/sdk/physical"
+)
+
+// replicationLogSize is the number of writes held by the replication log. A
+// secondary further behind than that is sent a copy of the storage instead.
+const replicationLogSize = 1 << 16
+
+// localStoragePrefixes are the storage paths which belong to the local
+// cluster and its seal, and are never replicated. The keyring is sent apart
+// by the primary, see WALBatch.
+var localStoragePrefixes = []string{
+	"core/cluster/local/",
+	consts.CoreReplicatedClusterPrefix,
+	consts.CoreReplicatedClusterPrefixDR,
+	coreLeaderPrefix,
+	CoreLockPath,
+	poisonPillPath,
+	knownPrimaryAddrsPrefix,
+	requestCountersPath,
+	keyringPath,
+	masterKeyPath,
+	barrierSealConfigPath,
+	recoverySealConfigPath,
+	recoverySealConfigPlaintextPath,
+	recoveryKeyPath,
+	"core/hsm/",
+	coreSealWrapPath,
+	coreBarrierUnsealKeysBackupPath,
+	coreRecoveryUnsealKeysBackupPath,
+	coreDROperationTokenPath,
+	autoSnapshotStatusPath,
+}
+
+// replicationLog is the log of the keys written to the storage, which the
+// primary ships to its secondaries. Only the keys are logged: the values
+// are read from the storage when shipped, so that replaying a write is
+// always safe. The log is held in memory and starts a new epoch whenever it
+// is created, which makes the secondaries copy the whole storage again.
+type replicationLog struct {
+	l      sync.Mutex
+	epoch  string
+	index  uint64
+	keys   []string
+	notify chan struct{}
+}
+
+func newReplicationLog() *replicationLog {
+	l := &replicationLog{
+		keys:   make([]string, replicationLogSize),
+		notify: make(chan struct{}),
+	}
+	l.epoch, _ = xxuuid.GenerateUUID()
+	return l
+}
+
+// append logs the writes of the keys
+func (l *replicationLog) append(keys ...string) {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	for _, key := range keys {
+		l.index++
+		l.keys[l.index%replicationLogSize] = key
+	}
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// position returns the epoch of the log and the index of its last write
+func (l *replicationLog) position() (string, uint64) {
+	l.l.Lock()
+	defer l.l.Unlock()
+	return l.epoch, l.index
+}
+
+// since returns the keys written after the index and the index of the last
+// one, along with a channel closed on the next write. It returns false when
+// the log does not hold the writes after the index.
+func (l *replicationLog) since(epoch string, index uint64) ([]string, uint64, <-chan struct{}, bool) {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	if epoch != l.epoch || index > l.index || l.index-index > replicationLogSize {
+		return nil, 0, nil, false
+	}
+
+	var keys []string
+	for i := index + 1; i <= l.index; i++ {
+		keys = append(keys, l.keys[i%replicationLogSize])
+	}
+	return keys, l.index, l.notify, true
+}
+
+// reset starts a new epoch
+func (l *replicationLog) reset() {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	l.epoch, _ = xxuuid.GenerateUUID()
+	l.index = 0
+	close(l.notify)
+	l.notify = make(chan struct{})
+}
+
+// isLocalStorageKey returns whether the key belongs to the local cluster
+func isLocalStorageKey(key string) bool {
+	for _, prefix := range localStoragePrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// replicatedKey returns whether a key of the storage is replicated. Besides
+// the local storage paths, performance replication leaves out the local
+// mounts, the salts of the audit devices, and the leases of the local mounts
+// along with the index of the leases by token, which each cluster keeps and
+// revokes on its own.
+func (c *Core) replicatedKey(key string, performance bool) bool {
+	if isLocalStorageKey(key) {
+		return false
+	}
+	if !performance {
+		return true
+	}
+
+	switch key {
+	case coreLocalMountConfigPath, coreLocalAuthConfigPath, coreLocalAuditConfigPath:
+		return false
+	}
+	if strings.HasPrefix(key, auditBarrierPrefix) {
+		return false
+	}
+	if strings.HasPrefix(key, systemBarrierPrefix+expirationSubPath) {
+		leaseID := strings.TrimPrefix(key, systemBarrierPrefix+expirationSubPath+leaseViewPrefix)
+		if leaseID == key {
+			return false
+		}
+		entry := c.router.MatchingMountEntry(namespace.RootContext(nil), leaseID)
+		return entry == nil || !entry.Local
+	}
+	if entry, _, ok := c.router.matchingMountEntryByPath(context.Background(), key, false); ok && entry.Local {
+		return false
+	}
+	return true
+}
+
+// replicatedLease returns whether a lease of a performance secondary is
+// replicated from its primary, which revokes it
+func (c *Core) replicatedLease(leaseID string) bool {
+	if !c.IsPerfSecondary() {
+		return false
+	}
+	return c.replicatedKey(systemBarrierPrefix+expirationSubPath+leaseViewPrefix+leaseID, true)
+}
+
+// replicatedBackend wraps the physical storage of the cluster. It logs the
+// writes in the replication log and, on a secondary, rejects the writes of
+// the replicated keys, which are only written by applying the writes of the
+// primary to the underlying storage.
+type replicatedBackend struct {
+	physical.Backend
+	core *Core
+	log  *replicationLog
+}
+
+// transactionalReplicatedBackend is a replicated backend that wraps a
+// physical that is transactional
+type transactionalReplicatedBackend struct {
+	*replicatedBackend
+	physical.Transactional
+}
+
+var _ physical.Backend = (*replicatedBackend)(nil)
+var _ physical.Transactional = (*transactionalReplicatedBackend)(nil)
+
+func newReplicatedBackend(c *Core, b physical.Backend, log *replicationLog) physical.Backend {
+	ret := &replicatedBackend{
+		Backend: b,
+		core:    c,
+		log:     log,
+	}
+	if txn, ok := b.(physical.Transactional); ok {
+		return &transactionalReplicatedBackend{
+			replicatedBackend: ret,
+			Transactional:     txn,
+		}
+	}
+	return ret
+}
+
+// checkWritable returns an error when the key is written by replication
+func (b *replicatedBackend) checkWritable(key string) error {
+	state := b.core.ReplicationState()
+	switch {
+	case state.HasState(consts.ReplicationDRSecondary):
+		if b.core.replicatedKey(key, false) {
+			return logical.ErrReadOnly
+		}
+	case state.HasState(consts.ReplicationPerformanceSecondary):
+		if b.core.replicatedKey(key, true) {
+			return logical.ErrReadOnly
+		}
+	}
+	return nil
+}
+
+func (b *replicatedBackend) Put(ctx context.Context, entry *physical.Entry) error {
+	if err := b.checkWritable(entry.Key); err != nil {
+		return err
+	}
+	b.core.storageLock.RLock()
+	defer b.core.storageLock.RUnlock()
+	if err := b.Backend.Put(ctx, entry); err != nil {
+		return err
+	}
+	b.log.append(entry.Key)
+	return nil
+}
+
+func (b *replicatedBackend) Delete(ctx context.Context, key string) error {
+	if err := b.checkWritable(key); err != nil {
+		return err
+	}
+	b.core.storageLock.RLock()
+	defer b.core.storageLock.RUnlock()
+	if err := b.Backend.Delete(ctx, key); err != nil {
+		return err
+	}
+	b.log.append(key)
+	return nil
+}
+
+func (b *transactionalReplicatedBackend) Transaction(ctx context.Context, txns []*physical.TxnEntry) error {
+	keys := make([]string, 0, len(txns))
+	for _, txn := range txns {
+		if txn.Operation == physical.GetOperation {
+			continue
+		}
+		if err := b.checkWritable(txn.Entry.Key); err != nil {
+			return err
+		}
+		keys = append(keys, txn.Entry.Key)
+	}
+	b.core.storageLock.RLock()
+	defer b.core.storageLock.RUnlock()
+	if err := b.Transactional.Transaction(ctx, txns); err != nil {
+		return err
+	}
+	b.log.append(keys...)
+	return nil
+}
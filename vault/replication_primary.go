@@ -0,0 +1,475 @@
+package vault
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/forwarding"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/vault/replication"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// replicationBatchEntries and replicationBatchSize bound the entries
+	// and the size of the values sent in a batch
+	replicationBatchEntries = 512
+	replicationBatchSize    = 4 * 1024 * 1024
+)
+
+// replicationPrimary serves the secondaries of a mode of replication over
+// the cluster listener. It is both the cluster handler of the ALPN of the
+// mode and the gRPC server the secondaries stream the writes from.
+type replicationPrimary struct {
+	core      *Core
+	mode      *replicationMode
+	logger    log.Logger
+	fws       *http2.Server
+	rpcServer *grpc.Server
+	stopCh    chan struct{}
+
+	caCert       []byte
+	parsedCACert *x509.Certificate
+	caKey        *ecdsa.PrivateKey
+
+	// l guards the secondaries connected to the primary
+	l           sync.Mutex
+	connections map[string]*replicationConnection
+}
+
+// replicationConnection is a secondary streaming the writes of the primary
+type replicationConnection struct {
+	addr          string
+	connectedAt   time.Time
+	lastHeartbeat time.Time
+	index         uint64
+}
+
+// startReplicationPrimary registers the handler of the mode on the cluster
+// listener. The replication lock must be held.
+func (c *Core) startReplicationPrimary(mode *replicationMode, cluster *replication.Cluster) error {
+	if c.clusterListener == nil {
+		return errors.New("replication requires the cluster listener, set a cluster address")
+	}
+	if _, ok := c.replicationPrimaries[mode.name]; ok {
+		return nil
+	}
+
+	parsedCACert, err := x509.ParseCertificate(cluster.CACert)
+	if err != nil {
+		return err
+	}
+	caKey, err := x509.ParseECPrivateKey(cluster.CAKey)
+	if err != nil {
+		return err
+	}
+
+	p := &replicationPrimary{
+		core:   c,
+		mode:   mode,
+		logger: c.logger.Named("replication." + mode.name),
+		fws:    c.clusterListener.Server(),
+		rpcServer: grpc.NewServer(
+			grpc.KeepaliveParams(keepalive.ServerParameters{
+				Time: 2 * HeartbeatInterval,
+			}),
+			grpc.MaxRecvMsgSize(math.MaxInt32),
+			grpc.MaxSendMsgSize(math.MaxInt32),
+		),
+		stopCh:       make(chan struct{}),
+		caCert:       cluster.CACert,
+		parsedCACert: parsedCACert,
+		caKey:        caKey,
+		connections:  make(map[string]*replicationConnection),
+	}
+	RegisterReplicationServer(p.rpcServer, p)
+
+	c.clusterListener.AddHandler(mode.alpn, p)
+	c.replicationPrimaries[mode.name] = p
+	p.logger.Info("serving secondaries", "cluster_id", cluster.ClusterID)
+	return nil
+}
+
+// stopReplicationPrimaryMode stops the handler of the mode. The replication
+// lock must be held.
+func (c *Core) stopReplicationPrimaryMode(mode *replicationMode) {
+	p, ok := c.replicationPrimaries[mode.name]
+	if !ok {
+		return
+	}
+	delete(c.replicationPrimaries, mode.name)
+	c.stopReplicationPrimary(p)
+}
+
+func (c *Core) stopReplicationPrimary(p *replicationPrimary) {
+	if c.clusterListener != nil {
+		c.clusterListener.StopHandler(p.mode.alpn)
+	}
+}
+
+// ServerLookup satisfies the ClusterHandler interface and returns the
+// certificate authority of the primary, which it serves replication with.
+func (p *replicationPrimary) ServerLookup(ctx context.Context, clientHello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return &tls.Certificate{
+		Certificate: [][]byte{p.caCert},
+		PrivateKey:  p.caKey,
+		Leaf:        p.parsedCACert,
+	}, nil
+}
+
+// CALookup satisfies the ClusterHandler interface and returns the certificate
+// authority the client certificates of the secondaries are signed with.
+func (p *replicationPrimary) CALookup(ctx context.Context) (*x509.Certificate, error) {
+	return p.parsedCACert, nil
+}
+
+// Handoff serves a replication connection.
+func (p *replicationPrimary) Handoff(ctx context.Context, shutdownWg *sync.WaitGroup, closeCh chan struct{}, tlsConn *tls.Conn) error {
+	p.logger.Debug("got replication connection", "remote_addr", tlsConn.RemoteAddr())
+
+	shutdownWg.Add(2)
+	// quitCh is used to close the connection and the second
+	// goroutine if the server closes before closeCh.
+	quitCh := make(chan struct{})
+	go func() {
+		select {
+		case <-quitCh:
+		case <-closeCh:
+		case <-p.stopCh:
+		}
+		tlsConn.Close()
+		shutdownWg.Done()
+	}()
+
+	// The secondaries dial the TLS connection themselves and send requests
+	// with the http scheme, which leaves out the state of the connection the
+	// client certificate is authorized with
+	state := tlsConn.ConnectionState()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS == nil {
+			r.TLS = &state
+		}
+		p.rpcServer.ServeHTTP(w, r)
+	})
+
+	go func() {
+		p.fws.ServeConn(tlsConn, &http2.ServeConnOpts{
+			Handler: handler,
+			BaseConfig: &http.Server{
+				ErrorLog: p.logger.StandardLogger(nil),
+			},
+		})
+
+		// close the quitCh which will close the connection and
+		// the other goroutine.
+		close(quitCh)
+		shutdownWg.Done()
+	}()
+
+	return nil
+}
+
+// Stop stops the replication server and closes the connections.
+func (p *replicationPrimary) Stop() error {
+	close(p.stopCh)
+	p.rpcServer.Stop()
+	return nil
+}
+
+// authorize returns the identifier of the secondary the client certificate
+// of the call was issued to, as long as it is still registered with that
+// certificate
+func (p *replicationPrimary) authorize(ctx context.Context) (string, error) {
+	pr, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "no peer information")
+	}
+	tlsInfo, ok := pr.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return "", status.Error(codes.Unauthenticated, "no client certificate")
+	}
+	cert := tlsInfo.State.PeerCertificates[0]
+	id := cert.Subject.CommonName
+
+	secondary, err := p.core.loadReplicationSecondary(ctx, p.mode, id)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	if secondary == nil || secondary.SerialNumber != cert.SerialNumber.Text(16) {
+		return "", status.Errorf(codes.PermissionDenied, "secondary %q is not allowed to replicate", id)
+	}
+	return id, nil
+}
+
+// StreamWALs streams the writes of the primary to a secondary, starting with
+// a copy of the storage when the position of the secondary is not in the
+// log. Batches without entries are sent as heartbeats, and the secondary is
+// disconnected once it is revoked.
+func (p *replicationPrimary) StreamWALs(req *StreamWALsRequest, stream Replication_StreamWALsServer) error {
+	ctx := stream.Context()
+	id, err := p.authorize(ctx)
+	if err != nil {
+		return err
+	}
+
+	conn := p.connect(ctx, id)
+	defer p.disconnect(id, conn)
+	p.logger.Info("secondary connected", "id", id, "remote_addr", conn.addr)
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	epoch, index := req.Epoch, req.Index
+	for {
+		keys, last, notify, ok := p.core.replicationLog.since(epoch, index)
+		switch {
+		case !ok:
+			p.logger.Info("sending a copy of the storage", "id", id)
+			epoch, index, err = p.sendCopy(ctx, stream)
+			if err != nil {
+				return err
+			}
+			p.update(conn, index)
+			continue
+
+		case last > index:
+			if err := p.sendWrites(ctx, stream, epoch, index, last, keys); err != nil {
+				return err
+			}
+			index = last
+			p.update(conn, index)
+			continue
+		}
+
+		select {
+		case <-notify:
+		case <-heartbeat.C:
+			if _, err := p.authorize(ctx); err != nil {
+				p.logger.Info("disconnecting secondary", "id", id, "error", err)
+				return err
+			}
+			if err := stream.Send(&WALBatch{Epoch: epoch, Index: index}); err != nil {
+				return err
+			}
+			p.update(conn, index)
+		case <-ctx.Done():
+			return nil
+		case <-p.stopCh:
+			return status.Error(codes.Unavailable, "replication stopped")
+		}
+	}
+}
+
+// sendCopy sends the replicated entries of the storage, as of the current
+// position of the log which the secondary continues from
+func (p *replicationPrimary) sendCopy(ctx context.Context, stream Replication_StreamWALsServer) (string, uint64, error) {
+	c := p.core
+	epoch, index := c.replicationLog.position()
+
+	var count, size int
+	batch := &WALBatch{Epoch: epoch, Index: index, Reset_: true}
+	err := walkStorage(ctx, c.replicationStorage, "", func(key string) error {
+		if !c.replicatedKey(key, p.mode.performance) {
+			return nil
+		}
+		entry, err := c.replicationStorage.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+
+		batch.Entries = append(batch.Entries, &WALEntry{
+			Key:      entry.Key,
+			Value:    entry.Value,
+			SealWrap: entry.SealWrap,
+		})
+		count++
+		size += len(entry.Value)
+		if len(batch.Entries) < replicationBatchEntries && size < replicationBatchSize {
+			return nil
+		}
+		if err := stream.Send(batch); err != nil {
+			return err
+		}
+		batch = &WALBatch{Epoch: epoch, Index: index}
+		size = 0
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	keyring, err := p.keyring()
+	if err != nil {
+		return "", 0, err
+	}
+	batch.Synced = true
+	batch.Keyring = keyring
+	if err := stream.Send(batch); err != nil {
+		return "", 0, err
+	}
+
+	p.logger.Info("sent a copy of the storage", "entries", count, "index", index)
+	return epoch, index, nil
+}
+
+// sendWrites sends the current values of the keys written after the index,
+// the batches but the last one keep the index so that a secondary that
+// stops in between gets them again
+func (p *replicationPrimary) sendWrites(ctx context.Context, stream Replication_StreamWALsServer, epoch string, index, last uint64, keys []string) error {
+	c := p.core
+
+	var withKeyring bool
+	seen := make(map[string]struct{}, len(keys))
+	unique := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+
+		switch {
+		case key == keyringPath:
+			withKeyring = true
+		case c.replicatedKey(key, p.mode.performance):
+			unique = append(unique, key)
+		}
+	}
+
+	batch := &WALBatch{Epoch: epoch, Index: index}
+	if withKeyring {
+		keyring, err := p.keyring()
+		if err != nil {
+			return err
+		}
+		batch.Keyring = keyring
+	}
+
+	var size int
+	for _, key := range unique {
+		entry, err := c.replicationStorage.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			batch.Entries = append(batch.Entries, &WALEntry{
+				Key:     key,
+				Deleted: true,
+			})
+		} else {
+			batch.Entries = append(batch.Entries, &WALEntry{
+				Key:      key,
+				Value:    entry.Value,
+				SealWrap: entry.SealWrap,
+			})
+			size += len(entry.Value)
+		}
+		if len(batch.Entries) < replicationBatchEntries && size < replicationBatchSize {
+			continue
+		}
+		if err := stream.Send(batch); err != nil {
+			return err
+		}
+		batch = &WALBatch{Epoch: epoch, Index: index}
+		size = 0
+	}
+
+	batch.Index = last
+	return stream.Send(batch)
+}
+
+// keyring returns the serialized keyring of the primary without its master
+// key
+func (p *replicationPrimary) keyring() ([]byte, error) {
+	keyring, err := p.core.barrier.Keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	// The clone shares the master key with the barrier, so it is replaced
+	// rather than zeroed
+	return keyring.SetMasterKey(nil).Serialize()
+}
+
+// ForwardRequest serves a request forwarded by a performance secondary
+func (p *replicationPrimary) ForwardRequest(ctx context.Context, freq *forwarding.Request) (*forwarding.Response, error) {
+	if !p.mode.performance {
+		return nil, status.Error(codes.Unimplemented, "requests are only forwarded by performance secondaries")
+	}
+	if _, err := p.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if p.core.clusterHandler == nil {
+		return nil, status.Error(codes.Unavailable, "no handler for forwarded requests")
+	}
+
+	s := &forwardedRequestRPCServer{
+		core:    p.core,
+		handler: p.core.clusterHandler,
+	}
+	return s.ForwardRequest(ctx, freq)
+}
+
+func (p *replicationPrimary) connect(ctx context.Context, id string) *replicationConnection {
+	conn := &replicationConnection{
+		connectedAt:   time.Now(),
+		lastHeartbeat: time.Now(),
+	}
+	if pr, ok := peer.FromContext(ctx); ok && pr.Addr != nil {
+		conn.addr = pr.Addr.String()
+	}
+
+	p.l.Lock()
+	p.connections[id] = conn
+	p.l.Unlock()
+	return conn
+}
+
+func (p *replicationPrimary) disconnect(id string, conn *replicationConnection) {
+	p.l.Lock()
+	if p.connections[id] == conn {
+		delete(p.connections, id)
+	}
+	p.l.Unlock()
+	p.logger.Info("secondary disconnected", "id", id)
+}
+
+func (p *replicationPrimary) update(conn *replicationConnection, index uint64) {
+	p.l.Lock()
+	conn.index = index
+	conn.lastHeartbeat = time.Now()
+	p.l.Unlock()
+}
+
+// connectionStatus returns the secondaries connected to the primary
+func (p *replicationPrimary) connectionStatus() map[string]interface{} {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	ret := make(map[string]interface{}, len(p.connections))
+	for id, conn := range p.connections {
+		ret[id] = map[string]interface{}{
+			"remote_addr":    conn.addr,
+			"connected_at":   conn.connectedAt.Format(time.RFC3339),
+			"last_heartbeat": conn.lastHeartbeat.Format(time.RFC3339),
+			"last_wal":       conn.index,
+		}
+	}
+	return ret
+}
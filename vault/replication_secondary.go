@@ -0,0 +1,598 @@
+package vault
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	"github.com/jiangjiali/vault/sdk/helper/forwarding"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/sdk/logical"
+	"github.com/jiangjiali/vault/sdk/physical"
+	"github.com/jiangjiali/vault/vault/replication"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	// replicationRetryMin and replicationRetryMax bound the time a secondary
+	// waits before connecting again to its primary
+	replicationRetryMin = time.Second
+	replicationRetryMax = 30 * time.Second
+
+	// replicationPersistInterval is how often a secondary stores its
+	// position while it applies writes
+	replicationPersistInterval = time.Second
+
+	// replicationWALWaitTimeout is how long a request forwarded to the
+	// primary waits for its writes to be applied locally
+	replicationWALWaitTimeout = 2 * time.Second
+)
+
+// replicationSecondary streams the writes of the primary and applies them to
+// the storage of the local cluster. It never takes the replication lock, so
+// that it can be stopped while the lock is held.
+type replicationSecondary struct {
+	core   *Core
+	mode   *replicationMode
+	logger log.Logger
+	cancel context.CancelFunc
+	doneCh chan struct{}
+	conn   *grpc.ClientConn
+	client ReplicationClient
+
+	// l guards the fields below
+	l             sync.Mutex
+	cluster       replication.Cluster
+	lastPersist   time.Time
+	state         string
+	lastError     error
+	lastHeartbeat time.Time
+	applied       chan struct{}
+}
+
+// replicationCopy is a copy of the storage of the primary being applied
+type replicationCopy struct {
+	// stale holds the keys to remove once the copy is applied, the keys of
+	// the secondary which the primary does not have
+	stale map[string]struct{}
+
+	// local holds the entries of the secondary that are kept, which are
+	// encrypted again with the keyring of the primary
+	local []*logical.StorageEntry
+}
+
+// startReplicationSecondary connects to the primary of the mode. The
+// replication lock must be held.
+func (c *Core) startReplicationSecondary(mode *replicationMode, cluster *replication.Cluster) error {
+	if _, ok := c.replicationSecondaries[mode.name]; ok {
+		return nil
+	}
+	if cluster.PrimaryClusterAddr == "" {
+		// A demoted primary waits to be pointed to the new primary
+		c.logger.Info("replication secondary has no primary", "mode", mode.name)
+		return nil
+	}
+
+	caCert, err := x509.ParseCertificate(cluster.CACert)
+	if err != nil {
+		return err
+	}
+	clientCert, err := x509.ParseCertificate(cluster.ClientCert)
+	if err != nil {
+		return err
+	}
+	clientKey, err := x509.ParseECPrivateKey(cluster.ClientKey)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{
+			{
+				Certificate: [][]byte{cluster.ClientCert},
+				PrivateKey:  clientKey,
+				Leaf:        clientCert,
+			},
+		},
+		RootCAs:    pool,
+		ServerName: caCert.Subject.CommonName,
+		NextProtos: []string{mode.alpn},
+		MinVersion: tls.VersionTLS12,
+	}
+
+	host := cluster.PrimaryClusterAddr
+	if i := strings.Index(host, "://"); i != -1 {
+		host = host[i+3:]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	conn, err := grpc.DialContext(ctx, host,
+		grpc.WithDialer(func(addr string, timeout time.Duration) (net.Conn, error) {
+			dialer := &net.Dialer{
+				Timeout: timeout,
+			}
+			return tls.DialWithDialer(dialer, "tcp", addr, tlsConfig.Clone())
+		}),
+		grpc.WithInsecure(), // it's not, we handle it in the dialer
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time: 2 * HeartbeatInterval,
+		}),
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallRecvMsgSize(math.MaxInt32),
+			grpc.MaxCallSendMsgSize(math.MaxInt32),
+		))
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	s := &replicationSecondary{
+		core:    c,
+		mode:    mode,
+		logger:  c.logger.Named("replication." + mode.name),
+		cancel:  cancel,
+		doneCh:  make(chan struct{}),
+		conn:    conn,
+		client:  NewReplicationClient(conn),
+		cluster: *cluster,
+		state:   "connecting",
+		applied: make(chan struct{}),
+	}
+	c.replicationSecondaries[mode.name] = s
+	c.updateReplicationState()
+
+	go s.run(ctx)
+	return nil
+}
+
+// stopReplicationSecondary disconnects from the primary of the mode. The
+// replication lock must be held.
+func (c *Core) stopReplicationSecondary(mode *replicationMode) {
+	s, ok := c.replicationSecondaries[mode.name]
+	if !ok {
+		return
+	}
+	delete(c.replicationSecondaries, mode.name)
+	s.stop()
+}
+
+// stop disconnects from the primary and stores the position of the
+// secondary
+func (s *replicationSecondary) stop() {
+	s.cancel()
+	<-s.doneCh
+	s.conn.Close()
+
+	if err := s.persist(context.Background()); err != nil {
+		s.logger.Error("failed to store replication position", "error", err)
+	}
+}
+
+func (s *replicationSecondary) run(ctx context.Context) {
+	defer close(s.doneCh)
+
+	retry := replicationRetryMin
+	for {
+		synced, err := s.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.l.Lock()
+		s.state = "connecting"
+		s.lastError = err
+		s.l.Unlock()
+		s.logger.Warn("replication stream closed", "error", err)
+
+		if synced {
+			retry = replicationRetryMin
+		}
+		select {
+		case <-time.After(retry):
+		case <-ctx.Done():
+			return
+		}
+		if retry *= 2; retry > replicationRetryMax {
+			retry = replicationRetryMax
+		}
+	}
+}
+
+// stream applies the writes of the primary until the stream is closed. It
+// returns whether any batch was applied.
+func (s *replicationSecondary) stream(ctx context.Context) (bool, error) {
+	epoch, index := s.position()
+	if s.bootstrapping() {
+		// A copy was interrupted, start again
+		epoch, index = "", 0
+	}
+
+	stream, err := s.client.StreamWALs(ctx, &StreamWALsRequest{
+		Epoch: epoch,
+		Index: index,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var applied bool
+	var cp *replicationCopy
+	for {
+		batch, err := stream.Recv()
+		if err != nil {
+			return applied, err
+		}
+		s.heartbeat()
+
+		if batch.Reset_ {
+			if cp, err = s.beginCopy(ctx); err != nil {
+				return applied, err
+			}
+		}
+		if cp != nil {
+			if err := s.applyEntries(ctx, batch.Entries, cp); err != nil {
+				return applied, err
+			}
+			if batch.Synced {
+				if err := s.finishCopy(ctx, cp, batch); err != nil {
+					return applied, err
+				}
+				cp = nil
+				applied = true
+			}
+			continue
+		}
+
+		if len(batch.Keyring) != 0 {
+			if err := s.installKeyring(ctx, batch.Keyring); err != nil {
+				return applied, err
+			}
+		}
+		if err := s.applyEntries(ctx, batch.Entries, nil); err != nil {
+			return applied, err
+		}
+		s.invalidate(batch.Entries)
+		s.advance(ctx, batch.Epoch, batch.Index)
+		applied = true
+	}
+}
+
+// beginCopy flags the secondary as bootstrapping and keeps aside the
+// entries it does not receive from the primary
+func (s *replicationSecondary) beginCopy(ctx context.Context) (*replicationCopy, error) {
+	c := s.core
+	s.logger.Info("copying the storage of the primary")
+
+	s.l.Lock()
+	s.state = "bootstrapping"
+	s.cluster.State.AddState(s.mode.bootstrapping)
+	first := s.cluster.Epoch == ""
+	s.l.Unlock()
+	c.setReplicationStateFlag(s.mode.bootstrapping, true)
+	if err := s.persist(ctx); err != nil {
+		return nil, err
+	}
+
+	cp := &replicationCopy{
+		stale: make(map[string]struct{}),
+	}
+	err := walkStorage(ctx, c.replicationStorage, "", func(key string) error {
+		// The storage of the cluster is replaced on the first copy, and only
+		// the replicated keys afterwards
+		var stale bool
+		if first {
+			stale = !isLocalStorageKey(key)
+		} else {
+			stale = c.replicatedKey(key, s.mode.performance)
+		}
+		if stale {
+			cp.stale[key] = struct{}{}
+			return nil
+		}
+
+		switch key {
+		case keyringPath, masterKeyPath:
+			return nil
+		}
+		// The entries written outside of the barrier do not decrypt
+		entry, err := c.barrier.Get(ctx, key)
+		if err != nil || entry == nil {
+			return nil
+		}
+		cp.local = append(cp.local, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// finishCopy removes the stale keys, installs the keyring of the primary
+// and sets up again the state of the cluster from the copied storage
+func (s *replicationSecondary) finishCopy(ctx context.Context, cp *replicationCopy, batch *WALBatch) error {
+	c := s.core
+
+	c.storageLock.RLock()
+	for key := range cp.stale {
+		if err := c.replicationStorage.Delete(ctx, key); err != nil {
+			c.storageLock.RUnlock()
+			return err
+		}
+		c.replicationLog.append(key)
+	}
+	c.storageLock.RUnlock()
+
+	if len(batch.Keyring) == 0 {
+		return errors.New("copy of the storage has no keyring")
+	}
+	if err := s.installKeyring(ctx, batch.Keyring); err != nil {
+		return err
+	}
+	for _, entry := range cp.local {
+		if err := c.barrier.Put(ctx, entry); err != nil {
+			return err
+		}
+	}
+
+	s.l.Lock()
+	s.cluster.Epoch = batch.Epoch
+	s.cluster.Index = batch.Index
+	s.cluster.State.ClearState(s.mode.bootstrapping)
+	s.state = "stream-wals"
+	s.lastError = nil
+	s.l.Unlock()
+	if err := s.persist(ctx); err != nil {
+		return err
+	}
+	c.setReplicationStateFlag(s.mode.bootstrapping, false)
+	s.notifyApplied()
+
+	s.logger.Info("copied the storage of the primary", "index", batch.Index)
+	go c.reloadState()
+	return nil
+}
+
+// applyEntries writes the entries of the primary to the storage beneath the
+// barrier
+func (s *replicationSecondary) applyEntries(ctx context.Context, entries []*WALEntry, cp *replicationCopy) error {
+	c := s.core
+	c.storageLock.RLock()
+	defer c.storageLock.RUnlock()
+
+	for _, entry := range entries {
+		if isLocalStorageKey(entry.Key) {
+			continue
+		}
+		if cp != nil {
+			delete(cp.stale, entry.Key)
+		}
+
+		var err error
+		if entry.Deleted {
+			err = c.replicationStorage.Delete(ctx, entry.Key)
+		} else {
+			err = c.replicationStorage.Put(ctx, &physical.Entry{
+				Key:      entry.Key,
+				Value:    entry.Value,
+				SealWrap: entry.SealWrap,
+			})
+		}
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to apply write of %q: {{err}}", entry.Key), err)
+		}
+		c.replicationLog.append(entry.Key)
+	}
+	return nil
+}
+
+// installKeyring replaces the keys of the barrier with the ones of the
+// primary
+func (s *replicationSecondary) installKeyring(ctx context.Context, buf []byte) error {
+	keyring, err := DeserializeKeyring(buf)
+	if err != nil {
+		return errwrap.Wrapf("failed to decode the keyring of the primary: {{err}}", err)
+	}
+
+	return s.core.barrier.SetKeyring(ctx, keyring)
+}
+
+// invalidate tells the backends of a performance secondary about the
+// writes of the primary. The changes to the mounts set up the state of the
+// cluster again.
+func (s *replicationSecondary) invalidate(entries []*WALEntry) {
+	if !s.mode.performance || len(entries) == 0 {
+		return
+	}
+	c := s.core
+
+	for _, entry := range entries {
+		switch {
+		case entry.Key == coreMountConfigPath,
+			entry.Key == coreAuthConfigPath,
+			entry.Key == coreAuditConfigPath,
+			strings.HasPrefix(entry.Key, pluginCatalogPath):
+			go c.reloadState()
+			return
+		}
+	}
+
+	ctx := c.activeContext
+	if ctx == nil {
+		return
+	}
+	for _, entry := range entries {
+		_, mountPath, prefix, ok := c.router.MatchingAPIPrefixByStoragePath(ctx, entry.Key)
+		if !ok {
+			continue
+		}
+		backend := c.router.MatchingBackend(ctx, mountPath)
+		if backend == nil {
+			continue
+		}
+		backend.InvalidateKey(ctx, strings.TrimPrefix(entry.Key, prefix))
+	}
+}
+
+// advance moves the position of the secondary and stores it from time to
+// time, replaying the writes after the stored position is safe
+func (s *replicationSecondary) advance(ctx context.Context, epoch string, index uint64) {
+	s.l.Lock()
+	moved := s.cluster.Epoch != epoch || s.cluster.Index != index
+	s.cluster.Epoch = epoch
+	s.cluster.Index = index
+	s.state = "stream-wals"
+	s.lastError = nil
+	persist := moved && time.Since(s.lastPersist) > replicationPersistInterval
+	s.l.Unlock()
+
+	if moved {
+		s.notifyApplied()
+	}
+	if persist {
+		if err := s.persist(ctx); err != nil {
+			s.logger.Error("failed to store replication position", "error", err)
+		}
+	}
+}
+
+func (s *replicationSecondary) notifyApplied() {
+	s.l.Lock()
+	close(s.applied)
+	s.applied = make(chan struct{})
+	s.l.Unlock()
+}
+
+func (s *replicationSecondary) heartbeat() {
+	s.l.Lock()
+	s.lastHeartbeat = time.Now()
+	s.l.Unlock()
+}
+
+// persist stores the replication state of the secondary
+func (s *replicationSecondary) persist(ctx context.Context) error {
+	s.l.Lock()
+	cluster := s.cluster
+	s.lastPersist = time.Now()
+	s.l.Unlock()
+
+	return s.core.persistReplicationCluster(ctx, s.mode, &cluster)
+}
+
+// bootstrapping returns whether the secondary is copying the storage of
+// its primary
+func (s *replicationSecondary) bootstrapping() bool {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.cluster.State.HasState(s.mode.bootstrapping)
+}
+
+// position returns the position in the log of the primary up to which the
+// writes are applied
+func (s *replicationSecondary) position() (string, uint64) {
+	s.l.Lock()
+	defer s.l.Unlock()
+	return s.cluster.Epoch, s.cluster.Index
+}
+
+// waitForIndex waits for the writes of the primary up to the index to be
+// applied, it returns false when they are not in time
+func (s *replicationSecondary) waitForIndex(ctx context.Context, index uint64) bool {
+	timer := time.NewTimer(replicationWALWaitTimeout)
+	defer timer.Stop()
+
+	for {
+		s.l.Lock()
+		done := s.cluster.Index >= index
+		applied := s.applied
+		s.l.Unlock()
+		if done {
+			return true
+		}
+
+		select {
+		case <-applied:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// status returns the state of the connection to the primary
+func (s *replicationSecondary) status() map[string]interface{} {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	ret := map[string]interface{}{
+		"state":           s.state,
+		"epoch":           s.cluster.Epoch,
+		"last_remote_wal": s.cluster.Index,
+	}
+	if !s.lastHeartbeat.IsZero() {
+		ret["last_heartbeat"] = s.lastHeartbeat.Format(time.RFC3339)
+	}
+	if s.lastError != nil {
+		ret["connection_error"] = s.lastError.Error()
+	}
+	return ret
+}
+
+// forwardToPrimary forwards a request of a performance secondary to its
+// primary, and waits for the writes of the request to be applied locally
+func (c *Core) forwardToPrimary(req *http.Request) (int, http.Header, []byte, error) {
+	c.replicationLock.RLock()
+	s := c.replicationSecondaries[performanceReplicationMode.name]
+	c.replicationLock.RUnlock()
+	if s == nil {
+		return 0, nil, nil, ErrCannotForward
+	}
+
+	origPath := req.URL.Path
+	defer func() {
+		req.URL.Path = origPath
+	}()
+
+	req.URL.Path = req.Context().Value("original_request_path").(string)
+
+	freq, err := forwarding.GenerateForwardedRequest(req)
+	if err != nil {
+		c.logger.Error("error creating forwarding RPC request", "error", err)
+		return 0, nil, nil, fmt.Errorf("error creating forwarding RPC request")
+	}
+	if freq == nil {
+		c.logger.Error("got nil forwarding RPC request")
+		return 0, nil, nil, fmt.Errorf("got nil forwarding RPC request")
+	}
+	resp, err := s.client.ForwardRequest(req.Context(), freq)
+	if err != nil {
+		c.logger.Error("error during request forwarded to the primary", "error", err)
+		return 0, nil, nil, fmt.Errorf("error during forwarding RPC request")
+	}
+
+	var header http.Header
+	if resp.HeaderEntries != nil {
+		header = make(http.Header)
+		for k, v := range resp.HeaderEntries {
+			header[k] = v.Values
+		}
+	}
+
+	if resp.LastRemoteWal > 0 {
+		WaitUntilWALShipped(req.Context(), c, resp.LastRemoteWal)
+	}
+
+	return int(resp.StatusCode), header, resp.Body, nil
+}
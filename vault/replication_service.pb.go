@@ -0,0 +1,327 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: vault/replication_service.proto
+
+package vault
+
+import proto "github.com/golang/protobuf/proto"
+import fmt "fmt"
+import math "math"
+import forwarding "github.com/jiangjiali/vault/sdk/helper/forwarding"
+
+import (
+	context "golang.org/x/net/context"
+	grpc "google.golang.org/grpc"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
+
+type StreamWALsRequest struct {
+	// Epoch and Index are the position in the log of the primary up to which
+	// the secondary has applied the writes. A secondary without a position,
+	// or with one the primary no longer holds, is sent a copy of the storage.
+	Epoch string `protobuf:"bytes,1,opt,name=epoch" json:"epoch,omitempty"`
+	Index uint64 `protobuf:"varint,2,opt,name=index" json:"index,omitempty"`
+}
+
+func (m *StreamWALsRequest) Reset()                    { *m = StreamWALsRequest{} }
+func (m *StreamWALsRequest) String() string            { return proto.CompactTextString(m) }
+func (*StreamWALsRequest) ProtoMessage()               {}
+func (*StreamWALsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+func (m *StreamWALsRequest) GetEpoch() string {
+	if m != nil {
+		return m.Epoch
+	}
+	return ""
+}
+
+func (m *StreamWALsRequest) GetIndex() uint64 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+type WALEntry struct {
+	Key     string `protobuf:"bytes,1,opt,name=key" json:"key,omitempty"`
+	Value   []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Deleted bool   `protobuf:"varint,3,opt,name=deleted" json:"deleted,omitempty"`
+	// SealWrap is set when the value is wrapped by the seal, which the
+	// secondary wraps it with its own seal
+	SealWrap bool `protobuf:"varint,4,opt,name=seal_wrap,json=sealWrap" json:"seal_wrap,omitempty"`
+}
+
+func (m *WALEntry) Reset()                    { *m = WALEntry{} }
+func (m *WALEntry) String() string            { return proto.CompactTextString(m) }
+func (*WALEntry) ProtoMessage()               {}
+func (*WALEntry) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
+func (m *WALEntry) GetKey() string {
+	if m != nil {
+		return m.Key
+	}
+	return ""
+}
+
+func (m *WALEntry) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *WALEntry) GetDeleted() bool {
+	if m != nil {
+		return m.Deleted
+	}
+	return false
+}
+
+func (m *WALEntry) GetSealWrap() bool {
+	if m != nil {
+		return m.SealWrap
+	}
+	return false
+}
+
+type WALBatch struct {
+	Epoch string `protobuf:"bytes,1,opt,name=epoch" json:"epoch,omitempty"`
+	// Index is the position in the log of the primary once the batch is
+	// applied
+	Index   uint64      `protobuf:"varint,2,opt,name=index" json:"index,omitempty"`
+	Entries []*WALEntry `protobuf:"bytes,3,rep,name=entries" json:"entries,omitempty"`
+	// Reset is set on the first batch of a copy of the storage, and Synced
+	// on its last one. The keys the copy did not include are removed from
+	// the secondary when it is synced.
+	Reset_ bool `protobuf:"varint,4,opt,name=reset" json:"reset,omitempty"`
+	Synced bool `protobuf:"varint,5,opt,name=synced" json:"synced,omitempty"`
+	// Keyring is the keyring of the primary, sent with the end of a copy and
+	// whenever it is written. The secondary persists it encrypted with its own
+	// master key, so that it is still unsealed with its own keys.
+	Keyring []byte `protobuf:"bytes,6,opt,name=keyring,proto3" json:"keyring,omitempty"`
+}
+
+func (m *WALBatch) Reset()                    { *m = WALBatch{} }
+func (m *WALBatch) String() string            { return proto.CompactTextString(m) }
+func (*WALBatch) ProtoMessage()               {}
+func (*WALBatch) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *WALBatch) GetEpoch() string {
+	if m != nil {
+		return m.Epoch
+	}
+	return ""
+}
+
+func (m *WALBatch) GetIndex() uint64 {
+	if m != nil {
+		return m.Index
+	}
+	return 0
+}
+
+func (m *WALBatch) GetEntries() []*WALEntry {
+	if m != nil {
+		return m.Entries
+	}
+	return nil
+}
+
+func (m *WALBatch) GetReset_() bool {
+	if m != nil {
+		return m.Reset_
+	}
+	return false
+}
+
+func (m *WALBatch) GetSynced() bool {
+	if m != nil {
+		return m.Synced
+	}
+	return false
+}
+
+func (m *WALBatch) GetKeyring() []byte {
+	if m != nil {
+		return m.Keyring
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*StreamWALsRequest)(nil), "vault.StreamWALsRequest")
+	proto.RegisterType((*WALEntry)(nil), "vault.WALEntry")
+	proto.RegisterType((*WALBatch)(nil), "vault.WALBatch")
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for Replication service
+
+type ReplicationClient interface {
+	StreamWALs(ctx context.Context, in *StreamWALsRequest, opts ...grpc.CallOption) (Replication_StreamWALsClient, error)
+	ForwardRequest(ctx context.Context, in *forwarding.Request, opts ...grpc.CallOption) (*forwarding.Response, error)
+}
+
+type replicationClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewReplicationClient(cc *grpc.ClientConn) ReplicationClient {
+	return &replicationClient{cc}
+}
+
+func (c *replicationClient) StreamWALs(ctx context.Context, in *StreamWALsRequest, opts ...grpc.CallOption) (Replication_StreamWALsClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Replication_serviceDesc.Streams[0], c.cc, "/vault.Replication/StreamWALs", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &replicationStreamWALsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Replication_StreamWALsClient interface {
+	Recv() (*WALBatch, error)
+	grpc.ClientStream
+}
+
+type replicationStreamWALsClient struct {
+	grpc.ClientStream
+}
+
+func (x *replicationStreamWALsClient) Recv() (*WALBatch, error) {
+	m := new(WALBatch)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *replicationClient) ForwardRequest(ctx context.Context, in *forwarding.Request, opts ...grpc.CallOption) (*forwarding.Response, error) {
+	out := new(forwarding.Response)
+	err := grpc.Invoke(ctx, "/vault.Replication/ForwardRequest", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for Replication service
+
+type ReplicationServer interface {
+	StreamWALs(*StreamWALsRequest, Replication_StreamWALsServer) error
+	ForwardRequest(context.Context, *forwarding.Request) (*forwarding.Response, error)
+}
+
+func RegisterReplicationServer(s *grpc.Server, srv ReplicationServer) {
+	s.RegisterService(&_Replication_serviceDesc, srv)
+}
+
+func _Replication_StreamWALs_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamWALsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ReplicationServer).StreamWALs(m, &replicationStreamWALsServer{stream})
+}
+
+type Replication_StreamWALsServer interface {
+	Send(*WALBatch) error
+	grpc.ServerStream
+}
+
+type replicationStreamWALsServer struct {
+	grpc.ServerStream
+}
+
+func (x *replicationStreamWALsServer) Send(m *WALBatch) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Replication_ForwardRequest_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(forwarding.Request)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ReplicationServer).ForwardRequest(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/vault.Replication/ForwardRequest",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ReplicationServer).ForwardRequest(ctx, req.(*forwarding.Request))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _Replication_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "vault.Replication",
+	HandlerType: (*ReplicationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ForwardRequest",
+			Handler:    _Replication_ForwardRequest_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamWALs",
+			Handler:       _Replication_StreamWALs_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "vault/replication_service.proto",
+}
+
+func init() { proto.RegisterFile("vault/replication_service.proto", fileDescriptor0) }
+
+var fileDescriptor0 = []byte{
+	// 372 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x52, 0xcb, 0x6e, 0xdb, 0x30,
+	0x10, 0xb4, 0x2a, 0x3f, 0xe9, 0xa2, 0x0f, 0xd6, 0x28, 0x08, 0x17, 0x45, 0x55, 0xf5, 0xa2, 0x5e,
+	0xa4, 0xc2, 0x3d, 0x16, 0x45, 0x60, 0x03, 0xc9, 0xc9, 0x27, 0xe5, 0x60, 0x20, 0x17, 0x83, 0x96,
+	0x36, 0x32, 0x6d, 0x99, 0x62, 0x48, 0xca, 0x8e, 0x3e, 0x21, 0x9f, 0x92, 0xbf, 0x0c, 0x44, 0x4a,
+	0x70, 0x82, 0x5c, 0x72, 0x11, 0x34, 0x43, 0x0e, 0x77, 0x76, 0x67, 0xd1, 0x8f, 0x23, 0x2d, 0x73,
+	0x1d, 0x49, 0x10, 0x39, 0x4b, 0xa8, 0x66, 0x05, 0x5f, 0x2b, 0x90, 0x47, 0x96, 0x40, 0x28, 0x64,
+	0xa1, 0x0b, 0xdc, 0x33, 0x17, 0xa6, 0xdf, 0xb7, 0x90, 0x0b, 0x90, 0xd1, 0x6d, 0x21, 0x4f, 0x54,
+	0xa6, 0x8c, 0x67, 0x91, 0xae, 0x04, 0x28, 0x7b, 0xcb, 0xbf, 0x40, 0x9f, 0xaf, 0xb5, 0x04, 0x7a,
+	0x58, 0xcd, 0x97, 0x2a, 0x86, 0xbb, 0x12, 0x94, 0xc6, 0x13, 0xd4, 0x03, 0x51, 0x24, 0x5b, 0xe2,
+	0x78, 0x4e, 0x30, 0x8a, 0x2d, 0xa8, 0x59, 0xc6, 0x53, 0xb8, 0x27, 0xef, 0x3c, 0x27, 0xe8, 0xc6,
+	0x16, 0xf8, 0x0c, 0x0d, 0x57, 0xf3, 0xe5, 0x25, 0xd7, 0xb2, 0xc2, 0x9f, 0x90, 0xbb, 0x87, 0xaa,
+	0x51, 0xd5, 0xbf, 0xb5, 0xe6, 0x48, 0xf3, 0x12, 0x8c, 0xe6, 0x7d, 0x6c, 0x01, 0x26, 0x68, 0x90,
+	0x42, 0x0e, 0x1a, 0x52, 0xe2, 0x7a, 0x4e, 0x30, 0x8c, 0x5b, 0x88, 0xbf, 0xa1, 0x91, 0x02, 0x9a,
+	0xaf, 0x4f, 0x92, 0x0a, 0xd2, 0x35, 0x67, 0xc3, 0x9a, 0x58, 0x49, 0x2a, 0xfc, 0x47, 0xc7, 0xd4,
+	0x5a, 0x50, 0x6d, 0xdd, 0xbc, 0xd5, 0x23, 0xfe, 0x8d, 0x06, 0xc0, 0xb5, 0x64, 0xa0, 0x88, 0xeb,
+	0xb9, 0xc1, 0x78, 0xf6, 0x31, 0x34, 0xc3, 0x09, 0x5b, 0xe7, 0x71, 0x7b, 0x5e, 0x3f, 0x20, 0x41,
+	0x81, 0x6e, 0x8a, 0x5b, 0x80, 0xbf, 0xa2, 0xbe, 0xaa, 0x78, 0x02, 0x29, 0xe9, 0x19, 0xba, 0x41,
+	0x75, 0x23, 0x7b, 0xa8, 0x24, 0xe3, 0x19, 0xe9, 0x9b, 0x06, 0x5b, 0x38, 0x7b, 0x70, 0xd0, 0x38,
+	0x3e, 0x67, 0x83, 0xff, 0x21, 0x74, 0x9e, 0x33, 0x26, 0x4d, 0xfd, 0x57, 0xa3, 0x9f, 0x3e, 0x73,
+	0x66, 0xfa, 0xf4, 0x3b, 0x7f, 0x1c, 0xfc, 0x1f, 0x7d, 0xb8, 0xb2, 0xf1, 0xb5, 0x09, 0x7d, 0x09,
+	0xcf, 0x79, 0x86, 0xad, 0x76, 0xf2, 0x92, 0x54, 0xa2, 0xe0, 0x0a, 0xfc, 0xce, 0xe2, 0xd7, 0xcd,
+	0xcf, 0x8c, 0xe9, 0x6d, 0xb9, 0x09, 0x93, 0xe2, 0x10, 0xed, 0x18, 0xe5, 0xd9, 0x8e, 0xd1, 0x9c,
+	0x45, 0x76, 0x85, 0xcc, 0x77, 0xd3, 0x37, 0xfb, 0xf0, 0xf7, 0x69, 0x00, 0x0f, 0x60, 0x90, 0x8f,
+	0x58, 0x02, 0x00, 0x00,
+}
@@ -15,6 +15,7 @@ import (
 	"time"
 
 	"github.com/jiangjiali/vault/sdk/helper/cache"
+	"github.com/jiangjiali/vault/sdk/helper/consts"
 	"github.com/jiangjiali/vault/sdk/helper/forwarding"
 	"github.com/jiangjiali/vault/vault/replication"
 
@@ -329,6 +330,13 @@ func (c *Core) clearForwardingClients() {
 // ForwardRequest forwards a given request to the active node and returns the
 // response.
 func (c *Core) ForwardRequest(req *http.Request) (int, http.Header, []byte, error) {
+	// The active node of a performance secondary forwards to its primary
+	if c.ReplicationState().HasState(consts.ReplicationPerformanceSecondary) {
+		if standby, _ := c.Standby(); !standby {
+			return c.forwardToPrimary(req)
+		}
+	}
+
 	c.requestForwardingConnectionLock.RLock()
 	defer c.requestForwardingConnectionLock.RUnlock()
 
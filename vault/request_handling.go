@@ -410,6 +410,9 @@ func (c *Core) switchedLockHandleRequest(httpCtx context.Context, req *logical.R
 		return nil, errwrap.Wrapf("could not parse namespace from http context: {{err}}", err)
 	}
 	ctx = namespace.ContextWithNamespace(ctx, ns)
+	if c.drainContext != nil {
+		ctx = logical.ContextWithDrainCh(ctx, c.drainContext.Done())
+	}
 
 	resp, err = c.handleCancelableRequest(ctx, ns, req)
 
@@ -427,7 +430,8 @@ func (c *Core) handleCancelableRequest(ctx context.Context, ns *namespace.Namesp
 	// backends. Basically, it's all just terrible, so don't allow it.
 	if strings.HasSuffix(req.Path, "/") &&
 		(req.Operation == logical.UpdateOperation ||
-			req.Operation == logical.CreateOperation) {
+			req.Operation == logical.CreateOperation ||
+			req.Operation == logical.PatchOperation) {
 		return logical.ErrorResponse("cannot write to a path ending in '/'"), nil
 	}
 
@@ -4,12 +4,16 @@ package vault
 
 import (
 	"context"
+	"strings"
 
+	"github.com/jiangjiali/vault/sdk/helper/consts"
 	"github.com/jiangjiali/vault/sdk/helper/identity"
 	"github.com/jiangjiali/vault/sdk/logical"
 )
 
-func waitForReplicationState(context.Context, *Core, *logical.Request) error { return nil }
+func waitForReplicationState(ctx context.Context, c *Core, req *logical.Request) error {
+	return c.checkReplicationState(ctx, req)
+}
 
 func checkNeedsCG(context.Context, *Core, *logical.Request, *logical.Auth, error, []string) (error, *logical.Response, *logical.Auth, error) {
 	return nil, nil, nil, nil
@@ -19,15 +23,25 @@ func checkErrControlGroupTokenNeedsCreated(err error) bool {
 	return false
 }
 
+// shouldForward returns whether a request of a performance secondary
+// wrote to the replicated storage, which only its primary writes to
 func shouldForward(c *Core, resp *logical.Response, err error) bool {
-	return false
+	if !c.ReplicationState().HasState(consts.ReplicationPerformanceSecondary) {
+		return false
+	}
+	if err != nil && strings.Contains(err.Error(), logical.ErrReadOnly.Error()) {
+		return true
+	}
+	return resp != nil && resp.IsError() && strings.Contains(resp.Error().Error(), logical.ErrReadOnly.Error())
 }
 
 func syncCounter(c *Core) {
 }
 
+// forward hands the request back to the HTTP layer, which forwards it to
+// the primary
 func forward(ctx context.Context, c *Core, req *logical.Request) (*logical.Response, error) {
-	panic("forward called in OSS Vault")
+	return nil, logical.ErrPerfStandbyPleaseForward
 }
 
 func getLeaseRegisterFunc(c *Core) (func(context.Context, *logical.Request, *logical.Response) (string, error), error) {
@@ -129,6 +129,7 @@ func (r *Router) Mount(backend logical.Backend, prefix string, mountEntry *Mount
 	}
 	re.rootPaths.Store(pathsToRadix(paths.Root))
 	re.loginPaths.Store(pathsToRadix(paths.Unauthenticated))
+	storageView.setSealWrapPaths(mountEntry.SealWrap, paths.SealWrapStorage)
 
 	switch {
 	case prefix == "":
@@ -0,0 +1,593 @@
+// +build cgo
+
+package pkcs11
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/armon/metrics"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	p11 "github.com/jiangjiali/vault/sdk/helper/pkcs11"
+	"github.com/jiangjiali/vault/sdk/helper/xxuuid"
+	"github.com/jiangjiali/vault/sdk/physical"
+	"github.com/jiangjiali/vault/vault/seal"
+)
+
+const (
+	// MechanismAESGCM wraps the data keys with an AES key of the HSM
+	MechanismAESGCM = "aes-gcm"
+
+	// MechanismRSAOAEP wraps the data keys with an RSA key pair of the HSM
+	MechanismRSAOAEP = "rsa-oaep"
+
+	gcmIVSize  = 12
+	gcmTagBits = 128
+
+	generatedAESKeySize = 32
+	generatedRSAKeyBits = 2048
+)
+
+// Seal is a seal that wraps the data encryption keys with a key stored in
+// an HSM reached through its PKCS#11 library.
+type Seal struct {
+	logger log.Logger
+
+	lib        string
+	slot       uint
+	pin        string
+	keyLabel   string
+	mechanism  uint
+	generate   bool
+	tokenLabel string
+
+	// l guards the session, PKCS#11 sessions must not be used concurrently
+	l       sync.Mutex
+	ctx     *p11.Ctx
+	session p11.SessionHandle
+	// keys caches the handles of the keys by label and object class, the
+	// handles are only valid for the session
+	keys map[string]p11.ObjectHandle
+
+	currentKeyID *atomic.Value
+}
+
+var _ seal.Access = (*Seal)(nil)
+
+// NewSeal creates a new PKCS#11 seal
+func NewSeal(logger log.Logger) *Seal {
+	s := &Seal{
+		logger:       logger.ResetNamed("seal-pkcs11"),
+		keys:         make(map[string]p11.ObjectHandle),
+		currentKeyID: new(atomic.Value),
+	}
+	s.currentKeyID.Store("")
+	return s
+}
+
+// configValue returns the value of the environment variable if it is set,
+// and the value of the config otherwise.
+func configValue(config map[string]string, key, env string) string {
+	if v := os.Getenv(env); v != "" {
+		return v
+	}
+	return config[key]
+}
+
+// SetConfig processes the config info from the server config
+func (s *Seal) SetConfig(config map[string]string) (map[string]string, error) {
+	if config == nil {
+		config = map[string]string{}
+	}
+
+	s.lib = configValue(config, "lib", "VAULT_HSM_LIB")
+	if s.lib == "" {
+		return nil, fmt.Errorf("lib is required")
+	}
+
+	s.pin = configValue(config, "pin", "VAULT_HSM_PIN")
+	if s.pin == "" {
+		return nil, fmt.Errorf("pin is required")
+	}
+
+	s.keyLabel = configValue(config, "key_label", "VAULT_HSM_KEY_LABEL")
+	if s.keyLabel == "" {
+		return nil, fmt.Errorf("key_label is required")
+	}
+
+	slotRaw := configValue(config, "slot", "VAULT_HSM_SLOT")
+	s.tokenLabel = configValue(config, "token_label", "VAULT_HSM_TOKEN_LABEL")
+	switch {
+	case slotRaw != "" && s.tokenLabel != "":
+		return nil, fmt.Errorf("only one of slot and token_label can be set")
+	case slotRaw != "":
+		slot, err := strconv.ParseUint(slotRaw, 0, 32)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed parsing slot: {{err}}", err)
+		}
+		s.slot = uint(slot)
+	case s.tokenLabel == "":
+		return nil, fmt.Errorf("slot or token_label is required")
+	}
+
+	mechanism, err := parseMechanism(configValue(config, "mechanism", "VAULT_HSM_MECHANISM"))
+	if err != nil {
+		return nil, err
+	}
+	s.mechanism = mechanism
+
+	if raw := configValue(config, "generate_key", "VAULT_HSM_GENERATE_KEY"); raw != "" {
+		if s.generate, err = strconv.ParseBool(raw); err != nil {
+			return nil, errwrap.Wrapf("failed parsing generate_key: {{err}}", err)
+		}
+	}
+
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	// Look the current key up now so that a misconfiguration fails the
+	// startup rather than the unseal
+	if err := s.setupKey(); err != nil {
+		s.close()
+		return nil, err
+	}
+	s.currentKeyID.Store(s.keyLabel)
+
+	sealInfo := map[string]string{
+		"lib":       s.lib,
+		"slot":      strconv.FormatUint(uint64(s.slot), 10),
+		"key_label": s.keyLabel,
+		"mechanism": mechanismName(s.mechanism),
+	}
+	if s.tokenLabel != "" {
+		sealInfo["token_label"] = s.tokenLabel
+	}
+	return sealInfo, nil
+}
+
+func parseMechanism(raw string) (uint, error) {
+	switch strings.ToLower(raw) {
+	case "", MechanismAESGCM:
+		return p11.CKM_AES_GCM, nil
+	case MechanismRSAOAEP:
+		return p11.CKM_RSA_PKCS_OAEP, nil
+	}
+
+	// Accept the numeric values of the mechanisms as well
+	mech, err := strconv.ParseUint(raw, 0, 32)
+	if err == nil && (uint(mech) == p11.CKM_AES_GCM || uint(mech) == p11.CKM_RSA_PKCS_OAEP) {
+		return uint(mech), nil
+	}
+	return 0, fmt.Errorf("unsupported mechanism %q, must be %q or %q", raw, MechanismAESGCM, MechanismRSAOAEP)
+}
+
+func mechanismName(mech uint) string {
+	switch mech {
+	case p11.CKM_AES_GCM:
+		return MechanismAESGCM
+	case p11.CKM_RSA_PKCS_OAEP:
+		return MechanismRSAOAEP
+	}
+	return fmt.Sprintf("0x%x", mech)
+}
+
+// open loads the library and opens a logged in session on the slot.
+func (s *Seal) open() error {
+	ctx := p11.New(s.lib)
+	if ctx == nil {
+		return fmt.Errorf("failed to load PKCS#11 library %q", s.lib)
+	}
+	if err := ctx.Initialize(); err != nil && !isError(err, p11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return errwrap.Wrapf("failed to initialize PKCS#11 library: {{err}}", err)
+	}
+	s.ctx = ctx
+
+	if s.tokenLabel != "" {
+		slot, err := s.findSlot()
+		if err != nil {
+			s.close()
+			return err
+		}
+		s.slot = slot
+	}
+
+	return s.openSession()
+}
+
+func (s *Seal) findSlot() (uint, error) {
+	slots, err := s.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, errwrap.Wrapf("failed to list slots: {{err}}", err)
+	}
+	for _, slot := range slots {
+		info, err := s.ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(info.Label) == s.tokenLabel {
+			return slot, nil
+		}
+	}
+	return 0, fmt.Errorf("no token labeled %q found", s.tokenLabel)
+}
+
+func (s *Seal) openSession() error {
+	session, err := s.ctx.OpenSession(s.slot, p11.CKF_SERIAL_SESSION|p11.CKF_RW_SESSION)
+	if err != nil {
+		return errwrap.Wrapf(fmt.Sprintf("failed to open session on slot %d: {{err}}", s.slot), err)
+	}
+	if err := s.ctx.Login(session, p11.CKU_USER, s.pin); err != nil && !isError(err, p11.CKR_USER_ALREADY_LOGGED_IN) {
+		s.ctx.CloseSession(session)
+		return errwrap.Wrapf("failed to log in: {{err}}", err)
+	}
+
+	s.session = session
+	s.keys = make(map[string]p11.ObjectHandle)
+	return nil
+}
+
+func (s *Seal) close() {
+	if s.ctx == nil {
+		return
+	}
+	if s.session != 0 {
+		s.ctx.Logout(s.session)
+		s.ctx.CloseSession(s.session)
+		s.session = 0
+	}
+	s.ctx.Finalize()
+	s.ctx.Destroy()
+	s.ctx = nil
+}
+
+func isError(err error, code uint) bool {
+	p11Err, ok := err.(p11.Error)
+	return ok && uint(p11Err) == code
+}
+
+// isSessionError returns whether the session needs to be opened again, for
+// instance after the HSM was restarted.
+func isSessionError(err error) bool {
+	return isError(err, p11.CKR_SESSION_HANDLE_INVALID) ||
+		isError(err, p11.CKR_SESSION_CLOSED) ||
+		isError(err, p11.CKR_DEVICE_REMOVED) ||
+		isError(err, p11.CKR_TOKEN_NOT_PRESENT) ||
+		isError(err, p11.CKR_USER_NOT_LOGGED_IN)
+}
+
+// withSession runs f with the session lock held, opening the session again
+// once if it is no longer valid.
+func (s *Seal) withSession(f func() error) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	if s.ctx == nil {
+		return errors.New("seal is finalized")
+	}
+
+	err := f()
+	if err == nil || !isSessionError(err) {
+		return err
+	}
+
+	s.logger.Warn("PKCS#11 session lost, opening a new one", "error", err)
+	s.ctx.CloseSession(s.session)
+	if err := s.openSession(); err != nil {
+		return err
+	}
+	return f()
+}
+
+// setupKey finds the configured key, generating it when allowed.
+func (s *Seal) setupKey() error {
+	return s.withSession(func() error {
+		_, err := s.findKey(s.keyLabel, s.encryptClass(s.mechanism))
+		if err == nil || !s.generate || !errwrap.ContainsType(err, new(keyNotFoundError)) {
+			return err
+		}
+
+		s.logger.Info("generating key", "key_label", s.keyLabel, "mechanism", mechanismName(s.mechanism))
+		return s.generateKey()
+	})
+}
+
+// keyNotFoundError is returned when no key has the label.
+type keyNotFoundError struct {
+	label string
+}
+
+func (e *keyNotFoundError) Error() string {
+	return fmt.Sprintf("no key labeled %q found", e.label)
+}
+
+func (s *Seal) encryptClass(mech uint) uint {
+	if mech == p11.CKM_RSA_PKCS_OAEP {
+		return p11.CKO_PUBLIC_KEY
+	}
+	return p11.CKO_SECRET_KEY
+}
+
+func (s *Seal) decryptClass(mech uint) uint {
+	if mech == p11.CKM_RSA_PKCS_OAEP {
+		return p11.CKO_PRIVATE_KEY
+	}
+	return p11.CKO_SECRET_KEY
+}
+
+// findKey returns the handle of the key of the class with the label. It must
+// be called with the session lock held.
+func (s *Seal) findKey(label string, class uint) (p11.ObjectHandle, error) {
+	cacheKey := fmt.Sprintf("%d/%s", class, label)
+	if handle, ok := s.keys[cacheKey]; ok {
+		return handle, nil
+	}
+
+	template := []*p11.Attribute{
+		p11.NewAttribute(p11.CKA_CLASS, class),
+		p11.NewAttribute(p11.CKA_LABEL, label),
+	}
+	if err := s.ctx.FindObjectsInit(s.session, template); err != nil {
+		return 0, err
+	}
+	handles, _, err := s.ctx.FindObjects(s.session, 2)
+	if finalErr := s.ctx.FindObjectsFinal(s.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(handles) {
+	case 0:
+		return 0, &keyNotFoundError{label: label}
+	case 1:
+	default:
+		return 0, fmt.Errorf("several keys labeled %q found", label)
+	}
+
+	s.keys[cacheKey] = handles[0]
+	return handles[0], nil
+}
+
+// generateKey creates the configured key in the token. The key can not be
+// extracted from the HSM. It must be called with the session lock held.
+func (s *Seal) generateKey() error {
+	var err error
+	switch s.mechanism {
+	case p11.CKM_AES_GCM:
+		_, err = s.ctx.GenerateKey(s.session,
+			[]*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_KEY_GEN, nil)},
+			[]*p11.Attribute{
+				p11.NewAttribute(p11.CKA_CLASS, p11.CKO_SECRET_KEY),
+				p11.NewAttribute(p11.CKA_KEY_TYPE, p11.CKK_AES),
+				p11.NewAttribute(p11.CKA_LABEL, s.keyLabel),
+				p11.NewAttribute(p11.CKA_VALUE_LEN, generatedAESKeySize),
+				p11.NewAttribute(p11.CKA_TOKEN, true),
+				p11.NewAttribute(p11.CKA_PRIVATE, true),
+				p11.NewAttribute(p11.CKA_SENSITIVE, true),
+				p11.NewAttribute(p11.CKA_EXTRACTABLE, false),
+				p11.NewAttribute(p11.CKA_ENCRYPT, true),
+				p11.NewAttribute(p11.CKA_DECRYPT, true),
+			})
+
+	case p11.CKM_RSA_PKCS_OAEP:
+		_, _, err = s.ctx.GenerateKeyPair(s.session,
+			[]*p11.Mechanism{p11.NewMechanism(p11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)},
+			[]*p11.Attribute{
+				p11.NewAttribute(p11.CKA_CLASS, p11.CKO_PUBLIC_KEY),
+				p11.NewAttribute(p11.CKA_KEY_TYPE, p11.CKK_RSA),
+				p11.NewAttribute(p11.CKA_LABEL, s.keyLabel),
+				p11.NewAttribute(p11.CKA_MODULUS_BITS, generatedRSAKeyBits),
+				p11.NewAttribute(p11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+				p11.NewAttribute(p11.CKA_TOKEN, true),
+				p11.NewAttribute(p11.CKA_ENCRYPT, true),
+			},
+			[]*p11.Attribute{
+				p11.NewAttribute(p11.CKA_CLASS, p11.CKO_PRIVATE_KEY),
+				p11.NewAttribute(p11.CKA_KEY_TYPE, p11.CKK_RSA),
+				p11.NewAttribute(p11.CKA_LABEL, s.keyLabel),
+				p11.NewAttribute(p11.CKA_TOKEN, true),
+				p11.NewAttribute(p11.CKA_PRIVATE, true),
+				p11.NewAttribute(p11.CKA_SENSITIVE, true),
+				p11.NewAttribute(p11.CKA_EXTRACTABLE, false),
+				p11.NewAttribute(p11.CKA_DECRYPT, true),
+			})
+	}
+	if err != nil {
+		return errwrap.Wrapf("failed to generate key: {{err}}", err)
+	}
+	return nil
+}
+
+// Init is called during core.Initialize
+func (s *Seal) Init(_ context.Context) error {
+	return nil
+}
+
+// Finalize is called during shutdown
+func (s *Seal) Finalize(_ context.Context) error {
+	s.l.Lock()
+	defer s.l.Unlock()
+
+	s.close()
+	return nil
+}
+
+// SealType returns the seal type for this particular seal implementation.
+func (s *Seal) SealType() string {
+	return seal.PKCS11
+}
+
+// KeyID returns the label of the key used to encrypt.
+func (s *Seal) KeyID() string {
+	return s.currentKeyID.Load().(string)
+}
+
+// Encrypt encrypts the plaintext with a new data key, and wraps the data key
+// with the HSM key.
+func (s *Seal) Encrypt(_ context.Context, plaintext []byte) (blob *physical.EncryptedBlobInfo, err error) {
+	defer func(now time.Time) {
+		metrics.MeasureSince([]string{"seal", "encrypt", "time"}, now)
+		metrics.MeasureSince([]string{"seal", "pkcs11", "encrypt", "time"}, now)
+
+		if err != nil {
+			metrics.IncrCounter([]string{"seal", "encrypt", "error"}, 1)
+			metrics.IncrCounter([]string{"seal", "pkcs11", "encrypt", "error"}, 1)
+		}
+	}(time.Now())
+
+	metrics.IncrCounter([]string{"seal", "encrypt"}, 1)
+	metrics.IncrCounter([]string{"seal", "pkcs11", "encrypt"}, 1)
+
+	env, err := seal.NewEnvelope().Encrypt(plaintext)
+	if err != nil {
+		return nil, errwrap.Wrapf("error wrapping data: {{err}}", err)
+	}
+
+	keyLabel := s.KeyID()
+	var wrappedKey []byte
+	err = s.withSession(func() error {
+		var err error
+		wrappedKey, err = s.wrapKey(keyLabel, env.Key)
+		return err
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf("error wrapping data key: {{err}}", err)
+	}
+
+	return &physical.EncryptedBlobInfo{
+		Ciphertext: env.Ciphertext,
+		IV:         env.IV,
+		KeyInfo: &physical.SealKeyInfo{
+			Mechanism:  uint64(s.mechanism),
+			KeyID:      keyLabel,
+			WrappedKey: wrappedKey,
+		},
+	}, nil
+}
+
+// Decrypt unwraps the data key with the HSM key it was wrapped with, which
+// may not be the current one, and decrypts the ciphertext.
+func (s *Seal) Decrypt(_ context.Context, in *physical.EncryptedBlobInfo) (pt []byte, err error) {
+	defer func(now time.Time) {
+		metrics.MeasureSince([]string{"seal", "decrypt", "time"}, now)
+		metrics.MeasureSince([]string{"seal", "pkcs11", "decrypt", "time"}, now)
+
+		if err != nil {
+			metrics.IncrCounter([]string{"seal", "decrypt", "error"}, 1)
+			metrics.IncrCounter([]string{"seal", "pkcs11", "decrypt", "error"}, 1)
+		}
+	}(time.Now())
+
+	metrics.IncrCounter([]string{"seal", "decrypt"}, 1)
+	metrics.IncrCounter([]string{"seal", "pkcs11", "decrypt"}, 1)
+
+	if in == nil || in.KeyInfo == nil {
+		return nil, errors.New("key info is missing")
+	}
+
+	var key []byte
+	err = s.withSession(func() error {
+		var err error
+		key, err = s.unwrapKey(in.KeyInfo)
+		return err
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf("error unwrapping data key: {{err}}", err)
+	}
+
+	pt, err = seal.NewEnvelope().Decrypt(&seal.EnvelopeInfo{
+		Ciphertext: in.Ciphertext,
+		Key:        key,
+		IV:         in.IV,
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf("error decrypting data: {{err}}", err)
+	}
+	return pt, nil
+}
+
+// wrapKey encrypts the data key with the HSM key. With AES-GCM the IV is
+// prepended to the result. It must be called with the session lock held.
+func (s *Seal) wrapKey(keyLabel string, key []byte) ([]byte, error) {
+	handle, err := s.findKey(keyLabel, s.encryptClass(s.mechanism))
+	if err != nil {
+		return nil, err
+	}
+
+	switch s.mechanism {
+	case p11.CKM_AES_GCM:
+		iv, err := xxuuid.GenerateRandomBytes(gcmIVSize)
+		if err != nil {
+			return nil, err
+		}
+		params := p11.NewGCMParams(iv, nil, gcmTagBits)
+		defer params.Free()
+
+		if err := s.ctx.EncryptInit(s.session, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_GCM, params)}, handle); err != nil {
+			return nil, err
+		}
+		ct, err := s.ctx.Encrypt(s.session, key)
+		if err != nil {
+			return nil, err
+		}
+
+		// Some HSMs generate the IV themselves
+		if generated := params.IV(); len(generated) == gcmIVSize {
+			iv = generated
+		}
+		return append(iv, ct...), nil
+
+	default:
+		if err := s.ctx.EncryptInit(s.session, []*p11.Mechanism{oaepMechanism()}, handle); err != nil {
+			return nil, err
+		}
+		return s.ctx.Encrypt(s.session, key)
+	}
+}
+
+// unwrapKey decrypts the data key with the HSM key and the mechanism recorded
+// in the key info. It must be called with the session lock held.
+func (s *Seal) unwrapKey(keyInfo *physical.SealKeyInfo) ([]byte, error) {
+	mech := uint(keyInfo.Mechanism)
+	handle, err := s.findKey(keyInfo.KeyID, s.decryptClass(mech))
+	if err != nil {
+		return nil, err
+	}
+
+	switch mech {
+	case p11.CKM_AES_GCM:
+		if len(keyInfo.WrappedKey) < gcmIVSize {
+			return nil, errors.New("wrapped key is too short")
+		}
+		params := p11.NewGCMParams(keyInfo.WrappedKey[:gcmIVSize], nil, gcmTagBits)
+		defer params.Free()
+
+		if err := s.ctx.DecryptInit(s.session, []*p11.Mechanism{p11.NewMechanism(p11.CKM_AES_GCM, params)}, handle); err != nil {
+			return nil, err
+		}
+		return s.ctx.Decrypt(s.session, keyInfo.WrappedKey[gcmIVSize:])
+
+	case p11.CKM_RSA_PKCS_OAEP:
+		if err := s.ctx.DecryptInit(s.session, []*p11.Mechanism{oaepMechanism()}, handle); err != nil {
+			return nil, err
+		}
+		return s.ctx.Decrypt(s.session, keyInfo.WrappedKey)
+	}
+
+	return nil, fmt.Errorf("unsupported mechanism %s", mechanismName(mech))
+}
+
+func oaepMechanism() *p11.Mechanism {
+	return p11.NewMechanism(p11.CKM_RSA_PKCS_OAEP, p11.NewOAEPParams(p11.CKM_SHA256, p11.CKG_MGF1_SHA256, p11.CKZ_DATA_SPECIFIED, nil))
+}
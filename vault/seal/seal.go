@@ -17,6 +17,21 @@ const (
 	HSMAutoDeprecated = "hsm-auto"
 )
 
+// KeyIDMatcher is implemented by the seals whose key ID changes as their key
+// is rotated, to recognize the IDs of the previous versions of their key.
+type KeyIDMatcher interface {
+	MatchesKeyID(keyID string) bool
+}
+
+// MatchesKeyID returns whether the data encrypted with the given key ID was
+// encrypted with the key of the seal.
+func MatchesKeyID(access Access, keyID string) bool {
+	if matcher, ok := access.(KeyIDMatcher); ok {
+		return matcher.MatchesKeyID(keyID)
+	}
+	return keyID != "" && keyID == access.KeyID()
+}
+
 // Access is the embedded implemention of autoSeal that contains logic
 // specific to encrypting and decrypting data, or in this case keys.
 type Access interface {
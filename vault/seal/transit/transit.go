@@ -29,6 +29,10 @@ type Seal struct {
 	mountPath string
 	keyName   string
 
+	// keyURL identifies the key across transit servers and mounts, the key
+	// ID is the key URL followed by the version of the key
+	keyURL string
+
 	currentKeyID *atomic.Value
 }
 
@@ -120,6 +124,8 @@ func (s *Seal) SetConfig(config map[string]string) (map[string]string, error) {
 		}
 	}
 
+	s.keyURL = fmt.Sprintf("%s/v1/%s", strings.TrimSuffix(apiConfig.Address, "/"), path.Join(namespace, s.mountPath, "keys", s.keyName))
+
 	if s.client == nil {
 		client, err := api.NewClient(apiConfig)
 		if err != nil {
@@ -211,6 +217,12 @@ func (s *Seal) KeyID() string {
 	return s.currentKeyID.Load().(string)
 }
 
+// MatchesKeyID returns whether the key id is the one of any version of the
+// key.
+func (s *Seal) MatchesKeyID(keyID string) bool {
+	return strings.HasPrefix(keyID, s.keyURL+":")
+}
+
 // Encrypt is used to encrypt using Vaults Transit engine
 func (s *Seal) Encrypt(_ context.Context, plaintext []byte) (blob *physical.EncryptedBlobInfo, err error) {
 	defer func(now time.Time) {
@@ -240,7 +252,7 @@ func (s *Seal) Encrypt(_ context.Context, plaintext []byte) (blob *physical.Encr
 	if len(splitKey) != 3 {
 		return nil, errors.New("invalid ciphertext returned")
 	}
-	keyID := splitKey[1]
+	keyID := s.keyURL + ":" + splitKey[1]
 	s.currentKeyID.Store(keyID)
 
 	ret := &physical.EncryptedBlobInfo{
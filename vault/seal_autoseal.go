@@ -4,6 +4,7 @@ import (
 	"context"
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync/atomic"
 
@@ -426,6 +427,61 @@ func (d *autoSeal) getRecoveryKeyInternal(ctx context.Context) ([]byte, error) {
 	return pt, nil
 }
 
+// UpgradeKeys re-encrypts the stored keys and the recovery key with the
+// current key of the seal when they were encrypted with another one, so that
+// the previous key can be retired after a rotation.
+func (d *autoSeal) UpgradeKeys(ctx context.Context) error {
+	if err := d.checkCore(); err != nil {
+		return err
+	}
+
+	currentKeyID := d.KeyID()
+	if currentKeyID == "" {
+		return nil
+	}
+
+	for _, path := range []string{StoredBarrierKeysPath, recoveryKeyPath} {
+		pe, err := d.core.physical.Get(ctx, path)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to read %q: {{err}}", path), err)
+		}
+		if pe == nil {
+			continue
+		}
+
+		blobInfo := &physical.EncryptedBlobInfo{}
+		if err := proto.Unmarshal(pe.Value, blobInfo); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to proto decode %q: {{err}}", path), err)
+		}
+		if blobInfo.KeyInfo == nil || blobInfo.KeyInfo.KeyID == currentKeyID {
+			continue
+		}
+
+		pt, err := d.Decrypt(ctx, blobInfo)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to decrypt %q: {{err}}", path), err)
+		}
+		blobInfo, err = d.Encrypt(ctx, pt)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to encrypt %q: {{err}}", path), err)
+		}
+		value, err := proto.Marshal(blobInfo)
+		if err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to marshal %q: {{err}}", path), err)
+		}
+
+		if err := d.core.physical.Put(ctx, &physical.Entry{
+			Key:   path,
+			Value: value,
+		}); err != nil {
+			return errwrap.Wrapf(fmt.Sprintf("failed to write %q: {{err}}", path), err)
+		}
+		d.core.logger.Info("autoseal: re-encrypted with the current seal key", "path", path, "key_id", currentKeyID)
+	}
+
+	return nil
+}
+
 // migrateRecoveryConfig is a helper func to migrate the recovery config to
 // live outside the barrier. This is called from SetRecoveryConfig which is
 // always called with the stateLock.
@@ -462,3 +518,55 @@ func (d *autoSeal) migrateRecoveryConfig(ctx context.Context) error {
 
 	return nil
 }
+
+// StoredKeysMigrationPending returns whether the stored keys are encrypted
+// with the key of the old seal rather than the one of the new seal, both auto
+// seals. It tells a pending migration between two seals of the same type
+// apart from a disabled seal kept to unwrap entries once it is done. The key
+// ID of the stored keys decides it, or decrypting them when it matches
+// neither seal.
+func (c *Core) StoredKeysMigrationPending(ctx context.Context, newSeal, oldSeal Seal) (bool, error) {
+	newAutoSeal, ok := newSeal.(*autoSeal)
+	if !ok {
+		return false, errors.New("seal does not store keys")
+	}
+	oldAutoSeal, ok := oldSeal.(*autoSeal)
+	if !ok {
+		return false, errors.New("disabled seal does not store keys")
+	}
+
+	pe, err := c.physical.Get(ctx, StoredBarrierKeysPath)
+	if err != nil {
+		return false, errwrap.Wrapf("failed to fetch stored keys: {{err}}", err)
+	}
+	if pe == nil {
+		return false, nil
+	}
+
+	blobInfo := &physical.EncryptedBlobInfo{}
+	if err := proto.Unmarshal(pe.Value, blobInfo); err != nil {
+		return false, errwrap.Wrapf("failed to proto decode stored keys: {{err}}", err)
+	}
+	var keyID string
+	if blobInfo.KeyInfo != nil {
+		keyID = blobInfo.KeyInfo.KeyID
+	}
+
+	switch {
+	case seal.MatchesKeyID(newAutoSeal.Access, keyID):
+		return false, nil
+	case seal.MatchesKeyID(oldAutoSeal.Access, keyID):
+		return true, nil
+	}
+
+	// The key ID does not tell the keys apart when the stored keys predate
+	// it, such as the bare version of a transit key, so they are decrypted
+	// with the new seal and then with the disabled seal
+	if _, err := newAutoSeal.Access.Decrypt(ctx, blobInfo); err == nil {
+		return false, nil
+	}
+	if _, err := oldAutoSeal.Access.Decrypt(ctx, blobInfo); err == nil {
+		return true, nil
+	}
+	return false, fmt.Errorf("stored keys are encrypted with key %q, which neither the seal nor the disabled seal can decrypt", keyID)
+}
@@ -0,0 +1,276 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	"github.com/jiangjiali/vault/sdk/helper/jsonutil"
+	"github.com/jiangjiali/vault/sdk/logical"
+	"github.com/jiangjiali/vault/vault/seal"
+)
+
+const (
+	// coreSealWrapPath records whether the entries were last written seal
+	// wrapped, so that they are rewritten when seal wrapping is turned on or
+	// off
+	coreSealWrapPath = "core/seal-wrap"
+)
+
+// sealWrapStatus is the state stored at coreSealWrapPath.
+type sealWrapStatus struct {
+	Enabled bool `json:"enabled"`
+
+	// Migrated is set when the seal was migrated since the entries were
+	// written, they are then rewritten with the new seal
+	Migrated bool `json:"migrated,omitempty"`
+}
+
+// sealWrapEnabled returns whether the entries flagged SealWrap are encrypted
+// with the seal, which requires an auto-unseal seal.
+func (c *Core) sealWrapEnabled() bool {
+	_, ok := c.seal.(*autoSeal)
+	return ok && !c.sealWrapDisabled
+}
+
+// setupSealWrapper hands the seal to the storage layer wrapping the entries.
+// With seal wrapping disabled the seal is still used to read the entries
+// wrapped before.
+func (c *Core) setupSealWrapper() {
+	var unwrapper *sealUnwrapper
+	switch u := c.sealUnwrapper.(type) {
+	case *sealUnwrapper:
+		unwrapper = u
+	case *transactionalSealUnwrapper:
+		unwrapper = u.sealUnwrapper
+	default:
+		return
+	}
+
+	var access, previous seal.Access
+	if autoSeal, ok := c.seal.(*autoSeal); ok {
+		access = autoSeal.Access
+	}
+	if autoSeal, ok := c.unwrapSeal.(*autoSeal); ok {
+		previous = autoSeal.Access
+	}
+	unwrapper.setSealWrapper(access, !c.sealWrapDisabled, previous)
+}
+
+// setupSealWrap rewrites the seal wrapped entries when seal wrapping has been
+// turned on or off, or the seal migrated, since they were written. The entries are rewritten once the
+// mounts accept writes, at the end of the unseal.
+func (c *Core) setupSealWrap(ctx context.Context) error {
+	var status sealWrapStatus
+	entry, err := c.barrier.Get(ctx, coreSealWrapPath)
+	if err != nil {
+		return errwrap.Wrapf("failed to read seal wrap status: {{err}}", err)
+	}
+	if entry != nil {
+		if err := jsonutil.DecodeJSON(entry.Value, &status); err != nil {
+			return errwrap.Wrapf("failed to decode seal wrap status: {{err}}", err)
+		}
+	}
+
+	enabled := c.sealWrapEnabled()
+	if status.Enabled == enabled && !status.Migrated {
+		return nil
+	}
+	// Nothing was wrapped before the first unseal with this status
+	if entry == nil && !enabled {
+		return c.storeSealWrapStatus(ctx, enabled)
+	}
+
+	c.postUnsealFuncs = append(c.postUnsealFuncs, func() {
+		c.logger.Info("rewriting seal wrapped entries", "seal_wrap", enabled)
+		count, err := c.rewriteSealWrapped(ctx)
+		if err != nil {
+			// The status is left as is so that this is retried on the next
+			// unseal
+			c.logger.Error("failed to rewrite seal wrapped entries", "error", err)
+			return
+		}
+		if err := c.storeSealWrapStatus(ctx, enabled); err != nil {
+			c.logger.Error("failed to store seal wrap status", "error", err)
+			return
+		}
+		c.logger.Info("rewrote seal wrapped entries", "seal_wrap", enabled, "entries", count)
+	})
+	return nil
+}
+
+// markSealWrapMigrated records that the seal was migrated, so that the
+// entries wrapped with the previous seal are rewritten on unseal.
+func (c *Core) markSealWrapMigrated(ctx context.Context) error {
+	entry, err := c.barrier.Get(ctx, coreSealWrapPath)
+	if err != nil {
+		return errwrap.Wrapf("failed to read seal wrap status: {{err}}", err)
+	}
+	if entry == nil {
+		return nil
+	}
+
+	var status sealWrapStatus
+	if err := jsonutil.DecodeJSON(entry.Value, &status); err != nil {
+		return errwrap.Wrapf("failed to decode seal wrap status: {{err}}", err)
+	}
+	if !status.Enabled {
+		return nil
+	}
+	status.Migrated = true
+	return c.storeSealWrapStatusEntry(ctx, &status)
+}
+
+func (c *Core) storeSealWrapStatus(ctx context.Context, enabled bool) error {
+	return c.storeSealWrapStatusEntry(ctx, &sealWrapStatus{
+		Enabled: enabled,
+	})
+}
+
+func (c *Core) storeSealWrapStatusEntry(ctx context.Context, status *sealWrapStatus) error {
+	value, err := jsonutil.EncodeJSON(status)
+	if err != nil {
+		return err
+	}
+	if err := c.barrier.Put(ctx, &logical.StorageEntry{
+		Key:   coreSealWrapPath,
+		Value: value,
+	}); err != nil {
+		return errwrap.Wrapf("failed to store seal wrap status: {{err}}", err)
+	}
+	return nil
+}
+
+// rewriteSealWrapped reads and writes back every seal wrapped entry, so that
+// the storage layer wraps or unwraps it according to the current seal.
+func (c *Core) rewriteSealWrapped(ctx context.Context) (int, error) {
+	var views []*BarrierView
+	c.router.l.RLock()
+	c.router.root.Walk(func(_ string, raw interface{}) bool {
+		if view, ok := raw.(*routeEntry).storageView.(*BarrierView); ok {
+			views = append(views, view)
+		}
+		return false
+	})
+	c.router.l.RUnlock()
+
+	var count int
+	for _, view := range views {
+		n, err := rewriteSealWrappedView(ctx, view)
+		if err != nil {
+			return count, errwrap.Wrapf(fmt.Sprintf("failed to rewrite entries of %q: {{err}}", view.Prefix()), err)
+		}
+		count += n
+	}
+
+	// Root tokens and their leases are seal wrapped by the core
+	if c.tokenStore != nil {
+		n, err := rewriteSealWrappedEntries(ctx, c.tokenStore.idBarrierView, func(value []byte) (bool, error) {
+			te := new(logical.TokenEntry)
+			if err := jsonutil.DecodeJSON(value, te); err != nil {
+				return false, err
+			}
+			return len(te.Policies) == 1 && te.Policies[0] == "root", nil
+		})
+		if err != nil {
+			return count, errwrap.Wrapf("failed to rewrite tokens: {{err}}", err)
+		}
+		count += n
+	}
+	if c.expiration != nil {
+		n, err := rewriteSealWrappedEntries(ctx, c.expiration.idView, func(value []byte) (bool, error) {
+			le, err := decodeLeaseEntry(value)
+			if err != nil {
+				return false, err
+			}
+			return le.Auth != nil && len(le.Auth.Policies) == 1 && le.Auth.Policies[0] == "root", nil
+		})
+		if err != nil {
+			return count, errwrap.Wrapf("failed to rewrite leases: {{err}}", err)
+		}
+		count += n
+	}
+
+	return count, nil
+}
+
+// rewriteSealWrappedView rewrites the entries of a mount matching its seal wrap
+// paths.
+func rewriteSealWrappedView(ctx context.Context, view *BarrierView) (int, error) {
+	paths, ok := view.sealWrapPaths.Load().(*sealWrapPaths)
+	if !ok {
+		return 0, nil
+	}
+
+	keys := make([]string, 0, len(paths.exact))
+	for key := range paths.exact {
+		keys = append(keys, key)
+	}
+	prefixes := paths.prefixes
+	if paths.all {
+		prefixes = []string{""}
+	}
+	for _, prefix := range prefixes {
+		err := logical.ScanView(ctx, view.SubView(prefix), func(path string) {
+			keys = append(keys, prefix+path)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	var count int
+	for _, key := range keys {
+		entry, err := view.Get(ctx, key)
+		if err != nil {
+			return count, err
+		}
+		if entry == nil {
+			continue
+		}
+		if err := view.Put(ctx, &logical.StorageEntry{
+			Key:   key,
+			Value: entry.Value,
+		}); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// rewriteSealWrappedEntries rewrites the entries of the view for which
+// sealWrapped returns true.
+func rewriteSealWrappedEntries(ctx context.Context, view *BarrierView, sealWrapped func([]byte) (bool, error)) (int, error) {
+	keys, err := logical.CollectKeys(ctx, view)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, key := range keys {
+		entry, err := view.Get(ctx, key)
+		if err != nil {
+			return count, err
+		}
+		if entry == nil || len(entry.Value) == 0 {
+			continue
+		}
+		ok, err := sealWrapped(entry.Value)
+		if err != nil {
+			return count, errwrap.Wrapf(fmt.Sprintf("failed to decode %q: {{err}}", key), err)
+		}
+		if !ok {
+			continue
+		}
+		if err := view.Put(ctx, &logical.StorageEntry{
+			Key:      key,
+			Value:    entry.Value,
+			SealWrap: true,
+		}); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
@@ -7,9 +7,11 @@ import (
 	"fmt"
 	"sync/atomic"
 
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
 	"github.com/jiangjiali/vault/sdk/helper/locksutil"
 	"github.com/jiangjiali/vault/sdk/physical"
 	"github.com/golang/protobuf/proto"
+	"github.com/jiangjiali/vault/vault/seal"
 
 	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
 )
@@ -22,6 +24,7 @@ func NewSealUnwrapper(underlying physical.Backend, logger log.Logger) physical.B
 		locks:        locksutil.CreateLocks(),
 		allowUnwraps: new(uint32),
 	}
+	ret.sealWrapper.Store((*sealWrapper)(nil))
 
 	if underTxn, ok := underlying.(physical.Transactional); ok {
 		return &transactionalSealUnwrapper{
@@ -41,6 +44,24 @@ type sealUnwrapper struct {
 	logger       log.Logger
 	locks        []*locksutil.LockEntry
 	allowUnwraps *uint32
+
+	// sealWrapper holds the seal used to wrap and unwrap the entries, it is
+	// nil when the seal cannot wrap values
+	sealWrapper atomic.Value
+}
+
+// sealWrapper is the seal the entries flagged SealWrap are encrypted with
+// before being written to storage.
+type sealWrapper struct {
+	access seal.Access
+
+	// wrap is unset when seal wrapping is disabled; the seal is still used to
+	// read the entries that were wrapped before
+	wrap bool
+
+	// previous is the seal migrated from, if any, used to read the entries
+	// it wrapped
+	previous seal.Access
 }
 
 // transactionalSealUnwrapper is a seal unwrapper that wraps a physical that is transactional
@@ -54,6 +75,11 @@ func (d *sealUnwrapper) Put(ctx context.Context, entry *physical.Entry) error {
 		return nil
 	}
 
+	entry, err := d.wrap(ctx, entry)
+	if err != nil {
+		return err
+	}
+
 	locksutil.LockForKey(d.locks, entry.Key).Lock()
 	defer locksutil.LockForKey(d.locks, entry.Key).Unlock()
 
@@ -83,11 +109,13 @@ func (d *sealUnwrapper) Get(ctx context.Context, key string) (*physical.Entry, e
 	if !performUnwrap {
 		return entry, nil
 	}
-	// It's actually encrypted and we can't read it
 	if se.Wrapped {
-		return nil, fmt.Errorf("cannot decode sealwrapped storage entry %q", entry.Key)
-	}
-	if atomic.LoadUint32(d.allowUnwraps) != 1 {
+		// Wrapped entries are only rewritten once seal wrapping is disabled,
+		// which is done below holding the key lock
+		if atomic.LoadUint32(d.allowUnwraps) != 1 || d.wrapping() {
+			return d.unwrap(ctx, key, se, false)
+		}
+	} else if atomic.LoadUint32(d.allowUnwraps) != 1 {
 		return &physical.Entry{
 			Key:   entry.Key,
 			Value: se.Ciphertext,
@@ -123,7 +151,7 @@ func (d *sealUnwrapper) Get(ctx context.Context, key string) (*physical.Entry, e
 		return entry, nil
 	}
 	if se.Wrapped {
-		return nil, fmt.Errorf("cannot decode sealwrapped storage entry %q", entry.Key)
+		return d.unwrap(ctx, key, se, true)
 	}
 
 	entry = &physical.Entry{
@@ -154,6 +182,24 @@ func (d *transactionalSealUnwrapper) Transaction(ctx context.Context, txns []*ph
 	for _, curr := range txns {
 		keys = append(keys, curr.Entry.Key)
 	}
+
+	// Wrap the entries being written, without modifying the caller's
+	// operations
+	wrapped := make([]*physical.TxnEntry, 0, len(txns))
+	for _, curr := range txns {
+		if curr.Operation != physical.PutOperation {
+			wrapped = append(wrapped, curr)
+			continue
+		}
+		entry, err := d.wrap(ctx, curr.Entry)
+		if err != nil {
+			return err
+		}
+		wrapped = append(wrapped, &physical.TxnEntry{
+			Operation: curr.Operation,
+			Entry:     entry,
+		})
+	}
 	// Lock the keys
 	for _, l := range locksutil.LocksForKeys(d.locks, keys) {
 		l.Lock()
@@ -162,7 +208,7 @@ func (d *transactionalSealUnwrapper) Transaction(ctx context.Context, txns []*ph
 		}(l)
 	}
 
-	if err := d.Transactional.Transaction(ctx, txns); err != nil {
+	if err := d.Transactional.Transaction(ctx, wrapped); err != nil {
 		return err
 	}
 
@@ -181,3 +227,101 @@ func (d *sealUnwrapper) runUnwraps() {
 	// primary
 	atomic.StoreUint32(d.allowUnwraps, 1)
 }
+
+// setSealWrapper sets the seal used to wrap the entries flagged SealWrap, and
+// the seal migrated from which may have wrapped some of them. Without either
+// the entries are left unwrapped and wrapped ones are unreadable.
+func (d *sealUnwrapper) setSealWrapper(access seal.Access, wrap bool, previous seal.Access) {
+	if access == nil && previous == nil {
+		d.sealWrapper.Store((*sealWrapper)(nil))
+		return
+	}
+	d.sealWrapper.Store(&sealWrapper{
+		access:   access,
+		wrap:     wrap && access != nil,
+		previous: previous,
+	})
+}
+
+// wrap returns the entry with its value encrypted by the seal if it is flagged
+// SealWrap and seal wrapping is enabled, or the entry as is otherwise.
+func (d *sealUnwrapper) wrap(ctx context.Context, entry *physical.Entry) (*physical.Entry, error) {
+	if !entry.SealWrap {
+		return entry, nil
+	}
+	sw := d.sealWrapper.Load().(*sealWrapper)
+	if sw == nil || !sw.wrap {
+		return entry, nil
+	}
+
+	se, err := sw.access.Encrypt(ctx, entry.Value)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to seal wrap storage entry %q: {{err}}", entry.Key), err)
+	}
+	se.Wrapped = true
+
+	value, err := proto.Marshal(se)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to encode seal wrapped storage entry %q: {{err}}", entry.Key), err)
+	}
+
+	return &physical.Entry{
+		Key:      entry.Key,
+		Value:    append(value, 's'),
+		SealWrap: true,
+	}, nil
+}
+
+// wrapping returns whether the entries flagged SealWrap are currently wrapped.
+func (d *sealUnwrapper) wrapping() bool {
+	sw := d.sealWrapper.Load().(*sealWrapper)
+	return sw != nil && sw.wrap
+}
+
+// unwrap decrypts a seal wrapped entry. When seal wrapping has been disabled
+// and rewrite is set, the entry is written back unwrapped by the active node;
+// the caller must hold the key lock.
+func (d *sealUnwrapper) unwrap(ctx context.Context, key string, se *physical.EncryptedBlobInfo, rewrite bool) (*physical.Entry, error) {
+	sw := d.sealWrapper.Load().(*sealWrapper)
+	if sw == nil {
+		return nil, fmt.Errorf("cannot decode sealwrapped storage entry %q", key)
+	}
+
+	value, err := sw.decrypt(ctx, se)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("failed to unwrap sealwrapped storage entry %q: {{err}}", key), err)
+	}
+
+	if sw.wrap {
+		return &physical.Entry{
+			Key:      key,
+			Value:    value,
+			SealWrap: true,
+		}, nil
+	}
+
+	entry := &physical.Entry{
+		Key:   key,
+		Value: value,
+	}
+	if !rewrite || atomic.LoadUint32(d.allowUnwraps) != 1 {
+		return entry, nil
+	}
+	return entry, d.underlying.Put(ctx, entry)
+}
+
+// decrypt decrypts a seal wrapped value with the current seal, or with the seal
+// migrated from if the current one cannot.
+func (sw *sealWrapper) decrypt(ctx context.Context, se *physical.EncryptedBlobInfo) ([]byte, error) {
+	if sw.access == nil {
+		return sw.previous.Decrypt(ctx, se)
+	}
+
+	value, err := sw.access.Decrypt(ctx, se)
+	if err != nil && sw.previous != nil {
+		if value, prevErr := sw.previous.Decrypt(ctx, se); prevErr == nil {
+			return value, nil
+		}
+	}
+	return value, err
+}
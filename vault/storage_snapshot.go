@@ -0,0 +1,183 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jiangjiali/vault/sdk/helper/consts"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	"github.com/jiangjiali/vault/sdk/logical"
+	"github.com/jiangjiali/vault/sdk/physical"
+	"github.com/jiangjiali/vault/sdk/physical/inmem"
+)
+
+// snapshotExcludedPrefixes are the storage paths left out of the snapshots
+// of the storage, which hold the state of the HA, replication and automatic
+// snapshots of the running cluster rather than its data
+var snapshotExcludedPrefixes = []string{
+	coreLeaderPrefix,
+	CoreLockPath,
+	poisonPillPath,
+	knownPrimaryAddrsPrefix,
+	consts.CoreReplicatedClusterPrefix,
+	consts.CoreReplicatedClusterPrefixDR,
+	coreDROperationTokenPath,
+	autoSnapshotStatusPath,
+}
+
+// SnapshotKey returns whether a key of the storage is saved in the snapshots
+// of the storage, and replaced when one is restored
+func SnapshotKey(key string) bool {
+	for _, prefix := range snapshotExcludedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// snapshot writes a snapshot of the storage. The writes to the storage wait
+// while its entries are read, not while it is written.
+func (c *Core) snapshot(ctx context.Context, w io.Writer) (*physical.SnapshotMeta, error) {
+	c.storageLock.Lock()
+	s, err := physical.TakeSnapshot(ctx, c.replicationStorage, SnapshotKey)
+	c.storageLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	if err := s.Archive(w); err != nil {
+		return nil, err
+	}
+	return s.Meta, nil
+}
+
+// restoreSnapshot replaces the storage with the one of a snapshot and sets
+// up the state of the cluster again from it. Unless forced, the snapshot
+// must have been taken with the unseal keys of the cluster, otherwise the
+// node seals itself once the snapshot is restored. A snapshot that cannot be
+// restored is a coded error.
+func (c *Core) restoreSnapshot(ctx context.Context, r io.Reader, force bool) (*physical.SnapshotMeta, error) {
+	if c.ReplicationState().HasState(consts.ReplicationPerformanceSecondary | consts.ReplicationDRSecondary) {
+		return nil, logical.CodedError(400, "cannot restore a snapshot on a replication secondary")
+	}
+
+	// The snapshot is spooled to a temporary file, as it is verified before
+	// the storage is replaced
+	tmp, err := ioutil.TempFile("", "vault-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	if _, err := io.Copy(tmp, r); err != nil {
+		return nil, errwrap.Wrapf("failed to read the snapshot: {{err}}", err)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var keyring *physical.Entry
+	meta, err := physical.ReadSnapshot(tmp, func(entry *physical.Entry) error {
+		if entry.Key == keyringPath {
+			keyring = entry
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, logical.CodedError(400, fmt.Sprintf("invalid snapshot: %s", err))
+	}
+	sameKeys, err := c.snapshotKeys(ctx, keyring)
+	if err != nil {
+		return nil, logical.CodedError(400, err.Error())
+	}
+	if !sameKeys && !force {
+		return nil, logical.CodedError(400, "snapshot was taken with other unseal keys, force the restore to replace them")
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	c.storageLock.Lock()
+	_, keys, err := physical.RestoreSnapshot(ctx, c.replicationStorage, tmp, SnapshotKey)
+	c.replicationLog.append(keys...)
+	c.storageLock.Unlock()
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to restore the snapshot: {{err}}", err)
+	}
+
+	if !sameKeys {
+		// The barrier cannot decrypt the restored keyring, the cluster has
+		// to be unsealed with the unseal keys of the snapshot
+		c.logger.Warn("restored a snapshot taken with other unseal keys, sealing")
+		c.seal.SetCachedBarrierConfig(nil)
+		c.seal.SetCachedRecoveryConfig(nil)
+		go func() {
+			if err := c.sealInternal(); err != nil {
+				c.logger.Error("failed to seal", "error", err)
+			}
+		}()
+		return meta, nil
+	}
+
+	if err := c.barrier.ReloadMasterKey(ctx); err != nil {
+		return nil, errwrap.Wrapf("error reloading master key: {{err}}", err)
+	}
+	if err := c.barrier.ReloadKeyring(ctx); err != nil {
+		return nil, errwrap.Wrapf("error reloading keyring: {{err}}", err)
+	}
+	return meta, c.reloadAfterRestore()
+}
+
+// snapshotKeys returns whether the keyring of a snapshot is encrypted with
+// the master key of the barrier, that is whether the cluster can still be
+// unsealed with its unseal keys once the snapshot is restored
+func (c *Core) snapshotKeys(ctx context.Context, entry *physical.Entry) (bool, error) {
+	if entry == nil || len(entry.Value) < 4 {
+		return false, errors.New("snapshot has no keyring")
+	}
+
+	keyring, err := c.barrier.Keyring()
+	if err != nil {
+		return false, err
+	}
+
+	inm, err := inmem.NewInmem(nil, c.logger)
+	if err != nil {
+		return false, err
+	}
+	if err := inm.Put(ctx, entry); err != nil {
+		return false, err
+	}
+	barrier, err := NewAESGCMBarrier(inm)
+	if err != nil {
+		return false, err
+	}
+	err = barrier.Unseal(ctx, keyring.MasterKey())
+	switch {
+	case err == ErrBarrierInvalidKey:
+		return false, nil
+	case err != nil:
+		return false, errwrap.Wrapf("failed to check the keyring of the snapshot: {{err}}", err)
+	}
+	return true, barrier.Seal()
+}
+
+// reloadAfterRestore sets up the state of the cluster again from the
+// restored storage
+func (c *Core) reloadAfterRestore() error {
+	if c.ha != nil {
+		return c.stepDownAfterRestore()
+	}
+
+	go c.reloadState()
+	return nil
+}
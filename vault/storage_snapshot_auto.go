@@ -0,0 +1,389 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jiangjiali/vault/sdk/helper/armon/metrics"
+	"github.com/jiangjiali/vault/sdk/helper/errwrap"
+	log "github.com/jiangjiali/vault/sdk/helper/hclutil/hclog"
+	"github.com/jiangjiali/vault/sdk/helper/jsonutil"
+	"github.com/jiangjiali/vault/sdk/logical"
+)
+
+const (
+	// autoSnapshotStatusPath is the storage path of the status of the
+	// automatic snapshots, which belongs to the local cluster
+	autoSnapshotStatusPath = "core/snapshot-auto/status"
+
+	// autoSnapshotTimestamp is the placeholder of the path of the automatic
+	// snapshots replaced by the time they are taken
+	autoSnapshotTimestamp = "{{timestamp}}"
+
+	// autoSnapshotTimeFormat is the format of the time in the file names
+	// of the automatic snapshots, which sorts them by time
+	autoSnapshotTimeFormat = "20060102T150405Z"
+)
+
+// AutoSnapshotConfig is the configuration of the automatic snapshots of the
+// storage taken by the active node
+type AutoSnapshotConfig struct {
+	Interval time.Duration
+
+	// PathTemplate is the path of the snapshot files, autoSnapshotTimestamp
+	// in their file name is replaced by the time of the snapshot
+	PathTemplate string
+
+	// Retain is the number of snapshot files kept, all of them are kept when
+	// zero
+	Retain int
+}
+
+// autoSnapshotStatus is the status of the automatic snapshots. It is kept in
+// the storage so that a new active node carries on with the schedule.
+type autoSnapshotStatus struct {
+	LastAttempt         time.Time `json:"last_attempt"`
+	LastSuccess         time.Time `json:"last_success"`
+	LastPath            string    `json:"last_path"`
+	LastEntries         int       `json:"last_entries"`
+	LastFailure         time.Time `json:"last_failure"`
+	LastError           string    `json:"last_error"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+}
+
+// autoSnapshots takes the snapshots of the storage on the schedule of its
+// configuration while the node is active
+type autoSnapshots struct {
+	core   *Core
+	config *AutoSnapshotConfig
+	logger log.Logger
+
+	l      sync.RWMutex
+	status autoSnapshotStatus
+	next   time.Time
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// validate checks the interval of the snapshots and that their file names
+// hold their time
+func (c *AutoSnapshotConfig) validate() error {
+	switch {
+	case c.Interval == 0:
+		return errors.New("interval is required")
+	case c.Interval < time.Minute:
+		return errors.New("interval must be at least one minute")
+	}
+	if c.PathTemplate == "" {
+		return errors.New("path is required")
+	}
+	if strings.Count(c.PathTemplate, autoSnapshotTimestamp) != 1 || !strings.Contains(filepath.Base(c.PathTemplate), autoSnapshotTimestamp) {
+		return fmt.Errorf("the file name of the path must contain %q once", autoSnapshotTimestamp)
+	}
+	if c.Retain < 0 {
+		return errors.New("retain cannot be negative")
+	}
+	return nil
+}
+
+func newAutoSnapshots(c *Core, config *AutoSnapshotConfig, logger log.Logger) *autoSnapshots {
+	return &autoSnapshots{
+		core:   c,
+		config: config,
+		logger: logger,
+	}
+}
+
+// startAutoSnapshots loads the status of the automatic snapshots and starts
+// taking them, if they are configured
+func (c *Core) startAutoSnapshots(ctx context.Context) error {
+	if c.autoSnapshots == nil {
+		return nil
+	}
+	return c.autoSnapshots.start(ctx)
+}
+
+// stopAutoSnapshots stops taking the automatic snapshots, waiting for the
+// one being taken
+func (c *Core) stopAutoSnapshots() error {
+	if c.autoSnapshots != nil {
+		c.autoSnapshots.stop()
+	}
+	return nil
+}
+
+// AutoSnapshotFailing returns whether the last automatic snapshot taken by
+// the active node failed. Its error is only given by the status of the
+// automatic snapshots, which requires a token.
+func (c *Core) AutoSnapshotFailing() bool {
+	if c.autoSnapshots == nil {
+		return false
+	}
+
+	a := c.autoSnapshots
+	a.l.RLock()
+	defer a.l.RUnlock()
+	return a.status.ConsecutiveFailures > 0
+}
+
+func (a *autoSnapshots) start(ctx context.Context) error {
+	a.stop()
+
+	entry, err := a.core.barrier.Get(ctx, autoSnapshotStatusPath)
+	if err != nil {
+		return errwrap.Wrapf("failed to read the status of the automatic snapshots: {{err}}", err)
+	}
+	var status autoSnapshotStatus
+	if entry != nil {
+		if err := jsonutil.DecodeJSON(entry.Value, &status); err != nil {
+			return errwrap.Wrapf("failed to decode the status of the automatic snapshots: {{err}}", err)
+		}
+	}
+
+	// Carry on with the schedule of the previous active node, a snapshot
+	// that is overdue is taken right away
+	next := status.LastAttempt.Add(a.config.Interval)
+	if now := time.Now(); next.Before(now) {
+		next = now
+	}
+
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	a.l.Lock()
+	a.status = status
+	a.next = next
+	a.stopCh = stopCh
+	a.doneCh = doneCh
+	a.l.Unlock()
+
+	go a.run(ctx, stopCh, doneCh)
+	a.logger.Info("automatic snapshots started", "interval", a.config.Interval, "next", next)
+	return nil
+}
+
+func (a *autoSnapshots) stop() {
+	a.l.Lock()
+	stopCh, doneCh := a.stopCh, a.doneCh
+	a.stopCh, a.doneCh = nil, nil
+	a.next = time.Time{}
+	a.l.Unlock()
+
+	if stopCh == nil {
+		return
+	}
+	close(stopCh)
+	<-doneCh
+}
+
+func (a *autoSnapshots) run(ctx context.Context, stopCh, doneCh chan struct{}) {
+	defer close(doneCh)
+
+	for {
+		a.l.RLock()
+		wait := time.Until(a.next)
+		a.l.RUnlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-stopCh:
+			timer.Stop()
+			return
+		}
+
+		path, entries, err := a.take(ctx, time.Now().UTC())
+		select {
+		case <-stopCh:
+			// The node stepped down or sealed while the snapshot was taken,
+			// the next active node takes it again
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+		a.record(ctx, path, entries, err)
+	}
+}
+
+// take writes a snapshot to the path of the configuration and removes the
+// snapshots beyond the ones retained
+func (a *autoSnapshots) take(ctx context.Context, now time.Time) (string, int, error) {
+	defer metrics.MeasureSince([]string{"core", "snapshot_auto", "take"}, now)
+
+	path := strings.Replace(a.config.PathTemplate, autoSnapshotTimestamp, now.Format(autoSnapshotTimeFormat), 1)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", 0, err
+	}
+
+	// The snapshot is written next to its destination and only moved there
+	// once complete, so that a failure does not leave a partial snapshot
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+"-")
+	if err != nil {
+		return "", 0, err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	meta, err := a.core.snapshot(ctx, tmp)
+	if err != nil {
+		return "", 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+
+	if err := a.prune(); err != nil {
+		return path, meta.Entries, errwrap.Wrapf("failed to remove old snapshots: {{err}}", err)
+	}
+	return path, meta.Entries, nil
+}
+
+// snapshots returns the paths of the snapshot files matching the path of
+// the configuration, from the oldest to the newest
+func (a *autoSnapshots) snapshots() ([]string, error) {
+	dir := filepath.Dir(a.config.PathTemplate)
+	base := filepath.Base(a.config.PathTemplate)
+	i := strings.Index(base, autoSnapshotTimestamp)
+	prefix, suffix := base[:i], base[i+len(autoSnapshotTimestamp):]
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var paths []string
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) || len(name) < len(prefix)+len(suffix) {
+			continue
+		}
+		if _, err := time.Parse(autoSnapshotTimeFormat, name[len(prefix):len(name)-len(suffix)]); err != nil {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, name))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// prune removes the oldest snapshot files beyond the ones retained
+func (a *autoSnapshots) prune() error {
+	if a.config.Retain == 0 {
+		return nil
+	}
+
+	paths, err := a.snapshots()
+	if err != nil {
+		return err
+	}
+	for len(paths) > a.config.Retain {
+		if err := os.Remove(paths[0]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		a.logger.Debug("removed old snapshot", "path", paths[0])
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// record updates the status of the automatic snapshots with the outcome of
+// a snapshot, emits its metrics and schedules the next one
+func (a *autoSnapshots) record(ctx context.Context, path string, entries int, err error) {
+	now := time.Now().UTC()
+
+	a.l.Lock()
+	a.status.LastAttempt = now
+	if path != "" {
+		a.status.LastSuccess = now
+		a.status.LastPath = path
+		a.status.LastEntries = entries
+	}
+	if err != nil {
+		a.status.LastFailure = now
+		a.status.LastError = err.Error()
+		a.status.ConsecutiveFailures++
+	} else {
+		a.status.ConsecutiveFailures = 0
+	}
+	a.next = now.Add(a.config.Interval)
+	status := a.status
+	a.l.Unlock()
+
+	if err != nil {
+		a.logger.Error("automatic snapshot failed", "error", err, "consecutive_failures", status.ConsecutiveFailures)
+		metrics.IncrCounter([]string{"core", "snapshot_auto", "failure"}, 1)
+	} else {
+		a.logger.Info("took automatic snapshot", "path", path, "entries", entries)
+		metrics.IncrCounter([]string{"core", "snapshot_auto", "success"}, 1)
+	}
+	metrics.SetGauge([]string{"core", "snapshot_auto", "consecutive_failures"}, float32(status.ConsecutiveFailures))
+
+	entry, err := logical.StorageEntryJSON(autoSnapshotStatusPath, status)
+	if err == nil {
+		err = a.core.barrier.Put(ctx, entry)
+	}
+	if err != nil {
+		a.logger.Error("failed to persist the status of the automatic snapshots", "error", err)
+	}
+}
+
+// statusData returns the status of the automatic snapshots as the data of a
+// response. The snapshots that cannot be listed are left out rather than
+// failing, since the status matters most when the snapshots fail.
+func (a *autoSnapshots) statusData() map[string]interface{} {
+	paths, err := a.snapshots()
+	if err != nil {
+		a.logger.Warn("failed to list the automatic snapshots", "error", err)
+	}
+	if paths == nil {
+		paths = []string{}
+	}
+
+	a.l.RLock()
+	defer a.l.RUnlock()
+
+	data := map[string]interface{}{
+		"interval":             int64(a.config.Interval.Seconds()),
+		"path":                 a.config.PathTemplate,
+		"retain":               a.config.Retain,
+		"snapshots":            paths,
+		"last_path":            a.status.LastPath,
+		"last_entries":         a.status.LastEntries,
+		"last_error":           a.status.LastError,
+		"consecutive_failures": a.status.ConsecutiveFailures,
+		"healthy":              a.status.ConsecutiveFailures == 0,
+	}
+	for key, t := range map[string]time.Time{
+		"next_snapshot": a.next,
+		"last_attempt":  a.status.LastAttempt,
+		"last_success":  a.status.LastSuccess,
+		"last_failure":  a.status.LastFailure,
+	} {
+		if t.IsZero() {
+			data[key] = ""
+			continue
+		}
+		data[key] = t.UTC().Format(time.RFC3339)
+	}
+	return data
+}
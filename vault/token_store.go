@@ -97,7 +97,18 @@ var (
 			if te.PublicID == "" {
 				return fmt.Errorf("missing public ID while destroying")
 			}
-			return ts.publicBackend.revoke(ctx, te.PublicID)
+			tokenNS, err := NamespaceByID(ctx, te.NamespaceID, ts.core)
+			if err != nil {
+				return err
+			}
+			if tokenNS == nil {
+				return namespace.ErrNoNamespace
+			}
+			publicBackend := ts.namespacePublicBackend(ctx, tokenNS)
+			if publicBackend == nil {
+				return fmt.Errorf("no per-token storage in namespace %q", tokenNS.Path)
+			}
+			return publicBackend.revoke(ctx, te.PublicID)
 		}
 	}
 )
@@ -1743,8 +1754,12 @@ func (ts *TokenStore) handleTidy(ctx context.Context, req *logical.Request, data
 				return errwrap.Wrapf("failed to fetch secondary index entries: {{err}}", err)
 			}
 
-			// List all the public storage keys
-			publicKeys, err := ts.publicBackend.storageView.List(quitCtx, "")
+			// List all the public storage keys of the namespace
+			publicBackend := ts.namespacePublicBackend(quitCtx, ns)
+			if publicBackend == nil {
+				return fmt.Errorf("no per-token storage in namespace %q", ns.Path)
+			}
+			publicKeys, err := publicBackend.storageView.List(quitCtx, "")
 			if err != nil {
 				return errwrap.Wrapf("failed to fetch public storage keys: {{err}}", err)
 			}
@@ -1938,7 +1953,7 @@ func (ts *TokenStore) handleTidy(ctx context.Context, req *logical.Request, data
 				key := strings.TrimSuffix(key, "/")
 				if !validPublicKeys[key] {
 					ts.logger.Info("deleting invalid public", "key", key)
-					err = ts.publicBackend.revoke(quitCtx, key)
+					err = publicBackend.revoke(quitCtx, key)
 					if err != nil {
 						tidyErrors = multierror.Append(tidyErrors, errwrap.Wrapf(fmt.Sprintf("failed to revoke public key %q: {{err}}", key), err))
 					}
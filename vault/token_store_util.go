@@ -3,25 +3,59 @@
 package vault
 
 import (
+	"context"
+
 	"github.com/jiangjiali/vault/sdk/helper/namespace"
 )
 
 func (ts *TokenStore) baseView(ns *namespace.Namespace) *BarrierView {
-	return ts.baseBarrierView
+	if ns.ID == namespace.RootNamespaceID {
+		return ts.baseBarrierView
+	}
+	return ts.core.namespaceSystemView(ns).SubView(tokenSubPath)
 }
 
 func (ts *TokenStore) idView(ns *namespace.Namespace) *BarrierView {
-	return ts.idBarrierView
+	if ns.ID == namespace.RootNamespaceID {
+		return ts.idBarrierView
+	}
+	return ts.baseView(ns).SubView(idPrefix)
 }
 
 func (ts *TokenStore) accessorView(ns *namespace.Namespace) *BarrierView {
-	return ts.accessorBarrierView
+	if ns.ID == namespace.RootNamespaceID {
+		return ts.accessorBarrierView
+	}
+	return ts.baseView(ns).SubView(accessorPrefix)
 }
 
 func (ts *TokenStore) parentView(ns *namespace.Namespace) *BarrierView {
-	return ts.parentBarrierView
+	if ns.ID == namespace.RootNamespaceID {
+		return ts.parentBarrierView
+	}
+	return ts.baseView(ns).SubView(parentPrefix)
 }
 
 func (ts *TokenStore) rolesView(ns *namespace.Namespace) *BarrierView {
-	return ts.rolesBarrierView
+	if ns.ID == namespace.RootNamespaceID {
+		return ts.rolesBarrierView
+	}
+	return ts.baseView(ns).SubView(rolesPrefix)
+}
+
+// namespacePublicBackend returns the per-token storage of the namespace, or
+// nil if it is not mounted.
+func (ts *TokenStore) namespacePublicBackend(ctx context.Context, ns *namespace.Namespace) *PublicBackend {
+	if ns.ID == namespace.RootNamespaceID {
+		return ts.publicBackend
+	}
+	backend, _ := ts.core.router.MatchingBackend(namespace.ContextWithNamespace(ctx, ns), publicMountPath).(*PublicBackend)
+	return backend
+}
+
+// invalidateNamespaceSalt drops the cached salt of a deleted namespace.
+func (ts *TokenStore) invalidateNamespaceSalt(ns *namespace.Namespace) {
+	ts.saltLock.Lock()
+	delete(ts.salts, ns.ID)
+	ts.saltLock.Unlock()
 }